@@ -1,14 +1,22 @@
 package kvbench
 
 import (
+	"bytes"
+	"math"
+	"os"
+	"path/filepath"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/dgraph-io/badger/v2"
 )
 
 type badgerStore struct {
-	mu sync.RWMutex
-	db *badger.DB
+	mu       sync.RWMutex
+	db       *badger.DB
+	inMemory bool
+	dir      string // the path opened
 }
 
 func badgerKey(key []byte) []byte {
@@ -32,7 +40,9 @@ func NewBadgerStore(path string, fsync bool) (Store, error) {
 	}
 
 	return &badgerStore{
-		db: db,
+		db:       db,
+		inMemory: opts.InMemory,
+		dir:      opts.Dir,
 	}, nil
 }
 
@@ -62,7 +72,7 @@ func (s *badgerStore) PGet(keys [][]byte) ([][]byte, []bool, error) {
 			if err == nil {
 				v, err := item.ValueCopy(nil)
 				if err == nil {
-					vals[i] = v
+					vals[i] = emptyIfNil(v)
 					oks[i] = true
 				}
 			}
@@ -81,6 +91,7 @@ func (s *badgerStore) Set(key, value []byte) error {
 
 func (s *badgerStore) Get(key []byte) ([]byte, bool, error) {
 	var v []byte
+	var ok bool
 
 	err := s.db.View(func(txn *badger.Txn) error {
 		item, err := txn.Get(key)
@@ -90,11 +101,32 @@ func (s *badgerStore) Get(key []byte) ([]byte, bool, error) {
 				return nil
 			})
 			// v, err = item.ValueCopy(nil)
+			ok = err == nil
 		}
 		return err
 	})
 
-	return v, v != nil, err
+	// badger hands back a nil slice for empty values, which would otherwise
+	// be indistinguishable from a missing key.
+	if ok {
+		v = emptyIfNil(v)
+	}
+	return v, ok, err
+}
+
+// Has looks the key up in the LSM tree without reading the value, which
+// may live in the value log.
+func (s *badgerStore) Has(key []byte) (bool, error) {
+	var ok bool
+	err := s.db.View(func(txn *badger.Txn) error {
+		_, err := txn.Get(key)
+		if err == badger.ErrKeyNotFound {
+			return nil
+		}
+		ok = err == nil
+		return err
+	})
+	return ok, err
 }
 
 func (s *badgerStore) Del(key []byte) (bool, error) {
@@ -104,6 +136,17 @@ func (s *badgerStore) Del(key []byte) (bool, error) {
 	return err == nil, err
 }
 
+func (s *badgerStore) PDel(keys [][]byte) error {
+	wb := s.db.NewWriteBatch()
+	for _, k := range keys {
+		if err := wb.Delete(k); err != nil {
+			wb.Cancel()
+			return err
+		}
+	}
+	return wb.Flush()
+}
+
 func (s *badgerStore) Keys(pattern []byte, limit int, withvals bool) ([][]byte, [][]byte, error) {
 	var keys [][]byte
 	var vals [][]byte
@@ -130,6 +173,404 @@ func (s *badgerStore) Keys(pattern []byte, limit int, withvals bool) ([][]byte,
 	return keys, vals, err
 }
 
+func (s *badgerStore) AllKeys(limit int, withvals bool) ([][]byte, [][]byte, error) {
+	var keys [][]byte
+	var vals [][]byte
+	err := s.db.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = withvals
+		it := txn.NewIterator(opts)
+		defer it.Close()
+		for it.Rewind(); it.Valid(); it.Next() {
+			if limit > 0 && len(keys) >= limit {
+				break
+			}
+			item := it.Item()
+			keys = append(keys, item.KeyCopy(nil))
+			if withvals {
+				v, err := item.ValueCopy(nil)
+				if err != nil {
+					return err
+				}
+				vals = append(vals, v)
+			}
+		}
+		return nil
+	})
+	return keys, vals, err
+}
+
+// RangeScan seeks the iterator to start and stops at end, fetching the values
+// only withvals.
+func (s *badgerStore) RangeScan(start, end []byte, limit int, withvals bool) ([][]byte, [][]byte, error) {
+	var keys [][]byte
+	var vals [][]byte
+	err := s.db.View(func(txn *badger.Txn) error {
+		var err error
+		keys, vals, err = badgerRangeScan(txn, start, end, limit, withvals)
+		return err
+	})
+	return keys, vals, err
+}
+
+// badgerRangeScan scans the keys visible to txn.
+func badgerRangeScan(txn *badger.Txn, start, end []byte, limit int, withvals bool) ([][]byte, [][]byte, error) {
+	var keys [][]byte
+	var vals [][]byte
+	opts := badger.DefaultIteratorOptions
+	opts.PrefetchValues = withvals
+	it := txn.NewIterator(opts)
+	defer it.Close()
+	for it.Seek(start); it.Valid(); it.Next() {
+		if limit > 0 && len(keys) >= limit {
+			break
+		}
+		item := it.Item()
+		if end != nil && bytes.Compare(item.Key(), end) >= 0 {
+			break
+		}
+		keys = append(keys, item.KeyCopy(nil))
+		if withvals {
+			v, err := item.ValueCopy(nil)
+			if err != nil {
+				return nil, nil, err
+			}
+			vals = append(vals, v)
+		}
+	}
+	return keys, vals, nil
+}
+
+// badgerSnapshot is a read-only transaction held open, which reads as of its
+// start timestamp. Goroutines may share it, as read-only transactions keep
+// no read set.
+type badgerSnapshot struct {
+	txn *badger.Txn
+}
+
+func (s *badgerStore) Snapshot() (Snapshot, error) {
+	return badgerSnapshot{s.db.NewTransaction(false)}, nil
+}
+
+func (s badgerSnapshot) Get(key []byte) ([]byte, bool, error) {
+	item, err := s.txn.Get(key)
+	if err == badger.ErrKeyNotFound {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	v, err := item.ValueCopy(nil)
+	if err != nil {
+		return nil, false, err
+	}
+	return emptyIfNil(v), true, nil
+}
+
+func (s badgerSnapshot) RangeScan(start, end []byte, limit int, withvals bool) ([][]byte, [][]byte, error) {
+	return badgerRangeScan(s.txn, start, end, limit, withvals)
+}
+
+func (s badgerSnapshot) Close() error {
+	s.txn.Discard()
+	return nil
+}
+
+// Count iterates the keys without their values. The key counts of the
+// table stats include older versions and deleted keys, and leave out the
+// memtables.
+func (s *badgerStore) Count() (int64, error) {
+	var n int64
+	err := s.db.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		it := txn.NewIterator(opts)
+		defer it.Close()
+		for it.Rewind(); it.Valid(); it.Next() {
+			n++
+		}
+		return nil
+	})
+	return n, err
+}
+
 func (s *badgerStore) FlushDB() error {
 	return s.db.DropAll()
 }
+
+// Compact runs value log GC until badger reports there is nothing left to
+// rewrite. Without it the value log keeps every deleted and overwritten value.
+func (s *badgerStore) Compact() error {
+	for {
+		err := s.db.RunValueLogGC(0.5)
+		switch err {
+		case nil:
+		case badger.ErrNoRewrite:
+			return nil
+		case badger.ErrGCInMemoryMode:
+			return ErrNotSupported
+		default:
+			return err
+		}
+	}
+}
+
+// Stats returns the bytes of the LSM tables and of the value log files on
+// disk, which the compaction phase compares around Compact to tell the
+// value log GC apart from the rest. badger's own Size is only refreshed
+// once a minute.
+func (s *badgerStore) Stats() (lsm, vlog int64) {
+	entries, _ := os.ReadDir(s.dir)
+	for _, e := range entries {
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		switch filepath.Ext(e.Name()) {
+		case ".sst":
+			lsm += info.Size()
+		case ".vlog":
+			vlog += info.Size()
+		}
+	}
+	return lsm, vlog
+}
+
+func (s *badgerStore) SetAsync(key, value []byte, cb func(error)) {
+	txn := s.db.NewTransaction(true)
+	if err := txn.Set(key, value); err != nil {
+		txn.Discard()
+		cb(err)
+		return
+	}
+	txn.CommitWith(cb)
+}
+
+func (s *badgerStore) Merge(key, value []byte) error {
+	return mergeWith(s.update, key, value)
+}
+
+// update reads and writes key in one transaction, retried when it conflicts
+// with a concurrent write of key.
+func (s *badgerStore) update(key []byte, fn updateFunc) error {
+	for {
+		err := s.db.Update(func(txn *badger.Txn) error {
+			return badgerUpdate(txn, key, fn)
+		})
+		if err != badger.ErrConflict {
+			return err
+		}
+	}
+}
+
+// badgerUpdate reads and writes key within txn.
+func badgerUpdate(txn *badger.Txn, key []byte, fn updateFunc) error {
+	var old []byte
+	item, err := txn.Get(key)
+	if err != nil && err != badger.ErrKeyNotFound {
+		return err
+	}
+	if err == nil {
+		if old, err = item.ValueCopy(nil); err != nil {
+			return err
+		}
+	}
+	v, err := fn(old, item != nil)
+	if err != nil || v == nil {
+		return err
+	}
+	return txn.Set(key, v)
+}
+
+func (s *badgerStore) Incr(key []byte, delta int64) (int64, error) {
+	return incrWith(s.update, key, delta)
+}
+
+func (s *badgerStore) CAS(key, oldValue, newValue []byte) (bool, error) {
+	return casWith(s.update, key, oldValue, newValue)
+}
+
+func (s *badgerStore) SetWithTTL(key, value []byte, ttl time.Duration) error {
+	return s.db.Update(func(txn *badger.Txn) error {
+		return txn.SetEntry(badger.NewEntry(key, value).WithTTL(ttl))
+	})
+}
+
+func (s *badgerStore) Ping() error {
+	return nil
+}
+
+func (s *badgerStore) Capabilities() Capability {
+	c := CapKeys | CapOrdered | CapAsync | CapTTL | CapTransactions | CapSnapshots | CapCAS | CapMerge | CapBackup
+	if !s.inMemory {
+		c |= CapCompact | CapPersistent
+	}
+	return c
+}
+
+// badgerManagedStore is badger opened in managed mode, where the caller
+// picks the read and commit timestamps of each transaction. The Store
+// methods commit at a counter and read at the newest timestamp, so the
+// usual phases run unchanged; SetAt and GetAt expose the timestamps. No
+// discard timestamp is set, so every version is kept.
+type badgerManagedStore struct {
+	*badgerStore
+	ts uint64 // newest commit timestamp, accessed atomically
+}
+
+// NewBadgerManagedStore opens badger in managed mode. It implements
+// VersionedStore.
+func NewBadgerManagedStore(path string, fsync bool) (Store, error) {
+	opts := badger.DefaultOptions(path)
+	if path == ":memory:" {
+		opts.InMemory = true
+	}
+	opts.Logger = nil
+
+	opts.SyncWrites = fsync
+	// all writes are blind, and with conflict detection badger requires
+	// commit timestamps to only grow, which SetAt does not guarantee
+	opts.DetectConflicts = false
+	db, err := badger.OpenManaged(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return &badgerManagedStore{
+		badgerStore: &badgerStore{
+			db:       db,
+			inMemory: opts.InMemory,
+			dir:      opts.Dir,
+		},
+	}, nil
+}
+
+// next returns a new commit timestamp.
+func (s *badgerManagedStore) next() uint64 {
+	return atomic.AddUint64(&s.ts, 1)
+}
+
+// advance moves the newest commit timestamp up to version, so that later
+// commits of next are not older than it.
+func (s *badgerManagedStore) advance(version uint64) {
+	for {
+		ts := atomic.LoadUint64(&s.ts)
+		if version <= ts || atomic.CompareAndSwapUint64(&s.ts, ts, version) {
+			return
+		}
+	}
+}
+
+func (s *badgerManagedStore) commit(version uint64, fn func(txn *badger.Txn) error) error {
+	txn := s.db.NewTransactionAt(math.MaxUint64, true)
+	defer txn.Discard()
+	if err := fn(txn); err != nil {
+		return err
+	}
+	return txn.CommitAt(version, nil)
+}
+
+func (s *badgerManagedStore) PSet(keys, vals [][]byte) error {
+	wb := s.db.NewWriteBatchAt(s.next())
+	for i := range keys {
+		err := wb.Set(keys[i], vals[i])
+		if err != nil {
+			wb.Cancel()
+			return err
+		}
+	}
+	return wb.Flush()
+}
+
+func (s *badgerManagedStore) Set(key, value []byte) error {
+	return s.commit(s.next(), func(txn *badger.Txn) error {
+		return txn.Set(key, value)
+	})
+}
+
+func (s *badgerManagedStore) Del(key []byte) (bool, error) {
+	err := s.commit(s.next(), func(txn *badger.Txn) error {
+		return txn.Delete(key)
+	})
+	return err == nil, err
+}
+
+func (s *badgerManagedStore) SetWithTTL(key, value []byte, ttl time.Duration) error {
+	return s.commit(s.next(), func(txn *badger.Txn) error {
+		return txn.SetEntry(badger.NewEntry(key, value).WithTTL(ttl))
+	})
+}
+
+// update commits key under mu: without conflict detection, concurrent
+// transactions would both read the old value.
+func (s *badgerManagedStore) update(key []byte, fn updateFunc) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.commit(s.next(), func(txn *badger.Txn) error {
+		return badgerUpdate(txn, key, fn)
+	})
+}
+
+func (s *badgerManagedStore) Incr(key []byte, delta int64) (int64, error) {
+	return incrWith(s.update, key, delta)
+}
+
+func (s *badgerManagedStore) CAS(key, oldValue, newValue []byte) (bool, error) {
+	return casWith(s.update, key, oldValue, newValue)
+}
+
+func (s *badgerManagedStore) Merge(key, value []byte) error {
+	return mergeWith(s.update, key, value)
+}
+
+func (s *badgerManagedStore) PDel(keys [][]byte) error {
+	wb := s.db.NewWriteBatchAt(s.next())
+	for _, k := range keys {
+		if err := wb.Delete(k); err != nil {
+			wb.Cancel()
+			return err
+		}
+	}
+	return wb.Flush()
+}
+
+func (s *badgerManagedStore) SetAsync(key, value []byte, cb func(error)) {
+	txn := s.db.NewTransactionAt(math.MaxUint64, true)
+	if err := txn.Set(key, value); err != nil {
+		txn.Discard()
+		cb(err)
+		return
+	}
+	txn.CommitAt(s.next(), cb)
+}
+
+func (s *badgerManagedStore) SetAt(key, value []byte, version uint64) error {
+	s.advance(version)
+	return s.commit(version, func(txn *badger.Txn) error {
+		return txn.Set(key, value)
+	})
+}
+
+// Snapshot reads as of the newest commit timestamp handed out by next, as
+// NewTransaction is not available in managed mode. A commit at that
+// timestamp still in flight becomes visible to it when it lands.
+func (s *badgerManagedStore) Snapshot() (Snapshot, error) {
+	return badgerSnapshot{s.db.NewTransactionAt(atomic.LoadUint64(&s.ts), false)}, nil
+}
+
+func (s *badgerManagedStore) GetAt(key []byte, version uint64) ([]byte, bool, error) {
+	txn := s.db.NewTransactionAt(version, false)
+	defer txn.Discard()
+	item, err := txn.Get(key)
+	if err == badger.ErrKeyNotFound {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	v, err := item.ValueCopy(nil)
+	if err != nil {
+		return nil, false, err
+	}
+	return emptyIfNil(v), true, nil
+}
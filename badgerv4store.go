@@ -0,0 +1,348 @@
+package kvbench
+
+import (
+	"bytes"
+	"time"
+
+	badger "github.com/dgraph-io/badger/v4"
+)
+
+// badgerV4Store is badger v4, next to the v2 badgerStore so that the two
+// versions can be compared. v4 keeps values of up to 1 MiB in the LSM tree
+// by default; NewBadgerV4Store moves the value threshold back to the 1 KiB
+// of v2, so that the comparison is not only one of that default.
+type badgerV4Store struct {
+	db       *badger.DB
+	inMemory bool
+}
+
+// badgerV4ValueThreshold is the size above which values go to the value log.
+const badgerV4ValueThreshold = 1 << 10
+
+// NewBadgerV4Store opens badger v4 at path, in memory for ":memory:". Only
+// the newest version of each key is kept.
+func NewBadgerV4Store(path string, fsync bool) (Store, error) {
+	opts := badger.DefaultOptions(path).
+		WithInMemory(path == ":memory:").
+		WithLogger(nil).
+		WithSyncWrites(fsync).
+		WithValueThreshold(badgerV4ValueThreshold).
+		WithNumVersionsToKeep(1)
+	if opts.InMemory {
+		opts.Dir, opts.ValueDir = "", ""
+	}
+	db, err := badger.Open(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return &badgerV4Store{
+		db:       db,
+		inMemory: opts.InMemory,
+	}, nil
+}
+
+func (s *badgerV4Store) Close() error {
+	return s.db.Close()
+}
+
+func (s *badgerV4Store) PSet(keys, vals [][]byte) error {
+	wb := s.db.NewWriteBatch()
+	for i := range keys {
+		if err := wb.Set(keys[i], vals[i]); err != nil {
+			wb.Cancel()
+			return err
+		}
+	}
+	return wb.Flush()
+}
+
+func (s *badgerV4Store) PGet(keys [][]byte) ([][]byte, []bool, error) {
+	vals := make([][]byte, len(keys))
+	oks := make([]bool, len(keys))
+	err := s.db.View(func(txn *badger.Txn) error {
+		for i, k := range keys {
+			v, ok, err := badgerV4Get(txn, k)
+			if err != nil {
+				return err
+			}
+			vals[i], oks[i] = v, ok
+		}
+		return nil
+	})
+	return vals, oks, err
+}
+
+func (s *badgerV4Store) Set(key, value []byte) error {
+	return s.db.Update(func(txn *badger.Txn) error {
+		return txn.Set(key, value)
+	})
+}
+
+func (s *badgerV4Store) Get(key []byte) ([]byte, bool, error) {
+	var v []byte
+	var ok bool
+	err := s.db.View(func(txn *badger.Txn) error {
+		var err error
+		v, ok, err = badgerV4Get(txn, key)
+		return err
+	})
+	return v, ok, err
+}
+
+// badgerV4Get reads key within txn. The value is copied, as the slice of
+// the item is only valid until txn ends.
+func badgerV4Get(txn *badger.Txn, key []byte) ([]byte, bool, error) {
+	item, err := txn.Get(key)
+	if err == badger.ErrKeyNotFound {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	v, err := item.ValueCopy(nil)
+	if err != nil {
+		return nil, false, err
+	}
+	return emptyIfNil(v), true, nil
+}
+
+// Has looks the key up in the LSM tree without reading the value, which
+// may live in the value log.
+func (s *badgerV4Store) Has(key []byte) (bool, error) {
+	var ok bool
+	err := s.db.View(func(txn *badger.Txn) error {
+		_, err := txn.Get(key)
+		if err == badger.ErrKeyNotFound {
+			return nil
+		}
+		ok = err == nil
+		return err
+	})
+	return ok, err
+}
+
+// Del reads the key in the transaction that deletes it, to report whether
+// it was present, and is retried like update.
+func (s *badgerV4Store) Del(key []byte) (bool, error) {
+	for {
+		var ok bool
+		err := s.db.Update(func(txn *badger.Txn) error {
+			_, err := txn.Get(key)
+			if err == badger.ErrKeyNotFound {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			ok = true
+			return txn.Delete(key)
+		})
+		if err != badger.ErrConflict {
+			return ok && err == nil, err
+		}
+	}
+}
+
+func (s *badgerV4Store) PDel(keys [][]byte) error {
+	wb := s.db.NewWriteBatch()
+	for _, k := range keys {
+		if err := wb.Delete(k); err != nil {
+			wb.Cancel()
+			return err
+		}
+	}
+	return wb.Flush()
+}
+
+func (s *badgerV4Store) Keys(pattern []byte, limit int, withvals bool) ([][]byte, [][]byte, error) {
+	var keys [][]byte
+	var vals [][]byte
+	err := s.db.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = withvals
+		opts.Prefix = pattern
+		it := txn.NewIterator(opts)
+		defer it.Close()
+		for it.Seek(pattern); it.ValidForPrefix(pattern); it.Next() {
+			if limit > 0 && len(keys) >= limit {
+				break
+			}
+			item := it.Item()
+			keys = append(keys, item.KeyCopy(nil))
+			if withvals {
+				v, err := item.ValueCopy(nil)
+				if err != nil {
+					return err
+				}
+				vals = append(vals, emptyIfNil(v))
+			}
+		}
+		return nil
+	})
+	return keys, vals, err
+}
+
+func (s *badgerV4Store) AllKeys(limit int, withvals bool) ([][]byte, [][]byte, error) {
+	return s.Keys(nil, limit, withvals)
+}
+
+func (s *badgerV4Store) RangeScan(start, end []byte, limit int, withvals bool) ([][]byte, [][]byte, error) {
+	var keys [][]byte
+	var vals [][]byte
+	err := s.db.View(func(txn *badger.Txn) error {
+		var err error
+		keys, vals, err = badgerV4RangeScan(txn, start, end, limit, withvals)
+		return err
+	})
+	return keys, vals, err
+}
+
+// badgerV4RangeScan scans the keys visible to txn.
+func badgerV4RangeScan(txn *badger.Txn, start, end []byte, limit int, withvals bool) ([][]byte, [][]byte, error) {
+	var keys [][]byte
+	var vals [][]byte
+	opts := badger.DefaultIteratorOptions
+	opts.PrefetchValues = withvals
+	it := txn.NewIterator(opts)
+	defer it.Close()
+	for it.Seek(start); it.Valid(); it.Next() {
+		if limit > 0 && len(keys) >= limit {
+			break
+		}
+		item := it.Item()
+		if end != nil && bytes.Compare(item.Key(), end) >= 0 {
+			break
+		}
+		keys = append(keys, item.KeyCopy(nil))
+		if withvals {
+			v, err := item.ValueCopy(nil)
+			if err != nil {
+				return nil, nil, err
+			}
+			vals = append(vals, emptyIfNil(v))
+		}
+	}
+	return keys, vals, nil
+}
+
+// badgerV4Snapshot is a read-only transaction held open, like
+// badgerSnapshot.
+type badgerV4Snapshot struct {
+	txn *badger.Txn
+}
+
+func (s *badgerV4Store) Snapshot() (Snapshot, error) {
+	return badgerV4Snapshot{s.db.NewTransaction(false)}, nil
+}
+
+func (s badgerV4Snapshot) Get(key []byte) ([]byte, bool, error) {
+	return badgerV4Get(s.txn, key)
+}
+
+func (s badgerV4Snapshot) RangeScan(start, end []byte, limit int, withvals bool) ([][]byte, [][]byte, error) {
+	return badgerV4RangeScan(s.txn, start, end, limit, withvals)
+}
+
+func (s badgerV4Snapshot) Close() error {
+	s.txn.Discard()
+	return nil
+}
+
+// Count iterates the keys without their values, as badgerStore does.
+func (s *badgerV4Store) Count() (int64, error) {
+	var n int64
+	err := s.db.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		it := txn.NewIterator(opts)
+		defer it.Close()
+		for it.Rewind(); it.Valid(); it.Next() {
+			n++
+		}
+		return nil
+	})
+	return n, err
+}
+
+func (s *badgerV4Store) FlushDB() error {
+	return s.db.DropAll()
+}
+
+// Compact runs value log GC until badger reports there is nothing left to
+// rewrite.
+func (s *badgerV4Store) Compact() error {
+	for {
+		err := s.db.RunValueLogGC(0.5)
+		switch err {
+		case nil:
+		case badger.ErrNoRewrite:
+			return nil
+		case badger.ErrGCInMemoryMode:
+			return ErrNotSupported
+		default:
+			return err
+		}
+	}
+}
+
+func (s *badgerV4Store) SetAsync(key, value []byte, cb func(error)) {
+	txn := s.db.NewTransaction(true)
+	if err := txn.Set(key, value); err != nil {
+		txn.Discard()
+		cb(err)
+		return
+	}
+	txn.CommitWith(cb)
+}
+
+func (s *badgerV4Store) Merge(key, value []byte) error {
+	return mergeWith(s.update, key, value)
+}
+
+// update reads and writes key in one transaction, retried when it conflicts
+// with a concurrent write of key.
+func (s *badgerV4Store) update(key []byte, fn updateFunc) error {
+	for {
+		err := s.db.Update(func(txn *badger.Txn) error {
+			old, ok, err := badgerV4Get(txn, key)
+			if err != nil {
+				return err
+			}
+			v, err := fn(old, ok)
+			if err != nil || v == nil {
+				return err
+			}
+			return txn.Set(key, v)
+		})
+		if err != badger.ErrConflict {
+			return err
+		}
+	}
+}
+
+func (s *badgerV4Store) Incr(key []byte, delta int64) (int64, error) {
+	return incrWith(s.update, key, delta)
+}
+
+func (s *badgerV4Store) CAS(key, oldValue, newValue []byte) (bool, error) {
+	return casWith(s.update, key, oldValue, newValue)
+}
+
+func (s *badgerV4Store) SetWithTTL(key, value []byte, ttl time.Duration) error {
+	return s.db.Update(func(txn *badger.Txn) error {
+		return txn.SetEntry(badger.NewEntry(key, value).WithTTL(ttl))
+	})
+}
+
+func (s *badgerV4Store) Ping() error {
+	return nil
+}
+
+func (s *badgerV4Store) Capabilities() Capability {
+	c := CapKeys | CapOrdered | CapAsync | CapTTL | CapTransactions | CapSnapshots | CapCAS | CapMerge
+	if !s.inMemory {
+		c |= CapCompact | CapPersistent
+	}
+	return c
+}
@@ -2,7 +2,9 @@ package kvbench
 
 import (
 	"bytes"
+	"fmt"
 	"sync"
+	"time"
 
 	"go.etcd.io/bbolt"
 )
@@ -10,8 +12,9 @@ import (
 var bboltBucket = []byte("keys")
 
 type bboltStore struct {
-	mu sync.RWMutex
-	db *bbolt.DB
+	mu   sync.RWMutex
+	db   *bbolt.DB
+	rotx *roTxPool[*bbolt.Tx] // nil unless read transactions are reused
 }
 
 func bboltKey(key []byte) []byte {
@@ -21,10 +24,23 @@ func bboltKey(key []byte) []byte {
 	return r
 }
 func NewBboltStore(path string, fsync bool) (Store, error) {
+	return NewBboltStoreWithOptions(path, fsync, BoltOptions{})
+}
+
+// NewBboltStoreWithOptions is NewBboltStore with tuning options.
+func NewBboltStoreWithOptions(path string, fsync bool, o BoltOptions) (Store, error) {
 	if path == ":memory:" {
 		return nil, ErrMemoryNotAllowed
 	}
-	db, err := bbolt.Open(path, 0666, nil)
+	freelist := bbolt.FreelistType(o.freelistType())
+	if freelist != bbolt.FreelistArrayType && freelist != bbolt.FreelistMapType {
+		return nil, fmt.Errorf("unknown bbolt freelist type %q, want array or hashmap", o.FreelistType)
+	}
+	db, err := bbolt.Open(path, 0666, &bbolt.Options{
+		InitialMmapSize: o.InitialMmapSize,
+		NoFreelistSync:  o.NoFreelistSync,
+		FreelistType:    freelist,
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -36,12 +52,19 @@ func NewBboltStore(path string, fsync bool) (Store, error) {
 		db.Close()
 		return nil, err
 	}
-	return &bboltStore{
+	s := &bboltStore{
 		db: db,
-	}, nil
+	}
+	if o.ReadTxReuse > 0 {
+		s.rotx = newRoTxPool(func() (*bbolt.Tx, error) { return db.Begin(false) }, o.ReadTxReuse)
+	}
+	return s, nil
 }
 
 func (s *bboltStore) Close() error {
+	if s.rotx != nil {
+		s.rotx.close()
+	}
 	s.db.Close()
 	return nil
 }
@@ -88,6 +111,18 @@ func (s *bboltStore) Set(key, value []byte) error {
 }
 
 func (s *bboltStore) Get(key []byte) ([]byte, bool, error) {
+	if s.rotx != nil {
+		t, err := s.rotx.get()
+		if err != nil {
+			return nil, false, err
+		}
+		// the value must outlive the transaction
+		v := t.tx.Bucket(bboltBucket).Get(bboltKey(key))
+		ok := v != nil
+		v = bcopy(v)
+		s.rotx.put(t)
+		return v, ok, nil
+	}
 	var v []byte
 	err := s.db.View(func(tx *bbolt.Tx) error {
 		v = tx.Bucket(bboltBucket).Get(bboltKey(key))
@@ -96,6 +131,25 @@ func (s *bboltStore) Get(key []byte) ([]byte, bool, error) {
 	return v, v != nil, err
 }
 
+// Has looks the key up in the bucket without copying the value.
+func (s *bboltStore) Has(key []byte) (bool, error) {
+	if s.rotx != nil {
+		t, err := s.rotx.get()
+		if err != nil {
+			return false, err
+		}
+		ok := t.tx.Bucket(bboltBucket).Get(bboltKey(key)) != nil
+		s.rotx.put(t)
+		return ok, nil
+	}
+	var ok bool
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		ok = tx.Bucket(bboltBucket).Get(bboltKey(key)) != nil
+		return nil
+	})
+	return ok, err
+}
+
 func (s *bboltStore) Del(key []byte) (bool, error) {
 	var v []byte
 	err := s.db.Update(func(tx *bbolt.Tx) error {
@@ -106,6 +160,19 @@ func (s *bboltStore) Del(key []byte) (bool, error) {
 	return v != nil, err
 }
 
+// PDel deletes keys in one read-write transaction.
+func (s *bboltStore) PDel(keys [][]byte) error {
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(bboltBucket)
+		for _, k := range keys {
+			if err := b.Delete(bboltKey(k)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
 func (s *bboltStore) Keys(pattern []byte, limit int, withvalues bool) ([][]byte, [][]byte, error) {
 	//spattern := string(pattern)
 	//min, max := match.Allowable(spattern)
@@ -166,12 +233,211 @@ func (s *bboltStore) Keys(pattern []byte, limit int, withvalues bool) ([][]byte,
 	return keys, vals, err
 }
 
+func (s *bboltStore) AllKeys(limit int, withvals bool) ([][]byte, [][]byte, error) {
+	var keys [][]byte
+	var vals [][]byte
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		c := tx.Bucket(bboltBucket).Cursor()
+		for key, value := c.First(); key != nil; key, value = c.Next() {
+			if limit > 0 && len(keys) >= limit {
+				break
+			}
+			keys = append(keys, bcopy(key[1:]))
+			if withvals {
+				vals = append(vals, bcopy(value))
+			}
+		}
+		return nil
+	})
+	return keys, vals, err
+}
+
+// RangeScan seeks the cursor to the prefixed start and stops at end.
+func (s *bboltStore) RangeScan(start, end []byte, limit int, withvals bool) ([][]byte, [][]byte, error) {
+	var keys [][]byte
+	var vals [][]byte
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		keys, vals = bboltRangeScan(tx.Bucket(bboltBucket), start, end, limit, withvals)
+		return nil
+	})
+	return keys, vals, err
+}
+
+// bboltRangeScan scans b with a cursor.
+func bboltRangeScan(b *bbolt.Bucket, start, end []byte, limit int, withvals bool) ([][]byte, [][]byte) {
+	var keys [][]byte
+	var vals [][]byte
+	c := b.Cursor()
+	for key, value := c.Seek(bboltKey(start)); key != nil; key, value = c.Next() {
+		if limit > 0 && len(keys) >= limit {
+			break
+		}
+		if end != nil && bytes.Compare(key[1:], end) >= 0 {
+			break
+		}
+		keys = append(keys, bcopy(key[1:]))
+		if withvals {
+			vals = append(vals, bcopy(value))
+		}
+	}
+	return keys, vals
+}
+
+// Count returns the KeyN of the bucket stats.
+func (s *bboltStore) Count() (int64, error) {
+	var n int64
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		n = int64(tx.Bucket(bboltBucket).Stats().KeyN)
+		return nil
+	})
+	return n, err
+}
+
 func (s *bboltStore) FlushDB() error {
 	return s.db.Update(func(tx *bbolt.Tx) error {
-		if err := tx.DeleteBucket(bboltBucket); err != nil {
+		// namespace buckets included
+		var names [][]byte
+		if err := tx.ForEach(func(name []byte, _ *bbolt.Bucket) error {
+			names = append(names, bcopy(name))
+			return nil
+		}); err != nil {
 			return err
 		}
+		for _, name := range names {
+			if err := tx.DeleteBucket(name); err != nil {
+				return err
+			}
+		}
 		_, err := tx.CreateBucket(bboltBucket)
 		return err
 	})
 }
+
+func (s *bboltStore) Compact() error {
+	return ErrNotSupported
+}
+
+func (s *bboltStore) SetAsync(key, value []byte, cb func(error)) {
+	cb(s.Set(key, value))
+}
+
+// SetNS maps namespaces to buckets. Bucket names start with 'n', so they never
+// clash with the bucket of the default keyspace.
+func (s *bboltStore) SetNS(ns, key, value []byte) error {
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists(bboltNSBucket(ns))
+		if err != nil {
+			return err
+		}
+		return b.Put(key, value)
+	})
+}
+
+func (s *bboltStore) GetNS(ns, key []byte) ([]byte, bool, error) {
+	var v []byte
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(bboltNSBucket(ns))
+		if b == nil {
+			return nil
+		}
+		if value := b.Get(key); value != nil {
+			v = bcopy(value)
+		}
+		return nil
+	})
+	return v, v != nil, err
+}
+
+func (s *bboltStore) DelNS(ns, key []byte) (bool, error) {
+	var v []byte
+	err := s.db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(bboltNSBucket(ns))
+		if b == nil {
+			return nil
+		}
+		v = b.Get(key)
+		return b.Delete(key)
+	})
+	return v != nil, err
+}
+
+func bboltNSBucket(ns []byte) []byte {
+	r := make([]byte, len(ns)+1)
+	r[0] = 'n'
+	copy(r[1:], ns)
+	return r
+}
+
+func (s *bboltStore) Merge(key, value []byte) error {
+	return mergeWith(s.update, key, value)
+}
+
+// update reads and writes key in one write transaction, which bbolt runs one
+// at a time.
+func (s *bboltStore) update(key []byte, fn updateFunc) error {
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(bboltBucket)
+		old := b.Get(bboltKey(key))
+		v, err := fn(old, old != nil)
+		if err != nil || v == nil {
+			return err
+		}
+		return b.Put(bboltKey(key), v)
+	})
+}
+
+func (s *bboltStore) Incr(key []byte, delta int64) (int64, error) {
+	return incrWith(s.update, key, delta)
+}
+
+func (s *bboltStore) CAS(key, oldValue, newValue []byte) (bool, error) {
+	return casWith(s.update, key, oldValue, newValue)
+}
+
+func (s *bboltStore) SetWithTTL(key, value []byte, ttl time.Duration) error {
+	return ErrNotSupported
+}
+
+func (s *bboltStore) Ping() error {
+	return nil
+}
+
+// bboltSnapshot is a read transaction held open. Goroutines may share it: a
+// read-only bucket only reads the mmap, though its cursors bump the
+// transaction's statistics without synchronization.
+type bboltSnapshot struct {
+	tx *bbolt.Tx
+	b  *bbolt.Bucket
+}
+
+// Snapshot begins a read transaction. While it is open, the pages it reads
+// cannot be reused, and a write that has to grow the file waits for it.
+func (s *bboltStore) Snapshot() (Snapshot, error) {
+	tx, err := s.db.Begin(false)
+	if err != nil {
+		return nil, err
+	}
+	return bboltSnapshot{tx, tx.Bucket(bboltBucket)}, nil
+}
+
+func (s bboltSnapshot) Get(key []byte) ([]byte, bool, error) {
+	v := s.b.Get(bboltKey(key))
+	if v == nil {
+		return nil, false, nil
+	}
+	// the value must outlive the transaction
+	return bcopy(v), true, nil
+}
+
+func (s bboltSnapshot) RangeScan(start, end []byte, limit int, withvals bool) ([][]byte, [][]byte, error) {
+	keys, vals := bboltRangeScan(s.b, start, end, limit, withvals)
+	return keys, vals, nil
+}
+
+func (s bboltSnapshot) Close() error {
+	return s.tx.Rollback()
+}
+
+func (s *bboltStore) Capabilities() Capability {
+	return CapKeys | CapOrdered | CapPersistent | CapTransactions | CapSnapshots | CapCAS | CapMerge | CapBackup
+}
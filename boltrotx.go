@@ -0,0 +1,145 @@
+package kvbench
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// BoltOptions tunes a bolt or bbolt store. Zero fields keep the defaults.
+type BoltOptions struct {
+	// ReadTxReuse is the number of Gets served by one read transaction
+	// before it is replaced, 0 to open a transaction per Get. Reused
+	// transactions see the data as of when they began, so Get may miss
+	// recent writes for up to ReadTxReuse calls or roTxMaxAge.
+	ReadTxReuse int
+	// InitialMmapSize is the size in bytes the file is first mapped with,
+	// 0 to map it at its size. A map large enough for the whole database
+	// saves the remaps as it grows, each of which waits for every read
+	// transaction.
+	InitialMmapSize int
+	// NoFreelistSync leaves the freelist out of every commit, bbolt only.
+	// The freelist is then rebuilt by scanning the file when it is opened.
+	NoFreelistSync bool
+	// FreelistType is "array" or "hashmap", bbolt only; "" is array. The
+	// hashmap freelist allocates in constant time in a fragmented file.
+	FreelistType string
+}
+
+// String returns the effective settings. Those of the freelist are bolt's
+// fixed ones for a bolt store.
+func (o BoltOptions) String() string {
+	return fmt.Sprintf("readtxreuse=%d initialmmapsize=%dMiB nofreelistsync=%v freelist=%s",
+		o.ReadTxReuse, o.InitialMmapSize>>20, o.NoFreelistSync, o.freelistType())
+}
+
+func (o BoltOptions) freelistType() string {
+	if o.FreelistType == "" {
+		return "array"
+	}
+	return o.FreelistType
+}
+
+// roTxMaxAge is how long a reused read transaction may stay open. An open
+// read transaction keeps a writer that needs to grow the file waiting, so
+// idle ones are closed after this long too.
+const roTxMaxAge = 10 * time.Millisecond
+
+type roTx[T interface{ Rollback() error }] struct {
+	tx    T
+	uses  int
+	begun time.Time
+}
+
+// roTxPool hands out read transactions for reuse across Gets, replacing
+// each after maxUses calls or roTxMaxAge.
+type roTxPool[T interface{ Rollback() error }] struct {
+	begin   func() (T, error)
+	maxUses int
+	txs     chan *roTx[T]
+	done    chan struct{}
+	wg      sync.WaitGroup
+}
+
+func newRoTxPool[T interface{ Rollback() error }](begin func() (T, error), maxUses int) *roTxPool[T] {
+	p := &roTxPool[T]{
+		begin:   begin,
+		maxUses: maxUses,
+		txs:     make(chan *roTx[T], 64),
+		done:    make(chan struct{}),
+	}
+	p.wg.Add(1)
+	go p.expire()
+	return p
+}
+
+// get returns a read transaction, to be handed back with put.
+func (p *roTxPool[T]) get() (*roTx[T], error) {
+	select {
+	case t := <-p.txs:
+		return t, nil
+	default:
+	}
+	tx, err := p.begin()
+	if err != nil {
+		return nil, err
+	}
+	return &roTx[T]{tx: tx, begun: time.Now()}, nil
+}
+
+func (p *roTxPool[T]) put(t *roTx[T]) {
+	t.uses++
+	if t.uses >= p.maxUses || time.Since(t.begun) >= roTxMaxAge {
+		t.tx.Rollback()
+		return
+	}
+	select {
+	case p.txs <- t:
+	default:
+		t.tx.Rollback()
+	}
+}
+
+// expire closes the pooled transactions that are older than roTxMaxAge.
+func (p *roTxPool[T]) expire() {
+	defer p.wg.Done()
+	ticker := time.NewTicker(roTxMaxAge)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.done:
+			return
+		case <-ticker.C:
+		}
+		for n := len(p.txs); n > 0; n-- {
+			select {
+			case t := <-p.txs:
+				if time.Since(t.begun) >= roTxMaxAge {
+					t.tx.Rollback()
+					continue
+				}
+				select {
+				case p.txs <- t:
+				default:
+					t.tx.Rollback()
+				}
+			default:
+			}
+		}
+	}
+}
+
+// close rolls back the pooled transactions. Transactions handed out by get
+// must have been put back.
+func (p *roTxPool[T]) close() {
+	close(p.done)
+	p.wg.Wait()
+	for {
+		select {
+		case t := <-p.txs:
+			t.tx.Rollback()
+		default:
+			return
+		}
+	}
+}
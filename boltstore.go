@@ -1,8 +1,10 @@
 package kvbench
 
 import (
+	"bytes"
 	"errors"
 	"sync"
+	"time"
 
 	"github.com/boltdb/bolt"
 	"github.com/tidwall/match"
@@ -11,8 +13,9 @@ import (
 var boltBucket = []byte("keys")
 
 type boltStore struct {
-	mu sync.RWMutex
-	db *bolt.DB
+	mu   sync.RWMutex
+	db   *bolt.DB
+	rotx *roTxPool[*bolt.Tx] // nil unless read transactions are reused
 }
 
 func boltKey(key []byte) []byte {
@@ -22,10 +25,18 @@ func boltKey(key []byte) []byte {
 	return r
 }
 func NewBoltStore(path string, fsync bool) (Store, error) {
+	return NewBoltStoreWithOptions(path, fsync, BoltOptions{})
+}
+
+// NewBoltStoreWithOptions is NewBoltStore with tuning options.
+func NewBoltStoreWithOptions(path string, fsync bool, o BoltOptions) (Store, error) {
 	if path == ":memory:" {
 		return nil, ErrMemoryNotAllowed
 	}
-	db, err := bolt.Open(path, 0666, nil)
+	if o.NoFreelistSync || o.freelistType() != "array" {
+		return nil, errors.New("bolt always syncs its array freelist, the freelist options are bbolt's")
+	}
+	db, err := bolt.Open(path, 0666, &bolt.Options{InitialMmapSize: o.InitialMmapSize})
 	if err != nil {
 		return nil, err
 	}
@@ -37,12 +48,19 @@ func NewBoltStore(path string, fsync bool) (Store, error) {
 		db.Close()
 		return nil, err
 	}
-	return &boltStore{
+	s := &boltStore{
 		db: db,
-	}, nil
+	}
+	if o.ReadTxReuse > 0 {
+		s.rotx = newRoTxPool(func() (*bolt.Tx, error) { return db.Begin(false) }, o.ReadTxReuse)
+	}
+	return s, nil
 }
 
 func (s *boltStore) Close() error {
+	if s.rotx != nil {
+		s.rotx.close()
+	}
 	s.db.Close()
 	return nil
 }
@@ -89,6 +107,18 @@ func (s *boltStore) Set(key, value []byte) error {
 }
 
 func (s *boltStore) Get(key []byte) ([]byte, bool, error) {
+	if s.rotx != nil {
+		t, err := s.rotx.get()
+		if err != nil {
+			return nil, false, err
+		}
+		// the value must outlive the transaction
+		v := t.tx.Bucket(boltBucket).Get(boltKey(key))
+		ok := v != nil
+		v = bcopy(v)
+		s.rotx.put(t)
+		return v, ok, nil
+	}
 	var v []byte
 	err := s.db.View(func(tx *bolt.Tx) error {
 		v = tx.Bucket(boltBucket).Get(boltKey(key))
@@ -97,6 +127,25 @@ func (s *boltStore) Get(key []byte) ([]byte, bool, error) {
 	return v, v != nil, err
 }
 
+// Has looks the key up in the bucket without copying the value.
+func (s *boltStore) Has(key []byte) (bool, error) {
+	if s.rotx != nil {
+		t, err := s.rotx.get()
+		if err != nil {
+			return false, err
+		}
+		ok := t.tx.Bucket(boltBucket).Get(boltKey(key)) != nil
+		s.rotx.put(t)
+		return ok, nil
+	}
+	var ok bool
+	err := s.db.View(func(tx *bolt.Tx) error {
+		ok = tx.Bucket(boltBucket).Get(boltKey(key)) != nil
+		return nil
+	})
+	return ok, err
+}
+
 func (s *boltStore) Del(key []byte) (bool, error) {
 	var v []byte
 	err := s.db.Update(func(tx *bolt.Tx) error {
@@ -107,6 +156,19 @@ func (s *boltStore) Del(key []byte) (bool, error) {
 	return v != nil, err
 }
 
+// PDel deletes keys in one read-write transaction.
+func (s *boltStore) PDel(keys [][]byte) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(boltBucket)
+		for _, k := range keys {
+			if err := b.Delete(boltKey(k)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
 func (s *boltStore) Keys(pattern []byte, limit int, withvalues bool) ([][]byte, [][]byte, error) {
 	spattern := string(pattern)
 	min, max := match.Allowable(spattern)
@@ -154,12 +216,212 @@ func (s *boltStore) Keys(pattern []byte, limit int, withvalues bool) ([][]byte,
 	return keys, vals, err
 }
 
+func (s *boltStore) AllKeys(limit int, withvals bool) ([][]byte, [][]byte, error) {
+	var keys [][]byte
+	var vals [][]byte
+	err := s.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(boltBucket).Cursor()
+		for key, value := c.First(); key != nil; key, value = c.Next() {
+			if limit > 0 && len(keys) >= limit {
+				break
+			}
+			keys = append(keys, bcopy(key[1:]))
+			if withvals {
+				vals = append(vals, bcopy(value))
+			}
+		}
+		return nil
+	})
+	return keys, vals, err
+}
+
+// RangeScan seeks the cursor to the prefixed start and stops at end.
+func (s *boltStore) RangeScan(start, end []byte, limit int, withvals bool) ([][]byte, [][]byte, error) {
+	var keys [][]byte
+	var vals [][]byte
+	err := s.db.View(func(tx *bolt.Tx) error {
+		keys, vals = boltRangeScan(tx.Bucket(boltBucket), start, end, limit, withvals)
+		return nil
+	})
+	return keys, vals, err
+}
+
+// boltRangeScan scans b with a cursor.
+func boltRangeScan(b *bolt.Bucket, start, end []byte, limit int, withvals bool) ([][]byte, [][]byte) {
+	var keys [][]byte
+	var vals [][]byte
+	c := b.Cursor()
+	for key, value := c.Seek(boltKey(start)); key != nil; key, value = c.Next() {
+		if limit > 0 && len(keys) >= limit {
+			break
+		}
+		if end != nil && bytes.Compare(key[1:], end) >= 0 {
+			break
+		}
+		keys = append(keys, bcopy(key[1:]))
+		if withvals {
+			vals = append(vals, bcopy(value))
+		}
+	}
+	return keys, vals
+}
+
+// Count returns the KeyN of the bucket stats, which does not count the
+// namespace buckets.
+func (s *boltStore) Count() (int64, error) {
+	var n int64
+	err := s.db.View(func(tx *bolt.Tx) error {
+		n = int64(tx.Bucket(boltBucket).Stats().KeyN)
+		return nil
+	})
+	return n, err
+}
+
 func (s *boltStore) FlushDB() error {
 	return s.db.Update(func(tx *bolt.Tx) error {
-		if err := tx.DeleteBucket(boltBucket); err != nil {
+		// namespace buckets included
+		var names [][]byte
+		if err := tx.ForEach(func(name []byte, _ *bolt.Bucket) error {
+			names = append(names, bcopy(name))
+			return nil
+		}); err != nil {
 			return err
 		}
+		for _, name := range names {
+			if err := tx.DeleteBucket(name); err != nil {
+				return err
+			}
+		}
 		_, err := tx.CreateBucket(boltBucket)
 		return err
 	})
 }
+
+func (s *boltStore) Compact() error {
+	return ErrNotSupported
+}
+
+func (s *boltStore) SetAsync(key, value []byte, cb func(error)) {
+	cb(s.Set(key, value))
+}
+
+// SetNS maps namespaces to buckets. Bucket names start with 'n', so they never
+// clash with the bucket of the default keyspace.
+func (s *boltStore) SetNS(ns, key, value []byte) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists(boltNSBucket(ns))
+		if err != nil {
+			return err
+		}
+		return b.Put(key, value)
+	})
+}
+
+func (s *boltStore) GetNS(ns, key []byte) ([]byte, bool, error) {
+	var v []byte
+	err := s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(boltNSBucket(ns))
+		if b == nil {
+			return nil
+		}
+		if value := b.Get(key); value != nil {
+			v = bcopy(value)
+		}
+		return nil
+	})
+	return v, v != nil, err
+}
+
+func (s *boltStore) DelNS(ns, key []byte) (bool, error) {
+	var v []byte
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(boltNSBucket(ns))
+		if b == nil {
+			return nil
+		}
+		v = b.Get(key)
+		return b.Delete(key)
+	})
+	return v != nil, err
+}
+
+func boltNSBucket(ns []byte) []byte {
+	r := make([]byte, len(ns)+1)
+	r[0] = 'n'
+	copy(r[1:], ns)
+	return r
+}
+
+func (s *boltStore) Merge(key, value []byte) error {
+	return mergeWith(s.update, key, value)
+}
+
+// update reads and writes key in one write transaction, which bolt runs one
+// at a time.
+func (s *boltStore) update(key []byte, fn updateFunc) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(boltBucket)
+		old := b.Get(boltKey(key))
+		v, err := fn(old, old != nil)
+		if err != nil || v == nil {
+			return err
+		}
+		return b.Put(boltKey(key), v)
+	})
+}
+
+func (s *boltStore) Incr(key []byte, delta int64) (int64, error) {
+	return incrWith(s.update, key, delta)
+}
+
+func (s *boltStore) CAS(key, oldValue, newValue []byte) (bool, error) {
+	return casWith(s.update, key, oldValue, newValue)
+}
+
+func (s *boltStore) SetWithTTL(key, value []byte, ttl time.Duration) error {
+	return ErrNotSupported
+}
+
+func (s *boltStore) Ping() error {
+	return nil
+}
+
+// boltSnapshot is a read transaction held open. Goroutines may share it: a
+// read-only bucket only reads the mmap, though its cursors bump the
+// transaction's statistics without synchronization.
+type boltSnapshot struct {
+	tx *bolt.Tx
+	b  *bolt.Bucket
+}
+
+// Snapshot begins a read transaction. While it is open, the pages it reads
+// cannot be reused, and a write that has to grow the file waits for it.
+func (s *boltStore) Snapshot() (Snapshot, error) {
+	tx, err := s.db.Begin(false)
+	if err != nil {
+		return nil, err
+	}
+	return boltSnapshot{tx, tx.Bucket(boltBucket)}, nil
+}
+
+func (s boltSnapshot) Get(key []byte) ([]byte, bool, error) {
+	v := s.b.Get(boltKey(key))
+	if v == nil {
+		return nil, false, nil
+	}
+	// the value must outlive the transaction
+	return bcopy(v), true, nil
+}
+
+func (s boltSnapshot) RangeScan(start, end []byte, limit int, withvals bool) ([][]byte, [][]byte, error) {
+	keys, vals := boltRangeScan(s.b, start, end, limit, withvals)
+	return keys, vals, nil
+}
+
+func (s boltSnapshot) Close() error {
+	return s.tx.Rollback()
+}
+
+func (s *boltStore) Capabilities() Capability {
+	return CapKeys | CapOrdered | CapPersistent | CapTransactions | CapSnapshots | CapCAS | CapMerge | CapBackup
+}
@@ -131,6 +131,12 @@ func (s *btreeStore) Get(key []byte) ([]byte, bool, error) {
 	return v.(*btreeItem).value, true, nil
 }
 
+func (s *btreeStore) Has(key []byte) (bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.tr.Get(&btreeItem{string(key), nil}) != nil, nil
+}
+
 func (s *btreeStore) Del(key []byte) (bool, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
@@ -145,6 +151,26 @@ func (s *btreeStore) Del(key []byte) (bool, error) {
 	return v != nil, nil
 }
 
+func (s *btreeStore) PDel(keys [][]byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.aof != nil {
+		s.aof.BeginBuffer()
+		for i := range keys {
+			if s.tr.Get(&btreeItem{string(keys[i]), nil}) != nil {
+				s.aof.AppendBuffer([]byte("del"), keys[i])
+			}
+		}
+		if err := s.aof.WriteBuffer(); err != nil {
+			return err
+		}
+	}
+	for i := range keys {
+		s.tr.Delete(&btreeItem{string(keys[i]), nil})
+	}
+	return nil
+}
+
 func (s *btreeStore) Keys(pattern []byte, limit int, withvalues bool) ([][]byte, [][]byte, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
@@ -177,6 +203,57 @@ func (s *btreeStore) Keys(pattern []byte, limit int, withvalues bool) ([][]byte,
 	return keys, vals, nil
 }
 
+func (s *btreeStore) AllKeys(limit int, withvals bool) ([][]byte, [][]byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var keys [][]byte
+	var vals [][]byte
+	s.tr.Ascend(&btreeItem{}, func(v any) bool {
+		if limit > 0 && len(keys) >= limit {
+			return false
+		}
+		a := v.(*btreeItem)
+		keys = append(keys, []byte(a.key))
+		if withvals {
+			vals = append(vals, bcopy(a.value))
+		}
+		return true
+	})
+	return keys, vals, nil
+}
+
+func (s *btreeStore) RangeScan(start, end []byte, limit int, withvals bool) ([][]byte, [][]byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var keys [][]byte
+	var vals [][]byte
+	s.tr.Ascend(&btreeItem{key: string(start)}, func(v any) bool {
+		if limit > 0 && len(keys) >= limit {
+			return false
+		}
+		a := v.(*btreeItem)
+		if end != nil && a.key >= string(end) {
+			return false
+		}
+		keys = append(keys, []byte(a.key))
+		if withvals {
+			vals = append(vals, bcopy(a.value))
+		}
+		return true
+	})
+	return keys, vals, nil
+}
+
+func (s *btreeStore) Snapshot() (Snapshot, error) {
+	return nil, ErrNotSupported
+}
+
+func (s *btreeStore) Count() (int64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return int64(s.tr.Len()), nil
+}
+
 func (s *btreeStore) FlushDB() error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
@@ -188,3 +265,60 @@ func (s *btreeStore) FlushDB() error {
 	s.tr = btree.New(byKeys)
 	return nil
 }
+
+func (s *btreeStore) Compact() error {
+	return ErrNotSupported
+}
+
+func (s *btreeStore) SetAsync(key, value []byte, cb func(error)) {
+	cb(s.Set(key, value))
+}
+
+func (s *btreeStore) Merge(key, value []byte) error {
+	return mergeWith(s.update, key, value)
+}
+
+// update reads and writes key under the write lock.
+func (s *btreeStore) update(key []byte, fn updateFunc) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var old []byte
+	item := s.tr.Get(&btreeItem{string(key), nil})
+	if item != nil {
+		old = item.(*btreeItem).value
+	}
+	v, err := fn(old, item != nil)
+	if err != nil || v == nil {
+		return err
+	}
+	if s.aof != nil {
+		if err := s.aof.Write([]byte("set"), key, v); err != nil {
+			return err
+		}
+	}
+	s.tr.Set(&btreeItem{string(key), bcopy(v)})
+	return nil
+}
+
+func (s *btreeStore) Incr(key []byte, delta int64) (int64, error) {
+	return incrWith(s.update, key, delta)
+}
+
+func (s *btreeStore) CAS(key, oldValue, newValue []byte) (bool, error) {
+	return casWith(s.update, key, oldValue, newValue)
+}
+
+func (s *btreeStore) SetWithTTL(key, value []byte, ttl time.Duration) error {
+	return ErrNotSupported
+}
+
+func (s *btreeStore) Ping() error {
+	return nil
+}
+
+func (s *btreeStore) Capabilities() Capability {
+	if s.aof != nil {
+		return CapKeys | CapOrdered | CapPersistent | CapCAS | CapMerge
+	}
+	return CapKeys | CapOrdered | CapCAS | CapMerge
+}
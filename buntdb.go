@@ -2,13 +2,15 @@ package kvbench
 
 import (
 	"sync"
+	"time"
 
 	"github.com/tidwall/buntdb"
 )
 
 type buntdbStore struct {
-	mu sync.RWMutex
-	db *buntdb.DB
+	mu     sync.RWMutex
+	db     *buntdb.DB
+	memory bool
 }
 
 func buntdbKey(key []byte) []byte {
@@ -31,7 +33,8 @@ func NewBuntdbStore(path string, fsync bool) (Store, error) {
 	db.SetConfig(opts)
 
 	return &buntdbStore{
-		db: db,
+		db:     db,
+		memory: path == ":memory:",
 	}, nil
 }
 
@@ -93,6 +96,19 @@ func (s *buntdbStore) Get(key []byte) ([]byte, bool, error) {
 	return v, v != nil, err
 }
 
+func (s *buntdbStore) Has(key []byte) (bool, error) {
+	var ok bool
+	err := s.db.View(func(tx *buntdb.Tx) error {
+		_, err := tx.Get(string(key))
+		if err == buntdb.ErrNotFound {
+			return nil
+		}
+		ok = err == nil
+		return err
+	})
+	return ok, err
+}
+
 func (s *buntdbStore) Del(key []byte) (bool, error) {
 	err := s.db.Update(func(tx *buntdb.Tx) error {
 		_, err := tx.Delete(string(key))
@@ -101,6 +117,17 @@ func (s *buntdbStore) Del(key []byte) (bool, error) {
 	return err == nil, err
 }
 
+func (s *buntdbStore) PDel(keys [][]byte) error {
+	return s.db.Update(func(tx *buntdb.Tx) error {
+		for _, k := range keys {
+			if _, err := tx.Delete(string(k)); err != nil && err != buntdb.ErrNotFound {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
 func (s *buntdbStore) Keys(pattern []byte, limit int, withvals bool) ([][]byte, [][]byte, error) {
 	var keys [][]byte
 	var vals [][]byte
@@ -118,8 +145,119 @@ func (s *buntdbStore) Keys(pattern []byte, limit int, withvals bool) ([][]byte,
 	return keys, vals, err
 }
 
+func (s *buntdbStore) AllKeys(limit int, withvals bool) ([][]byte, [][]byte, error) {
+	var keys [][]byte
+	var vals [][]byte
+	err := s.db.View(func(tx *buntdb.Tx) error {
+		return tx.Ascend("", func(key, value string) bool {
+			if limit > 0 && len(keys) >= limit {
+				return false
+			}
+			keys = append(keys, []byte(key))
+			if withvals {
+				vals = append(vals, []byte(value))
+			}
+			return true
+		})
+	})
+	return keys, vals, err
+}
+
+// RangeScan iterates AscendRange, or AscendGreaterOrEqual without end.
+func (s *buntdbStore) RangeScan(start, end []byte, limit int, withvals bool) ([][]byte, [][]byte, error) {
+	var keys [][]byte
+	var vals [][]byte
+	iter := func(key, value string) bool {
+		if limit > 0 && len(keys) >= limit {
+			return false
+		}
+		keys = append(keys, []byte(key))
+		if withvals {
+			vals = append(vals, []byte(value))
+		}
+		return true
+	}
+	err := s.db.View(func(tx *buntdb.Tx) error {
+		if end == nil {
+			return tx.AscendGreaterOrEqual("", string(start), iter)
+		}
+		return tx.AscendRange("", string(start), string(end), iter)
+	})
+	return keys, vals, err
+}
+
+func (s *buntdbStore) Snapshot() (Snapshot, error) {
+	return nil, ErrNotSupported
+}
+
+func (s *buntdbStore) Count() (int64, error) {
+	var n int
+	err := s.db.View(func(tx *buntdb.Tx) error {
+		var err error
+		n, err = tx.Len()
+		return err
+	})
+	return int64(n), err
+}
+
 func (s *buntdbStore) FlushDB() error {
 	return s.db.Update(func(tx *buntdb.Tx) error {
 		return tx.DeleteAll()
 	})
 }
+
+func (s *buntdbStore) Compact() error {
+	return s.db.Shrink()
+}
+
+func (s *buntdbStore) SetAsync(key, value []byte, cb func(error)) {
+	cb(s.Set(key, value))
+}
+
+func (s *buntdbStore) Merge(key, value []byte) error {
+	return mergeWith(s.update, key, value)
+}
+
+// update reads and writes key in one write transaction, which buntdb runs
+// one at a time.
+func (s *buntdbStore) update(key []byte, fn updateFunc) error {
+	return s.db.Update(func(tx *buntdb.Tx) error {
+		old, err := tx.Get(string(key))
+		if err != nil && err != buntdb.ErrNotFound {
+			return err
+		}
+		v, err := fn([]byte(old), err == nil)
+		if err != nil || v == nil {
+			return err
+		}
+		_, _, err = tx.Set(string(key), string(v), nil)
+		return err
+	})
+}
+
+func (s *buntdbStore) Incr(key []byte, delta int64) (int64, error) {
+	return incrWith(s.update, key, delta)
+}
+
+func (s *buntdbStore) CAS(key, oldValue, newValue []byte) (bool, error) {
+	return casWith(s.update, key, oldValue, newValue)
+}
+
+func (s *buntdbStore) SetWithTTL(key, value []byte, ttl time.Duration) error {
+	return s.db.Update(func(tx *buntdb.Tx) error {
+		_, _, err := tx.Set(string(key), string(value), &buntdb.SetOptions{Expires: true, TTL: ttl})
+		return err
+	})
+}
+
+func (s *buntdbStore) Ping() error {
+	return nil
+}
+
+func (s *buntdbStore) Capabilities() Capability {
+	c := CapKeys | CapOrdered | CapTTL | CapTransactions | CapCAS | CapMerge | CapBackup
+	if !s.memory {
+		c |= CapCompact | CapPersistent
+	}
+	return c
+}
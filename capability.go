@@ -0,0 +1,72 @@
+package kvbench
+
+import "strings"
+
+// Capability is a set of features of a store, as returned by
+// Store.Capabilities. It describes the backend, so a store may support a
+// feature that the Store interface does not expose (yet), e.g. TTL.
+type Capability uint32
+
+const (
+	// CapKeys means Keys is supported.
+	CapKeys Capability = 1 << iota
+	// CapOrdered means keys are iterated in key order.
+	CapOrdered
+	// CapCompact means Compact reclaims space.
+	CapCompact
+	// CapAsync means SetAsync returns before the write is committed.
+	CapAsync
+	// CapPersistent means the data survives a restart.
+	CapPersistent
+	// CapTTL means entries can expire.
+	CapTTL
+	// CapTransactions means several operations can commit atomically.
+	CapTransactions
+	// CapSnapshots means consistent point-in-time reads are available.
+	CapSnapshots
+	// CapCAS means CAS is supported.
+	CapCAS
+	// CapBackup means the store can be copied while open.
+	CapBackup
+	// CapMerge means Merge is supported.
+	CapMerge
+)
+
+// AllCapabilities lists every capability in the order String names them.
+var AllCapabilities = []Capability{
+	CapKeys, CapOrdered, CapCompact, CapAsync, CapPersistent,
+	CapTTL, CapTransactions, CapSnapshots, CapCAS, CapBackup, CapMerge,
+}
+
+var capabilityNames = []string{
+	"keys",
+	"ordered",
+	"compact",
+	"async",
+	"persistent",
+	"ttl",
+	"transactions",
+	"snapshots",
+	"cas",
+	"backup",
+	"merge",
+}
+
+// Has reports whether c includes all of f.
+func (c Capability) Has(f Capability) bool {
+	return c&f == f
+}
+
+// String lists the names of the capabilities in c, e.g. "keys,ordered".
+func (c Capability) String() string {
+	var names []string
+	for i, name := range capabilityNames {
+		if c.Has(1 << i) {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return "none"
+	}
+	return strings.Join(names, ",")
+}
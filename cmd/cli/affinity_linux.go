@@ -0,0 +1,52 @@
+package main
+
+import (
+	"os"
+	"runtime"
+	"sort"
+	"strconv"
+
+	"golang.org/x/sys/unix"
+)
+
+// setCPUAffinity pins every thread of the process to cpus and returns the
+// CPUs the calling thread ended up allowed on. sched_setaffinity applies to
+// a single thread, and threads inherit the mask of the thread that creates
+// them, so the runtime's existing threads are all pinned while the calling
+// goroutine holds its own; threads started later for the benchmark
+// goroutines inherit the mask.
+func setCPUAffinity(cpus []int) ([]int, error) {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	var set unix.CPUSet
+	for _, cpu := range cpus {
+		set.Set(cpu)
+	}
+	tasks, err := os.ReadDir("/proc/self/task")
+	if err != nil {
+		return nil, err
+	}
+	for _, task := range tasks {
+		tid, err := strconv.Atoi(task.Name())
+		if err != nil {
+			continue
+		}
+		// threads may exit while we walk them
+		if err := unix.SchedSetaffinity(tid, &set); err != nil && err != unix.ESRCH {
+			return nil, err
+		}
+	}
+
+	if err := unix.SchedGetaffinity(0, &set); err != nil {
+		return nil, err
+	}
+	var used []int
+	for _, cpu := range cpus {
+		if set.IsSet(cpu) {
+			used = append(used, cpu)
+		}
+	}
+	sort.Ints(used)
+	return used, nil
+}
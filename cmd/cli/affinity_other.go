@@ -0,0 +1,10 @@
+//go:build !linux
+
+package main
+
+import "errors"
+
+// setCPUAffinity is only implemented with sched_setaffinity on Linux.
+func setCPUAffinity(cpus []int) ([]int, error) {
+	return nil, errors.New("-cpu-affinity is only supported on linux")
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/smallnest/kvbench"
+)
+
+// batchDelSize is the number of keys of one PDel call.
+const batchDelSize = 1000
+
+// batchDelKeyBase is the first key index written by the batch delete test,
+// far from the indexes of the other phases.
+const batchDelKeyBase = 1 << 53
+
+// test batched deletes. Del has already removed most of the loaded keys, so
+// every goroutine writes a batch of new keys with PSet and deletes it with
+// PDel, until -d has passed. Only the PDel calls are timed, and the rate is
+// the sum of the rates of the goroutines.
+func testBatchDelete(record *Record, name string, store kvbench.Store) {
+	var wg sync.WaitGroup
+	wg.Add(*c)
+
+	p := newPhase(record, name, "Batch del")
+	defer p.stop()
+
+	counts := make([]int, *c)
+	durs := make([]time.Duration, *c)
+	errs := make([]error, *c)
+	for j := 0; j < *c; j++ {
+		index := uint64(j)
+		go func() {
+			defer wg.Done()
+			keys := make([][]byte, batchDelSize)
+			vals := make([][]byte, batchDelSize)
+			for k := range vals {
+				vals[k] = data
+			}
+			next := batchDelKeyBase + index
+		LOOP:
+			for {
+				select {
+				case <-p.done():
+					break LOOP
+				default:
+					for k := range keys {
+						keys[k] = genKey(next)
+						next += uint64(*c)
+					}
+					err := store.PSet(keys, vals)
+					if errors.Is(err, kvbench.ErrFault) {
+						// an injected fault fails the batch but not the run
+						continue
+					}
+					if err != nil {
+						errs[index] = err
+						return
+					}
+					start := time.Now()
+					err = store.PDel(keys)
+					durs[index] += time.Since(start)
+					if errors.Is(err, kvbench.ErrFault) {
+						continue
+					}
+					if err != nil {
+						errs[index] = err
+						return
+					}
+					counts[index] += len(keys)
+					p.tick(index, len(keys))
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	var rate float64
+	for j := range counts {
+		if errs[j] != nil {
+			fmt.Printf("%s batch del error: %v\n", name, errs[j])
+			record.add("Batch del op/s", "op/s", -1)
+			return
+		}
+		if durs[j] > 0 {
+			rate += float64(counts[j]) / durs[j].Seconds()
+		}
+	}
+	fmt.Printf("%s batch del rate: %d op/s, batch size: %d\n", name, int64(rate), batchDelSize)
+	record.add("Batch del op/s", "op/s", int(rate))
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/smallnest/kvbench"
+)
+
+// zipfS is the skew of the Zipfian key distribution of the cache test.
+const zipfS = 1.1
+
+type cacheStats interface {
+	CacheStats() (hits, misses, evictions int64)
+}
+
+// test a cache (lru, freecache) under a Zipfian workload: every goroutine
+// gets keys drawn from -set keys with skew zipfS, and sets the ones that
+// miss, as a cache-aside client would. The hit ratio and evictions come from
+// the store. Stores that are no cache record -1.
+func testCache(record *Record, name string, store kvbench.Store) {
+	cs, ok := store.(cacheStats)
+	if !ok || *setCount < 2 {
+		record.add("Zipf op/s", "op/s", -1)
+		record.add("Zipf hit ratio(%)", "%", -1)
+		record.add("Zipf evictions", "", -1)
+		return
+	}
+	hits0, misses0, evictions0 := cs.CacheStats()
+
+	var wg sync.WaitGroup
+	wg.Add(*c)
+
+	p := newPhase(record, name, "Zipf")
+	defer p.stop()
+
+	counts := make([]int, *c)
+	start := time.Now()
+	for j := 0; j < *c; j++ {
+		index := uint64(j)
+		go func() {
+			var count int
+			zipf := rand.NewZipf(newRand(index), zipfS, 1, uint64(*setCount-1))
+		LOOP:
+			for {
+				select {
+				case <-p.done():
+					break LOOP
+				default:
+					key := zipfKey(zipf.Uint64())
+					if _, ok, _ := store.Get(key); !ok {
+						store.Set(key, data)
+					}
+					count++
+					p.tick(index, 1)
+				}
+			}
+			counts[index] = count
+			wg.Done()
+		}()
+	}
+	wg.Wait()
+	dur := time.Since(start)
+	d := int64(dur)
+	var n int
+	for _, count := range counts {
+		n += count
+	}
+	hits, misses, evictions := cs.CacheStats()
+	hits, misses, evictions = hits-hits0, misses-misses0, evictions-evictions0
+	ratio := -1
+	if hits+misses > 0 {
+		ratio = int(hits * 100 / (hits + misses))
+	}
+	fmt.Printf("%s zipf rate: %d op/s, hit ratio: %d%%, evictions: %d, took: %d s\n", name, int64(n)*1e6/(d/1e3), ratio, evictions, int(dur.Seconds()))
+	record.add("Zipf op/s", "op/s", int(int64(n)*1e6/(d/1e3)))
+	record.add("Zipf hit ratio(%)", "%", ratio)
+	record.add("Zipf evictions", "", int(evictions))
+}
+
+// zipfKey returns the key of rank i of the cache test, after the -key-prefix.
+func zipfKey(i uint64) []byte {
+	k := make([]byte, len(*keyPrefix)+9)
+	r := k[copy(k, *keyPrefix):]
+	r[0] = 'z'
+	binary.BigEndian.PutUint64(r[1:], i)
+	return k
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/smallnest/kvbench"
+)
+
+// capabilityStores are the store types listed by -capabilities. s3, tikv
+// and jetstream are left out, since opening them needs a server.
+var capabilityStores = []string{
+	"badger", "badger-managed", "badgerv4", "bbolt", "bolt", "btree", "buntdb",
+	"freecache", "grpc", "hlog", "kv", "leveldb", "lru", "map", "memdb",
+	"nutsdb", "pebble", "pogreb", "redis", "resp", "slotfile", "sqlite",
+}
+
+// printCapabilities opens each of stores in a temporary directory and writes
+// a table of the capabilities it reports, one row per store.
+func printCapabilities(w io.Writer, stores []string, fsync bool) error {
+	wd, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	dir, err := os.MkdirTemp("", "kvbench-caps")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	if err := os.Chdir(dir); err != nil {
+		return err
+	}
+	defer os.Chdir(wd)
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	header := []string{"store"}
+	for _, f := range kvbench.AllCapabilities {
+		header = append(header, f.String())
+	}
+	fmt.Fprintln(tw, strings.Join(header, "\t"))
+	for _, s := range stores {
+		store, _, err := getStore(s, fsync, "")
+		if err != nil {
+			return fmt.Errorf("%s: %w", s, err)
+		}
+		caps := store.Capabilities()
+		store.Close()
+		row := []string{s}
+		for _, f := range kvbench.AllCapabilities {
+			if caps.Has(f) {
+				row = append(row, "x")
+			} else {
+				row = append(row, "-")
+			}
+		}
+		fmt.Fprintln(tw, strings.Join(row, "\t"))
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/smallnest/kvbench"
+)
+
+// casKeys is the number of keys all goroutines of the cas test share.
+const casKeys = 100
+
+// test compare-and-swap writes of keys that every goroutine shares: each
+// goroutine moves a key from the version it last read to the next one, and
+// reads the key again when another goroutine got there first. CAS wins is
+// the percentage of CAS calls that swapped. Stores without CAS record -1.
+func testCAS(record *Record, name string, store kvbench.Store) {
+	keys := make([][]byte, casKeys)
+	for k := range keys {
+		keys[k] = []byte(*keyPrefix + "cas-" + strconv.Itoa(k))
+	}
+	if _, err := store.CAS(keys[0], nil, casVersion(0)); errors.Is(err, kvbench.ErrNotSupported) {
+		fmt.Printf("%s cas rate: %d op/s, wins: %d%%\n", name, -1, -1)
+		record.add("CAS op/s", "op/s", -1)
+		record.add("CAS wins(%)", "%", -1)
+		return
+	} else if err != nil {
+		panic(err)
+	}
+
+	p := newPhase(record, name, "CAS")
+	n, wins, dur := runCAS(p, store, keys)
+	p.stop()
+
+	rate := int64(float64(n) / dur.Seconds())
+	ratio := -1
+	if n > 0 {
+		ratio = wins * 100 / n
+	}
+	fmt.Printf("%s cas rate: %d op/s, wins: %d%%\n", name, rate, ratio)
+	record.add("CAS op/s", "op/s", int(rate))
+	record.add("CAS wins(%)", "%", ratio)
+}
+
+// casVersion encodes version n of a cas test key.
+func casVersion(n uint64) []byte {
+	v := make([]byte, 8)
+	binary.BigEndian.PutUint64(v, n)
+	return v
+}
+
+// runCAS swaps keys from *c goroutines, each starting at its own key, until
+// p is done and returns the number of CAS calls and of those that swapped.
+func runCAS(p *phase, store kvbench.Store, keys [][]byte) (int, int, time.Duration) {
+	var wg sync.WaitGroup
+	wg.Add(*c)
+
+	counts := make([]int, *c)
+	wins := make([]int, *c)
+	start := time.Now()
+	for j := 0; j < *c; j++ {
+		index := uint64(j)
+		go func() {
+			defer wg.Done()
+			// the value of each key as this goroutine last read it, nil
+			// for absent
+			seen := make([][]byte, len(keys))
+			var count, won int
+			i := int(index)
+		LOOP:
+			for {
+				select {
+				case <-p.done():
+					break LOOP
+				default:
+					k := i % len(keys)
+					next := casVersion(1)
+					if seen[k] != nil {
+						next = casVersion(binary.BigEndian.Uint64(seen[k]) + 1)
+					}
+					t := p.sampleStart(index)
+					swapped, err := store.CAS(keys[k], seen[k], next)
+					p.sample(index, t, "cas", false)
+					if err != nil {
+						panic(err)
+					}
+					count++
+					if swapped {
+						won++
+						seen[k] = next
+					} else if v, ok, err := store.Get(keys[k]); err != nil {
+						panic(err)
+					} else if ok {
+						seen[k] = v
+					} else {
+						seen[k] = nil
+					}
+					i++
+					p.tick(index, 1)
+				}
+			}
+			counts[index] = count
+			wins[index] = won
+		}()
+	}
+	wg.Wait()
+	dur := time.Since(start)
+
+	var n, won int
+	for j := range counts {
+		n += counts[j]
+		won += wins[j]
+	}
+	return n, won, dur
+}
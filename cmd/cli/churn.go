@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/smallnest/kvbench"
+)
+
+// test deleting loaded keys and setting them again at once until -d has
+// passed. The Del phase deletes every key once; LSM stores pay for repeated
+// churn, which piles up tombstones and shadowed values until compactions
+// drop them, so the rate and the disk growth here show how well a store
+// keeps up. Each goroutine cycles through its share of the loaded keys, and
+// an op is one Del and one Set. The disk growth is -1 for stores not on
+// disk.
+func testChurn(record *Record, name string, store kvbench.Store, path string) {
+	before, onDisk := diskUsage(path)
+
+	p := newPhase(record, name, "Churn")
+	n, failed, dur := runChurn(p, store)
+	p.stop()
+
+	growth := -1
+	if after, ok := diskUsage(path); ok && onDisk {
+		growth = int((after - before) / 1024 / 1024)
+	}
+	d := int64(dur)
+	rate := int64(n) * 1e6 / (d / 1e3)
+	fmt.Printf("%s churn rate: %d op/s, disk growth: %d MiB, took: %d s, errors: %d\n", name, rate, growth, int(dur.Seconds()), failed)
+	record.add("Churn op/s", "op/s", int(rate))
+	record.add("Churn disk growth(MiB)", "MiB", growth)
+	record.add("Churn errors", "", failed)
+	warnErrors(name, "churn", failed, n)
+}
+
+// runChurn deletes and sets again the loaded keys from *c goroutines until
+// p is done and returns the number of ops, those that failed and the time
+// taken.
+func runChurn(p *phase, store kvbench.Store) (int, int, time.Duration) {
+	var wg sync.WaitGroup
+	wg.Add(*c)
+
+	count := uint64(*setCount)
+	if count == 0 {
+		count = 1
+	}
+	counts := make([]int, *c)
+	errs := make([]int, *c)
+	start := time.Now()
+	for j := 0; j < *c; j++ {
+		index := uint64(j)
+		go func() {
+			var n, failed int
+			i := index % count
+		LOOP:
+			for {
+				select {
+				case <-p.done():
+					break LOOP
+				default:
+					key := genKey(i)
+					v := workload.value(i)
+					if v == nil {
+						v = values[:sizes.pick(i)]
+					}
+					if _, err := store.Del(key); err != nil {
+						failed++
+					} else if err := store.Set(key, v); err != nil {
+						failed++
+					}
+					p.wrote(len(v))
+					if i += uint64(*c); i >= count {
+						i = index % count
+					}
+					n++
+					p.tick(index, 1)
+				}
+			}
+			counts[index] = n
+			errs[index] = failed
+			wg.Done()
+		}()
+	}
+	wg.Wait()
+	dur := time.Since(start)
+	var n, failed int
+	for j := range counts {
+		n += counts[j]
+		failed += errs[j]
+	}
+	return n, failed, dur
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// compareThreshold is the change in percent below which -compare counts a
+// metric as unchanged.
+const compareThreshold = 5
+
+// resultRow is one row of a result CSV, by column header.
+type resultRow struct {
+	name   string
+	values map[string]string
+}
+
+// readResults reads a CSV written with -save and returns its headers and
+// rows by store name, the last row winning when a store was run twice. The
+// unit row written with -units is skipped.
+func readResults(path string) ([]string, map[string]resultRow, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+	r := csv.NewReader(f)
+	r.FieldsPerRecord = -1
+	records, err := r.ReadAll()
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if len(records) == 0 {
+		return nil, nil, fmt.Errorf("%s: empty", path)
+	}
+	headers := records[0]
+	rows := make(map[string]resultRow)
+	for _, rec := range records[1:] {
+		if len(rec) == 0 || rec[0] == "" {
+			continue
+		}
+		row := resultRow{name: rec[0], values: make(map[string]string)}
+		for i, v := range rec {
+			if i < len(headers) {
+				row.values[headers[i]] = v
+			}
+		}
+		rows[row.name] = row
+	}
+	return headers, rows, nil
+}
+
+// higherIsBetter reports whether an increase of the metric is an
+// improvement. Rates, ratios and gains are; costs, latencies, sizes and
+// error counts are not.
+func higherIsBetter(header string) bool {
+	h := strings.ToLower(header)
+	for _, s := range []string{"op/s", "hit ratio", "reclaimed", "gain", "readable"} {
+		if strings.Contains(h, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// compareResults writes, for every store in both a and b, the change of
+// each numeric metric from a to b, followed by a count of improvements and
+// regressions of more than compareThreshold percent. Metrics that are -1
+// (unsupported) or 0 in a are left out.
+func compareResults(w io.Writer, a, b string, color bool) error {
+	headers, rowsA, err := readResults(a)
+	if err != nil {
+		return err
+	}
+	_, rowsB, err := readResults(b)
+	if err != nil {
+		return err
+	}
+	paint := func(s string, code int) string {
+		if !color {
+			return s
+		}
+		return fmt.Sprintf("\x1b[%dm%s\x1b[0m", code, s)
+	}
+
+	var improved, regressed, compared int
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "store\tmetric\t%s\t%s\tdelta\n", a, b)
+	var names []string
+	for name := range rowsA {
+		if _, ok := rowsB[name]; ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for _, h := range headers[1:] {
+			va, errA := strconv.ParseFloat(rowsA[name].values[h], 64)
+			vb, errB := strconv.ParseFloat(rowsB[name].values[h], 64)
+			if errA != nil || errB != nil || va == -1 || vb == -1 || va == 0 {
+				continue
+			}
+			delta := (vb - va) * 100 / va
+			cell := fmt.Sprintf("%+.1f%%", delta)
+			better := delta > 0 == higherIsBetter(h)
+			switch {
+			case delta > -compareThreshold && delta < compareThreshold:
+			case better:
+				improved++
+				cell = paint(cell, 32)
+			default:
+				regressed++
+				cell = paint(cell, 31)
+			}
+			compared++
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", name, h, rowsA[name].values[h], rowsB[name].values[h], cell)
+		}
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "%d stores, %d metrics compared: %d improved, %d regressed by more than %d%%\n",
+		len(names), compared, improved, regressed, compareThreshold)
+	return nil
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// diskFullTimeout is how long a single write or read of the disk full test
+// may take before the store counts as hung.
+const diskFullTimeout = 30 * time.Second
+
+// test how a store behaves when its disk fills up. A second instance of the
+// store is opened in -disk-full, which should be a small filesystem created
+// for it (e.g. a tmpfs mounted with size=64m), and written until a write
+// fails, hangs for diskFullTimeout or -d has passed. The test records the
+// writes that succeeded, whether the store returned an error or hung, and
+// whether the written keys still read back afterwards.
+func testDiskFull(record *Record, name string, memory bool) {
+	if *diskFull == "" {
+		return
+	}
+	base := (*s)[strings.LastIndex(*s, ":")+1:]
+	if memory || isRemoteOrMemory(base) {
+		fmt.Printf("%s disk full: not a local disk store\n", name)
+		recordDiskFull(record, -1, -1, -1, -1)
+		return
+	}
+	path := filepath.Join(*diskFull, "kvbench-diskfull-"+base+".db")
+	defer os.RemoveAll(path)
+	store, _, err := getStore(*s, *fsync, path)
+	if err != nil {
+		panic(err)
+	}
+
+	var ops int
+	var writeErr error
+	var written [][]byte
+	hung := false
+	deadline := time.Now().Add(*duration)
+	for i := uint64(0); time.Now().Before(deadline) && !interrupted(); i++ {
+		key := genKey(i)
+		if !withTimeout(func() { writeErr = store.Set(key, data) }) {
+			hung = true
+			break
+		}
+		if writeErr != nil {
+			break
+		}
+		ops++
+		// keep a few keys across the run to read back
+		if ops&(ops-1) == 0 {
+			written = append(written, key)
+		}
+	}
+
+	readable := 0
+	if !hung {
+		readable = 1
+		for _, key := range written {
+			var ok bool
+			var err error
+			if !withTimeout(func() { _, ok, err = store.Get(key) }) {
+				hung = true
+				readable = 0
+				break
+			}
+			if err != nil || !ok {
+				readable = 0
+			}
+		}
+		if !hung {
+			withTimeout(func() { store.Close() })
+		}
+	}
+
+	switch {
+	case hung:
+		fmt.Printf("%s disk full: hung after %d writes\n", name, ops)
+	case writeErr != nil:
+		fmt.Printf("%s disk full: error after %d writes: %v, readable: %v\n", name, ops, writeErr, readable == 1)
+	default:
+		fmt.Printf("%s disk full: no error after %d writes within %s, readable: %v\n", name, ops, *duration, readable == 1)
+	}
+	clean := 0
+	if writeErr != nil {
+		clean = 1
+	}
+	hungFlag := 0
+	if hung {
+		hungFlag = 1
+	}
+	recordDiskFull(record, ops, clean, hungFlag, readable)
+}
+
+func recordDiskFull(record *Record, ops, clean, hung, readable int) {
+	record.add("DiskFull writes", "", ops)
+	record.add("DiskFull error", "", clean)
+	record.add("DiskFull hung", "", hung)
+	record.add("DiskFull readable", "", readable)
+}
+
+// withTimeout runs fn and reports whether it returned within
+// diskFullTimeout. A call that does not return is left running.
+func withTimeout(fn func()) bool {
+	done := make(chan struct{})
+	go func() {
+		fn()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-time.After(diskFullTimeout):
+		return false
+	}
+}
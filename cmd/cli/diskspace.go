@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// amplification is a rough ratio of the disk a store uses to the raw size of
+// the keys and values written into it, taken with some headroom from runs of
+// this benchmark. Stores not listed use defaultAmplification.
+var amplification = map[string]float64{
+	"map":            2,
+	"btree":          2,
+	"bolt":           2.5,
+	"bbolt":          2.5,
+	"leveldb":        1.5,
+	"pebble":         1.5,
+	"rocksdb":        1.5,
+	"badger":         2.5,
+	"badger-managed": 2.5,
+	"badgerv4":       2.5,
+	"buntdb":         2,
+	"pogreb":         2,
+	"nutsdb":         3,
+	"kv":             3,
+	"hlog":           2,
+	"sqlite":         2,
+	"moss":           3,
+	"slotfile":       2,
+}
+
+const defaultAmplification = 3
+
+// isRemoteOrMemory reports whether the store type base keeps its data
+// elsewhere or only in memory, even without a /memory suffix, so that it
+// writes nothing to a local disk.
+func isRemoteOrMemory(base string) bool {
+	switch base {
+	case "grpc", "resp", "redis", "s3", "tikv", "jetstream", "memdb", "lru", "freecache":
+		return true
+	}
+	return false
+}
+
+// checkDiskSpace fails if the set phase is expected to need more disk than is
+// available on the filesystem of dir. Memory-only and remote stores are not
+// checked.
+func checkDiskSpace(store string, dir string, memory bool) error {
+	// e.g. "delay:10ms:zstd:bolt" or "fault:0.01:bolt" is sized like bolt
+	base := store[strings.LastIndex(store, ":")+1:]
+	if memory || isRemoteOrMemory(base) {
+		return nil
+	}
+	avail, ok := availableDisk(dir)
+	if !ok {
+		return nil
+	}
+
+	factor := *amplificationFactor
+	if factor <= 0 {
+		if factor = amplification[base]; factor == 0 {
+			factor = defaultAmplification
+		}
+	}
+	keySize := meanKeySize()
+	need := uint64(float64(*setCount) * float64(keySize+sizes.mean()) * factor)
+	if need > avail {
+		return fmt.Errorf("not enough disk space for %d entries of %d bytes in %s: need about %d MiB (x%.1f amplification), %d MiB available; lower -set or -size, or pass -diskcheck=false",
+			*setCount, keySize+sizes.mean(), store, need/1024/1024, factor, avail/1024/1024)
+	}
+	return nil
+}
+
+// meanKeySize returns the mean length of the keys the load writes: the
+// -key-prefix and the 9 bytes of genKey, or the keys of the -keyfile after
+// the prefix.
+func meanKeySize() int {
+	if workload == nil {
+		return len(*keyPrefix) + 9
+	}
+	var sum int
+	for _, k := range workload.keys {
+		sum += len(k)
+	}
+	return len(*keyPrefix) + sum/len(workload.keys)
+}
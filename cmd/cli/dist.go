@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+)
+
+// readKeys picks the key indexes read by one goroutine of the get phases.
+// With -dist uniform it strides through the loaded keys from the goroutine's
+// index by *c; with -dist zipfian it draws ranks of the loaded keys with
+// skew -zipf-s, so the hot keys are the first ones loaded and always exist.
+type readKeys struct {
+	zipf     *rand.Zipf
+	start, i uint64
+}
+
+func newReadKeys(index uint64) *readKeys {
+	k := &readKeys{start: index, i: index}
+	if *dist == "zipfian" && *setCount > 1 {
+		k.zipf = rand.NewZipf(newRand(index), *zipfSkew, 1, uint64(*setCount-1))
+	}
+	return k
+}
+
+// next returns the index of the next key to read.
+func (k *readKeys) next() uint64 {
+	if k.zipf != nil {
+		return k.zipf.Uint64()
+	}
+	i := k.i
+	k.i += uint64(*c)
+	return i
+}
+
+// restart makes a uniform sequence start over, after a miss showed that it
+// ran past the loaded keys. Zipfian ranks never do.
+func (k *readKeys) restart() {
+	k.i = k.start
+}
+
+// distName describes -dist for the output and the KeyDist column.
+func distName() string {
+	if *dist == "zipfian" {
+		return fmt.Sprintf("zipfian(s=%g)", *zipfSkew)
+	}
+	return *dist
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/smallnest/kvbench"
+)
+
+// growthSamples is the number of keys whose Get latency the growth test
+// measures at every size.
+const growthSamples = 1000
+
+// growthKeyBase is the first key index written by the growth test, far from
+// the indexes of the other phases so it only adds new keys.
+const growthKeyBase = 1 << 48
+
+// test how Get latency changes as the store grows. For each size in -growth
+// the store is filled up to that many entries, counting the -set entries of
+// the load phase, and then the same sample of keys is read one at a time.
+func testGrowth(record *Record, name string, store kvbench.Store) {
+	if *growth == "" {
+		return
+	}
+	var sizes []int
+	for _, f := range strings.Split(*growth, ",") {
+		n, err := strconv.Atoi(strings.TrimSpace(f))
+		if err != nil || n < 1 {
+			panic(fmt.Errorf("invalid growth size: %v", f))
+		}
+		sizes = append(sizes, n)
+	}
+	sort.Ints(sizes)
+
+	var sample [][]byte
+	written := *setCount
+	next := uint64(growthKeyBase)
+	for _, n := range sizes {
+		start := time.Now()
+		for written < n {
+			checkInterrupt()
+			size := n - written
+			if size > *batch {
+				size = *batch
+			}
+			keyList := make([][]byte, size)
+			valList := make([][]byte, size)
+			for i := range keyList {
+				keyList[i] = genKey(next)
+				valList[i] = data
+				next++
+			}
+			if err := store.PSet(keyList, valList); err != nil {
+				panic(err)
+			}
+			// the sample is taken from the first keys written, so every
+			// size reads the same keys
+			for i := 0; i < len(keyList) && len(sample) < growthSamples; i++ {
+				sample = append(sample, keyList[i])
+			}
+			written += size
+		}
+		fill := time.Since(start)
+
+		latencies := make([]time.Duration, 0, len(sample))
+		var misses int
+		for _, k := range sample {
+			getStart := time.Now()
+			_, ok, err := store.Get(k)
+			latencies = append(latencies, time.Since(getStart))
+			if err != nil || !ok {
+				misses++
+			}
+		}
+		p50, p99 := time.Duration(-1), time.Duration(-1)
+		if len(latencies) > 0 {
+			sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+			p50, p99 = percentile(latencies, 50), percentile(latencies, 99)
+		}
+		fmt.Printf("%s growth to %d entries took: %s, get p50: %s, p99: %s, misses: %d/%d\n",
+			name, n, fill, p50, p99, misses, len(sample))
+		record.add(fmt.Sprintf("Size%d Get p50(ns)", n), "ns", int(p50.Nanoseconds()))
+		record.add(fmt.Sprintf("Size%d Get p99(ns)", n), "ns", int(p99.Nanoseconds()))
+	}
+}
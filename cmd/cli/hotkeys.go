@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/smallnest/kvbench"
+)
+
+// test all goroutines on the same -hotkeys loaded keys, getting them, or
+// setting them too in the ratio of -hotkeys-mix. The other phases spread
+// the goroutines over all the keys; here they contend for the same few, which
+// shows whether a store locks per key, per shard or once for all of them.
+func testHotKeys(record *Record, name string, store kvbench.Store) {
+	if *hotKeys <= 0 {
+		return
+	}
+	getFrac := 1.0
+	if *hotKeysMix != "" {
+		var err error
+		if getFrac, err = parseMix(*hotKeysMix); err != nil {
+			panic(err)
+		}
+	}
+	n := *hotKeys
+	if n > *setCount {
+		n = *setCount
+	}
+	if n < 1 {
+		n = 1
+	}
+	keys := make([][]byte, n)
+	for i := range keys {
+		keys[i] = genKey(uint64(i))
+	}
+
+	warmUp(name, "Hotkeys", func(p *phase) { runHotKeys(p, store, keys, getFrac) })
+	p := newPhase(record, name, "Hotkeys")
+	defer p.stop()
+	p.measureLatency()
+	ops, failed, dur := runHotKeys(p, store, keys, getFrac)
+
+	rate := int64(float64(ops) / dur.Seconds())
+	fmt.Printf("%s hotkeys rate: %d op/s on %d keys, took: %d s, errors: %d\n", name, rate, n, int(dur.Seconds()), failed)
+	record.add("Hotkeys op/s", "op/s", int(rate))
+	record.add("Hotkeys errors", "", failed)
+	warnErrors(name, "hotkeys", failed, ops)
+	p.addLatency()
+}
+
+// runHotKeys gets or sets keys from *c goroutines, a get with probability
+// getFrac, each goroutine cycling through all of keys from its own offset,
+// until p is done. It returns the number of calls and of those that failed.
+func runHotKeys(p *phase, store kvbench.Store, keys [][]byte, getFrac float64) (int, int, time.Duration) {
+	var wg sync.WaitGroup
+	wg.Add(*c)
+
+	counts := make([]int, *c)
+	errs := make([]int, *c)
+	start := time.Now()
+	for j := 0; j < *c; j++ {
+		index := uint64(j)
+		go func() {
+			defer wg.Done()
+			r := newRand(index)
+			var count, failed int
+			k := int(index) % len(keys)
+		LOOP:
+			for {
+				select {
+				case <-p.done():
+					break LOOP
+				default:
+					t := p.sampleStart(index)
+					if getFrac == 1 || r.Float64() < getFrac {
+						_, ok, err := store.Get(keys[k])
+						p.sample(index, t, "get", ok)
+						if err != nil {
+							failed++
+						}
+					} else {
+						v := workload.value(uint64(k))
+						if v == nil {
+							v = values[:sizes.pick(uint64(k))]
+						}
+						if store.Set(keys[k], v) != nil {
+							failed++
+						}
+						p.sample(index, t, "set", false)
+						p.wrote(len(v))
+					}
+					if k++; k == len(keys) {
+						k = 0
+					}
+					count++
+					p.tick(index, 1)
+				}
+			}
+			counts[index], errs[index] = count, failed
+		}()
+	}
+	wg.Wait()
+	dur := time.Since(start)
+
+	var n, failed int
+	for j := range counts {
+		n += counts[j]
+		failed += errs[j]
+	}
+	return n, failed, dur
+}
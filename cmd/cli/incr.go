@@ -0,0 +1,107 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/smallnest/kvbench"
+)
+
+// incrCounters is the number of counters all goroutines of the incr test
+// share.
+const incrCounters = 100
+
+// test atomic increments of counters that every goroutine shares, so that
+// increments of the same counter contend. Afterwards the counters must add
+// up to the successful Incr calls; Incr lost reports the difference, which
+// is not 0 when the store's Incr is not atomic. Stores without Incr record
+// -1.
+func testIncr(record *Record, name string, store kvbench.Store) {
+	keys := make([][]byte, incrCounters)
+	for k := range keys {
+		keys[k] = []byte(*keyPrefix + "incr-" + strconv.Itoa(k))
+	}
+	base, err := sumCounters(store, keys)
+	if errors.Is(err, kvbench.ErrNotSupported) {
+		fmt.Printf("%s incr rate: %d op/s, lost: %d\n", name, -1, -1)
+		record.add("Incr op/s", "op/s", -1)
+		record.add("Incr lost", "", -1)
+		return
+	}
+	if err != nil {
+		panic(err)
+	}
+
+	p := newPhase(record, name, "Incr")
+	n, dur := runIncr(p, store, keys)
+	p.stop()
+
+	total, err := sumCounters(store, keys)
+	if err != nil {
+		panic(err)
+	}
+	lost := int64(n) - (total - base)
+	rate := int64(float64(n) / dur.Seconds())
+	fmt.Printf("%s incr rate: %d op/s, lost: %d\n", name, rate, lost)
+	record.add("Incr op/s", "op/s", int(rate))
+	record.add("Incr lost", "", int(lost))
+}
+
+// sumCounters adds up the counters at keys, creating the missing ones.
+func sumCounters(store kvbench.Store, keys [][]byte) (int64, error) {
+	var sum int64
+	for _, k := range keys {
+		v, err := store.Incr(k, 0)
+		if err != nil {
+			return 0, err
+		}
+		sum += v
+	}
+	return sum, nil
+}
+
+// runIncr increments keys by 1 from *c goroutines, each starting at its own
+// counter, until p is done and returns the number of successful Incr calls.
+func runIncr(p *phase, store kvbench.Store, keys [][]byte) (int, time.Duration) {
+	var wg sync.WaitGroup
+	wg.Add(*c)
+
+	counts := make([]int, *c)
+	start := time.Now()
+	for j := 0; j < *c; j++ {
+		index := uint64(j)
+		go func() {
+			defer wg.Done()
+			var count int
+			i := int(index)
+		LOOP:
+			for {
+				select {
+				case <-p.done():
+					break LOOP
+				default:
+					t := p.sampleStart(index)
+					_, err := store.Incr(keys[i%len(keys)], 1)
+					p.sample(index, t, "incr", false)
+					if err == nil {
+						count++
+					}
+					i++
+					p.tick(index, 1)
+				}
+			}
+			counts[index] = count
+		}()
+	}
+	wg.Wait()
+	dur := time.Since(start)
+
+	var n int
+	for _, count := range counts {
+		n += count
+	}
+	return n, dur
+}
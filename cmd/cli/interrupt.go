@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// errInterrupted is the error of a run stopped by SIGINT or SIGTERM.
+var errInterrupted = errors.New("interrupted")
+
+// runCtx is the context every phase derives its own from. It is cancelled by
+// the first SIGINT or SIGTERM once handleInterrupts is installed, which ends
+// the current phase early and stops the run before the next one.
+var runCtx = context.Background()
+
+// handleInterrupts cancels runCtx on the first SIGINT or SIGTERM, so that the
+// run unwinds and main still closes the store, removes its files and saves
+// the partial row. A second signal kills the process as usual. The returned
+// function stops watching for signals.
+func handleInterrupts() func() {
+	ctx, cancel := context.WithCancel(context.Background())
+	runCtx = ctx
+	sig := make(chan os.Signal, 1)
+	stopped := make(chan struct{})
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		select {
+		case s := <-sig:
+			signal.Stop(sig)
+			fmt.Fprintf(os.Stderr, "%v: stopping the run, again to quit at once\n", s)
+			cancel()
+		case <-stopped:
+		}
+	}()
+	return func() {
+		signal.Stop(sig)
+		close(stopped)
+	}
+}
+
+// interrupted reports whether the run was interrupted.
+func interrupted() bool {
+	return runCtx.Err() != nil
+}
+
+// checkInterrupt panics with errInterrupted once the run is interrupted, for
+// runPhases to stop at the next phase. It must be called on the goroutine of
+// runPhases.
+func checkInterrupt() {
+	if interrupted() {
+		panic(errInterrupted)
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// keyFileWorkload is the keys of -keyfile, and their values where the file
+// has them, replayed by the load, set, get and delete phases in place of the
+// generated keys.
+type keyFileWorkload struct {
+	keys   [][]byte
+	values [][]byte // nil, or a nil entry, where the file has no value
+	valued int      // number of keys with a value
+	sorted [][]byte // the keys in order, for the Scan windows
+}
+
+// workload is the loaded -keyfile, nil without one.
+var workload *keyFileWorkload
+
+// key returns the key of index i, cycling through the file.
+func (w *keyFileWorkload) key(i uint64) []byte {
+	return w.keys[i%uint64(len(w.keys))]
+}
+
+// value returns the value the file has for the key of index i, or nil.
+func (w *keyFileWorkload) value(i uint64) []byte {
+	if w == nil || w.valued == 0 {
+		return nil
+	}
+	return w.values[i%uint64(len(w.keys))]
+}
+
+// scanEnd returns the key of the file scanWidth keys in order after the key
+// of index i, or nil past the last one.
+func (w *keyFileWorkload) scanEnd(i uint64) []byte {
+	key := w.key(i)
+	j := sort.Search(len(w.sorted), func(j int) bool { return bytes.Compare(w.sorted[j], key) >= 0 })
+	if j+scanWidth >= len(w.sorted) {
+		return nil
+	}
+	return append([]byte(*keyPrefix), w.sorted[j+scanWidth]...)
+}
+
+// loadKeyFile reads a -keyfile in the -keyfile-format: lines, one key per
+// line with an optional value after a tab, or lenprefix, records of a key
+// and a value each preceded by its length as a big-endian uint32, a value
+// of length 0 meaning none. A path ending in .gz is decompressed.
+func loadKeyFile(path, format string) (*keyFileWorkload, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var r io.Reader = bufio.NewReaderSize(f, 1<<20)
+	if strings.HasSuffix(path, ".gz") {
+		zr, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		defer zr.Close()
+		r = bufio.NewReaderSize(zr, 1<<20)
+	}
+	w := &keyFileWorkload{}
+	switch format {
+	case "lines":
+		err = w.readLines(r)
+	case "lenprefix":
+		err = w.readLenPrefixed(r)
+	default:
+		return nil, fmt.Errorf("unknown -keyfile-format: %v", format)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if len(w.keys) == 0 {
+		return nil, fmt.Errorf("%s: no keys", path)
+	}
+	if w.valued == 0 {
+		w.values = nil
+	}
+	w.sorted = append([][]byte(nil), w.keys...)
+	sort.Slice(w.sorted, func(i, j int) bool { return bytes.Compare(w.sorted[i], w.sorted[j]) < 0 })
+	return w, nil
+}
+
+func (w *keyFileWorkload) add(key, value []byte) {
+	if len(value) > 0 {
+		w.valued++
+	} else {
+		value = nil
+	}
+	w.keys = append(w.keys, key)
+	w.values = append(w.values, value)
+}
+
+// readLines reads keys one per line, skipping empty lines.
+func (w *keyFileWorkload) readLines(r io.Reader) error {
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64*1024), 64<<20)
+	for n := 1; sc.Scan(); n++ {
+		line := bytes.TrimSuffix(sc.Bytes(), []byte("\r"))
+		if len(line) == 0 {
+			continue
+		}
+		key, value, _ := bytes.Cut(line, []byte("\t"))
+		if len(key) == 0 {
+			return fmt.Errorf("line %d: empty key", n)
+		}
+		w.add(append([]byte(nil), key...), append([]byte(nil), value...))
+	}
+	return sc.Err()
+}
+
+// readLenPrefixed reads length-prefixed key and value records until the end
+// of the input, which must fall between two records.
+func (w *keyFileWorkload) readLenPrefixed(r io.Reader) error {
+	for {
+		key, err := readLenPrefix(r)
+		if err == io.EOF {
+			return nil
+		}
+		if err == nil && len(key) == 0 {
+			err = errors.New("empty key")
+		}
+		var value []byte
+		if err == nil {
+			value, err = readLenPrefix(r)
+		}
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return fmt.Errorf("record %d: %w", len(w.keys)+1, err)
+		}
+		w.add(key, value)
+	}
+}
+
+// readLenPrefix reads a big-endian uint32 length and that many bytes. It
+// returns io.EOF only at the end of the input before the length.
+func readLenPrefix(r io.Reader) ([]byte, error) {
+	var n [4]byte
+	if _, err := io.ReadFull(r, n[:]); err != nil {
+		return nil, err
+	}
+	b := make([]byte, binary.BigEndian.Uint32(n[:]))
+	if _, err := io.ReadFull(r, b); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return b, nil
+}
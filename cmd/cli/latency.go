@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/bits"
+	"time"
+)
+
+// latencyEvery is how often the phases with latency percentiles time an
+// operation: one in latencyEvery per goroutine. Two clock reads per
+// operation would slow the fastest stores noticeably.
+const latencyEvery = 8
+
+// latencySubBits is the number of bits of a latency kept below its highest
+// set bit, so a bucket is at most 1/32 of its values wide.
+const latencySubBits = 5
+
+const (
+	latencySub     = 1 << latencySubBits
+	latencyBuckets = latencySub + (64-latencySubBits-1)*latencySub
+)
+
+// latencyHist is a log-linear histogram of latencies in nanoseconds,
+// written by one goroutine only.
+type latencyHist struct {
+	next   int64 // operations until the next timed one
+	counts [latencyBuckets]int64
+	n      int64
+	max    int64
+	_      [56]byte // keep next off the cache line of the previous histogram
+}
+
+func latencyBucket(v int64) int {
+	if v < latencySub {
+		if v < 0 {
+			return 0
+		}
+		return int(v)
+	}
+	shift := bits.Len64(uint64(v)) - latencySubBits - 1
+	return latencySub + shift*latencySub + int(v>>shift) - latencySub
+}
+
+// latencyLow returns the smallest value counted in bucket i.
+func latencyLow(i int) int64 {
+	if i < latencySub {
+		return int64(i)
+	}
+	shift := (i - latencySub) / latencySub
+	return int64(latencySub+(i-latencySub)%latencySub) << shift
+}
+
+func (h *latencyHist) add(d time.Duration) {
+	v := int64(d)
+	h.counts[latencyBucket(v)]++
+	h.n++
+	if v > h.max {
+		h.max = v
+	}
+}
+
+func (h *latencyHist) merge(o *latencyHist) {
+	for i, n := range o.counts {
+		h.counts[i] += n
+	}
+	h.n += o.n
+	if o.max > h.max {
+		h.max = o.max
+	}
+}
+
+// percentile returns the lower bound of the bucket holding the p-th
+// percentile, or the exact maximum for p = 100. h must not be empty.
+func (h *latencyHist) percentile(p float64) time.Duration {
+	if p >= 100 {
+		return time.Duration(h.max)
+	}
+	rank := int64(math.Ceil(p*float64(h.n)/100)) - 1
+	var seen int64
+	for i, n := range h.counts {
+		if seen += n; seen > rank {
+			return time.Duration(latencyLow(i))
+		}
+	}
+	return time.Duration(h.max)
+}
+
+// measureLatency makes sampleStart time one operation in latencyEvery of
+// each goroutine of p, for addLatency.
+func (p *phase) measureLatency() {
+	p.lat = make([]latencyHist, *c)
+	for i := range p.lat {
+		p.lat[i].next = latencyEvery
+	}
+}
+
+// addLatency merges the histograms of the goroutines and writes the p50,
+// p99, p999 and max columns of p, or -1 if no operation was timed.
+func (p *phase) addLatency() {
+	var all latencyHist
+	for i := range p.lat {
+		all.merge(&p.lat[i])
+	}
+	label := p.label
+	if all.n == 0 {
+		for _, q := range []string{"p50", "p99", "p999", "max"} {
+			p.record.add(label+" "+q+"(ns)", "ns", -1)
+		}
+		return
+	}
+	p50, p99, p999 := all.percentile(50), all.percentile(99), all.percentile(99.9)
+	max := time.Duration(all.max)
+	fmt.Printf("%s %s latency: p50: %s, p99: %s, p999: %s, max: %s\n", p.name, label, p50, p99, p999, max)
+	p.record.add(label+" p50(ns)", "ns", int(p50))
+	p.record.add(label+" p99(ns)", "ns", int(p99))
+	p.record.add(label+" p999(ns)", "ns", int(p999))
+	p.record.add(label+" max(ns)", "ns", int(max))
+}
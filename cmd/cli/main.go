@@ -1,16 +1,19 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"encoding/binary"
 	"encoding/csv"
 	"errors"
 	"flag"
 	"fmt"
+	"math"
 	"math/rand"
 	"os"
 	"path/filepath"
 	"runtime"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -22,26 +25,285 @@ import (
 )
 
 var (
-	duration = flag.Duration("d", 10*time.Second, "test duration for each case")
-	c        = flag.Int("c", runtime.NumCPU(), "concurrent goroutines")
-	setCount = flag.Int("set", 4000000, "set count")
-	size     = flag.Int("size", 256, "data size")
-	fsync    = flag.Bool("fsync", false, "fsync")
-	s        = flag.String("s", "map", "store type")
-	savePath = flag.String("save", "", "save path")
-	data     = make([]byte, *size)
+	duration  = flag.Duration("d", 10*time.Second, "test duration for each case")
+	c         = flag.Int("c", runtime.NumCPU(), "concurrent goroutines")
+	setCount  = flag.Int("set", 4000000, "set count")
+	batch     = flag.Int("batch", 1000, "entries per PSet of the load phase and the other batched writes")
+	size      = flag.String("size", "256", "value size in bytes, or comma separated size:weight pairs")
+	fsync     = flag.Bool("fsync", false, "fsync")
+	s         = flag.String("s", "map", "store type")
+	savePath  = flag.String("save", "", "save path")
+	format    = flag.String("format", "csv", "format of the -save file: csv or json")
+	report    = flag.String("report", "", "Markdown file the row of the run is appended to, as one table of all the runs written to it")
+	runCount  = flag.Int("runs", 1, "times every phase runs, on a store emptied in between; the row holds the mean of each metric and the stddev of each op/s")
+	cSweep    = flag.String("csweep", "", "comma separated concurrency levels, e.g. 1,2,4,8: runs the phases at each in place of -c, on a store emptied in between, with a row per level")
+	procs     = flag.Int("procs", 0, "GOMAXPROCS, 0 keeps the runtime default")
+	affinity  = flag.String("cpu-affinity", "", "CPU list the process is pinned to, e.g. 0-3,8; empty leaves it unpinned")
+	units     = flag.Bool("units", false, "write the units as a second CSV header row")
+	resources = flag.Bool("resources", false, "report goroutine and open file descriptor counts per phase")
+	dropCache = flag.Bool("drop-cache", false, "reopen the store and drop its page cache before a cold read phase")
+	warmup    = flag.Duration("warmup", 0, "time each workload runs unmeasured before its measured window, 0 for no warm-up")
+	sizes     valueSizes // the parsed -size
+	data      []byte     // the value of the set phases, of the average size, allocated by main once -size is parsed
+	values    []byte     // bytes of the largest size, filled by fillValue, which the Set phase writes prefixes of
+
+	compressible = flag.Float64("compressible", 0, "share of every generated value, from 0 to 1, that is zeros rather than random bytes")
+
+	seed        = flag.Int64("seed", 123, "seed of the random keys and values, each goroutine drawing from its own source seeded with it plus its index")
+	keyOrder    = flag.String("keyorder", "random", "key order: random, sequential or reverse")
+	keyPrefix   = flag.String("key-prefix", "", "namespace prepended to every generated key")
+	keyFile     = flag.String("keyfile", "", "file of the keys, and optionally values, the load, set, get and delete phases replay instead of generated keys; .gz files are decompressed")
+	keyFileFmt  = flag.String("keyfile-format", "lines", "format of -keyfile: lines, a key per line with an optional tab separated value, or lenprefix, uint32 big-endian length-prefixed keys and values")
+	dist        = flag.String("dist", "uniform", "key distribution of the get and getmixed phases: uniform or zipfian")
+	zipfSkew    = flag.Float64("zipf-s", 1.1, "skew of -dist zipfian, greater than 1")
+	consistency = flag.String("consistency", "strong", "read consistency for replicated stores: strong or eventual; the other stores only accept strong")
+
+	diskCheck           = flag.Bool("diskcheck", true, "abort before the run if the disk is likely too small for -set entries")
+	amplificationFactor = flag.Float64("amplification", 0, "disk usage over raw data size assumed by -diskcheck, 0 uses a per-store default")
+
+	batchMixSize = flag.Int("batchmix-size", 100, "entries per PSet in the batch mixed test")
+	batchMixGets = flag.Int("batchmix-gets", 100, "Get calls issued after each PSet in the batch mixed test")
+
+	rate = flag.Int("rate", 0, "target op/s of the open-loop set and get phases, 0 runs closed-loop only")
+
+	slo      = flag.Duration("slo", 0, "p99 set latency bound of the SLO test, which finds the highest open-loop set rate within it; 0 skips it")
+	sloStart = flag.Int("slo-start", 1000, "first set rate in op/s tried by the SLO test")
+	sloStep  = flag.Duration("slo-step", time.Second, "time each rate runs in the SLO test")
+
+	untilStable     = flag.Bool("until-stable", false, "end each phase once its throughput is stable, running for at most -d")
+	stableWindow    = flag.Duration("stable-window", time.Second, "throughput window of -until-stable")
+	stableThreshold = flag.Float64("stable-threshold", 5, "largest change in percent between windows that -until-stable counts as stable")
+	stableCount     = flag.Int("stable-count", 3, "consecutive stable windows that end a phase with -until-stable")
+
+	verify        = flag.Bool("verify", false, "read back a sample of the loaded entries and report mismatches")
+	verifySamples = flag.Int("verify-samples", 1000, "entries of the load phase read back by -verify")
+	verifyDumps   = flag.Int("verify-dumps", 10, "mismatches described on stderr by -verify")
+
+	grpcAddr  = flag.String("grpc-addr", "127.0.0.1:6381", "address of the kvpb.KV service for the grpc store")
+	respAddr  = flag.String("resp-addr", "127.0.0.1:6379", "address of the Redis protocol server for the resp store")
+	redisAddr = flag.String("redis-addr", envOr("REDIS_ADDR", "127.0.0.1:6379"), "address of the Redis server for the redis store, $REDIS_ADDR by default")
+
+	s3Endpoint = flag.String("s3-endpoint", "http://127.0.0.1:9000", "endpoint of the s3 store, with http:// to connect without TLS")
+	s3Bucket   = flag.String("s3-bucket", "kvbench", "bucket of the s3 store")
+	s3Prefix   = flag.String("s3-prefix", "kvbench/", "object name prefix of the s3 store")
+
+	tikvPD  = flag.String("tikv-pd", "127.0.0.1:2379", "comma separated PD addresses of the TiKV cluster for the tikv store")
+	tikvTxn = flag.Bool("tikv-txn", false, "use the transactional API of TiKV rather than the raw one")
+
+	jetStreamURL    = flag.String("jetstream-url", "nats://127.0.0.1:4222", "URL of the NATS server for the jetstream store")
+	jetStreamBucket = flag.String("jetstream-bucket", "kvbench", "key-value bucket of the jetstream store, created if missing")
+
+	mix = flag.String("mix", "", "get:set weights of the mixed test, e.g. 90:10, where every goroutine picks each operation at random; empty skips it")
+
+	hotKeys    = flag.Int("hotkeys", 0, "number of loaded keys all goroutines of the hot keys test get, 0 skips it")
+	hotKeysMix = flag.String("hotkeys-mix", "", "get:set weights of the hot keys test, e.g. 90:10; empty only gets")
+
+	namespaces = flag.String("namespaces", "", "comma separated namespace counts for the namespace test, e.g. 1,8,64; empty skips it")
+
+	diskFull = flag.String("disk-full", "", "directory on a small filesystem to fill in the disk full test; empty skips it")
+
+	compactInterval = flag.Duration("compact-interval", 0, "interval of the compactions forced during a second set phase, 0 skips it")
+
+	lruCapacity = flag.Int("lru-capacity", 1000000, "entries held by the lru store")
+
+	freecacheSize = flag.Int("freecache-size", 256<<20, "bytes of memory of the freecache store, which evicts entries beyond it and rejects values larger than 1/1024 of it")
+
+	growth = flag.String("growth", "", "comma separated store sizes in entries for the growth test, e.g. 1000000,10000000; empty skips it")
+
+	samplesOut  = flag.String("samples-out", "", "file to write sampled per-operation latencies to as JSON lines; empty writes none")
+	samplesRate = flag.Float64("samples-rate", 0.01, "fraction of the operations written to -samples-out")
+
+	metricsAddr = flag.String("metrics-addr", "", "address to serve live Prometheus metrics of the phases on at /metrics, e.g. :9100; empty serves none")
+
+	cpuProfile   = flag.String("cpuprofile", "", "file to write a CPU profile of the phases to; empty writes none")
+	memProfile   = flag.String("memprofile", "", "file to write a heap profile taken after the phases to; empty writes none")
+	blockProfile = flag.String("blockprofile", "", "file to write a profile of the blocking events of the phases to; empty writes none")
+
+	compare = flag.Bool("compare", false, "compare two CSV files given as arguments, e.g. -compare before.csv after.csv, and exit")
+
+	capabilities = flag.Bool("capabilities", false, "print the capabilities of every store type and exit")
+
+	leveldbBloomBits = flag.Int("leveldb-bloom-bits", 0, "bits per key of the leveldb bloom filter, 0 for no filter")
+	leveldbTableSize = flag.Int("leveldb-table-size", 0, "leveldb compaction table size in MiB, 0 keeps the default of 2")
+	leveldbBuffer    = flag.Int("leveldb-write-buffer", 0, "leveldb write buffer size in MiB, 0 keeps the default of 4")
+
+	boltReadTxReuse = flag.Int("bolt-rotx", 0, "Gets served by one reused bolt/bbolt read transaction, 0 opens one per Get")
+	boltMmapSize    = flag.Int("bolt-mmap-size", 0, "initial mmap size of bolt/bbolt in MiB, 0 maps the file at its size")
+
+	bboltNoFreelistSync = flag.Bool("bbolt-nofreelistsync", false, "leave the bbolt freelist out of every commit, rebuilding it when the file is opened")
+	bboltFreelist       = flag.String("bbolt-freelist", "array", "bbolt freelist type: array or hashmap")
+
+	pebbleBatchBytes = flag.Int("pebble-batch-bytes", 0, "largest pebble batch committed by PSet in MiB, 0 keeps the default of 64")
+	pebbleBatchCount = flag.Int("pebble-batch-count", 0, "most entries of a pebble batch committed by PSet, 0 for no limit")
 )
 
 type Record struct {
 	Name    string
 	Headers []string
-	Values  []int
+	// Units holds the unit of each column in Headers, "" if it has none.
+	Units  []string
+	Values []int
+	// Info holds string-valued columns such as the Go version. They are
+	// written right after the name, so they must be added before any of
+	// the Values.
+	Info []string
+	// Err is the error that stopped the phases, nil if they all ran. The
+	// columns of the phases that did not run are missing.
+	Err error
+	// Concurrency is the -c of the run, which -csweep changes from one
+	// level to the next before the rows are saved.
+	Concurrency int
+}
+
+// add appends a metric column. header is the column name as written in the
+// single-row CSV header, e.g. "Get op/s", and unit is its unit, e.g. "op/s".
+func (r *Record) add(header, unit string, value int) {
+	r.Headers = append(r.Headers, header)
+	r.Units = append(r.Units, unit)
+	r.Values = append(r.Values, value)
+}
+
+// addInfo appends a string-valued column.
+func (r *Record) addInfo(header, value string) {
+	r.Headers = append(r.Headers, header)
+	r.Units = append(r.Units, "")
+	r.Info = append(r.Info, value)
+}
+
+// metricNames returns the headers with their unit suffix removed, for the
+// two-row header written with -units.
+func (r *Record) metricNames() []string {
+	names := make([]string, len(r.Headers))
+	for i, h := range r.Headers {
+		if u := r.Units[i]; u != "" {
+			h = strings.TrimSuffix(h, "("+u+")")
+			h = strings.TrimSuffix(h, " "+u)
+		}
+		names[i] = h
+	}
+	return names
+}
+
+// newRand returns the random source of goroutine index, seeded from -seed
+// so that runs draw the same keys and values. Each goroutine has its own, as
+// the global source of math/rand is shared behind a lock.
+func newRand(index uint64) *rand.Rand {
+	return rand.New(rand.NewSource(*seed + int64(index)))
 }
 
 func main() {
-	rand.Seed(123)
 	flag.Parse()
-	fmt.Printf("duration=%v, c=%d size=%d store=%s\n", *duration, *c, *size, *s)
+	rand.Seed(*seed)
+	if *procs > 0 {
+		runtime.GOMAXPROCS(*procs)
+	}
+	var pinned string
+	if *affinity != "" {
+		cpus, err := parseCPUList(*affinity)
+		if err != nil {
+			panic(err)
+		}
+		used, err := setCPUAffinity(cpus)
+		if err != nil {
+			panic(err)
+		}
+		if len(used) == 0 {
+			panic(fmt.Errorf("none of the CPUs %s is available", *affinity))
+		}
+		// the runtime only sizes GOMAXPROCS from the affinity at startup
+		if *procs == 0 {
+			runtime.GOMAXPROCS(len(used))
+		}
+		pinned = formatCPUList(used)
+		fmt.Printf("cpu affinity: %s\n", pinned)
+	}
+	if *compare {
+		if flag.NArg() != 2 {
+			fmt.Fprintln(os.Stderr, "-compare needs two CSV files")
+			os.Exit(2)
+		}
+		fi, _ := os.Stdout.Stat()
+		color := fi != nil && fi.Mode()&os.ModeCharDevice != 0
+		if err := compareResults(os.Stdout, flag.Arg(0), flag.Arg(1), color); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+	if *capabilities {
+		if err := printCapabilities(os.Stdout, capabilityStores, *fsync); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+	fmt.Printf("duration=%v, c=%d size=%s store=%s gomaxprocs=%d numcpu=%d go=%s\n", *duration, *c, *size, *s,
+		runtime.GOMAXPROCS(0), runtime.NumCPU(), runtime.Version())
+
+	if *compressible < 0 || *compressible > 1 {
+		panic(fmt.Errorf("invalid -compressible: %v, must be from 0 to 1", *compressible))
+	}
+	if err := initValues(*size); err != nil {
+		panic(err)
+	}
+	fmt.Printf("value size: %d bytes\n", len(data))
+	if len(values) == 0 {
+		fmt.Println("warning: -size is 0, the set phases write empty values")
+	}
+
+	if *keyFile != "" {
+		var err error
+		if workload, err = loadKeyFile(*keyFile, *keyFileFmt); err != nil {
+			panic(err)
+		}
+		// the load writes every key of the file once, unless -set asks
+		// for fewer
+		if n := len(workload.keys); !flagSet("set") || *setCount > n {
+			*setCount = n
+		}
+		fmt.Printf("key file: %d keys, %d with values, -set %d\n", len(workload.keys), workload.valued, *setCount)
+	}
+
+	switch *keyOrder {
+	case "random", "sequential", "reverse":
+	default:
+		panic(fmt.Errorf("unknown key order: %v", *keyOrder))
+	}
+	switch *format {
+	case "csv", "json":
+	default:
+		panic(fmt.Errorf("unknown -format: %v", *format))
+	}
+	if *batch < 1 {
+		panic(fmt.Errorf("invalid -batch: %d, must be at least 1", *batch))
+	}
+	if *runCount < 1 {
+		panic(fmt.Errorf("invalid -runs: %d, must be at least 1", *runCount))
+	}
+	levels, err := parseSweep(*cSweep)
+	if err != nil {
+		panic(err)
+	}
+	// checked here rather than by their phases, which run after the load
+	for _, f := range []struct{ name, mix string }{{"mix", *mix}, {"hotkeys-mix", *hotKeysMix}} {
+		if f.mix == "" {
+			continue
+		}
+		if _, err := parseMix(f.mix); err != nil {
+			fmt.Fprintf(os.Stderr, "invalid -%s: %v\n", f.name, err)
+			os.Exit(2)
+		}
+	}
+	switch *dist {
+	case "uniform":
+	case "zipfian":
+		if !(*zipfSkew > 1) {
+			panic(fmt.Errorf("invalid -zipf-s: %v, must be greater than 1", *zipfSkew))
+		}
+	default:
+		panic(fmt.Errorf("unknown key distribution: %v", *dist))
+	}
+	fmt.Printf("key distribution: %s\n", distName())
 
 	var memory bool
 	var path string
@@ -51,15 +313,11 @@ func main() {
 		*s = strings.TrimSuffix(*s, "/memory")
 	}
 
-	store, path, err := getStore(*s, *fsync, path)
+	readConsistency, err := consistencyInfo(*s, *consistency)
 	if err != nil {
 		panic(err)
 	}
-	if !memory {
-		defer os.RemoveAll(path)
-	}
 
-	defer store.Close()
 	name := *s
 	if memory {
 		name = name + "/memory"
@@ -69,20 +327,291 @@ func main() {
 	} else {
 		name = name + "/nofsync"
 	}
+
+	if *diskCheck {
+		if err := checkDiskSpace(*s, ".", memory); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
+
+	stopInterrupts := handleInterrupts()
+	defer stopInterrupts()
+	rt := newResourceTracker(name)
+	store, path, err := getStore(*s, *fsync, path)
+	if err != nil {
+		panic(err)
+	}
+	// memory-only stores such as memdb need no /memory suffix
+	memory = memory || path == ":memory:"
+	// deferred first, so that it exits after the files are removed
+	defer func() {
+		if verifyFailed || runFailed {
+			os.Exit(1)
+		}
+	}()
+	if !memory {
+		defer os.RemoveAll(path)
+	}
+	rt.phase("open")
+	fmt.Printf("%s capabilities: %s\n", name, store.Capabilities())
+	settings := storeSettings(*s)
+	if settings != "" {
+		fmt.Printf("%s options: %s\n", name, settings)
+	}
+
+	if err := openSamples(); err != nil {
+		panic(err)
+	}
+	stopMetrics, err := startMetrics()
+	if err != nil {
+		panic(err)
+	}
+	stopProfiles, err := startProfiles()
+	if err != nil {
+		panic(err)
+	}
+	// a row per level of -csweep, each the aggregate of its -runs, every
+	// level but the first on the store emptied again
+	var rows []*Record
+	for l, level := range levels {
+		*c = level
+		if len(levels) > 1 {
+			fmt.Printf("%s concurrency %d\n", name, level)
+		}
+		var records []*Record
+		for run := 1; run <= *runCount; run++ {
+			if run > 1 {
+				fmt.Printf("%s run %d of %d\n", name, run, *runCount)
+			}
+			if run > 1 || l > 0 {
+				if store, err = resetStore(store, path, memory); err != nil {
+					panic(err)
+				}
+			}
+			record := newRecord(name, store.Capabilities(), settings, readConsistency, pinned)
+			records = append(records, record)
+			store, err = runPhases(record, name, store, path, memory, rt)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "%s failed: %v\n", name, err)
+				record.Err = err
+				runFailed = true
+				break
+			}
+		}
+		rows = append(rows, aggregateRuns(records))
+		if runFailed {
+			break
+		}
+	}
+	if err := stopProfiles(); err != nil {
+		panic(err)
+	}
+	if err := closeSamples(); err != nil {
+		panic(err)
+	}
+	if err := stopMetrics(); err != nil {
+		panic(err)
+	}
+
+	store.Close()
+	rt.closed(rows...)
+	for _, record := range rows {
+		if *format == "json" {
+			saveJSON(record, memory)
+		} else {
+			saveReorder(record)
+		}
+		saveMarkdown(record)
+		saveMeta(record)
+	}
+}
+
+// consistencyStores are the store types that read at the level of
+// -consistency.
+var consistencyStores = []string{"tikv"}
+
+// consistencyInfo parses level and returns it as written to the Consistency
+// column of store: "n/a" if the store does not read at the level, which then
+// must be the default strong.
+func consistencyInfo(store, level string) (string, error) {
+	c, err := kvbench.ParseConsistency(level)
+	if err != nil {
+		return "", err
+	}
+	base := store[strings.LastIndex(store, ":")+1:]
+	for _, s := range consistencyStores {
+		if s == base {
+			return string(c), nil
+		}
+	}
+	if c != kvbench.ConsistencyStrong {
+		return "", fmt.Errorf("-consistency %s is not supported by %s, which always reads its own writes", c, base)
+	}
+	return "n/a", nil
+}
+
+func newRecord(name string, caps kvbench.Capability, settings string, readConsistency string, pinned string) *Record {
 	record := &Record{
-		Name:   name,
-		Values: make([]int, 0),
+		Name:        name,
+		Values:      make([]int, 0),
+		Concurrency: *c,
 	}
 	record.Headers = append(record.Headers, "name")
-	testBatchWriteFixCount(record, name, store, *setCount)
+	record.Units = append(record.Units, "")
+	record.addInfo("GoVersion", runtime.Version())
+	record.addInfo("Consistency", readConsistency)
+	record.addInfo("KeyOrder", *keyOrder)
+	record.addInfo("KeyDist", distName())
+	record.addInfo("KeyPrefix", *keyPrefix)
+	record.addInfo("KeyFile", *keyFile)
+	record.addInfo("Compressible", strconv.FormatFloat(*compressible, 'g', -1, 64))
+	record.addInfo("Loop", loopMode())
+	record.addInfo("Capabilities", caps.String())
+	record.addInfo("StoreOptions", settings)
+	record.addInfo("CPUAffinity", pinned)
+	record.add("GOMAXPROCS", "", runtime.GOMAXPROCS(0))
+	record.add("Concurrency", "", record.Concurrency)
+	record.add("NumCPU", "", runtime.NumCPU())
+	return record
+}
+
+// runPhases runs every benchmark phase against store and returns it, reopened
+// if -drop-cache closed it. Each phase adds the same columns to record whether
+// or not the store supports it, using -1 for unsupported metrics, so that the
+// rows of different stores line up in one CSV.
+//
+// A failed load, or a panic of a phase on this goroutine, stops the phases and
+// is returned with the store, so that the row collected so far can still be
+// saved. So does an interrupt, as errInterrupted, once the current phase is
+// over.
+func runPhases(record *Record, name string, store kvbench.Store, path string, memory bool, rt *resourceTracker) (out kvbench.Store, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			// the caller still closes the store the panic left open
+			out, err = store, fmt.Errorf("panic: %v", r)
+			if interrupted() {
+				// a phase cut short may also fail on what it measured
+				err = errInterrupted
+			}
+		}
+	}()
+	testPing(record, name, store)
+	sampler, loaded, err := testBatchWriteFixCount(record, name, store, *setCount)
+	if err != nil {
+		return store, err
+	}
+	rt.phase("batch write")
+	testVerify(record, name, store, sampler)
+	testCount(record, name, store)
 	showMemUsage(record, name)
-	showDiskUsage(record, name, path)
+	showDiskUsage(record, name, path, "")
+	showDiskPerByte(record, name, path, loaded)
 	testKeys(record, name, store)
-	testSet(record, name, store)
+	rt.phase("keys")
+	testAllKeys(record, name, store)
+	rt.phase("allkeys")
+	testScan(record, name, store)
+	rt.phase("scan")
+	setRate := testSet(record, name, store)
+	rt.phase("set")
+	testSetCompacting(record, name, store, setRate)
+	rt.phase("setcompact")
+	testOverwrite(record, name, store)
+	rt.phase("overwrite")
+	testSetAsync(record, name, store, setRate)
+	rt.phase("setasync")
+	testSetTTL(record, name, store)
+	rt.phase("setttl")
 	testGet(record, name, store)
+	rt.phase("get")
+	testHas(record, name, store)
+	rt.phase("has")
+	if *dropCache {
+		store = testGetCold(record, name, store, path, memory)
+		rt.phase("getcold")
+	}
 	testGetSet(record, name, store)
+	rt.phase("getset")
+	testMixed(record, name, store)
+	rt.phase("mixed")
+	testHotKeys(record, name, store)
+	rt.phase("hotkeys")
+	testScanMixed(record, name, store)
+	rt.phase("scanmixed")
+	testSnapshotRead(record, name, store)
+	rt.phase("snapshot")
+	testOpenLoop(record, name, store)
+	rt.phase("openloop")
+	testSLO(record, name, store)
+	rt.phase("slo")
+	testBatchMixed(record, name, store)
+	rt.phase("batchmixed")
+	testMerge(record, name, store)
+	rt.phase("merge")
+	testIncr(record, name, store)
+	rt.phase("incr")
+	testCAS(record, name, store)
+	rt.phase("cas")
+	testChurn(record, name, store, path)
+	rt.phase("churn")
 	testDelete(record, name, store)
-	saveReorder(record)
+	rt.phase("del")
+	testBatchDelete(record, name, store)
+	rt.phase("batchdel")
+	showDiskUsage(record, name, path, "AfterDelete")
+	testCompact(record, name, store, path)
+	rt.phase("compact")
+	showDiskUsage(record, name, path, "AfterCompact")
+	testEdgeCases(record, name, store)
+	rt.phase("edge cases")
+	testNamespaces(record, name, store)
+	rt.phase("namespaces")
+	testVersioned(record, name, store)
+	rt.phase("versioned")
+	testGrowth(record, name, store)
+	rt.phase("growth")
+	testCache(record, name, store)
+	rt.phase("zipf")
+	testTiered(record, name, store)
+	rt.phase("tiered")
+	testDiskFull(record, name, memory)
+	rt.phase("disk full")
+	showCompression(record, name, store)
+	return store, nil
+}
+
+// pingSamples is the number of pings whose median testPing reports.
+const pingSamples = 10
+
+// test that the store is reachable before anything is timed, and record the
+// median ping, the baseline cost of a round trip for networked stores.
+func testPing(record *Record, name string, store kvbench.Store) {
+	pings := make([]time.Duration, pingSamples)
+	for i := range pings {
+		start := time.Now()
+		if err := store.Ping(); err != nil {
+			panic(fmt.Errorf("%s unreachable: %w", name, err))
+		}
+		pings[i] = time.Since(start)
+	}
+	sort.Slice(pings, func(i, j int) bool { return pings[i] < pings[j] })
+	p50 := percentile(pings, 50)
+	fmt.Printf("%s ping: %s\n", name, p50)
+	record.add("Ping(us)", "us", int(p50.Microseconds()))
+}
+
+// testCount prints the number of keys in store after the load phase. Fewer
+// than -set means colliding keys or failed batches shrank the dataset.
+func testCount(record *Record, name string, store kvbench.Store) {
+	n, err := store.Count()
+	if err != nil {
+		fmt.Printf("%s count error: %v\n", name, err)
+		record.add("KeyCount", "", -1)
+		return
+	}
+	fmt.Printf("%s key count after load: %d, set: %d\n", name, n, *setCount)
+	record.add("KeyCount", "", int(n))
 }
 
 func showMemUsage(record *Record, name string) {
@@ -95,44 +624,210 @@ func showMemUsage(record *Record, name string) {
 	fmt.Printf("\tHeapObjects = %v", m.HeapObjects)
 	fmt.Printf("\tHeapInuse = %v MiB", m.HeapInuse/1024/1024)
 	fmt.Printf("\tNumGC = %v\n", m.NumGC)
-	record.Headers = append(record.Headers, "MemUsage(MiB)")
-	record.Values = append(record.Values, int(m.Alloc/1024/1024))
-	record.Headers = append(record.Headers, "HeapInuse(MiB)")
-	record.Values = append(record.Values, int(m.HeapInuse/1024/1024))
+	record.add("MemUsage(MiB)", "MiB", int(m.Alloc/1024/1024))
+	record.add("HeapInuse(MiB)", "MiB", int(m.HeapInuse/1024/1024))
 }
 
-func showDiskUsage(record *Record, name string, path string) {
-	var fileSize int64
+// showDiskUsage records the disk usage of path. stage names the point of the
+// run it is taken at, e.g. "AfterDelete", and is empty after the load phase.
+func showDiskUsage(record *Record, name string, path string, stage string) {
+	fileSize, ok := diskUsage(path)
+	if !ok {
+		// memory-only or remote store
+		record.add("DiskUsage"+stage+"(MiB)", "MiB", -1)
+		return
+	}
+	if stage == "" {
+		fmt.Printf("%s disk usage: %d MiB\n", name, int(fileSize/1024/1024))
+	} else {
+		fmt.Printf("%s disk usage %s: %d MiB\n", name, stage, int(fileSize/1024/1024))
+	}
+	record.add("DiskUsage"+stage+"(MiB)", "MiB", int(fileSize/1024/1024))
+}
+
+// showDiskPerByte records the disk usage of path after the load per logical
+// byte loaded, in percent: under 100 for a store that compresses more than it
+// adds, see -compressible.
+func showDiskPerByte(record *Record, name string, path string, loaded int64) {
+	fileSize, ok := diskUsage(path)
+	if !ok || loaded == 0 {
+		record.add("DiskPerLogicalByte(%)", "%", -1)
+		return
+	}
+	perByte := int(fileSize * 100 / loaded)
+	fmt.Printf("%s disk usage per logical byte: %d%% of %d MiB loaded\n", name, perByte, loaded/1024/1024)
+	record.add("DiskPerLogicalByte(%)", "%", perByte)
+}
+
+// diskUsage returns the size in bytes of the file or directory at path.
+func diskUsage(path string) (int64, bool) {
+	if path == ":memory:" {
+		return 0, false
+	}
 	fileInfo, err := os.Stat(path)
 	if os.IsNotExist(err) {
-		return
+		return 0, false
 	}
 	if fileInfo.IsDir() {
-		fileSize, err = GetDirSize(path)
-	} else {
-		fileSize = fileInfo.Size()
+		fileSize, _ := GetDirSize(path)
+		return fileSize, true
+	}
+	return fileInfo.Size(), true
+}
+
+// test set and get of the same keys spread over N namespaces, for each N in
+// -namespaces. Stores with native namespaces (bolt buckets) use them, the
+// others a key prefix.
+func testNamespaces(record *Record, name string, store kvbench.Store) {
+	if *namespaces == "" {
+		return
+	}
+	nss := kvbench.Namespaces(store)
+	for _, f := range strings.Split(*namespaces, ",") {
+		n, err := strconv.Atoi(strings.TrimSpace(f))
+		if err != nil || n < 1 {
+			panic(fmt.Errorf("invalid namespace count: %v", f))
+		}
+		ns := make([][]byte, n)
+		for i := range ns {
+			ns[i] = []byte("ns" + strconv.Itoa(i))
+		}
+
+		setRate := runNamespaceOps(func(i uint64) {
+			nss.SetNS(ns[i%uint64(n)], genKey(i/uint64(n)), data)
+		})
+		getRate := runNamespaceOps(func(i uint64) {
+			nss.GetNS(ns[i%uint64(n)], genKey(i/uint64(n)))
+		})
+		fmt.Printf("%s namespaces %d set rate: %d op/s, get rate: %d op/s\n", name, n, setRate, getRate)
+		record.add(fmt.Sprintf("NS%d Set op/s", n), "op/s", int(setRate))
+		record.add(fmt.Sprintf("NS%d Get op/s", n), "op/s", int(getRate))
+	}
+}
+
+// runNamespaceOps calls op from *c goroutines for *duration, each goroutine
+// with its own sequence of i, and returns the rate in op/s.
+func runNamespaceOps(op func(i uint64)) int64 {
+	var wg sync.WaitGroup
+	wg.Add(*c)
+
+	ctx, cancel := context.WithTimeout(runCtx, *duration)
+	defer cancel()
+
+	counts := make([]int, *c)
+	start := time.Now()
+	for j := 0; j < *c; j++ {
+		index := uint64(j)
+		go func() {
+			var count int
+			i := index
+		LOOP:
+			for {
+				select {
+				case <-ctx.Done():
+					break LOOP
+				default:
+					op(i)
+					i += uint64(*c)
+					count++
+				}
+			}
+			counts[index] = count
+			wg.Done()
+		}()
+	}
+	wg.Wait()
+	d := int64(time.Since(start))
+	var n int
+	for _, count := range counts {
+		n += count
+	}
+	return int64(n) * 1e6 / (d / 1e3)
+}
+
+// showCompression records how well a compressing store (e.g. "zstd:bolt")
+// did over the whole run. Other stores record -1.
+func showCompression(record *Record, name string, store kvbench.Store) {
+	cs, ok := store.(interface {
+		CompressionStats() (raw, compressed int64, perCall time.Duration)
+	})
+	if !ok {
+		record.add("Compression ratio(%)", "%", -1)
+		record.add("Codec cost(ns/op)", "ns/op", -1)
+		return
+	}
+	raw, compressed, perCall := cs.CompressionStats()
+	ratio := 100
+	if raw > 0 {
+		ratio = int(compressed * 100 / raw)
+	}
+	fmt.Printf("%s compression ratio: %d%%, codec cost: %d ns/op\n", name, ratio, perCall.Nanoseconds())
+	record.add("Compression ratio(%)", "%", ratio)
+	record.add("Codec cost(ns/op)", "ns/op", int(perCall.Nanoseconds()))
+}
+
+// compactStats is implemented by stores (badger) that report the bytes of
+// their LSM tables and value log apart.
+type compactStats interface {
+	Stats() (lsm, vlog int64)
+}
+
+// test compaction (e.g. badger value log GC) after the delete phase. Stores
+// with Stats also record how much of the reclaimed space was value log.
+func testCompact(record *Record, name string, store kvbench.Store, path string) {
+	if !store.Capabilities().Has(kvbench.CapCompact) {
+		recordCompact(record, name, -1, -1, -1)
+		return
 	}
-	fmt.Printf("%s disk usage: %d MiB\n", name, int(fileSize/1024/1024))
-	record.Headers = append(record.Headers, "DiskUsage(MiB)")
-	record.Values = append(record.Values, int(fileSize/1024/1024))
+	cs, hasStats := store.(compactStats)
+	var vlogBefore int64
+	if hasStats {
+		_, vlogBefore = cs.Stats()
+	}
+	before, _ := diskUsage(path)
+	start := time.Now()
+	err := store.Compact()
+	dur := time.Since(start)
+	if err != nil {
+		if !errors.Is(err, kvbench.ErrNotSupported) {
+			fmt.Printf("%s compact error: %v\n", name, err)
+		}
+		recordCompact(record, name, -1, -1, -1)
+		return
+	}
+	after, _ := diskUsage(path)
+	vlogReclaimed := -1
+	if hasStats {
+		_, vlogAfter := cs.Stats()
+		vlogReclaimed = int((vlogBefore - vlogAfter) / 1024 / 1024)
+	}
+	recordCompact(record, name, int(dur.Milliseconds()), int((before-after)/1024/1024), vlogReclaimed)
+}
+
+func recordCompact(record *Record, name string, ms, reclaimed, vlogReclaimed int) {
+	fmt.Printf("%s compact took: %d ms, reclaimed: %d MiB, value log: %d MiB\n", name, ms, reclaimed, vlogReclaimed)
+	record.add("Compact cost(ms)", "ms", ms)
+	record.add("Compact reclaimed(MiB)", "MiB", reclaimed)
+	record.add("Compact vlog reclaimed(MiB)", "MiB", vlogReclaimed)
 }
 
 // test batch writes
 func testBatchWrite(name string, store kvbench.Store) {
 	var wg sync.WaitGroup
 	start := time.Now()
-	ctx, cancel := context.WithTimeout(context.Background(), *duration)
+	ctx, cancel := context.WithTimeout(runCtx, *duration)
 	defer cancel()
 
 	var total uint64
 	for i := 0; i < *c; i++ {
 		wg.Add(1)
 		go func(proc int) {
-			batchSize := uint64(1000)
+			r := newRand(uint64(proc))
+			batchSize := uint64(*batch)
 			var keyList, valList [][]byte
 			for i := uint64(0); i < batchSize; i++ {
 				keyList = append(keyList, genKey(i))
-				valList = append(valList, make([]byte, *size))
+				valList = append(valList, make([]byte, sizes.pick(i)))
 			}
 		LOOP:
 			for {
@@ -142,88 +837,404 @@ func testBatchWrite(name string, store kvbench.Store) {
 				default:
 					// Fill random keys and values.
 					for i := range keyList {
-						rand.Read(keyList[i])
-						rand.Read(valList[i])
+						r.Read(keyList[i][len(*keyPrefix):])
+						fillValue(valList[i], r.Read)
 					}
 					err := store.PSet(keyList, valList)
 					if err != nil {
-						fmt.Printf("%s error: %v\n", name, err)
-						panic(err)
+						fmt.Printf("%s error: %v\n", name, err)
+						panic(err)
+					}
+					atomic.AddUint64(&total, uint64(len(keyList)))
+				}
+			}
+			wg.Done()
+		}(i)
+	}
+	wg.Wait()
+	fmt.Printf("%s batch write test inserted: %d entries; took: %s s\n", name, total, time.Since(start))
+}
+
+// runFailed is set when the phases stopped on an error, to exit with status 1
+// once the run is saved.
+var runFailed bool
+
+// test batch writes and return the sampled entries and the logical size of
+// the load, the bytes of the keys and values written. A PSet error other than
+// an injected fault stops the load and is returned, with -1 in the columns of
+// the test.
+func testBatchWriteFixCount(record *Record, name string, store kvbench.Store, count int) (*verifySampler, int64, error) {
+	sampler := newVerifySampler(count)
+	r := newRand(0)
+	start := time.Now()
+	var total uint64
+	batchSize := loadBatchSize(count)
+	pageCount := 0
+	var commits []time.Duration
+	var faults, valueBytes, keyBytes int
+	if count%batchSize == 0 {
+		pageCount = count / batchSize
+	} else {
+		pageCount = count/batchSize + 1
+	}
+	// every page is generated in place into the buffers of one batch, so the
+	// load allocates as much for any count as for one page and MemUsage
+	// counts what the store keeps rather than garbage of the loader. The
+	// stores copy what PSet gets.
+	keyLen, valLen := len(*keyPrefix)+9, sizes.max()
+	keyBuf := make([]byte, batchSize*keyLen)
+	valBuf := make([]byte, batchSize*valLen)
+	keyBatch := make([][]byte, batchSize)
+	valBatch := make([][]byte, batchSize)
+	// the values of a -keyfile are passed as they are, so the buffers the
+	// random values are read into are kept apart from valBatch
+	valBufs := make([][]byte, batchSize)
+	for j := range keyBatch {
+		keyBatch[j] = keyBuf[j*keyLen : j*keyLen : (j+1)*keyLen]
+		valBufs[j] = valBuf[j*valLen : j*valLen : (j+1)*valLen]
+	}
+	for i := 0; i < pageCount; i++ {
+		if interrupted() {
+			fmt.Printf("%s batch write test interrupted after %d entries\n", name, total)
+			addLoadFailed(record, batchSize)
+			return nil, 0, errInterrupted
+		}
+		startIdx := i * batchSize
+		endIdx := startIdx + batchSize
+		if endIdx > count {
+			endIdx = count
+		}
+		keyList, valList := keyBatch[:endIdx-startIdx], valBatch[:endIdx-startIdx]
+		var batchBytes, batchKeyBytes int
+		for j := range keyList {
+			// the read phases look the keys up by the same indexes
+			i := uint64(startIdx + j)
+			keyList[j] = appendKey(keyList[j][:0], i)
+			if v := workload.value(i); v != nil {
+				valList[j] = v
+			} else {
+				valList[j] = valBufs[j][:sizes.pick(i)]
+				fillValue(valList[j], r.Read)
+			}
+			batchBytes += len(valList[j])
+			batchKeyBytes += len(keyList[j])
+		}
+		commitStart := time.Now()
+		err := store.PSet(keyList, valList)
+		if errors.Is(err, kvbench.ErrFault) {
+			// an injected fault fails the batch but not the run
+			faults++
+			continue
+		}
+		if err != nil {
+			fmt.Printf("%s batch write test failed after %d entries: %v\n", name, total, err)
+			addLoadFailed(record, batchSize)
+			return nil, 0, err
+		}
+		commits = append(commits, time.Since(commitStart))
+		valueBytes += batchBytes
+		keyBytes += batchKeyBytes
+		metrics.wrote(name, "batch write", batchBytes)
+		for i := range keyList {
+			sampler.add(keyList[i], valList[i])
+		}
+		atomic.AddUint64(&total, uint64(len(keyList)))
+	}
+	dur := time.Since(start)
+	var commitTotal time.Duration
+	for _, d := range commits {
+		commitTotal += d
+	}
+	var mean int64
+	var p50, p99 time.Duration
+	if total > 0 {
+		// the mean leaves out the time spent generating the entries
+		mean = commitTotal.Nanoseconds() / int64(total)
+		sort.Slice(commits, func(i, j int) bool { return commits[i] < commits[j] })
+		p50, p99 = percentile(commits, 50), percentile(commits, 99)
+	}
+	if faults > 0 {
+		fmt.Printf("%s batch write test: %d batches failed with injected faults\n", name, faults)
+	}
+	var avgSize int
+	if count > 0 {
+		avgSize = valueBytes / count
+	}
+	fmt.Printf("%s batch write test inserted: %d entries; took: %s, mean: %d ns/entry, commit p50: %s, p99: %s, value size: %d bytes\n", name, total, dur, mean, p50, p99, avgSize)
+	record.add("batch write cost(s)", "s", int(dur.Seconds()))
+	record.add("Batch write mean(ns)", "ns", int(mean))
+	record.add("Batch commit p50(us)", "us", int(p50.Microseconds()))
+	record.add("Batch commit p99(us)", "us", int(p99.Microseconds()))
+	record.add("Value size(avg)", "bytes", avgSize)
+	record.add("Batch size", "", batchSize)
+	return sampler, int64(keyBytes + valueBytes), nil
+}
+
+// addLoadFailed adds the columns of a load that did not complete.
+func addLoadFailed(record *Record, batchSize int) {
+	record.add("batch write cost(s)", "s", -1)
+	record.add("Batch write mean(ns)", "ns", -1)
+	record.add("Batch commit p50(us)", "us", -1)
+	record.add("Batch commit p99(us)", "us", -1)
+	record.add("Value size(avg)", "bytes", -1)
+	record.add("Batch size", "", batchSize)
+}
+
+// loadBatchSize returns -batch, or count if it is smaller, so that a load of
+// fewer entries is one full batch rather than an empty page.
+func loadBatchSize(count int) int {
+	if *batch > count && count > 0 {
+		return count
+	}
+	return *batch
+}
+
+// meanNs returns the mean time in ns of one of the n calls that *c
+// goroutines made in d, or -1 when there were none, as a phase whose window
+// ends before its first call completes has.
+func meanNs(d int64, n int) int64 {
+	if n == 0 {
+		return -1
+	}
+	return d / int64(n*(*c))
+}
+
+// percentile returns the p-th percentile of sorted, which must not be empty.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	i := int(math.Ceil(p/100*float64(len(sorted)))) - 1
+	if i < 0 {
+		i = 0
+	}
+	return sorted[i]
+}
+
+// test get
+func testGet(record *Record, name string, store kvbench.Store) {
+	warmUp(name, "Get", func(p *phase) { runGets(p, store) })
+	p := newPhase(record, name, "Get")
+	defer p.stop()
+	p.measureLatency()
+	r, dur := runGets(p, store)
+	n := r.calls
+	d := int64(dur)
+	fmt.Printf("%s get rate: %d op/s, mean: %d ns, took: %d s, errors: %d, misses: %d\n", name, int64(n)*1e6/(d/1e3), meanNs(d, n), int(dur.Seconds()), r.errors, r.misses)
+	if r.firstErr != nil {
+		fmt.Printf("%s first get error: %v\n", name, r.firstErr)
+	}
+	record.add("Get op/s", "op/s", int(int64(n)*1e6/(d/1e3)))
+	record.add("Get errors", "", r.errors)
+	record.add("Get misses", "", r.misses)
+	warnErrors(name, "get", r.errors, n)
+	p.addLatency()
+}
+
+// errorWarnRatio is the share of failed calls above which a phase warns that
+// its op/s measures failures rather than work done.
+const errorWarnRatio = 0.01
+
+// warnErrors warns when more than errorWarnRatio of the n calls of op failed.
+func warnErrors(name, op string, errs, n int) {
+	if n > 0 && float64(errs) > errorWarnRatio*float64(n) {
+		fmt.Printf("warning: %s %s: %d of %d calls failed (%.1f%%), its rate counts failed calls\n", name, op, errs, n, float64(errs)*100/float64(n))
+	}
+}
+
+// test has, which reads the same keys as get but not their values
+func testHas(record *Record, name string, store kvbench.Store) {
+	warmUp(name, "Has", func(p *phase) { runHas(p, store) })
+	p := newPhase(record, name, "Has")
+	defer p.stop()
+	n, miss, dur := runHas(p, store)
+	d := int64(dur)
+	fmt.Printf("%s has rate: %d op/s, mean: %d ns, took: %d s, misses: %d\n", name, int64(n)*1e6/(d/1e3), meanNs(d, n), int(dur.Seconds()), miss)
+	record.add("Has op/s", "op/s", int(int64(n)*1e6/(d/1e3)))
+}
+
+// runHas checks keys from *c goroutines until p is done and returns the
+// number of Has calls and misses.
+func runHas(p *phase, store kvbench.Store) (int, int, time.Duration) {
+	var wg sync.WaitGroup
+	wg.Add(*c)
+
+	counts := make([]int, *c)
+	misses := make([]int, *c)
+	start := time.Now()
+	for j := 0; j < *c; j++ {
+		index := uint64(j)
+		go func() {
+			var count, miss int
+			i := index
+		LOOP:
+			for {
+				select {
+				case <-p.done():
+					break LOOP
+				default:
+					t := p.sampleStart(index)
+					ok, _ := store.Has(genKey(i))
+					p.sample(index, t, "has", ok)
+					if !ok {
+						miss++
+						i = index
+					}
+					i += uint64(*c)
+					count++
+					p.tick(index, 1)
+				}
+			}
+			counts[index] = count
+			misses[index] = miss
+			wg.Done()
+		}()
+	}
+	wg.Wait()
+	dur := time.Since(start)
+	var n, miss int
+	for j := range counts {
+		n += counts[j]
+		miss += misses[j]
+	}
+	return n, miss, dur
+}
+
+// test get after closing and reopening the store, with the page cache of its
+// files dropped where the OS allows it, so reads are served from disk.
+// It returns the reopened store, which replaces the closed one.
+func testGetCold(record *Record, name string, store kvbench.Store, path string, memory bool) kvbench.Store {
+	if memory {
+		fmt.Printf("%s getcold rate: %d op/s, mean: %d ns, took: %d s\n", name, -1, -1, -1)
+		record.add("Getcold op/s", "op/s", -1)
+		return store
+	}
+	if err := store.Close(); err != nil {
+		panic(err)
+	}
+	if err := dropPageCache(path); err != nil {
+		fmt.Printf("%s drop page cache: %v\n", name, err)
+	}
+	store, _, err := getStore(*s, *fsync, path)
+	if err != nil {
+		panic(err)
+	}
+	p := newPhase(record, name, "Getcold")
+	defer p.stop()
+	r, dur := runGets(p, store)
+	n := r.calls
+	d := int64(dur)
+	fmt.Printf("%s getcold rate: %d op/s, mean: %d ns, took: %d s\n", name, int64(n)*1e6/(d/1e3), meanNs(d, n), int(dur.Seconds()))
+	record.add("Getcold op/s", "op/s", int(int64(n)*1e6/(d/1e3)))
+	return store
+}
+
+// getResult counts the Get calls of runGets. A miss is a Get that found no
+// value, an error one that failed; both are included in calls.
+type getResult struct {
+	calls  int
+	misses int
+	errors int
+	// firstErr is the first error returned by Get, if any.
+	firstErr error
+}
+
+// runGets reads the loaded keys from *c goroutines until p is done.
+func runGets(p *phase, store kvbench.Store) (getResult, time.Duration) {
+	var wg sync.WaitGroup
+	wg.Add(*c)
+
+	results := make([]getResult, *c)
+	start := time.Now()
+	for j := 0; j < *c; j++ {
+		index := uint64(j)
+		go func() {
+			r := &results[index]
+			keys := newReadKeys(index)
+		LOOP:
+			for {
+				select {
+				case <-p.done():
+					break LOOP
+				default:
+					t := p.sampleStart(index)
+					_, ok, err := store.Get(genKey(keys.next()))
+					p.sample(index, t, "get", ok)
+					if err != nil {
+						r.errors++
+						if r.firstErr == nil {
+							r.firstErr = err
+						}
+					} else if !ok {
+						r.misses++
 					}
-					atomic.AddUint64(&total, uint64(len(keyList)))
+					if !ok {
+						keys.restart()
+					}
+					r.calls++
+					p.tick(index, 1)
 				}
 			}
 			wg.Done()
-		}(i)
+		}()
 	}
 	wg.Wait()
-	fmt.Printf("%s batch write test inserted: %d entries; took: %s s\n", name, total, time.Since(start))
+	dur := time.Since(start)
+	var total getResult
+	for _, r := range results {
+		total.calls += r.calls
+		total.misses += r.misses
+		total.errors += r.errors
+		if total.firstErr == nil {
+			total.firstErr = r.firstErr
+		}
+	}
+	return total, dur
 }
 
-// test batch writes
-func testBatchWriteFixCount(record *Record, name string, store kvbench.Store, count int) {
-	start := time.Now()
-	var total uint64
-	batchSize := 1000
-	pageCount := 0
-	if count%batchSize == 0 {
-		pageCount = count / batchSize
-	} else {
-		pageCount = count/batchSize + 1
-	}
-	for i := 0; i < pageCount; i++ {
-		startIdx := i * batchSize
-		endIdx := startIdx + batchSize
-		var keyList, valList [][]byte
-		for i := startIdx; i < endIdx; i++ {
-			keyList = append(keyList, genKey(uint64(endIdx-startIdx)))
-			valList = append(valList, make([]byte, *size))
-		}
-		for i := range keyList {
-			rand.Read(keyList[i])
-			rand.Read(valList[i])
-			v := rand.Intn(127 - 32)
-			keyList[i][0] = byte(32 + v)
+// test prefix scans twice, with keys only and with values, so that the
+// difference shows the cost of reading values during a scan
+func testKeys(record *Record, name string, store kvbench.Store) {
+	for _, withvals := range []bool{false, true} {
+		mode := "keysonly"
+		if withvals {
+			mode = "withvals"
 		}
-		err := store.PSet(keyList, valList)
-		if err != nil {
-			fmt.Printf("%s error: %v\n", name, err)
-			panic(err)
+		label := "Keys(" + mode + ")"
+		if !store.Capabilities().Has(kvbench.CapKeys) {
+			fmt.Printf("%s keys %s rate: %d op/s, mean: %d ns, took: %d s\n", name, mode, -1, -1, -1)
+			record.add(label+" op/s", "op/s", -1)
+			continue
 		}
-		atomic.AddUint64(&total, uint64(len(keyList)))
+		warmUp(name, label, func(p *phase) { runKeys(p, store, withvals) })
+		p := newPhase(record, name, label)
+		n, dur := runKeys(p, store, withvals)
+		p.stop()
+		d := int64(dur)
+		fmt.Printf("%s keys %s rate: %d op/s, mean: %d ns, took: %d s\n", name, mode, int64(n)*1e6/(d/1e3), meanNs(d, n), int(dur.Seconds()))
+		record.add(label+" op/s", "op/s", int(int64(n)*1e6/(d/1e3)))
 	}
-	fmt.Printf("%s batch write test inserted: %d entries; took: %s s , mean: %f\n", name, total, time.Since(start), time.Since(start).Seconds())
-	record.Headers = append(record.Headers, "batch write cost(s)")
-	record.Values = append(record.Values, int(time.Since(start).Seconds()))
 }
 
-// test get
-func testGet(record *Record, name string, store kvbench.Store) {
+// runKeys scans random prefixes from *c goroutines until p is done and
+// returns the number of Keys calls.
+func runKeys(p *phase, store kvbench.Store, withvals bool) (int, time.Duration) {
 	var wg sync.WaitGroup
 	wg.Add(*c)
 
-	ctx, cancel := context.WithTimeout(context.Background(), *duration)
-	defer cancel()
-
 	counts := make([]int, *c)
 	start := time.Now()
 	for j := 0; j < *c; j++ {
 		index := uint64(j)
 		go func() {
 			var count int
-			i := index
+			r := newRand(index)
 		LOOP:
 			for {
 				select {
-				case <-ctx.Done():
+				case <-p.done():
 					break LOOP
 				default:
-					_, ok, _ := store.Get(genKey(i))
-					if !ok {
-						i = index
-					}
-					i += uint64(*c)
+					store.Keys(genKeyPrefix(r), 0, withvals)
 					count++
+					p.tick(index, 1)
 				}
 			}
 			counts[index] = count
@@ -232,30 +1243,30 @@ func testGet(record *Record, name string, store kvbench.Store) {
 	}
 	wg.Wait()
 	dur := time.Since(start)
-	d := int64(dur)
 	var n int
 	for _, count := range counts {
 		n += count
 	}
-	fmt.Printf("%s get rate: %d op/s, mean: %d ns, took: %d s\n", name, int64(n)*1e6/(d/1e3), d/int64((n)*(*c)), int(dur.Seconds()))
-	record.Headers = append(record.Headers, "Get op/s")
-	record.Values = append(record.Values, int(int64(n)*1e6/(d/1e3)))
+	return n, dur
 }
 
-// test get
-func testKeys(record *Record, name string, store kvbench.Store) {
-	_, _, err := store.Keys(genKeyPrefix(0), 0, true)
+// allKeysLimit is the number of keys each AllKeys call enumerates.
+const allKeysLimit = 1000
+
+// test enumerating keys in store order, supported by stores that cannot
+// scan a prefix
+func testAllKeys(record *Record, name string, store kvbench.Store) {
+	_, _, err := store.AllKeys(allKeysLimit, true)
 	if err != nil && errors.Is(err, kvbench.ErrNotSupported) {
-		fmt.Printf("%s keys rate: %d op/s, mean: %d ns, took: %d s\n", name, -1, -1, -1)
-		record.Headers = append(record.Headers, "Keys op/s")
-		record.Values = append(record.Values, -1)
+		fmt.Printf("%s allkeys rate: %d op/s, mean: %d ns, took: %d s\n", name, -1, -1, -1)
+		record.add("AllKeys op/s", "op/s", -1)
 		return
 	}
 	var wg sync.WaitGroup
 	wg.Add(*c)
 
-	ctx, cancel := context.WithTimeout(context.Background(), *duration)
-	defer cancel()
+	p := newPhase(record, name, "AllKeys")
+	defer p.stop()
 
 	counts := make([]int, *c)
 	start := time.Now()
@@ -263,19 +1274,15 @@ func testKeys(record *Record, name string, store kvbench.Store) {
 		index := uint64(j)
 		go func() {
 			var count int
-			i := index
 		LOOP:
 			for {
 				select {
-				case <-ctx.Done():
+				case <-p.done():
 					break LOOP
 				default:
-					_, _, err := store.Keys(genKeyPrefix(i), 0, true)
-					if err != nil {
-						i = index
-					}
-					i += uint64(*c)
+					store.AllKeys(allKeysLimit, true)
 					count++
+					p.tick(index, 1)
 				}
 			}
 			counts[index] = count
@@ -289,103 +1296,281 @@ func testKeys(record *Record, name string, store kvbench.Store) {
 	for _, count := range counts {
 		n += count
 	}
-	fmt.Printf("%s keys rate: %d op/s, mean: %d ns, took: %d s\n", name, int64(n)*1e6/(d/1e3), d/int64((n)*(*c)), int(dur.Seconds()))
-	record.Headers = append(record.Headers, "Keys op/s")
-	record.Values = append(record.Values, int(int64(n)*1e6/(d/1e3)))
+	fmt.Printf("%s allkeys rate: %d op/s, mean: %d ns, took: %d s\n", name, int64(n)*1e6/(d/1e3), meanNs(d, n), int(dur.Seconds()))
+	record.add("AllKeys op/s", "op/s", int(int64(n)*1e6/(d/1e3)))
 }
 
 // test multiple get/one set
 func testGetSet(record *Record, name string, store kvbench.Store) {
+	warmUp(name, "Getmixed", func(p *phase) { runGetSet(p, store) })
+	p := newPhase(record, name, "Getmixed")
+	defer p.stop()
+	p.measureLatency()
+	r, dur := runGetSet(p, store)
+	n := r.gets
+	d := int64(dur)
+	if r.sets == 0 {
+		fmt.Printf("%s setmixed rate: -1 op/s, mean: -1 ns, took: %d s\n", name, int(dur.Seconds()))
+		record.add("Setmixed op/s", "op/s", -1)
+	} else {
+		fmt.Printf("%s setmixed rate: %d op/s, mean: %d ns, took: %d s, errors: %d\n", name, int64(r.sets)*1e6/(d/1e3), d/int64(r.sets), int(dur.Seconds()), r.setErrors)
+		record.add("Setmixed op/s", "op/s", int(int64(r.sets)*1e6/(d/1e3)))
+	}
+	record.add("Setmixed errors", "", r.setErrors)
+	fmt.Printf("%s getmixed rate: %d op/s, mean: %d ns, took: %d s, errors: %d\n", name, int64(n)*1e6/(d/1e3), meanNs(d, n), int(dur.Seconds()), r.getErrors)
+	record.add("Getmixed op/s", "op/s", int(int64(n)*1e6/(d/1e3)))
+	record.add("Getmixed errors", "", r.getErrors)
+	warnErrors(name, "setmixed", r.setErrors, r.sets)
+	warnErrors(name, "getmixed", r.getErrors, n)
+	p.addLatency()
+}
+
+// getSetResult counts the calls of runGetSet and those that failed.
+type getSetResult struct {
+	gets, getErrors int
+	sets, setErrors int
+}
+
+// runGetSet gets keys from *c goroutines while one more goroutine sets keys,
+// until p is done.
+func runGetSet(p *phase, store kvbench.Store) (getSetResult, time.Duration) {
 	var wg sync.WaitGroup
 	wg.Add(*c)
 
 	ch := make(chan struct{})
-
-	var setCount uint64
+	setter := make(chan [2]int)
 
 	go func() {
+		var sets, errs int
 		i := uint64(0)
 		for {
 			select {
 			case <-ch:
+				setter <- [2]int{sets, errs}
 				return
 			default:
-				store.Set(genKey(i), data)
-				setCount++
+				if store.Set(genKey(i), data) != nil {
+					errs++
+				}
+				p.wrote(len(data))
+				sets++
 				i++
 			}
 		}
 	}()
 
-	ctx, cancel := context.WithTimeout(context.Background(), *duration)
-	defer cancel()
+	counts := make([]int, *c)
+	errs := make([]int, *c)
+	start := time.Now()
+	for j := 0; j < *c; j++ {
+		index := uint64(j)
+		go func() {
+			var count, failed int
+			keys := newReadKeys(index)
+		LOOP:
+			for {
+				select {
+				case <-p.done():
+					break LOOP
+				default:
+					t := p.sampleStart(index)
+					_, ok, err := store.Get(genKey(keys.next()))
+					p.sample(index, t, "get", ok)
+					if err != nil {
+						failed++
+					}
+					count++
+					p.tick(index, 1)
+				}
+			}
+			counts[index] = count
+			errs[index] = failed
+			wg.Done()
+		}()
+	}
+	wg.Wait()
+	close(ch)
+	dur := time.Since(start)
+	set := <-setter
+	r := getSetResult{sets: set[0], setErrors: set[1]}
+	for j := range counts {
+		r.gets += counts[j]
+		r.getErrors += errs[j]
+	}
+	return r, dur
+}
+
+func testSet(record *Record, name string, store kvbench.Store) int64 {
+	warmUp(name, "Set", func(p *phase) { runSets(p, store) })
+	p := newPhase(record, name, "Set")
+	defer p.stop()
+	p.measureLatency()
+	n, errs, dur := runSets(p, store)
+	d := int64(dur)
+	fmt.Printf("%s set rate: %d op/s, mean: %d ns, took: %d s, errors: %d\n", name, int64(n)*1e6/(d/1e3), meanNs(d, n), int(dur.Seconds()), errs)
+	record.add("Set op/s", "op/s", int(int64(n)*1e6/(d/1e3)))
+	record.add("Set errors", "", errs)
+	warnErrors(name, "set", errs, n)
+	p.addLatency()
+	return int64(n) * 1e6 / (d / 1e3)
+}
+
+// runSets writes keys from *c goroutines until p is done and returns the
+// number of Set calls and of those that failed.
+func runSets(p *phase, store kvbench.Store) (int, int, time.Duration) {
+	var wg sync.WaitGroup
+	wg.Add(*c)
 
 	counts := make([]int, *c)
+	errs := make([]int, *c)
 	start := time.Now()
 	for j := 0; j < *c; j++ {
 		index := uint64(j)
 		go func() {
-			var count int
+			var count, failed int
 			i := index
 		LOOP:
 			for {
 				select {
-				case <-ctx.Done():
+				case <-p.done():
 					break LOOP
 				default:
-					store.Get(genKey(i))
+					t := p.sampleStart(index)
+					v := workload.value(i)
+					if v == nil {
+						v = values[:sizes.pick(i)]
+					}
+					if store.Set(genKey(i), v) != nil {
+						failed++
+					}
+					p.wrote(len(v))
+					p.sample(index, t, "set", false)
 					i += uint64(*c)
 					count++
+					p.tick(index, 1)
 				}
 			}
 			counts[index] = count
+			errs[index] = failed
+			wg.Done()
+		}()
+	}
+	wg.Wait()
+	dur := time.Since(start)
+	var n, failed int
+	for j := range counts {
+		n += counts[j]
+		failed += errs[j]
+	}
+	return n, failed, dur
+}
+
+// asyncInflight bounds the number of outstanding SetAsync calls per goroutine.
+const asyncInflight = 256
+
+// test async set, compared to the synchronous set rate
+func testSetAsync(record *Record, name string, store kvbench.Store, setRate int64) {
+	var wg sync.WaitGroup
+	wg.Add(*c)
+
+	p := newPhase(record, name, "SetAsync")
+	defer p.stop()
+
+	var completed, failed uint64
+	start := time.Now()
+	for j := 0; j < *c; j++ {
+		index := uint64(j)
+		go func() {
+			sem := make(chan struct{}, asyncInflight)
+			done := func(err error) {
+				if err != nil {
+					atomic.AddUint64(&failed, 1)
+				} else {
+					atomic.AddUint64(&completed, 1)
+					p.tick(index, 1)
+				}
+				<-sem
+			}
+			i := index
+		LOOP:
+			for {
+				select {
+				case <-p.done():
+					break LOOP
+				case sem <- struct{}{}:
+					store.SetAsync(genKey(i), data, done)
+					i += uint64(*c)
+				}
+			}
+			// wait for the outstanding callbacks
+			for k := 0; k < asyncInflight; k++ {
+				sem <- struct{}{}
+			}
 			wg.Done()
 		}()
 	}
 	wg.Wait()
-	close(ch)
 	dur := time.Since(start)
 	d := int64(dur)
-	var n int
-	for _, count := range counts {
-		n += count
+	n := int64(completed)
+	rate := n * 1e6 / (d / 1e3)
+	var gain int64 = -1
+	if setRate > 0 {
+		gain = (rate - setRate) * 100 / setRate
 	}
-	record.Headers = append(record.Headers, "Setmixed op/s")
-	if setCount == 0 {
-		fmt.Printf("%s setmixed rate: -1 op/s, mean: -1 ns, took: %d s\n", name, int(dur.Seconds()))
-		record.Values = append(record.Values, -1)
-	} else {
-		fmt.Printf("%s setmixed rate: %d op/s, mean: %d ns, took: %d s\n", name, int64(setCount)*1e6/(d/1e3), d/int64(setCount), int(dur.Seconds()))
-		record.Values = append(record.Values, int(int64(setCount)*1e6/(d/1e3)))
+	if failed > 0 {
+		fmt.Printf("%s setasync errors: %d\n", name, failed)
 	}
-	fmt.Printf("%s getmixed rate: %d op/s, mean: %d ns, took: %d s\n", name, int64(n)*1e6/(d/1e3), d/int64((n)*(*c)), int(dur.Seconds()))
-	record.Headers = append(record.Headers, "Getmixed op/s")
-	record.Values = append(record.Values, int(int64(n)*1e6/(d/1e3)))
+	fmt.Printf("%s setasync rate: %d op/s, gain over set: %d%%, took: %d s\n", name, rate, gain, int(dur.Seconds()))
+	record.add("SetAsync op/s", "op/s", int(rate))
+	record.add("SetAsync gain(%)", "%", int(gain))
 }
 
-func testSet(record *Record, name string, store kvbench.Store) {
+// test PSet batches interleaved with point gets
+func testBatchMixed(record *Record, name string, store kvbench.Store) {
 	var wg sync.WaitGroup
 	wg.Add(*c)
 
-	ctx, cancel := context.WithTimeout(context.Background(), *duration)
-	defer cancel()
+	p := newPhase(record, name, "Batchmixed")
+	defer p.stop()
 
+	batchSize := *batchMixSize
 	counts := make([]int, *c)
 	start := time.Now()
 	for j := 0; j < *c; j++ {
 		index := uint64(j)
 		go func() {
-			count := 0
+			var count int
 			i := index
+			keyList := make([][]byte, batchSize)
+			valList := make([][]byte, batchSize)
+			for k := range valList {
+				valList[k] = data
+			}
 		LOOP:
 			for {
 				select {
-				case <-ctx.Done():
+				case <-p.done():
 					break LOOP
 				default:
-					store.Set(genKey(i), data)
-					i += uint64(*c)
-					count++
+					first := i
+					for k := range keyList {
+						keyList[k] = genKey(i)
+						i += uint64(*c)
+					}
+					store.PSet(keyList, valList)
+					count += batchSize
+					p.tick(index, batchSize)
+					g := first
+					for k := 0; k < *batchMixGets; k++ {
+						store.Get(genKey(g))
+						g += uint64(*c)
+						if g >= i {
+							g = first
+						}
+						count++
+						p.tick(index, 1)
+					}
 				}
 			}
 			counts[index] = count
@@ -399,70 +1584,276 @@ func testSet(record *Record, name string, store kvbench.Store) {
 	for _, count := range counts {
 		n += count
 	}
-	fmt.Printf("%s set rate: %d op/s, mean: %d ns, took: %d s\n", name, int64(n)*1e6/(d/1e3), d/int64((n)*(*c)), int(dur.Seconds()))
-	record.Headers = append(record.Headers, "Set op/s")
-	record.Values = append(record.Values, int(int64(n)*1e6/(d/1e3)))
+	// every goroutine spends the whole window on its own operations, so the
+	// amortized cost of one logical operation is the goroutine time per op,
+	// -1 when not one batch completed in the window.
+	var rate, amortized int64 = 0, -1
+	if n > 0 {
+		rate = int64(n) * 1e6 / (d / 1e3)
+		amortized = d * int64(*c) / int64(n)
+	}
+	fmt.Printf("%s batchmixed rate: %d op/s, amortized: %d ns, took: %d s\n", name, rate, amortized, int(dur.Seconds()))
+	record.add("Batchmixed op/s", "op/s", int(rate))
+	record.add("Batchmixed amortized(ns)", "ns", int(amortized))
 }
 
 func testDelete(record *Record, name string, store kvbench.Store) {
 	var wg sync.WaitGroup
 	wg.Add(*c)
 
-	ctx, cancel := context.WithTimeout(context.Background(), *duration)
-	defer cancel()
+	p := newPhase(record, name, "Del")
+	defer p.stop()
+	p.measureLatency()
 
 	counts := make([]int, *c)
+	errs := make([]int, *c)
 	start := time.Now()
 	for j := 0; j < *c; j++ {
 		index := uint64(j)
 		go func() {
-			var count int
+			var count, failed int
 			i := index
 		LOOP:
 			for {
 				select {
-				case <-ctx.Done():
+				case <-p.done():
 					break LOOP
 				default:
-					store.Del(genKey(i))
+					t := p.sampleStart(index)
+					ok, err := store.Del(genKey(i))
+					p.sample(index, t, "del", ok)
+					if err != nil {
+						failed++
+					}
 					i += uint64(*c)
 					count++
+					p.tick(index, 1)
 				}
 			}
 			counts[index] = count
+			errs[index] = failed
 			wg.Done()
 		}()
 	}
 	wg.Wait()
 	dur := time.Since(start)
 	d := int64(dur)
-	var n int
-	for _, count := range counts {
-		n += count
+	var n, failed int
+	for j := range counts {
+		n += counts[j]
+		failed += errs[j]
+	}
+
+	fmt.Printf("%s del rate: %d op/s, mean: %d ns, took: %d s, errors: %d\n", name, int64(n)*1e6/(d/1e3), meanNs(d, n), int(dur.Seconds()), failed)
+	record.add("Del op/s", "op/s", int(int64(n)*1e6/(d/1e3)))
+	record.add("Del errors", "", failed)
+	warnErrors(name, "del", failed, n)
+	p.addLatency()
+}
+
+// edgeMaxKeySize is the largest key size probed by testEdgeCases. It is
+// bolt's MaxKeySize, the smallest documented limit among the backends.
+const edgeMaxKeySize = 32768
+
+type edgeCase struct {
+	name  string
+	key   []byte
+	value []byte
+}
+
+// test empty/nil keys and values and the maximum key size
+func testEdgeCases(record *Record, name string, store kvbench.Store) {
+	maxKey := make([]byte, edgeMaxKeySize)
+	for i := range maxKey {
+		maxKey[i] = 'k'
+	}
+	cases := []edgeCase{
+		{"empty key", []byte{}, data},
+		{"nil key", nil, data},
+		{"empty value", []byte("edge-empty-value"), []byte{}},
+		{"nil value", []byte("edge-nil-value"), nil},
+		{"max key", maxKey, data},
 	}
 
-	fmt.Printf("%s del rate: %d op/s, mean: %d ns, took: %d s\n", name, int64(n)*1e6/(d/1e3), d/int64((n)*(*c)), int(dur.Seconds()))
-	record.Headers = append(record.Headers, "Del op/s")
-	record.Values = append(record.Values, int(int64(n)*1e6/(d/1e3)))
+	var rejected, misbehaved int
+	for _, ec := range cases {
+		err := store.Set(ec.key, ec.value)
+		if err != nil {
+			rejected++
+			fmt.Printf("%s edge case %s: rejected: %v\n", name, ec.name, err)
+			continue
+		}
+		v, ok, err := store.Get(ec.key)
+		switch {
+		case err != nil:
+			misbehaved++
+			fmt.Printf("%s edge case %s: get error: %v\n", name, ec.name, err)
+		case !ok:
+			misbehaved++
+			fmt.Printf("%s edge case %s: set succeeded but key not found\n", name, ec.name)
+		case !bytes.Equal(v, ec.value):
+			misbehaved++
+			fmt.Printf("%s edge case %s: value mismatch: got %d bytes, want %d\n", name, ec.name, len(v), len(ec.value))
+		default:
+			fmt.Printf("%s edge case %s: ok\n", name, ec.name)
+		}
+		store.Del(ec.key)
+	}
+	record.add("EdgeCase rejected", "", rejected)
+	record.add("EdgeCase misbehaved", "", misbehaved)
 }
 
+// genKey returns the key for index i after the -key-prefix. With -keyorder
+// sequential or reverse, keys sort in (reverse) index order; with random,
+// they are a bijective mix of i, so they sort in no particular order but
+// every index still has its own key, the same on every call.
 func genKey(i uint64) []byte {
-	r := make([]byte, 9)
-	v := rand.Intn(127 - 32)
-	r[0] = byte(32 + v)
-	binary.BigEndian.PutUint64(r[1:], i)
-	return r
+	return appendKey(make([]byte, 0, len(*keyPrefix)+9), i)
+}
+
+// appendKey appends the key of genKey for index i to k, for loaders that
+// reuse the buffers of their keys. With -keyfile, the key is the one of the
+// file for i, cycling through it, after the -key-prefix.
+func appendKey(k []byte, i uint64) []byte {
+	k = append(k, *keyPrefix...)
+	if workload != nil {
+		return append(k, workload.key(i)...)
+	}
+	var r [9]byte
+	switch *keyOrder {
+	case "sequential":
+		r[0] = 'k'
+		binary.BigEndian.PutUint64(r[1:], i)
+	case "reverse":
+		r[0] = 'k'
+		binary.BigEndian.PutUint64(r[1:], math.MaxUint64-i)
+	default:
+		h := mix64(i)
+		r[0] = byte(32 + h%(127-32))
+		binary.BigEndian.PutUint64(r[1:], h)
+	}
+	return append(k, r[:]...)
+}
+
+// newData returns n bytes, the value written by the set phases, filled by
+// fillValue.
+func newData(n int) []byte {
+	b := make([]byte, n)
+	fillValue(b, rand.Read)
+	return b
+}
+
+// fillValue fills b with random bytes from read, but for the last
+// -compressible share of it, which it zeroes. Random values do not compress,
+// so without -compressible stores that compress their blocks show none of
+// the disk savings they get on real data.
+func fillValue(b []byte, read func([]byte) (int, error)) {
+	n := len(b) - int(float64(len(b))**compressible)
+	read(b[:n])
+	for i := n; i < len(b); i++ {
+		b[i] = 0
+	}
+}
+
+// mix64 is the finalizer of splitmix64, a bijection of the uint64s.
+func mix64(z uint64) uint64 {
+	z += 0x9e3779b97f4a7c15
+	z = (z ^ z>>30) * 0xbf58476d1ce4e5b9
+	z = (z ^ z>>27) * 0x94d049bb133111eb
+	return z ^ z>>31
+}
+
+// genKeyPrefix returns a random 3 byte prefix of the keys of the load phase,
+// after the -key-prefix, drawn from r.
+func genKeyPrefix(r *rand.Rand) []byte {
+	k := make([]byte, len(*keyPrefix)+3)
+	b := k[copy(k, *keyPrefix):]
+	r.Read(b)
+	b[0] = byte(32 + r.Intn(127-32))
+	return k
+}
+
+// flagSet reports whether the flag name was given on the command line.
+func flagSet(name string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
+
+func levelDBOptions() kvbench.LevelDBOptions {
+	return kvbench.LevelDBOptions{
+		BloomBits:           *leveldbBloomBits,
+		CompactionTableSize: *leveldbTableSize * 1024 * 1024,
+		WriteBuffer:         *leveldbBuffer * 1024 * 1024,
+	}
+}
+
+func boltOptions() kvbench.BoltOptions {
+	return kvbench.BoltOptions{
+		ReadTxReuse:     *boltReadTxReuse,
+		InitialMmapSize: *boltMmapSize << 20,
+		NoFreelistSync:  *bboltNoFreelistSync,
+		FreelistType:    *bboltFreelist,
+	}
+}
+
+func pebbleOptions() kvbench.PebbleOptions {
+	return kvbench.PebbleOptions{
+		MaxBatchBytes: *pebbleBatchBytes * 1024 * 1024,
+		MaxBatchCount: *pebbleBatchCount,
+	}
+}
+
+// slotFileSlotSize is the smallest power of two that holds a generated key
+// and the largest -size value in one slot of the slotfile store.
+func slotFileSlotSize() int {
+	need := 7 + len(*keyPrefix) + 9 + sizes.max()
+	n := 64
+	for n < need {
+		n *= 2
+	}
+	return n
 }
 
-func genKeyPrefix(i uint64) []byte {
-	r := make([]byte, 3)
-	rand.Read(r)
-	v := rand.Intn(127 - 32)
-	r[0] = byte(32 + v)
-	return r
+// storeSettings describes the tuning flags that apply to store, "" if none do.
+func storeSettings(store string) string {
+	switch store[strings.LastIndex(store, ":")+1:] {
+	case "leveldb":
+		return levelDBOptions().String()
+	case "pebble":
+		return pebbleOptions().String()
+	case "bolt", "bbolt":
+		return boltOptions().String()
+	}
+	return ""
 }
 
 func getStore(s string, fsync bool, path string) (kvbench.Store, string, error) {
+	if strings.HasPrefix(s, "delay:") {
+		return getDelayStore(s, fsync, path)
+	}
+	if strings.HasPrefix(s, "fault:") {
+		return getFaultStore(s, fsync, path)
+	}
+	if strings.HasPrefix(s, "tiered:") {
+		return getTieredStore(s, fsync, path)
+	}
+	if codec, inner, ok := strings.Cut(s, ":"); ok {
+		switch codec {
+		case "snappy", "zstd", "lz4", "none":
+			store, path, err := getStore(inner, fsync, path)
+			if err != nil {
+				return nil, path, err
+			}
+			store, err = kvbench.NewCompressStore(store, codec)
+			return store, path, err
+		}
+	}
+
 	var store kvbench.Store
 	var err error
 	switch s {
@@ -482,17 +1873,17 @@ func getStore(s string, fsync bool, path string) (kvbench.Store, string, error)
 		if path == "" {
 			path = "bolt.db"
 		}
-		store, err = kvbench.NewBoltStore(path, fsync)
+		store, err = kvbench.NewBoltStoreWithOptions(path, fsync, boltOptions())
 	case "bbolt":
 		if path == "" {
 			path = "bbolt.db"
 		}
-		store, err = kvbench.NewBboltStore(path, fsync)
+		store, err = kvbench.NewBboltStoreWithOptions(path, fsync, boltOptions())
 	case "leveldb":
 		if path == "" {
 			path = "leveldb.db"
 		}
-		store, err = kvbench.NewLevelDBStore(path, fsync)
+		store, err = kvbench.NewLevelDBStoreWithOptions(path, fsync, levelDBOptions())
 	case "kv":
 		log.Warningf("kv store is unstable")
 		if path == "" {
@@ -504,21 +1895,36 @@ func getStore(s string, fsync bool, path string) (kvbench.Store, string, error)
 			path = "badger.db"
 		}
 		store, err = kvbench.NewBadgerStore(path, fsync)
+	case "badger-managed":
+		if path == "" {
+			path = "badger.db"
+		}
+		store, err = kvbench.NewBadgerManagedStore(path, fsync)
+	case "badgerv4":
+		if path == "" {
+			path = "badgerv4.db"
+		}
+		store, err = kvbench.NewBadgerV4Store(path, fsync)
 	case "buntdb":
 		if path == "" {
 			path = "buntdb.db"
 		}
 		store, err = kvbench.NewBuntdbStore(path, fsync)
-	//case "rocksdb":
-	//	if path == "" {
-	//		path = "rocksdb.db"
-	//	}
-	//	store, err = kvbench.NewRocksdbStore(path, fsync)
+	case "rocksdb":
+		if path == "" {
+			path = "rocksdb.db"
+		}
+		store, err = kvbench.NewRocksdbStore(path, fsync)
+	case "moss":
+		if path == "" {
+			path = "moss.db"
+		}
+		store, err = kvbench.NewMossStore(path, fsync)
 	case "pebble":
 		if path == "" {
 			path = "pebble.db"
 		}
-		store, err = kvbench.NewPebbleStore(path, fsync)
+		store, err = kvbench.NewPebbleStoreWithOptions(path, fsync, pebbleOptions())
 	case "pogreb":
 		if path == "" {
 			path = "pogreb.db"
@@ -529,11 +1935,135 @@ func getStore(s string, fsync bool, path string) (kvbench.Store, string, error)
 			path = "nutsdb.db"
 		}
 		store, err = kvbench.NewNutsdbStore(path, fsync)
+	case "hlog":
+		if path == "" {
+			path = "hlog.db"
+		}
+		store, err = kvbench.NewHybridLogStore(path, fsync)
+	case "slotfile":
+		if path == "" {
+			path = "slotfile.db"
+		}
+		store, err = kvbench.NewSlottedFileStore(path, slotFileSlotSize(), fsync)
+	case "sqlite":
+		if path == "" {
+			path = "sqlite.db"
+		}
+		store, err = kvbench.NewSQLiteStore(path, fsync)
+	case "memdb":
+		// memory only
+		if path == "" {
+			path = ":memory:"
+		}
+		store, err = kvbench.NewMemdbStore(path, fsync)
+	case "lru":
+		// memory only
+		path = ":memory:"
+		store, err = kvbench.NewLRUStore(*lruCapacity)
+	case "freecache":
+		// memory only
+		path = ":memory:"
+		store, err = kvbench.NewFreecacheStore(*freecacheSize)
+	case "grpc":
+		// the data lives with the server, there is no local path
+		store, err = kvbench.NewGRPCStore(*grpcAddr)
+	case "resp":
+		// the data lives with the server, there is no local path
+		store, err = kvbench.NewRESPStore(*respAddr)
+	case "redis":
+		// the data lives with the server, there is no local path
+		store, err = kvbench.NewRedisStore(*redisAddr, fsync)
+	case "s3":
+		// the objects live in the bucket, there is no local path
+		store, err = kvbench.NewS3Store(*s3Bucket, *s3Prefix, *s3Endpoint)
+	case "tikv":
+		// the data lives in the cluster, there is no local path; fsync is
+		// up to the servers
+		store, err = kvbench.NewTiKVStore(strings.Split(*tikvPD, ","), *tikvTxn, kvbench.Consistency(*consistency))
+	case "jetstream":
+		// the data lives with the server, there is no local path; fsync is
+		// its sync_interval
+		store, err = kvbench.NewJetStreamKVStore(*jetStreamURL, *jetStreamBucket)
 	}
 
 	return store, path, err
 }
 
+// envOr returns the environment variable key, or def if it is empty.
+func envOr(key, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
+}
+
+// getDelayStore opens a store spec like "delay:10ms:map" or
+// "delay:10ms+2ms:map", where the optional second duration is the jitter.
+func getDelayStore(s string, fsync bool, path string) (kvbench.Store, string, error) {
+	parts := strings.SplitN(s, ":", 3)
+	if len(parts) != 3 {
+		return nil, path, fmt.Errorf("invalid delay store: %v", s)
+	}
+	var delay, jitter time.Duration
+	var err error
+	ds, js, hasJitter := strings.Cut(parts[1], "+")
+	if delay, err = time.ParseDuration(ds); err != nil {
+		return nil, path, fmt.Errorf("invalid delay store: %v", err)
+	}
+	if hasJitter {
+		if jitter, err = time.ParseDuration(js); err != nil {
+			return nil, path, fmt.Errorf("invalid delay store: %v", err)
+		}
+	}
+	store, path, err := getStore(parts[2], fsync, path)
+	if err != nil {
+		return nil, path, err
+	}
+	return kvbench.NewDelayStore(store, delay, jitter), path, nil
+}
+
+// getFaultStore opens a store spec like "fault:0.01:pebble", where the number
+// is the fraction of operations that fail.
+func getFaultStore(s string, fsync bool, path string) (kvbench.Store, string, error) {
+	parts := strings.SplitN(s, ":", 3)
+	if len(parts) != 3 {
+		return nil, path, fmt.Errorf("invalid fault store: %v", s)
+	}
+	errRate, err := strconv.ParseFloat(parts[1], 64)
+	if err != nil || errRate < 0 || errRate > 1 {
+		return nil, path, fmt.Errorf("invalid fault store error rate: %v", parts[1])
+	}
+	store, path, err := getStore(parts[2], fsync, path)
+	if err != nil {
+		return nil, path, err
+	}
+	return kvbench.NewFaultStore(store, errRate), path, nil
+}
+
+// getTieredStore opens a store spec like "tiered:1000000:map:pebble", where
+// the number is the capacity in keys of the hot store, map here, which is
+// opened in memory. The path is that of the cold store.
+func getTieredStore(s string, fsync bool, path string) (kvbench.Store, string, error) {
+	parts := strings.SplitN(s, ":", 4)
+	if len(parts) != 4 {
+		return nil, path, fmt.Errorf("invalid tiered store: %v", s)
+	}
+	capacity, err := strconv.Atoi(parts[1])
+	if err != nil || capacity < 1 {
+		return nil, path, fmt.Errorf("invalid tiered store capacity: %v", parts[1])
+	}
+	hot, _, err := getStore(parts[2], fsync, ":memory:")
+	if err != nil {
+		return nil, path, err
+	}
+	cold, path, err := getStore(parts[3], fsync, path)
+	if err != nil {
+		hot.Close()
+		return nil, path, err
+	}
+	return kvbench.NewTieredStore(hot, cold, capacity), path, nil
+}
+
 // GetDirSize 用于获取指定目录的总大小（以字节为单位）。
 func GetDirSize(path string) (int64, error) {
 	var size int64
@@ -546,6 +2076,28 @@ func GetDirSize(path string) (int64, error) {
 	return size, err
 }
 
+// padToHeader appends -1 to the values of a failed run up to the number of
+// columns in the header of -save, so that its row still lines up with the
+// rows of the runs that completed.
+func padToHeader(values []string) []string {
+	file, err := os.Open(*savePath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer file.Close()
+	r := csv.NewReader(file)
+	r.FieldsPerRecord = -1
+	header, err := r.Read()
+	if err != nil {
+		// an empty file has no columns to line up with
+		return values
+	}
+	for len(values) < len(header) {
+		values = append(values, "-1")
+	}
+	return values
+}
+
 // 向savePath追加数据
 func saveReorder(record *Record) {
 	if *savePath == "" {
@@ -553,6 +2105,7 @@ func saveReorder(record *Record) {
 	}
 	values := make([]string, 0, len(record.Values))
 	values = append(values, record.Name)
+	values = append(values, record.Info...)
 	for _, v := range record.Values {
 		values = append(values, strconv.Itoa(v))
 	}
@@ -564,12 +2117,20 @@ func saveReorder(record *Record) {
 		defer file.Close()
 		writer := csv.NewWriter(file)
 		defer writer.Flush()
-		writer.Write(record.Headers)
+		if *units {
+			writer.Write(record.metricNames())
+			writer.Write(record.Units)
+		} else {
+			writer.Write(record.Headers)
+		}
 		writer.Write(values)
 		if err := writer.Error(); err != nil {
 			log.Fatal(err)
 		}
 	} else {
+		if record.Err != nil {
+			values = padToHeader(values)
+		}
 		file, err := os.OpenFile(*savePath, os.O_APPEND|os.O_WRONLY, 0600)
 		if err != nil {
 			log.Fatal(err)
@@ -0,0 +1,944 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/smallnest/kvbench"
+)
+
+// Stores that support different phases must still produce the same columns,
+// or their rows would not line up in one CSV.
+func TestRunPhases_sameHeaders(t *testing.T) {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	defer func(d time.Duration) { *duration = d }(*duration)
+	defer func(n int) { *setCount = n }(*setCount)
+	defer func(n int) { *c = n }(*c)
+	*duration = 20 * time.Millisecond
+	*setCount = 1000
+	*c = 2
+	if err := initValues(*size); err != nil {
+		t.Fatal(err)
+	}
+
+	var headers [][]string
+	// map/memory has no disk usage, pogreb cannot scan keys and compacts,
+	// zstd:map reports its compression, leveldb its options, tiered its tiers
+	for _, spec := range []struct{ store, path string }{
+		{"map", ":memory:"},
+		{"pogreb", ""},
+		{"zstd:map", ""},
+		{"leveldb", ""},
+		{"tiered:100:map:map", ""},
+	} {
+		store, path, err := getStore(spec.store, false, spec.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		record := newRecord(spec.store, store.Capabilities(), storeSettings(spec.store), "n/a", "")
+		store, err = runPhases(record, spec.store, store, path, path == ":memory:", nil)
+		if err != nil {
+			t.Fatalf("%s: %v", spec.store, err)
+		}
+		store.Close()
+		// as main does, so that the next store does not load these files
+		if path != ":memory:" {
+			os.RemoveAll(path)
+		}
+		if len(record.Headers) != len(record.Info)+len(record.Values)+1 {
+			t.Fatalf("%s: %d headers for %d values", spec.store, len(record.Headers), len(record.Info)+len(record.Values)+1)
+		}
+		headers = append(headers, record.Headers)
+	}
+	for i := 1; i < len(headers); i++ {
+		if !reflect.DeepEqual(headers[0], headers[i]) {
+			t.Fatalf("headers differ:\n%q\n%q", headers[0], headers[i])
+		}
+	}
+}
+
+// failingStore fails or panics in every PSet, as a broken backend would
+// in the load phase.
+type failingStore struct {
+	kvbench.Store
+	panics bool
+}
+
+func (s failingStore) PSet(keys, values [][]byte) error {
+	if s.panics {
+		panic("backend crashed")
+	}
+	return errors.New("disk on fire")
+}
+
+// A store that fails in the load phase gets a row of -1 lined up with the
+// rows already saved, rather than aborting the run without one.
+func TestRunPhases_failedStoreSaved(t *testing.T) {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+	defer func(p string) { *savePath = p }(*savePath)
+	*savePath = "runs.csv"
+
+	defer func(d time.Duration) { *duration = d }(*duration)
+	defer func(n int) { *setCount = n }(*setCount)
+	defer func(n int) { *c = n }(*c)
+	// under -race some phases of the map run may make no call in a window
+	// this short, which gives them a mean of -1 rather than a panic
+	*duration = 20 * time.Millisecond
+	*setCount = 1000
+	*c = 2
+	if err := initValues(*size); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"map", "failing", "panicking"} {
+		store, err := kvbench.NewMapStore(":memory:", false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if name != "map" {
+			store = failingStore{store, name == "panicking"}
+		}
+		record := newRecord(name, store.Capabilities(), "", "n/a", "")
+		store, err = runPhases(record, name, store, ":memory:", true, nil)
+		if (err != nil) != (name != "map") {
+			t.Fatalf("%s: runPhases returned %v", name, err)
+		}
+		record.Err = err
+		store.Close()
+		saveReorder(record)
+	}
+
+	f, err := os.Open(*savePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	rows, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 4 {
+		t.Fatalf("got %d rows, want the header and 3 runs", len(rows))
+	}
+	header := rows[0]
+	batch := -1
+	for i, h := range header {
+		if h == "batch write cost(s)" {
+			batch = i
+		}
+	}
+	for _, row := range rows[2:] {
+		if len(row) != len(header) || row[batch] != "-1" || row[len(row)-1] != "-1" {
+			t.Fatalf("failed run saved as %q", row)
+		}
+	}
+}
+
+// A SIGINT mid-run stops the phases, removes the files of the store and
+// saves the partial row marked as interrupted. With KVBENCH_MAIN set, the
+// test runs main in this process with the arguments in it, for the parent
+// test to signal.
+func TestInterrupt_cleansUp(t *testing.T) {
+	if args := os.Getenv("KVBENCH_MAIN"); args != "" {
+		os.Args = append([]string{"cli"}, strings.Fields(args)...)
+		main()
+		return
+	}
+	if runtime.GOOS == "windows" {
+		t.Skip("no SIGINT on windows")
+	}
+	dir := t.TempDir()
+	cmd := exec.Command(os.Args[0], "-test.run=^TestInterrupt_cleansUp$")
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "KVBENCH_MAIN=-s leveldb -d 10s -c 2 -set 1000 -save runs.csv")
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	store := filepath.Join(dir, "leveldb.db")
+	for start := time.Now(); ; time.Sleep(10 * time.Millisecond) {
+		if _, err := os.Stat(store); err == nil {
+			break
+		}
+		if time.Since(start) > 10*time.Second {
+			cmd.Process.Kill()
+			t.Fatal("the store was not created")
+		}
+	}
+	// past the load, into the first timed phase
+	time.Sleep(500 * time.Millisecond)
+	start := time.Now()
+	if err := cmd.Process.Signal(os.Interrupt); err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+	var err error
+	select {
+	case err = <-done:
+	case <-time.After(30 * time.Second):
+		cmd.Process.Kill()
+		t.Fatalf("still running 30s after SIGINT:\n%s", out.String())
+	}
+	var exit *exec.ExitError
+	if !errors.As(err, &exit) || exit.ExitCode() != 1 {
+		t.Fatalf("exited with %v, want status 1:\n%s", err, out.String())
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Fatalf("took %s to stop, more than the rest of the phase", d)
+	}
+	if _, err := os.Stat(store); !os.IsNotExist(err) {
+		t.Fatalf("store left behind: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "runs.csv")); err != nil {
+		t.Fatalf("partial row not saved: %v", err)
+	}
+	meta, err := os.ReadFile(filepath.Join(dir, "runs.csv.meta.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(meta, []byte(`"interrupted":true`)) {
+		t.Fatalf("row not marked as interrupted: %s", meta)
+	}
+}
+
+// The runs of -runs are averaged, with a stddev after each op/s, and a failed
+// last run is left out.
+func TestAggregateRuns(t *testing.T) {
+	var records []*Record
+	for _, v := range [][]int{{100, 10, -1}, {200, 20, 5}, {300, 30, 5}, {1, 1}} {
+		r := newRecord("map", 0, "", "n/a", "")
+		headers := []string{"Set op/s", "Set p99(ns)", "Zipf op/s"}
+		units := []string{"op/s", "ns", "op/s"}
+		for i := range v {
+			r.add(headers[i], units[i], v[i])
+		}
+		records = append(records, r)
+	}
+	records[3].Err = errors.New("disk on fire")
+
+	r := aggregateRuns(records)
+	if r.Err != records[3].Err {
+		t.Fatalf("Err = %v", r.Err)
+	}
+	n := len(r.Headers) - len(r.Values)
+	got := map[string]int{}
+	for i, v := range r.Values {
+		got[r.Headers[n+i]] = v
+	}
+	want := map[string]int{
+		"Set op/s": 200, "Set stddev op/s": 100, "Set p99(ns)": 20,
+		"Zipf op/s": -1, "Zipf stddev op/s": -1,
+	}
+	for h, v := range want {
+		if got[h] != v {
+			t.Errorf("%s = %d, want %d", h, got[h], v)
+		}
+	}
+	if r := aggregateRuns(records[:1]); r != records[0] {
+		t.Fatal("a single run is not returned as is")
+	}
+}
+
+// Failed calls are counted as errors rather than only as work done.
+func TestRunSets_countsErrors(t *testing.T) {
+	defer func(d time.Duration) { *duration = d }(*duration)
+	defer func(n int) { *c = n }(*c)
+	*duration = 20 * time.Millisecond
+	*c = 2
+	if err := initValues(*size); err != nil {
+		t.Fatal(err)
+	}
+	inner, err := kvbench.NewMapStore(":memory:", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := kvbench.NewFaultStore(inner, 1)
+
+	p := newPhase(&Record{}, "fault", "Set")
+	n, errs, _ := runSets(p, store)
+	p.stop()
+	if n == 0 || errs != n {
+		t.Fatalf("runSets: %d errors in %d calls", errs, n)
+	}
+
+	p = newPhase(&Record{}, "fault", "Getmixed")
+	r, _ := runGetSet(p, store)
+	p.stop()
+	// under -race the getters may not be scheduled within the phase
+	if r.gets+r.sets == 0 || r.getErrors != r.gets || r.setErrors != r.sets {
+		t.Fatalf("runGetSet: %+v", r)
+	}
+}
+
+// The metrics of a phase are served while the server runs, and the server
+// is gone once stopped.
+func TestStartMetrics(t *testing.T) {
+	defer func(a string) { *metricsAddr = a }(*metricsAddr)
+	defer func(d time.Duration) { *duration = d }(*duration)
+	defer func(n int) { *c = n }(*c)
+	*metricsAddr = "127.0.0.1:0"
+	*duration = 20 * time.Millisecond
+	*c = 2
+	if err := initValues(*size); err != nil {
+		t.Fatal(err)
+	}
+	stop, err := startMetrics()
+	if err != nil {
+		t.Fatal(err)
+	}
+	url := "http://" + metrics.addr.String() + "/metrics"
+
+	store, err := kvbench.NewMapStore(":memory:", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := newPhase(&Record{}, "map", "Set")
+	runSets(p, store)
+	p.stop()
+
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`kvbench_ops_total{phase="Set",store="map"}`,
+		`kvbench_op_latency_seconds_count{phase="Set",store="map"}`,
+		`kvbench_written_bytes_total{phase="Set",store="map"}`,
+	} {
+		if !strings.Contains(string(body), want) {
+			t.Fatalf("metrics lack %s:\n%s", want, body)
+		}
+	}
+
+	if err := stop(); err != nil {
+		t.Fatal(err)
+	}
+	if metrics != nil {
+		t.Fatal("metrics still set after stop")
+	}
+	if _, err := http.Get(url); err == nil {
+		t.Fatal("metrics still served after stop")
+	}
+}
+
+func TestGenKey_prefix(t *testing.T) {
+	defer func(p, o string) { *keyPrefix, *keyOrder = p, o }(*keyPrefix, *keyOrder)
+	*keyPrefix = "tenant1/"
+	for _, order := range []string{"random", "sequential", "reverse"} {
+		*keyOrder = order
+		if k := genKey(42); !bytes.HasPrefix(k, []byte("tenant1/")) || len(k) != len("tenant1/")+9 {
+			t.Fatalf("%s: genKey(42) = %q", order, k)
+		}
+	}
+	if k := genKeyPrefix(newRand(0)); !bytes.HasPrefix(k, []byte("tenant1/")) || len(k) != len("tenant1/")+3 {
+		t.Fatalf("genKeyPrefix = %q", k)
+	}
+}
+
+// Each goroutine draws the same values from -seed in every run, and
+// different ones from the other goroutines.
+func TestNewRand_seed(t *testing.T) {
+	defer func(s int64) { *seed = s }(*seed)
+	*seed = 7
+	a, b := newRand(1).Int63(), newRand(1).Int63()
+	if a != b {
+		t.Fatalf("newRand(1) drew %d, then %d", a, b)
+	}
+	if c := newRand(2).Int63(); c == a {
+		t.Fatalf("newRand(1) and newRand(2) both drew %d", a)
+	}
+}
+
+// The read phases must find the keys of the load phase, in every key order.
+func TestGenKey_loadedKeysFound(t *testing.T) {
+	if err := initValues(*size); err != nil {
+		t.Fatal(err)
+	}
+	defer func(o string) { *keyOrder = o }(*keyOrder)
+	for _, order := range []string{"random", "sequential", "reverse"} {
+		*keyOrder = order
+		store, _, err := getStore("map", false, ":memory:")
+		if err != nil {
+			t.Fatal(err)
+		}
+		record := newRecord("map", store.Capabilities(), storeSettings("map"), "n/a", "")
+		testBatchWriteFixCount(record, "map", store, 2500)
+		// every index is a key of its own, none overwriting another
+		keys, _, err := store.AllKeys(0, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		distinct := make(map[string]bool, len(keys))
+		for _, k := range keys {
+			distinct[string(k)] = true
+		}
+		if len(distinct) != 2500 {
+			t.Fatalf("%s: %d distinct keys loaded, want 2500", order, len(distinct))
+		}
+		for _, i := range []uint64{0, 999, 1000, 2499} {
+			if _, ok, err := store.Get(genKey(i)); err != nil || !ok {
+				t.Fatalf("%s: key %d written by the load phase not found: %v", order, i, err)
+			}
+		}
+		if _, ok, _ := store.Get(genKey(2500)); ok {
+			t.Fatalf("%s: key 2500 found, past the loaded keys", order)
+		}
+		if !bytes.Equal(genKey(7), genKey(7)) || bytes.Equal(genKey(7), genKey(8)) {
+			t.Fatalf("%s: genKey is not one key per index", order)
+		}
+		store.Close()
+	}
+}
+
+// -compressible zeroes the tail of the values and leaves the rest random, so
+// that the values compress to about the random share.
+func TestFillValue_compressible(t *testing.T) {
+	defer func(c float64) { *compressible = c }(*compressible)
+	r := newRand(0)
+	for _, c := range []float64{0, 0.75, 1} {
+		*compressible = c
+		b := make([]byte, 4096)
+		for i := range b {
+			b[i] = 0xff
+		}
+		fillValue(b, r.Read)
+		random := len(b) - int(float64(len(b))*c)
+		if !bytes.Equal(b[random:], make([]byte, len(b)-random)) {
+			t.Fatalf("-compressible %v: the tail is not zeroed", c)
+		}
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		zw.Write(b)
+		zw.Close()
+		if buf.Len() < random || buf.Len() > random+random/10+64 {
+			t.Fatalf("-compressible %v: %d bytes compress to %d, want about %d", c, len(b), buf.Len(), random)
+		}
+	}
+}
+
+// A -keyfile is read in both formats, gzipped or not, and the load writes its
+// keys with their values, or random ones where the file has none.
+func TestKeyFile_replayed(t *testing.T) {
+	if err := initValues(*size); err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	lines := filepath.Join(dir, "keys.txt.gz")
+	f, err := os.Create(lines)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zw := gzip.NewWriter(f)
+	io.WriteString(zw, "user:1\tAlice\r\n\nuser:2\nuser:3\tCarol\n")
+	zw.Close()
+	f.Close()
+	var lp bytes.Buffer
+	for _, kv := range [][2]string{{"user:1", "Alice"}, {"user:2", ""}, {"user:3", "Carol"}} {
+		for _, b := range kv {
+			binary.Write(&lp, binary.BigEndian, uint32(len(b)))
+			lp.WriteString(b)
+		}
+	}
+	prefixed := filepath.Join(dir, "keys.bin")
+	if err := os.WriteFile(prefixed, lp.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	defer func() { workload = nil }()
+	for _, tc := range []struct{ path, format string }{{lines, "lines"}, {prefixed, "lenprefix"}} {
+		w, err := loadKeyFile(tc.path, tc.format)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.format, err)
+		}
+		if len(w.keys) != 3 || w.valued != 2 || string(w.key(4)) != "user:2" || w.value(1) != nil {
+			t.Fatalf("%s: read %q %q", tc.format, w.keys, w.values)
+		}
+		workload = w
+		store, _, err := getStore("map", false, ":memory:")
+		if err != nil {
+			t.Fatal(err)
+		}
+		record := newRecord("map", store.Capabilities(), "", "n/a", "")
+		testBatchWriteFixCount(record, "map", store, 3)
+		if n, _ := store.Count(); n != 3 {
+			t.Fatalf("%s: %d keys loaded, want 3", tc.format, n)
+		}
+		if v, ok, _ := store.Get([]byte("user:3")); !ok || string(v) != "Carol" {
+			t.Fatalf("%s: user:3 = %q, %v", tc.format, v, ok)
+		}
+		if v, ok, _ := store.Get([]byte("user:2")); !ok || len(v) != sizes.pick(1) {
+			t.Fatalf("%s: user:2 = %d bytes, %v", tc.format, len(v), ok)
+		}
+		store.Close()
+	}
+	if _, err := loadKeyFile(prefixed, "csv"); err == nil {
+		t.Fatal("unknown -keyfile-format accepted")
+	}
+	if err := os.WriteFile(prefixed, lp.Bytes()[:lp.Len()-2], 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadKeyFile(prefixed, "lenprefix"); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Fatalf("truncated -keyfile: %v", err)
+	}
+}
+
+// A -batch larger than the load is clamped to one batch of all of it, and
+// recorded.
+func TestBatchWriteFixCount_batchSize(t *testing.T) {
+	if err := initValues(*size); err != nil {
+		t.Fatal(err)
+	}
+	defer func(b int) { *batch = b }(*batch)
+	for _, c := range []struct{ batch, count, want int }{
+		{1000, 2500, 1000},
+		{7, 50, 7},
+		{1000, 10, 10},
+	} {
+		*batch = c.batch
+		store, _, err := getStore("map", false, ":memory:")
+		if err != nil {
+			t.Fatal(err)
+		}
+		record := newRecord("map", store.Capabilities(), "", "n/a", "")
+		if _, _, err := testBatchWriteFixCount(record, "map", store, c.count); err != nil {
+			t.Fatal(err)
+		}
+		if n, _ := store.Count(); n != int64(c.count) {
+			t.Fatalf("-batch %d: %d of %d keys loaded", c.batch, n, c.count)
+		}
+		if got := record.Values[len(record.Values)-1]; record.Headers[len(record.Headers)-1] != "Batch size" || got != c.want {
+			t.Fatalf("-batch %d of %d keys: recorded %s = %d, want %d", c.batch, c.count, record.Headers[len(record.Headers)-1], got, c.want)
+		}
+		store.Close()
+	}
+}
+
+// A batch mixed window in which not one batch completes, here because it
+// is over as it starts, records -1 as its amortized cost.
+func TestBatchMixed_noBatch(t *testing.T) {
+	defer func(d time.Duration) { *duration = d }(*duration)
+	defer func(n int) { *c = n }(*c)
+	*duration = 0
+	*c = 2
+	store, err := kvbench.NewMapStore(":memory:", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	record := newRecord("map", store.Capabilities(), "", "n/a", "")
+	testBatchMixed(record, "map", store)
+	values := record.Headers[len(record.Headers)-len(record.Values):]
+	for i, h := range values {
+		if h == "Batchmixed amortized(ns)" {
+			if record.Values[i] != -1 {
+				t.Fatalf("amortized cost of no batch recorded as %d", record.Values[i])
+			}
+			return
+		}
+	}
+	t.Fatal("no Batchmixed amortized(ns) column")
+}
+
+// discardStore drops every PSet, so that a benchmark of the load phase counts
+// the allocations of the loader alone.
+type discardStore struct {
+	kvbench.Store
+}
+
+func (discardStore) PSet(keys, values [][]byte) error {
+	return nil
+}
+
+// BenchmarkBatchWriteFixCount loads 10000 entries per op in batches of 1000.
+// The loader fills the buffers of one batch in place, so its allocations per
+// op stay a few dozen rather than a few per entry.
+func BenchmarkBatchWriteFixCount(b *testing.B) {
+	if err := initValues(*size); err != nil {
+		b.Fatal(err)
+	}
+	defer func(v bool) { *verify = v }(*verify)
+	*verify = false
+	stdout := os.Stdout
+	os.Stdout, _ = os.Open(os.DevNull)
+	defer func() { os.Stdout = stdout }()
+	store := discardStore{}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		record := newRecord("discard", 0, "", "n/a", "")
+		if _, _, err := testBatchWriteFixCount(record, "discard", store, 10000); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// Stores that write nothing to the local disk skip the free disk space
+// check, however large the load.
+func TestCheckDiskSpace_notOnDisk(t *testing.T) {
+	if err := initValues(*size); err != nil {
+		t.Fatal(err)
+	}
+	defer func(n int) { *setCount = n }(*setCount)
+	*setCount = 1 << 40
+	dir := t.TempDir()
+	for _, store := range []string{"freecache", "lru", "memdb", "delay:1ms:freecache", "grpc", "s3"} {
+		if err := checkDiskSpace(store, dir, false); err != nil {
+			t.Fatalf("%s: %v", store, err)
+		}
+	}
+	if _, ok := availableDisk(dir); ok {
+		if err := checkDiskSpace("bolt", dir, false); err == nil {
+			t.Fatal("bolt: a load of 2^40 entries fits")
+		}
+	}
+}
+
+func TestMeanKeySize(t *testing.T) {
+	defer func(p string) { *keyPrefix = p }(*keyPrefix)
+	defer func(w *keyFileWorkload) { workload = w }(workload)
+	*keyPrefix = "tenant-1:"
+	workload = nil
+	if n := meanKeySize(); n != len(genKey(0)) {
+		t.Fatalf("meanKeySize() = %d, want %d", n, len(genKey(0)))
+	}
+	workload = &keyFileWorkload{keys: [][]byte{[]byte("a"), []byte("abcdefghijk")}}
+	if n := meanKeySize(); n != 9+6 {
+		t.Fatalf("meanKeySize() of the key file = %d, want 15", n)
+	}
+}
+
+func TestParseCPUList(t *testing.T) {
+	cpus, err := parseCPUList("0-3, 8,2,10-11")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []int{0, 1, 2, 3, 8, 10, 11}; !reflect.DeepEqual(cpus, want) {
+		t.Fatalf("parseCPUList = %v, want %v", cpus, want)
+	}
+	if s := formatCPUList([]int{0, 1, 2, 3, 8, 10, 11}); s != "0-3,8,10-11" {
+		t.Fatalf("formatCPUList = %q", s)
+	}
+	for _, bad := range []string{"", "a", "3-1", "-1", "1,"} {
+		if _, err := parseCPUList(bad); err == nil {
+			t.Fatalf("parseCPUList(%q) succeeded", bad)
+		}
+	}
+}
+
+func TestReadKeys_zipfianInRange(t *testing.T) {
+	defer func(d string, n int) { *dist, *setCount = d, n }(*dist, *setCount)
+	*dist, *setCount = "zipfian", 1000
+	keys := newReadKeys(0)
+	hot := 0
+	for i := 0; i < 10000; i++ {
+		k := keys.next()
+		if k >= uint64(*setCount) {
+			t.Fatalf("zipfian index %d outside the %d loaded keys", k, *setCount)
+		}
+		if k < 10 {
+			hot++
+		}
+	}
+	if hot < 3000 {
+		t.Fatalf("only %d of 10000 reads hit the 10 hottest keys", hot)
+	}
+}
+
+func TestSaveJSON_appendsToArray(t *testing.T) {
+	defer func(p string) { *savePath = p }(*savePath)
+	*savePath = filepath.Join(t.TempDir(), "runs.json")
+	for _, name := range []string{"map/nofsync", "btree/memory/nofsync"} {
+		record := &Record{Name: name}
+		record.Headers = append(record.Headers, "name")
+		record.Units = append(record.Units, "")
+		record.addInfo("KeyOrder", "random")
+		record.add("Get op/s", "op/s", 100)
+		record.add("Get p99(ns)", "ns", 2000)
+		saveJSON(record, strings.Contains(name, "/memory"))
+	}
+	b, err := os.ReadFile(*savePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var runs []jsonRun
+	if err := json.Unmarshal(b, &runs); err != nil {
+		t.Fatalf("not a JSON array: %v\n%s", err, b)
+	}
+	if len(runs) != 2 || runs[0].Name != "map/nofsync" || runs[0].Memory || !runs[1].Memory {
+		t.Fatalf("runs = %+v", runs)
+	}
+	want := []jsonMetric{{"Get op/s", "op/s", 100}, {"Get p99(ns)", "ns", 2000}}
+	if !reflect.DeepEqual(runs[1].Metrics, want) || runs[1].Info["KeyOrder"] != "random" {
+		t.Fatalf("run = %+v", runs[1])
+	}
+}
+
+// The rows of a -csweep are saved after the last level has run, each with
+// the concurrency of its own level.
+func TestSaveJSON_sweepConcurrency(t *testing.T) {
+	defer func(p string) { *savePath = p }(*savePath)
+	defer func(n int) { *c = n }(*c)
+	*savePath = filepath.Join(t.TempDir(), "runs.json")
+	if err := initValues(*size); err != nil {
+		t.Fatal(err)
+	}
+	var rows []*Record
+	for _, level := range []int{1, 4} {
+		*c = level
+		rows = append(rows, aggregateRuns([]*Record{newRecord("map/nofsync", 0, "", "n/a", "")}))
+	}
+	for _, record := range rows {
+		saveJSON(record, false)
+	}
+	b, err := os.ReadFile(*savePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var runs []jsonRun
+	if err := json.Unmarshal(b, &runs); err != nil {
+		t.Fatalf("not a JSON array: %v\n%s", err, b)
+	}
+	if len(runs) != 2 || runs[0].Concurrency != 1 || runs[1].Concurrency != 4 {
+		t.Fatalf("runs = %+v", runs)
+	}
+}
+
+// The runs of several stores make one Markdown table, a failed one padded
+// with -1.
+func TestSaveMarkdown_oneTable(t *testing.T) {
+	defer func(p string) { *report = p }(*report)
+	*report = filepath.Join(t.TempDir(), "report.md")
+	for _, name := range []string{"map/nofsync", "btree/memory/nofsync"} {
+		record := &Record{Name: name}
+		record.Headers = append(record.Headers, "name")
+		record.Units = append(record.Units, "")
+		record.addInfo("Capabilities", "keys|cas")
+		record.add("Get op/s", "op/s", 100)
+		if name == "map/nofsync" {
+			record.add("Get p99(ns)", "ns", 2000)
+		}
+		saveMarkdown(record)
+	}
+	b, err := os.ReadFile(*report)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `| name | Capabilities | Get op/s | Get p99(ns) |
+| --- | --- | ---: | ---: |
+| map/nofsync | keys\|cas | 100 | 2000 |
+| btree/memory/nofsync | keys\|cas | 100 | -1 |
+`
+	if string(b) != want {
+		t.Fatalf("report =\n%s\nwant\n%s", b, want)
+	}
+}
+
+func TestParseMix(t *testing.T) {
+	for _, c := range []struct {
+		mix  string
+		want float64
+	}{{"90:10", 0.9}, {"1:1", 0.5}, {"0:5", 0}, {" 3 : 1 ", 0.75}} {
+		got, err := parseMix(c.mix)
+		if err != nil || got != c.want {
+			t.Fatalf("parseMix(%q) = %v, %v, want %v", c.mix, got, err, c.want)
+		}
+	}
+	for _, bad := range []string{"", "90", "a:b", "-1:2", "0:0"} {
+		if _, err := parseMix(bad); err == nil {
+			t.Fatalf("parseMix(%q) succeeded", bad)
+		}
+	}
+}
+
+func TestParseSweep(t *testing.T) {
+	defer func(n int) { *c = n }(*c)
+	*c = 3
+	if levels, err := parseSweep(""); err != nil || !reflect.DeepEqual(levels, []int{3}) {
+		t.Fatalf("parseSweep(\"\") = %v, %v, want [3]", levels, err)
+	}
+	if levels, err := parseSweep("1, 2,4,32"); err != nil || !reflect.DeepEqual(levels, []int{1, 2, 4, 32}) {
+		t.Fatalf("parseSweep = %v, %v", levels, err)
+	}
+	for _, bad := range []string{"0", "1,,2", "a", "1,-4"} {
+		if _, err := parseSweep(bad); err == nil {
+			t.Fatalf("parseSweep(%q) succeeded", bad)
+		}
+	}
+}
+
+func TestConsistencyInfo(t *testing.T) {
+	if got, err := consistencyInfo("zstd:bolt", "strong"); err != nil || got != "n/a" {
+		t.Fatalf("consistencyInfo(bolt, strong) = %q, %v, want n/a", got, err)
+	}
+	for _, bad := range []string{"eventual", "weak"} {
+		if _, err := consistencyInfo("bolt", bad); err == nil {
+			t.Fatalf("consistencyInfo(bolt, %s) succeeded", bad)
+		}
+	}
+	if got, err := consistencyInfo("delay:1ms:tikv", "eventual"); err != nil || got != "eventual" {
+		t.Fatalf("consistencyInfo(tikv, eventual) = %q, %v, want eventual", got, err)
+	}
+}
+
+func TestParseSizes(t *testing.T) {
+	vs, err := parseSizes("64:70, 1024 : 25,65536:5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if vs.max() != 65536 || vs.mean() != (64*70+1024*25+65536*5)/100 {
+		t.Fatalf("max %d, mean %d", vs.max(), vs.mean())
+	}
+	counts := make(map[int]int)
+	for i := uint64(0); i < 10000; i++ {
+		counts[vs.pick(i)]++
+	}
+	// within a few percent of the weights
+	if counts[64] < 6500 || counts[1024] < 2200 || counts[65536] < 300 || counts[65536] > 700 {
+		t.Fatalf("picked sizes %v", counts)
+	}
+	if vs, err := parseSizes("256"); err != nil || vs.pick(1) != 256 || vs.mean() != 256 {
+		t.Fatalf("parseSizes(256) = %v, %v", vs, err)
+	}
+	for _, bad := range []string{"", "a", "-1", "64:0", "64:", "64:70,"} {
+		if _, err := parseSizes(bad); err == nil {
+			t.Fatalf("parseSizes(%q) succeeded", bad)
+		}
+	}
+}
+
+func TestLatencyHist(t *testing.T) {
+	var h latencyHist
+	for i := 1; i <= 1000; i++ {
+		h.add(time.Duration(i) * time.Microsecond)
+	}
+	for _, c := range []struct {
+		p    float64
+		want time.Duration
+	}{{50, 500 * time.Microsecond}, {99, 990 * time.Microsecond}, {99.9, 999 * time.Microsecond}} {
+		got := h.percentile(c.p)
+		// a bucket is at most 1/32 of its values wide
+		if got > c.want || got < c.want-c.want/32 {
+			t.Fatalf("p%v = %s, want about %s", c.p, got, c.want)
+		}
+	}
+	if got := h.percentile(100); got != time.Millisecond {
+		t.Fatalf("max = %s, want 1ms", got)
+	}
+	for _, v := range []int64{0, 31, 32, 63, 64, 1000, 1 << 40, 1<<63 - 1} {
+		if i := latencyBucket(v); latencyLow(i) > v || i+1 < latencyBuckets && latencyLow(i+1) <= v {
+			t.Fatalf("bucket %d of %d covers [%d, %d)", i, v, latencyLow(i), latencyLow(i+1))
+		}
+	}
+}
+
+func TestCompareResults(t *testing.T) {
+	dir := t.TempDir()
+	a := filepath.Join(dir, "a.csv")
+	b := filepath.Join(dir, "b.csv")
+	os.WriteFile(a, []byte("name,Set op/s,Get p99(us),Keys op/s\nmap,100,10,-1\nbolt,50,20,5\n"), 0600)
+	os.WriteFile(b, []byte("name,Set op/s,Get p99(us),Keys op/s\nmap,80,5,-1\npebble,1,1,1\n"), 0600)
+	var out bytes.Buffer
+	if err := compareResults(&out, a, b, false); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"-20.0%", "-50.0%", "1 stores, 2 metrics compared: 1 improved, 1 regressed"} {
+		if !strings.Contains(out.String(), want) {
+			t.Fatalf("output lacks %q:\n%s", want, out.String())
+		}
+	}
+}
+
+// keyRecorder records the keys a phase gets and sets.
+type keyRecorder struct {
+	kvbench.Store
+	mu   sync.Mutex
+	gets map[string]int
+	sets map[string]int
+}
+
+func (s *keyRecorder) Get(key []byte) ([]byte, bool, error) {
+	s.mu.Lock()
+	s.gets[string(key)]++
+	s.mu.Unlock()
+	return s.Store.Get(key)
+}
+
+func (s *keyRecorder) Set(key, value []byte) error {
+	s.mu.Lock()
+	s.sets[string(key)]++
+	s.mu.Unlock()
+	return s.Store.Set(key, value)
+}
+
+// Every goroutine of the hot keys test gets and sets the hot keys, and no
+// other.
+func TestRunHotKeys_onlyHotKeys(t *testing.T) {
+	defer func(d time.Duration) { *duration = d }(*duration)
+	defer func(n int) { *c = n }(*c)
+	*duration = 20 * time.Millisecond
+	*c = 4
+	if err := initValues(*size); err != nil {
+		t.Fatal(err)
+	}
+	inner, err := kvbench.NewMapStore(":memory:", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := &keyRecorder{Store: inner, gets: make(map[string]int), sets: make(map[string]int)}
+	keys := [][]byte{genKey(0), genKey(1), genKey(2)}
+
+	p := newPhase(&Record{}, "map", "Hotkeys")
+	n, errs, _ := runHotKeys(p, store, keys, 0.5)
+	p.stop()
+	if n == 0 || errs != 0 {
+		t.Fatalf("runHotKeys: %d errors in %d calls", errs, n)
+	}
+	for _, ops := range []map[string]int{store.gets, store.sets} {
+		if len(ops) != len(keys) {
+			t.Fatalf("%d keys used, want the %d hot keys", len(ops), len(keys))
+		}
+		for _, k := range keys {
+			if ops[string(k)] == 0 {
+				t.Fatalf("hot key %q not used", k)
+			}
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/smallnest/log"
+)
+
+// saveMarkdown appends the row of record to the Markdown table in -report,
+// writing the header first if the file does not exist yet, so that the runs
+// of several stores make one table with a row per store. Numbers are right
+// aligned.
+func saveMarkdown(record *Record) {
+	if *report == "" {
+		return
+	}
+	cells := make([]string, 0, len(record.Headers))
+	cells = append(cells, record.Name)
+	cells = append(cells, record.Info...)
+	for _, v := range record.Values {
+		cells = append(cells, strconv.Itoa(v))
+	}
+
+	var b strings.Builder
+	columns, err := markdownColumns(*report)
+	if os.IsNotExist(err) {
+		writeMarkdownRow(&b, record.Headers)
+		align := make([]string, len(record.Headers))
+		for i := range align {
+			if i < 1+len(record.Info) {
+				align[i] = "---"
+			} else {
+				align[i] = "---:"
+			}
+		}
+		writeMarkdownRow(&b, align)
+	} else if err != nil {
+		log.Fatal(err)
+	}
+	// a failed run lines up with the table like padToHeader does for -save
+	for len(cells) < columns {
+		cells = append(cells, "-1")
+	}
+	writeMarkdownRow(&b, cells)
+
+	file, err := os.OpenFile(*report, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer file.Close()
+	if _, err := file.WriteString(b.String()); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// markdownColumns returns the number of columns of the header of the table
+// in path, 0 if the file is empty.
+func markdownColumns(path string) (int, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+	sc := bufio.NewScanner(file)
+	sc.Buffer(nil, 1<<20)
+	if !sc.Scan() {
+		return 0, sc.Err()
+	}
+	return strings.Count(sc.Text(), "|") - strings.Count(sc.Text(), `\|`) - 1, nil
+}
+
+// writeMarkdownRow writes cells as one row of a Markdown table, escaping the
+// pipes within them.
+func writeMarkdownRow(b *strings.Builder, cells []string) {
+	b.WriteString("|")
+	for _, c := range cells {
+		fmt.Fprintf(b, " %s |", strings.ReplaceAll(c, "|", `\|`))
+	}
+	b.WriteString("\n")
+}
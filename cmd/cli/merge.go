@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/smallnest/kvbench"
+)
+
+// mergeCounters is the number of counters each goroutine of the merge test
+// adds to.
+const mergeCounters = 100
+
+// test adding to counters with Merge, compared to reading, adding and
+// writing them back with Get and Set. Merge uses the store's merge operator
+// where it has one and an atomic read-modify-write otherwise, so the gap
+// between the two is the cost of its atomicity. Every goroutine has its own
+// counters, so the Get+Set variant loses no updates. Stores without Merge
+// record -1.
+func testMerge(record *Record, name string, store kvbench.Store) {
+	if !store.Capabilities().Has(kvbench.CapMerge) {
+		fmt.Printf("%s merge rate: %d op/s, get+set rate: %d op/s\n", name, -1, -1)
+		record.add("Merge op/s", "op/s", -1)
+		record.add("Merge GetSet op/s", "op/s", -1)
+		return
+	}
+	one := make([]byte, 8)
+	binary.BigEndian.PutUint64(one, 1)
+
+	p := newPhase(record, name, "Merge")
+	mergeRate := runCounterOps(p, "merge-", func(key []byte) {
+		store.Merge(key, one)
+	})
+	p.stop()
+
+	p = newPhase(record, name, "Merge GetSet")
+	getSetRate := runCounterOps(p, "getset-", func(key []byte) {
+		v := make([]byte, 8)
+		if old, ok, err := store.Get(key); err == nil && ok && len(old) == 8 {
+			binary.BigEndian.PutUint64(v, binary.BigEndian.Uint64(old)+1)
+		} else {
+			binary.BigEndian.PutUint64(v, 1)
+		}
+		store.Set(key, v)
+	})
+	p.stop()
+
+	fmt.Printf("%s merge rate: %d op/s, get+set rate: %d op/s\n", name, mergeRate, getSetRate)
+	record.add("Merge op/s", "op/s", int(mergeRate))
+	record.add("Merge GetSet op/s", "op/s", int(getSetRate))
+}
+
+// runCounterOps calls add from *c goroutines until p is done, each cycling
+// through its own mergeCounters keys starting with prefix, and returns the
+// rate in op/s.
+func runCounterOps(p *phase, prefix string, add func(key []byte)) int64 {
+	var wg sync.WaitGroup
+	wg.Add(*c)
+
+	counts := make([]int, *c)
+	start := time.Now()
+	for j := 0; j < *c; j++ {
+		index := uint64(j)
+		go func() {
+			keys := make([][]byte, mergeCounters)
+			for k := range keys {
+				keys[k] = []byte(*keyPrefix + prefix + strconv.Itoa(int(index)) + "-" + strconv.Itoa(k))
+			}
+			var count int
+		LOOP:
+			for {
+				select {
+				case <-p.done():
+					break LOOP
+				default:
+					add(keys[count%mergeCounters])
+					count++
+					p.tick(index, 1)
+				}
+			}
+			counts[index] = count
+			wg.Done()
+		}()
+	}
+	wg.Wait()
+	d := int64(time.Since(start))
+	var n int
+	for _, count := range counts {
+		n += count
+	}
+	return int64(n) * 1e6 / (d / 1e3)
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"os"
+	"runtime"
+	"time"
+
+	"github.com/smallnest/log"
+)
+
+// runMeta describes how a CSV row was produced.
+type runMeta struct {
+	Name      string            `json:"name"`
+	Time      time.Time         `json:"time"`
+	Args      []string          `json:"args"`
+	Flags     map[string]string `json:"flags"`
+	Seed      int64             `json:"seed"`
+	Hostname  string            `json:"hostname"`
+	OS        string            `json:"os"`
+	Arch      string            `json:"arch"`
+	GoVersion string            `json:"go_version"`
+	// Interrupted marks a row cut short by SIGINT or SIGTERM, whose
+	// remaining columns are -1.
+	Interrupted bool `json:"interrupted,omitempty"`
+}
+
+// saveMeta appends the invocation of this run to <savePath>.meta.json, one
+// JSON object per line in the order of the CSV rows. Every flag is
+// recorded, including the ones left at their default.
+func saveMeta(record *Record) {
+	if *savePath == "" {
+		return
+	}
+	hostname, _ := os.Hostname()
+	meta := runMeta{
+		Name:        record.Name,
+		Time:        time.Now(),
+		Args:        os.Args,
+		Flags:       make(map[string]string),
+		Seed:        *seed,
+		Hostname:    hostname,
+		OS:          runtime.GOOS,
+		Arch:        runtime.GOARCH,
+		GoVersion:   runtime.Version(),
+		Interrupted: errors.Is(record.Err, errInterrupted),
+	}
+	flag.VisitAll(func(f *flag.Flag) {
+		meta.Flags[f.Name] = f.Value.String()
+	})
+	file, err := os.OpenFile(*savePath+".meta.json", os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer file.Close()
+	if err := json.NewEncoder(file).Encode(meta); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// liveMetrics serves the Prometheus metrics of -metrics-addr, updated by the
+// phases while they run. Every metric is labeled with the store and phase.
+type liveMetrics struct {
+	ops     *prometheus.CounterVec
+	latency *prometheus.HistogramVec
+	bytes   *prometheus.CounterVec
+
+	addr   net.Addr // the address listened on, resolved if -metrics-addr had port 0
+	server *http.Server
+	served chan error
+}
+
+// metrics is nil unless -metrics-addr is set.
+var metrics *liveMetrics
+
+// startMetrics listens on -metrics-addr, serves /metrics there and returns
+// the function that shuts the server down.
+func startMetrics() (func() error, error) {
+	if *metricsAddr == "" {
+		return func() error { return nil }, nil
+	}
+	ln, err := net.Listen("tcp", *metricsAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	labels := []string{"store", "phase"}
+	m := &liveMetrics{
+		ops: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "kvbench_ops_total",
+			Help: "Operations run by the benchmark phases.",
+		}, labels),
+		// the same operations are timed as for the latency percentiles
+		latency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "kvbench_op_latency_seconds",
+			Help:    "Latency of the timed operations of the benchmark phases.",
+			Buckets: prometheus.ExponentialBuckets(1e-6, 2, 24),
+		}, labels),
+		bytes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "kvbench_written_bytes_total",
+			Help: "Bytes of values written by the benchmark phases.",
+		}, labels),
+		addr:   ln.Addr(),
+		served: make(chan error, 1),
+	}
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(m.ops, m.latency, m.bytes)
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(reg, promhttp.HandlerOpts{}))
+	m.server = &http.Server{Handler: mux}
+	go func() {
+		m.served <- m.server.Serve(ln)
+	}()
+	fmt.Printf("metrics: serving on http://%s/metrics\n", m.addr)
+	metrics = m
+
+	return func() error {
+		metrics = nil
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		err := m.server.Shutdown(ctx)
+		if serr := <-m.served; serr != http.ErrServerClosed && err == nil {
+			err = serr
+		}
+		return err
+	}, nil
+}
+
+// wrote adds n bytes written outside of a phase, e.g. by the batch write.
+func (m *liveMetrics) wrote(name, label string, n int) {
+	if m != nil {
+		m.bytes.WithLabelValues(name, label).Add(float64(n))
+	}
+}
+
+// phaseMetrics are the metrics of one phase, looked up once so that the
+// goroutines of the phase only update them.
+type phaseMetrics struct {
+	ops     prometheus.Counter
+	latency prometheus.Observer
+	bytes   prometheus.Counter
+	next    []paddedCount // per goroutine, operations until the next timed one
+}
+
+// forPhase returns the metrics of the phase label of store name, or nil
+// without -metrics-addr.
+func (m *liveMetrics) forPhase(name, label string) *phaseMetrics {
+	if m == nil {
+		return nil
+	}
+	pm := &phaseMetrics{
+		ops:     m.ops.WithLabelValues(name, label),
+		latency: m.latency.WithLabelValues(name, label),
+		bytes:   m.bytes.WithLabelValues(name, label),
+		next:    make([]paddedCount, *c),
+	}
+	for i := range pm.next {
+		pm.next[i].n = latencyEvery
+	}
+	return pm
+}
+
+// wrote adds n bytes written by the phase.
+func (p *phase) wrote(n int) {
+	if p.metrics != nil {
+		p.metrics.bytes.Add(float64(n))
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/smallnest/kvbench"
+)
+
+// parseMix parses -mix, the weights of gets and sets such as "90:10", into
+// the fraction of operations that are gets.
+func parseMix(mix string) (float64, error) {
+	g, s, ok := strings.Cut(mix, ":")
+	if !ok {
+		return 0, fmt.Errorf("bad mix %q, want <get>:<set>", mix)
+	}
+	gets, err := strconv.Atoi(strings.TrimSpace(g))
+	if err != nil || gets < 0 {
+		return 0, fmt.Errorf("bad mix %q, want <get>:<set>", mix)
+	}
+	sets, err := strconv.Atoi(strings.TrimSpace(s))
+	if err != nil || sets < 0 || gets+sets == 0 {
+		return 0, fmt.Errorf("bad mix %q, want <get>:<set>", mix)
+	}
+	return float64(gets) / float64(gets+sets), nil
+}
+
+// test a workload of gets and sets in the ratio of -mix. Unlike getset,
+// which runs one writer against *c readers, every goroutine draws each
+// operation at random, so the ratio holds whatever the store's speed. Gets
+// read the loaded keys and sets overwrite them.
+func testMixed(record *Record, name string, store kvbench.Store) {
+	if *mix == "" {
+		return
+	}
+	getFrac, err := parseMix(*mix)
+	if err != nil {
+		panic(err)
+	}
+
+	warmUp(name, "Mixed", func(p *phase) { runMixed(p, store, getFrac) })
+	p := newPhase(record, name, "Mixed")
+	defer p.stop()
+	g, s, dur := runMixed(p, store, getFrac)
+
+	getRate := int64(float64(g) / dur.Seconds())
+	setRate := int64(float64(s) / dur.Seconds())
+	var ratio float64
+	if g+s > 0 {
+		ratio = 100 * float64(g) / float64(g+s)
+	}
+	fmt.Printf("%s mixed %s get rate: %d op/s, set rate: %d op/s, gets: %.1f%%, took: %d s\n", name, *mix, getRate, setRate, ratio, int(dur.Seconds()))
+	record.add("Mixed get op/s", "op/s", int(getRate))
+	record.add("Mixed set op/s", "op/s", int(setRate))
+	record.add("Mixed get ratio(%)", "%", int(ratio+0.5))
+}
+
+// runMixed gets or sets keys from *c goroutines, a get with probability
+// getFrac, until p is done and returns the number of Get and Set calls.
+func runMixed(p *phase, store kvbench.Store, getFrac float64) (int, int, time.Duration) {
+	var wg sync.WaitGroup
+	wg.Add(*c)
+
+	gets := make([]int, *c)
+	sets := make([]int, *c)
+	start := time.Now()
+	for j := 0; j < *c; j++ {
+		index := uint64(j)
+		go func() {
+			defer wg.Done()
+			// a source per goroutine, as the global one is shared
+			r := newRand(index)
+			var ng, ns int
+			gi, si := index, index
+		LOOP:
+			for {
+				select {
+				case <-p.done():
+					break LOOP
+				default:
+					t := p.sampleStart(index)
+					if r.Float64() < getFrac {
+						_, ok, _ := store.Get(genKey(gi))
+						p.sample(index, t, "get", ok)
+						gi += uint64(*c)
+						ng++
+					} else {
+						store.Set(genKey(si), data)
+						p.sample(index, t, "set", false)
+						p.wrote(len(data))
+						si += uint64(*c)
+						ns++
+					}
+					p.tick(index, 1)
+				}
+			}
+			gets[index], sets[index] = ng, ns
+		}()
+	}
+	wg.Wait()
+	dur := time.Since(start)
+
+	var g, s int
+	for j := range gets {
+		g += gets[j]
+		s += sets[j]
+	}
+	return g, s, dur
+}
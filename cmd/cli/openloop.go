@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/smallnest/kvbench"
+)
+
+// loopMode names the load model, for the Loop column.
+func loopMode() string {
+	if *rate > 0 {
+		return "open"
+	}
+	return "closed"
+}
+
+// test set and get open-loop: operations are due at fixed intervals of
+// 1/-rate whether or not the previous ones have completed, and latency is
+// measured from when an operation was due rather than when it was issued.
+// A store that falls behind the rate therefore shows the queueing delay a
+// client at that load would see, which closed-loop numbers hide.
+func testOpenLoop(record *Record, name string, store kvbench.Store) {
+	if *rate <= 0 {
+		return
+	}
+	runOpenLoop(record, name, "Set", func(i uint64) {
+		store.Set(genKey(i), data)
+	})
+	runOpenLoop(record, name, "Get", func(i uint64) {
+		store.Get(genKey(i))
+	})
+}
+
+func runOpenLoop(record *Record, name, label string, op func(i uint64)) {
+	all, dur := openLoop(*rate, *duration, op)
+	if len(all) == 0 {
+		fmt.Printf("%s openloop %s: no operation due within %s at %d op/s\n", name, label, *duration, *rate)
+		record.add("Openloop "+label+" op/s", "op/s", -1)
+		record.add("Openloop "+label+" p50(us)", "us", -1)
+		record.add("Openloop "+label+" p99(us)", "us", -1)
+		return
+	}
+	achieved := int64(len(all)) * 1e6 / (int64(dur) / 1e3)
+	p50, p99 := percentile(all, 50), percentile(all, 99)
+	fmt.Printf("%s openloop %s at %d op/s: achieved %d op/s, p50: %s, p99: %s, max: %s\n",
+		name, label, *rate, achieved, p50, p99, all[len(all)-1])
+	record.add("Openloop "+label+" op/s", "op/s", int(achieved))
+	record.add("Openloop "+label+" p50(us)", "us", int(p50.Microseconds()))
+	record.add("Openloop "+label+" p99(us)", "us", int(p99.Microseconds()))
+}
+
+// openLoop runs op from *c goroutines at opRate op/s for d and returns the
+// sorted latencies, measured from when each operation was due, and how long
+// it took.
+func openLoop(opRate int, d time.Duration, op func(i uint64)) ([]time.Duration, time.Duration) {
+	interval := time.Second / time.Duration(opRate)
+	var next uint64
+	latencies := make([][]time.Duration, *c)
+	var wg sync.WaitGroup
+	wg.Add(*c)
+	start := time.Now()
+	end := start.Add(d)
+	for j := 0; j < *c; j++ {
+		index := j
+		go func() {
+			defer wg.Done()
+			for {
+				k := atomic.AddUint64(&next, 1) - 1
+				due := start.Add(time.Duration(k) * interval)
+				if !due.Before(end) || interrupted() {
+					return
+				}
+				if d := time.Until(due); d > 0 {
+					time.Sleep(d)
+				}
+				op(k)
+				latencies[index] = append(latencies[index], time.Since(due))
+			}
+		}()
+	}
+	wg.Wait()
+	dur := time.Since(start)
+
+	var all []time.Duration
+	for _, l := range latencies {
+		all = append(all, l...)
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i] < all[j] })
+	return all, dur
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/smallnest/kvbench"
+)
+
+// overwriteKeys is the most keys inserted and then overwritten by the
+// overwrite test; fewer with a smaller -set.
+const overwriteKeys = 100000
+
+// test inserts and overwrites separately. The set phase writes a mix of new
+// and existing keys; here a fixed keyset of new keys is inserted first, and
+// then the same keys are overwritten until -d has passed, so the costs of
+// page splits and of in-place or out-of-place updates are not blended.
+func testOverwrite(record *Record, name string, store kvbench.Store) {
+	n := overwriteKeys
+	if *setCount < n {
+		n = *setCount
+	}
+	if n < 1 {
+		n = 1
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(*c)
+	start := time.Now()
+	for j := 0; j < *c; j++ {
+		index := j
+		go func() {
+			for i := index; i < n; i += *c {
+				store.Set(overwriteKey(uint64(i)), data)
+			}
+			wg.Done()
+		}()
+	}
+	wg.Wait()
+	dur := time.Since(start)
+	d := int64(dur)
+	fmt.Printf("%s insert rate: %d op/s, mean: %d ns, keys: %d\n", name, int64(n)*1e6/(d/1e3), meanNs(d, n), n)
+	record.add("Insert op/s", "op/s", int(int64(n)*1e6/(d/1e3)))
+
+	p := newPhase(record, name, "Overwrite")
+	defer p.stop()
+
+	wg.Add(*c)
+	counts := make([]int, *c)
+	start = time.Now()
+	for j := 0; j < *c; j++ {
+		index := uint64(j)
+		go func() {
+			count := 0
+			i := index
+		LOOP:
+			for {
+				select {
+				case <-p.done():
+					break LOOP
+				default:
+					t := p.sampleStart(index)
+					store.Set(overwriteKey(i), data)
+					p.sample(index, t, "set", false)
+					p.wrote(len(data))
+					if i += uint64(*c); i >= uint64(n) {
+						i = index
+					}
+					count++
+					p.tick(index, 1)
+				}
+			}
+			counts[index] = count
+			wg.Done()
+		}()
+	}
+	wg.Wait()
+	dur = time.Since(start)
+	d = int64(dur)
+	var ops int
+	for _, count := range counts {
+		ops += count
+	}
+	fmt.Printf("%s overwrite rate: %d op/s, mean: %d ns, took: %d s\n", name, int64(ops)*1e6/(d/1e3), meanNs(d, ops), int(dur.Seconds()))
+	record.add("Overwrite op/s", "op/s", int(int64(ops)*1e6/(d/1e3)))
+}
+
+// overwriteKey returns the key i of the overwrite test, after the
+// -key-prefix.
+func overwriteKey(i uint64) []byte {
+	k := make([]byte, len(*keyPrefix)+9)
+	r := k[copy(k, *keyPrefix):]
+	r[0] = 'o'
+	binary.BigEndian.PutUint64(r[1:], i)
+	return k
+}
@@ -0,0 +1,9 @@
+//go:build !linux
+
+package main
+
+// dropPageCache is a no-op where posix_fadvise is not available; the cold
+// read phase then only measures a freshly opened store.
+func dropPageCache(path string) error {
+	return nil
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// phase bounds how long a benchmark phase runs. By default that is -d. With
+// -until-stable the phase watches its throughput in windows of -stable-window
+// and ends once -stable-count consecutive windows are each within
+// -stable-threshold percent of the previous one, or after -d at the latest.
+type phase struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	record *Record
+	name   string
+	label  string
+
+	ops        []paddedCount // per goroutine, only with -until-stable
+	sampleNext []paddedCount // per goroutine, only with -samples-out
+	lat        []latencyHist // per goroutine, only after measureLatency
+	metrics    *phaseMetrics // only with -metrics-addr
+	wg         sync.WaitGroup
+	windows    int // windows until stable, -1 if the phase never settled
+}
+
+type paddedCount struct {
+	n int64
+	_ [56]byte // keep each counter on its own cache line
+}
+
+// newPhase starts a phase run by *c goroutines. label names it in the output
+// and in the "<label> windows" column written with -until-stable. It panics
+// with errInterrupted if the run is interrupted, rather than start.
+func newPhase(record *Record, name, label string) *phase {
+	checkInterrupt()
+	ctx, cancel := context.WithTimeout(runCtx, *duration)
+	p := &phase{ctx: ctx, cancel: cancel, record: record, name: name, label: label}
+	p.metrics = metrics.forPhase(name, label)
+	if *untilStable {
+		p.ops = make([]paddedCount, *c)
+		p.windows = -1
+		p.wg.Add(1)
+		go p.watch()
+	}
+	if samples != nil {
+		p.sampleNext = make([]paddedCount, *c)
+		for i := range p.sampleNext {
+			p.sampleNext[i].n = samples.every
+		}
+	}
+	return p
+}
+
+// done is closed when the phase is over.
+func (p *phase) done() <-chan struct{} {
+	return p.ctx.Done()
+}
+
+// tick reports n operations by goroutine index.
+func (p *phase) tick(index uint64, n int) {
+	if p.ops != nil {
+		atomic.AddInt64(&p.ops[index].n, int64(n))
+	}
+	if p.metrics != nil {
+		p.metrics.ops.Add(float64(n))
+	}
+}
+
+func (p *phase) total() int64 {
+	var n int64
+	for i := range p.ops {
+		n += atomic.LoadInt64(&p.ops[i].n)
+	}
+	return n
+}
+
+func (p *phase) watch() {
+	defer p.wg.Done()
+	ticker := time.NewTicker(*stableWindow)
+	defer ticker.Stop()
+	var last, lastRate int64
+	var windows, stable int
+	for {
+		select {
+		case <-p.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		n := p.total()
+		rate := n - last
+		last = n
+		windows++
+		if lastRate > 0 && abs(rate-lastRate)*100 <= int64(*stableThreshold*float64(lastRate)) {
+			stable++
+		} else {
+			stable = 0
+		}
+		lastRate = rate
+		if stable >= *stableCount {
+			p.windows = windows
+			p.cancel()
+			return
+		}
+	}
+}
+
+// stop ends the phase and, with -until-stable, reports how many windows it
+// took to settle.
+func (p *phase) stop() {
+	p.cancel()
+	p.wg.Wait()
+	if !*untilStable {
+		return
+	}
+	if p.windows < 0 {
+		fmt.Printf("%s %s not stable within %s\n", p.name, p.label, *duration)
+	} else {
+		fmt.Printf("%s %s stable after %d windows\n", p.name, p.label, p.windows)
+	}
+	p.record.add(p.label+" windows", "", p.windows)
+}
+
+func abs(n int64) int64 {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
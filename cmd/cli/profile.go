@@ -0,0 +1,68 @@
+package main
+
+import (
+	"io"
+	"os"
+	"runtime"
+	"runtime/pprof"
+)
+
+// startProfiles starts the CPU profile of -cpuprofile and the block profile
+// of -blockprofile, which records every blocking event, and returns the
+// function that stops them and writes them out with the heap profile of
+// -memprofile. main wraps the phases with them, so the store's open and
+// close are not profiled.
+func startProfiles() (func() error, error) {
+	var cpu *os.File
+	if *cpuProfile != "" {
+		f, err := os.Create(*cpuProfile)
+		if err != nil {
+			return nil, err
+		}
+		if err := pprof.StartCPUProfile(f); err != nil {
+			f.Close()
+			return nil, err
+		}
+		cpu = f
+	}
+	if *blockProfile != "" {
+		runtime.SetBlockProfileRate(1)
+	}
+
+	return func() error {
+		if cpu != nil {
+			pprof.StopCPUProfile()
+			if err := cpu.Close(); err != nil {
+				return err
+			}
+		}
+		if *blockProfile != "" {
+			err := writeProfile(*blockProfile, func(w io.Writer) error {
+				return pprof.Lookup("block").WriteTo(w, 0)
+			})
+			runtime.SetBlockProfileRate(0)
+			if err != nil {
+				return err
+			}
+		}
+		if *memProfile != "" {
+			// up to date statistics of the live heap
+			runtime.GC()
+			return writeProfile(*memProfile, pprof.WriteHeapProfile)
+		}
+		return nil
+	}, nil
+}
+
+// writeProfile creates path and writes a profile into it with write.
+func writeProfile(path string, write func(w io.Writer) error) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := write(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
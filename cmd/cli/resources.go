@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+)
+
+type resourceSample struct {
+	goroutines int
+	fds        int
+}
+
+func sampleResources() resourceSample {
+	return resourceSample{
+		goroutines: runtime.NumGoroutine(),
+		fds:        openFDs(),
+	}
+}
+
+// openFDs counts the open file descriptors of the process, or returns -1 when
+// /proc/self/fd is not available (i.e. not on Linux).
+func openFDs() int {
+	entries, err := os.ReadDir("/proc/self/fd")
+	if err != nil {
+		return -1
+	}
+	// ReadDir itself holds one descriptor open while listing.
+	return len(entries) - 1
+}
+
+// resourceTracker prints goroutine and file descriptor deltas between the
+// phases of a run. A nil tracker does nothing, so callers need not check
+// whether -resources is set.
+type resourceTracker struct {
+	name  string
+	start resourceSample
+	last  resourceSample
+}
+
+func newResourceTracker(name string) *resourceTracker {
+	if !*resources {
+		return nil
+	}
+	s := sampleResources()
+	return &resourceTracker{name: name, start: s, last: s}
+}
+
+// phase reports the change since the previous phase.
+func (t *resourceTracker) phase(phase string) {
+	if t == nil {
+		return
+	}
+	s := sampleResources()
+	fmt.Printf("%s %s goroutines: %d (%+d), fds: %d (%+d)\n", t.name, phase,
+		s.goroutines, s.goroutines-t.last.goroutines, s.fds, s.fds-t.last.fds)
+	t.last = s
+}
+
+// closed reports what is left over after the store has been closed compared
+// to before it was opened, and records it in every row of the run.
+func (t *resourceTracker) closed(records ...*Record) {
+	if t == nil {
+		return
+	}
+	t.phase("close")
+	goroutines := t.last.goroutines - t.start.goroutines
+	fds := t.last.fds - t.start.fds
+	if t.start.fds < 0 {
+		fds = -1
+	}
+	if goroutines > 0 || fds > 0 {
+		fmt.Printf("%s leaked after close: %d goroutines, %d fds\n", t.name, goroutines, fds)
+	}
+	for _, record := range records {
+		record.add("Goroutines leaked", "", goroutines)
+		record.add("FDs leaked", "", fds)
+	}
+}
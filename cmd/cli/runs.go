@@ -0,0 +1,96 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"strings"
+
+	"github.com/smallnest/kvbench"
+)
+
+// resetStore empties store between the runs of -runs with FlushDB, or, for
+// stores without it, by closing it and opening it again on fresh files. It
+// runs outside of the phases, so its time is not measured.
+func resetStore(store kvbench.Store, path string, memory bool) (kvbench.Store, error) {
+	err := store.FlushDB()
+	if !errors.Is(err, kvbench.ErrNotSupported) {
+		return store, err
+	}
+	if err := store.Close(); err != nil {
+		return nil, err
+	}
+	if !memory {
+		if err := os.RemoveAll(path); err != nil {
+			return nil, err
+		}
+	}
+	store, _, err = getStore(*s, *fsync, path)
+	return store, err
+}
+
+// aggregateRuns returns the record of the runs of -runs: the mean of every
+// metric, with a stddev column after each throughput. A metric that is -1
+// in any run stays -1. A failed last run is left out, and its error kept.
+// A single run is returned as is, without stddev columns.
+func aggregateRuns(records []*Record) *Record {
+	last := records[len(records)-1]
+	complete := records
+	if last.Err != nil {
+		complete = records[:len(records)-1]
+	}
+	if len(complete) == 0 {
+		return last
+	}
+	if len(complete) == 1 {
+		complete[0].Err = last.Err
+		return complete[0]
+	}
+
+	first := complete[0]
+	n := 1 + len(first.Info)
+	out := &Record{
+		Name:        first.Name,
+		Headers:     append([]string(nil), first.Headers[:n]...),
+		Units:       append([]string(nil), first.Units[:n]...),
+		Info:        first.Info,
+		Values:      make([]int, 0, len(first.Values)),
+		Err:         last.Err,
+		Concurrency: first.Concurrency,
+	}
+	for i := range first.Values {
+		header, unit := first.Headers[n+i], first.Units[n+i]
+		mean, stddev := meanStddev(complete, i)
+		out.add(header, unit, mean)
+		if unit != "op/s" {
+			continue
+		}
+		out.add(strings.TrimSuffix(header, " op/s")+" stddev op/s", unit, stddev)
+		if mean > 0 {
+			fmt.Printf("%s %s: mean %d, stddev %d (cv %.1f%%) over %d runs\n",
+				first.Name, header, mean, stddev, float64(stddev)*100/float64(mean), len(complete))
+		}
+	}
+	return out
+}
+
+// meanStddev returns the mean and the sample standard deviation of metric i
+// over records, rounded, or -1 and -1 if any of them lacks it.
+func meanStddev(records []*Record, i int) (int, int) {
+	var sum float64
+	for _, r := range records {
+		if i >= len(r.Values) || r.Values[i] < 0 {
+			return -1, -1
+		}
+		sum += float64(r.Values[i])
+	}
+	mean := sum / float64(len(records))
+	var sq float64
+	for _, r := range records {
+		d := float64(r.Values[i]) - mean
+		sq += d * d
+	}
+	stddev := math.Sqrt(sq / float64(len(records)-1))
+	return int(math.Round(mean)), int(math.Round(stddev))
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// samplesBuffer is the number of samples queued for the writer before new
+// ones are dropped, so a slow disk never blocks the benchmark goroutines.
+const samplesBuffer = 1 << 16
+
+// sample is one line of -samples-out.
+type sample struct {
+	Phase     string `json:"phase"`
+	TS        int64  `json:"ts"` // start of the operation, unix nanoseconds
+	LatencyNS int64  `json:"latency_ns"`
+	Op        string `json:"op"`
+	Hit       *bool  `json:"hit,omitempty"` // only for get, has and del
+}
+
+// sampleWriter encodes the samples of all phases on its own goroutine.
+type sampleWriter struct {
+	every   int64 // one operation in every is sampled, per goroutine
+	ch      chan sample
+	dropped int64
+	wg      sync.WaitGroup
+	file    *os.File
+	w       *bufio.Writer
+	err     error
+}
+
+// samples is nil unless -samples-out is set.
+var samples *sampleWriter
+
+// openSamples creates -samples-out and starts its writer.
+func openSamples() error {
+	if *samplesOut == "" {
+		return nil
+	}
+	if *samplesRate <= 0 || *samplesRate > 1 {
+		return fmt.Errorf("invalid -samples-rate: %v", *samplesRate)
+	}
+	file, err := os.Create(*samplesOut)
+	if err != nil {
+		return err
+	}
+	samples = &sampleWriter{
+		every: int64(math.Round(1 / *samplesRate)),
+		ch:    make(chan sample, samplesBuffer),
+		file:  file,
+		w:     bufio.NewWriterSize(file, 1<<20),
+	}
+	samples.wg.Add(1)
+	go samples.run()
+	return nil
+}
+
+func (s *sampleWriter) run() {
+	defer s.wg.Done()
+	enc := json.NewEncoder(s.w)
+	for smp := range s.ch {
+		if err := enc.Encode(smp); err != nil && s.err == nil {
+			s.err = err
+		}
+	}
+}
+
+// closeSamples writes the queued samples and closes -samples-out.
+func closeSamples() error {
+	if samples == nil {
+		return nil
+	}
+	close(samples.ch)
+	samples.wg.Wait()
+	err := samples.err
+	if ferr := samples.w.Flush(); err == nil {
+		err = ferr
+	}
+	if cerr := samples.file.Close(); err == nil {
+		err = cerr
+	}
+	if dropped := atomic.LoadInt64(&samples.dropped); dropped > 0 {
+		fmt.Printf("samples: dropped %d samples the writer could not keep up with\n", dropped)
+	}
+	return err
+}
+
+// sampleStart returns the time an operation of goroutine index starts if
+// the operation is sampled for -samples-out or timed for the latency
+// percentiles or -metrics-addr, or the zero time. The other operations only
+// count down.
+func (p *phase) sampleStart(index uint64) time.Time {
+	due := false
+	if p.lat != nil {
+		h := &p.lat[index]
+		if h.next--; h.next <= 0 {
+			h.next = latencyEvery
+			due = true
+		}
+	}
+	if p.metrics != nil {
+		next := &p.metrics.next[index].n
+		if *next--; *next <= 0 {
+			*next = latencyEvery
+			due = true
+		}
+	}
+	if p.sampleNext != nil {
+		next := &p.sampleNext[index].n
+		if *next--; *next <= 0 {
+			*next = samples.every
+			due = true
+		}
+	}
+	if !due {
+		return time.Time{}
+	}
+	return time.Now()
+}
+
+// sample ends an operation of goroutine index started at start by
+// sampleStart: it adds its latency to the histogram of the goroutine if it
+// was timed, and queues it if it was sampled. hit is only written for get,
+// has and del.
+func (p *phase) sample(index uint64, start time.Time, op string, hit bool) {
+	if start.IsZero() {
+		return
+	}
+	latency := time.Since(start)
+	// a counter was just reset by sampleStart if it is due
+	if p.lat != nil && p.lat[index].next == latencyEvery {
+		p.lat[index].add(latency)
+	}
+	if p.metrics != nil && p.metrics.next[index].n == latencyEvery {
+		p.metrics.latency.Observe(latency.Seconds())
+	}
+	if p.sampleNext == nil || p.sampleNext[index].n != samples.every {
+		return
+	}
+	smp := sample{
+		Phase:     p.label,
+		TS:        start.UnixNano(),
+		LatencyNS: int64(latency),
+		Op:        op,
+	}
+	if op == "get" || op == "has" || op == "del" {
+		smp.Hit = &hit
+	}
+	select {
+	case samples.ch <- smp:
+	default:
+		atomic.AddInt64(&samples.dropped, 1)
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+
+	"github.com/smallnest/log"
+)
+
+// jsonRun is the object written per run by -format json. The metrics are
+// the columns of the Record, in the same order as in the CSV.
+type jsonRun struct {
+	Name        string            `json:"name"`
+	Fsync       bool              `json:"fsync"`
+	Memory      bool              `json:"memory"`
+	Size        int               `json:"size"`
+	Concurrency int               `json:"concurrency"`
+	Info        map[string]string `json:"info"`
+	Metrics     []jsonMetric      `json:"metrics"`
+	// Error is the error that stopped the phases; Metrics then lacks the
+	// phases that did not run.
+	Error string `json:"error,omitempty"`
+}
+
+type jsonMetric struct {
+	Name  string `json:"name"`
+	Unit  string `json:"unit,omitempty"`
+	Value int    `json:"value"`
+}
+
+// newJSONRun converts record, whose Headers hold the name column, then the
+// Info columns, then the metrics.
+func newJSONRun(record *Record, memory bool) jsonRun {
+	run := jsonRun{
+		Name:        record.Name,
+		Fsync:       *fsync,
+		Memory:      memory,
+		Size:        sizes.mean(),
+		Concurrency: record.Concurrency,
+		Info:        make(map[string]string, len(record.Info)),
+		Metrics:     make([]jsonMetric, 0, len(record.Values)),
+	}
+	if record.Err != nil {
+		run.Error = record.Err.Error()
+	}
+	for i, v := range record.Info {
+		run.Info[record.Headers[1+i]] = v
+	}
+	first := 1 + len(record.Info)
+	for i, v := range record.Values {
+		run.Metrics = append(run.Metrics, jsonMetric{
+			Name:  record.Headers[first+i],
+			Unit:  record.Units[first+i],
+			Value: v,
+		})
+	}
+	return run
+}
+
+// saveJSON appends the run of record to the JSON array in -save, creating
+// the file if needed. The file is rewritten through a temporary file, so an
+// interrupted run leaves the previous array intact.
+func saveJSON(record *Record, memory bool) {
+	if *savePath == "" {
+		return
+	}
+	var runs []json.RawMessage
+	b, err := os.ReadFile(*savePath)
+	if err != nil && !os.IsNotExist(err) {
+		log.Fatal(err)
+	}
+	if len(b) > 0 {
+		if err := json.Unmarshal(b, &runs); err != nil {
+			log.Fatalf("%s is not a JSON array of runs: %v", *savePath, err)
+		}
+	}
+	run, err := json.Marshal(newJSONRun(record, memory))
+	if err != nil {
+		log.Fatal(err)
+	}
+	runs = append(runs, run)
+	b, err = json.MarshalIndent(runs, "", "  ")
+	if err != nil {
+		log.Fatal(err)
+	}
+	tmp := *savePath + ".tmp"
+	if err := os.WriteFile(tmp, append(b, '\n'), 0600); err != nil {
+		log.Fatal(err)
+	}
+	if err := os.Rename(tmp, *savePath); err != nil {
+		log.Fatal(err)
+	}
+}
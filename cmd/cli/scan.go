@@ -0,0 +1,122 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/smallnest/kvbench"
+)
+
+// scanWidth is the number of loaded keys a Scan window spans on average.
+const scanWidth = 100
+
+// scanWindow returns the bounds of the window of the keyspace starting at
+// the loaded key i. With -key-order sequential or reverse the window holds
+// the scanWidth keys next to i; with random it spans the share of the hashes
+// under i's first byte that holds scanWidth keys on average, but no further
+// than the next first byte, so small loads return fewer. With -keyfile it
+// holds the scanWidth keys of the file that sort from i's on.
+func scanWindow(i uint64) (start, end []byte) {
+	if workload != nil {
+		return genKey(i), workload.scanEnd(i)
+	}
+	switch *keyOrder {
+	case "sequential":
+		return genKey(i), genKey(i + scanWidth)
+	case "reverse":
+		// a later index sorts first
+		return genKey(i + scanWidth), genKey(i)
+	}
+	start = genKey(i)
+	end = append([]byte(nil), start...)
+	r := end[len(*keyPrefix):]
+	h := binary.BigEndian.Uint64(r[1:])
+	per := uint64(math.MaxUint64) / uint64(*setCount)
+	step := per * (127 - 32) * scanWidth
+	if per > math.MaxUint64/((127-32)*scanWidth) || h+step < h {
+		// past the hashes of the first byte, up to the next one
+		r[0]++
+		return start, end[:len(*keyPrefix)+1]
+	}
+	binary.BigEndian.PutUint64(r[1:], h+step)
+	return start, end
+}
+
+// test RangeScan over windows of the keyspace with values, picking the
+// window starts like the get phases. Stores without range scans record -1.
+func testScan(record *Record, name string, store kvbench.Store) {
+	start, end := scanWindow(0)
+	if _, _, err := store.RangeScan(start, end, 0, true); errors.Is(err, kvbench.ErrNotSupported) {
+		fmt.Printf("%s scan rate: %d op/s, entries: %d, took: %d s\n", name, -1, -1, -1)
+		record.add("Scan op/s", "op/s", -1)
+		record.add("Scan entries", "keys", -1)
+		return
+	}
+
+	warmUp(name, "Scan", func(p *phase) { runScan(p, store) })
+	p := newPhase(record, name, "Scan")
+	defer p.stop()
+	n, entries, dur := runScan(p, store)
+
+	var avg float64
+	if n > 0 {
+		avg = float64(entries) / float64(n)
+	}
+	rate := int64(float64(n) / dur.Seconds())
+	fmt.Printf("%s scan rate: %d op/s, entries: %.1f, took: %d s\n", name, rate, avg, int(dur.Seconds()))
+	record.add("Scan op/s", "op/s", int(rate))
+	record.add("Scan entries", "keys", int(avg+0.5))
+}
+
+// runScan scans windows from *c goroutines until p is done and returns the
+// number of RangeScan calls and the entries they returned.
+func runScan(p *phase, store kvbench.Store) (int, int, time.Duration) {
+	var wg sync.WaitGroup
+	wg.Add(*c)
+
+	counts := make([]int, *c)
+	entries := make([]int, *c)
+	start := time.Now()
+	for j := 0; j < *c; j++ {
+		index := uint64(j)
+		go func() {
+			defer wg.Done()
+			keys := newReadKeys(index)
+			var count, n int
+		LOOP:
+			for {
+				select {
+				case <-p.done():
+					break LOOP
+				default:
+					i := keys.next()
+					if i >= uint64(*setCount) {
+						keys.restart()
+						i = keys.next()
+					}
+					lo, hi := scanWindow(i)
+					t := p.sampleStart(index)
+					ks, _, err := store.RangeScan(lo, hi, 0, true)
+					p.sample(index, t, "scan", err == nil && len(ks) > 0)
+					count++
+					n += len(ks)
+					p.tick(index, 1)
+				}
+			}
+			counts[index], entries[index] = count, n
+		}()
+	}
+	wg.Wait()
+	dur := time.Since(start)
+
+	var count, n int
+	for j := range counts {
+		count += counts[j]
+		n += entries[j]
+	}
+	return count, n, dur
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/smallnest/kvbench"
+)
+
+// test long prefix scans running alongside point writes. Half of the *c
+// goroutines (at least one) scan the keys under a one byte prefix, about a
+// hundredth of the store, with values; the others Set. Long scans pin
+// snapshots or hold read transactions, so both rates show how scans and
+// writes get in each other's way. Stores without Keys record -1.
+func testScanMixed(record *Record, name string, store kvbench.Store) {
+	if !store.Capabilities().Has(kvbench.CapKeys) {
+		fmt.Printf("%s scanmixed keys rate: %d op/s, set rate: %d op/s\n", name, -1, -1)
+		record.add("Scanmixed Keys op/s", "op/s", -1)
+		record.add("Scanmixed Set op/s", "op/s", -1)
+		return
+	}
+	scanners := *c / 2
+	if scanners < 1 {
+		scanners = 1
+	}
+	writers := *c - scanners
+	if writers < 1 {
+		writers = 1
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(scanners + writers)
+
+	p := newPhase(record, name, "Scanmixed")
+	defer p.stop()
+
+	scans := make([]int, scanners)
+	sets := make([]int, writers)
+	start := time.Now()
+	for j := 0; j < scanners; j++ {
+		index := uint64(j)
+		go func() {
+			defer wg.Done()
+			r := newRand(index)
+			for {
+				select {
+				case <-p.done():
+					return
+				default:
+				}
+				prefix := genKeyPrefix(r)
+				store.Keys(prefix[:len(prefix)-2], 0, true)
+				scans[index]++
+				p.tick(index%uint64(*c), 1)
+			}
+		}()
+	}
+	for j := 0; j < writers; j++ {
+		index := uint64(j)
+		go func() {
+			defer wg.Done()
+			i := index
+			for {
+				select {
+				case <-p.done():
+					return
+				default:
+				}
+				store.Set(genKey(i), data)
+				i += uint64(writers)
+				sets[index]++
+				p.tick((uint64(scanners)+index)%uint64(*c), 1)
+			}
+		}()
+	}
+	wg.Wait()
+	d := int64(time.Since(start))
+	var nScans, nSets int
+	for _, n := range scans {
+		nScans += n
+	}
+	for _, n := range sets {
+		nSets += n
+	}
+	fmt.Printf("%s scanmixed keys rate: %d op/s, set rate: %d op/s, scanners: %d, writers: %d\n",
+		name, int64(nScans)*1e6/(d/1e3), int64(nSets)*1e6/(d/1e3), scanners, writers)
+	record.add("Scanmixed Keys op/s", "op/s", int(int64(nScans)*1e6/(d/1e3)))
+	record.add("Scanmixed Set op/s", "op/s", int(int64(nSets)*1e6/(d/1e3)))
+}
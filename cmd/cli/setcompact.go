@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/smallnest/kvbench"
+)
+
+// test set again while Compact is called every -compact-interval, and
+// compare with the set phase. LSM stores compact lazily, so a short set
+// phase hides a cost that a long running one pays; forcing compactions
+// shows the write rate the store can sustain. Stores that cannot compact
+// record -1.
+func testSetCompacting(record *Record, name string, store kvbench.Store, setRate int64) {
+	if *compactInterval <= 0 {
+		return
+	}
+	if !store.Capabilities().Has(kvbench.CapCompact) {
+		fmt.Printf("%s set with compaction rate: %d op/s, degradation: %d%%\n", name, -1, -1)
+		record.add("SetCompacting op/s", "op/s", -1)
+		record.add("SetCompacting degradation(%)", "%", -1)
+		record.add("SetCompacting compactions", "", -1)
+		return
+	}
+	p := newPhase(record, name, "SetCompacting")
+	compactions := make(chan int)
+	go func() {
+		var n int
+		ticker := time.NewTicker(*compactInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-p.done():
+				compactions <- n
+				return
+			case <-ticker.C:
+				if err := store.Compact(); err != nil {
+					fmt.Printf("%s compact error: %v\n", name, err)
+				}
+				n++
+			}
+		}
+	}()
+	n, _, dur := runSets(p, store)
+	p.stop()
+	compacted := <-compactions
+
+	d := int64(dur)
+	rate := int64(n) * 1e6 / (d / 1e3)
+	var degradation int64 = -1
+	if setRate > 0 {
+		degradation = (setRate - rate) * 100 / setRate
+	}
+	fmt.Printf("%s set with compaction rate: %d op/s, degradation: %d%%, compactions: %d, took: %d s\n",
+		name, rate, degradation, compacted, int(dur.Seconds()))
+	record.add("SetCompacting op/s", "op/s", int(rate))
+	record.add("SetCompacting degradation(%)", "%", int(degradation))
+	record.add("SetCompacting compactions", "", compacted)
+}
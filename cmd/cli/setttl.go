@@ -0,0 +1,71 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/smallnest/kvbench"
+)
+
+// setTTL is the TTL of the writes of the SetTTL test, long enough for none
+// to expire during the run.
+const setTTL = time.Hour
+
+// test set with a TTL, on the keys of the set test, so the cost of the
+// store's expiration bookkeeping shows against Set op/s.
+func testSetTTL(record *Record, name string, store kvbench.Store) {
+	if !store.Capabilities().Has(kvbench.CapTTL) {
+		fmt.Printf("%s setttl rate: %d op/s, mean: %d ns, took: %d s\n", name, -1, -1, -1)
+		record.add("SetTTL op/s", "op/s", -1)
+		return
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(*c)
+
+	p := newPhase(record, name, "SetTTL")
+	defer p.stop()
+
+	counts := make([]int, *c)
+	errs := make([]error, *c)
+	start := time.Now()
+	for j := 0; j < *c; j++ {
+		index := uint64(j)
+		go func() {
+			defer wg.Done()
+			i := index
+		LOOP:
+			for {
+				select {
+				case <-p.done():
+					break LOOP
+				default:
+					err := store.SetWithTTL(genKey(i), data, setTTL)
+					if errors.Is(err, kvbench.ErrNotSupported) {
+						errs[index] = err
+						return
+					}
+					i += uint64(*c)
+					counts[index]++
+					p.tick(index, 1)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	dur := time.Since(start)
+	d := int64(dur)
+	var n int
+	for j, count := range counts {
+		if errs[j] != nil {
+			fmt.Printf("%s setttl rate: %d op/s, mean: %d ns, took: %d s\n", name, -1, -1, -1)
+			record.add("SetTTL op/s", "op/s", -1)
+			return
+		}
+		n += count
+	}
+	fmt.Printf("%s setttl rate: %d op/s, mean: %d ns, took: %d s\n", name, int64(n)*1e6/(d/1e3), meanNs(d, n), int(dur.Seconds()))
+	record.add("SetTTL op/s", "op/s", int(int64(n)*1e6/(d/1e3)))
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// sizeBucket is one size:weight pair of -size.
+type sizeBucket struct {
+	size   int
+	weight int
+}
+
+// valueSizes are the value sizes of -size with their weights. The batch write
+// and Set phases pick the size of every value by weight, the other phases
+// write values of the average size.
+type valueSizes struct {
+	buckets []sizeBucket
+	total   int // sum of the weights
+}
+
+// parseSizes parses -size: a single size in bytes, or a comma separated list
+// of size:weight pairs such as 64:70,1024:25,65536:5.
+func parseSizes(s string) (valueSizes, error) {
+	var vs valueSizes
+	for _, f := range strings.Split(s, ",") {
+		sizeField, weightField, weighted := strings.Cut(strings.TrimSpace(f), ":")
+		size, err := strconv.Atoi(strings.TrimSpace(sizeField))
+		if err != nil || size < 0 {
+			return valueSizes{}, fmt.Errorf("invalid -size: %q", f)
+		}
+		weight := 1
+		if weighted {
+			if weight, err = strconv.Atoi(strings.TrimSpace(weightField)); err != nil || weight < 1 {
+				return valueSizes{}, fmt.Errorf("invalid -size weight: %q", f)
+			}
+		}
+		vs.buckets = append(vs.buckets, sizeBucket{size, weight})
+		vs.total += weight
+	}
+	return vs, nil
+}
+
+// max returns the largest size.
+func (vs valueSizes) max() int {
+	var m int
+	for _, b := range vs.buckets {
+		if b.size > m {
+			m = b.size
+		}
+	}
+	return m
+}
+
+// mean returns the weighted average size.
+func (vs valueSizes) mean() int {
+	var sum int
+	for _, b := range vs.buckets {
+		sum += b.size * b.weight
+	}
+	return sum / vs.total
+}
+
+// pick returns the size of the value of key index i. The same index always
+// gets the same size, and the sizes of consecutive indexes follow the
+// weights.
+func (vs valueSizes) pick(i uint64) int {
+	if len(vs.buckets) == 1 {
+		return vs.buckets[0].size
+	}
+	w := int(mix64(i) % uint64(vs.total))
+	for _, b := range vs.buckets {
+		if w < b.weight {
+			return b.size
+		}
+		w -= b.weight
+	}
+	panic("unreachable")
+}
+
+// initValues parses spec as -size into sizes and allocates data and values.
+func initValues(spec string) error {
+	vs, err := parseSizes(spec)
+	if err != nil {
+		return err
+	}
+	sizes = vs
+	data = newData(vs.mean())
+	values = newData(vs.max())
+	return nil
+}
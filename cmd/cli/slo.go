@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/smallnest/kvbench"
+)
+
+// sloKeyBase is the first key index written by the SLO test, far from the
+// indexes of the other phases.
+const sloKeyBase = 1 << 52
+
+// sloMaxSteps bounds the number of rates the SLO test tries.
+const sloMaxSteps = 30
+
+// sloRefineSteps is the number of bisections between the last rate that
+// met the SLO and the first that did not.
+const sloRefineSteps = 3
+
+// test the highest write rate that keeps the p99 latency within -slo. Set
+// runs open-loop for -slo-step at -slo-start op/s, and the rate doubles
+// until p99 exceeds -slo, then is refined by bisection. Latency is measured
+// from when each write was due, so a store that cannot keep up at a rate
+// fails it through queueing even if each write is fast.
+func testSLO(record *Record, name string, store kvbench.Store) {
+	if *slo <= 0 {
+		return
+	}
+	if *sloStart < 1 {
+		panic(fmt.Errorf("invalid -slo-start: %d", *sloStart))
+	}
+	// every step writes new keys, after those of the other phases
+	base := uint64(sloKeyBase)
+	set := func(k uint64) {
+		store.Set(genKey(base+k), data)
+	}
+	run := func(opRate int) (bool, time.Duration) {
+		checkInterrupt()
+		all, _ := openLoop(opRate, *sloStep, set)
+		base += uint64(len(all))
+		if len(all) == 0 {
+			return true, 0
+		}
+		p99 := percentile(all, 99)
+		ok := p99 <= *slo
+		fmt.Printf("%s slo set at %d op/s: p99: %s, within %s: %v\n", name, opRate, p99, *slo, ok)
+		return ok, p99
+	}
+
+	best, bestP99 := -1, time.Duration(0)
+	bad := -1
+	for opRate, step := *sloStart, 0; step < sloMaxSteps; opRate, step = opRate*2, step+1 {
+		ok, p99 := run(opRate)
+		if !ok {
+			bad = opRate
+			break
+		}
+		best, bestP99 = opRate, p99
+	}
+	if best > 0 && bad > 0 {
+		lo, hi := best, bad
+		for step := 0; step < sloRefineSteps && hi-lo > 1; step++ {
+			mid := lo + (hi-lo)/2
+			if ok, p99 := run(mid); ok {
+				lo, best, bestP99 = mid, mid, p99
+			} else {
+				hi = mid
+			}
+		}
+	}
+	if best < 0 {
+		fmt.Printf("%s slo set: p99 above %s already at %d op/s\n", name, *slo, *sloStart)
+		record.add("SLO Set op/s", "op/s", -1)
+		record.add("SLO Set p99(us)", "us", -1)
+		return
+	}
+	fmt.Printf("%s slo set: max rate with p99 within %s: %d op/s, p99: %s\n", name, *slo, best, bestP99)
+	record.add("SLO Set op/s", "op/s", best)
+	record.add("SLO Set p99(us)", "us", int(bestP99.Microseconds()))
+}
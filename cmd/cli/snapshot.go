@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/smallnest/kvbench"
+)
+
+// snapshotMarker is the value the writer of the snapshot test overwrites the
+// loaded keys with, which the snapshot must never read.
+var snapshotMarker = []byte("kvbench snapshot write")
+
+// test reads from one snapshot while a background goroutine overwrites the
+// loaded keys: *c goroutines Get the keys of the Get phase from the snapshot
+// opened before the writer started, so they must never see its value.
+// Snapshot violations counts the reads that did. Stores without snapshots
+// record -1.
+func testSnapshotRead(record *Record, name string, store kvbench.Store) {
+	snap, err := store.Snapshot()
+	if errors.Is(err, kvbench.ErrNotSupported) {
+		fmt.Printf("%s snapshot get rate: %d op/s, set rate: %d op/s, violations: %d\n", name, -1, -1, -1)
+		record.add("Snapshot Get op/s", "op/s", -1)
+		record.add("Snapshot Set op/s", "op/s", -1)
+		record.add("Snapshot violations", "", -1)
+		return
+	}
+	if err != nil {
+		panic(err)
+	}
+
+	loaded := uint64(*setCount)
+	if loaded == 0 {
+		loaded = 1
+	}
+	stopWriter := make(chan struct{})
+	writerDone := make(chan int)
+	go func() {
+		var sets int
+		for i := uint64(0); ; i++ {
+			select {
+			case <-stopWriter:
+				writerDone <- sets
+				return
+			default:
+			}
+			if store.Set(genKey(i%loaded), snapshotMarker) == nil {
+				sets++
+			}
+		}
+	}()
+
+	p := newPhase(record, name, "Snapshot")
+	n, violations, dur := runSnapshotGets(p, snap)
+	p.stop()
+	// a Bolt writer that has to grow the file waits for the snapshot, so it
+	// is closed before the writer is stopped
+	if err := snap.Close(); err != nil {
+		panic(err)
+	}
+	close(stopWriter)
+	sets := <-writerDone
+
+	getRate := int64(float64(n) / dur.Seconds())
+	setRate := int64(float64(sets) / dur.Seconds())
+	fmt.Printf("%s snapshot get rate: %d op/s, set rate: %d op/s, violations: %d\n", name, getRate, setRate, violations)
+	record.add("Snapshot Get op/s", "op/s", int(getRate))
+	record.add("Snapshot Set op/s", "op/s", int(setRate))
+	record.add("Snapshot violations", "", violations)
+}
+
+// runSnapshotGets reads snap from *c goroutines until p is done and returns
+// the number of Get calls and of values the writer wrote after snap.
+func runSnapshotGets(p *phase, snap kvbench.Snapshot) (int, int, time.Duration) {
+	var wg sync.WaitGroup
+	wg.Add(*c)
+
+	counts := make([]int, *c)
+	violations := make([]int, *c)
+	start := time.Now()
+	for j := 0; j < *c; j++ {
+		index := uint64(j)
+		go func() {
+			defer wg.Done()
+			keys := newReadKeys(index)
+		LOOP:
+			for {
+				select {
+				case <-p.done():
+					break LOOP
+				default:
+					t := p.sampleStart(index)
+					v, ok, err := snap.Get(genKey(keys.next()))
+					p.sample(index, t, "get", ok)
+					if err == nil && ok && bytes.Equal(v, snapshotMarker) {
+						violations[index]++
+					}
+					if !ok {
+						keys.restart()
+					}
+					counts[index]++
+					p.tick(index, 1)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	dur := time.Since(start)
+
+	var n, bad int
+	for j := range counts {
+		n += counts[j]
+		bad += violations[j]
+	}
+	return n, bad, dur
+}
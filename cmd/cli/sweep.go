@@ -0,0 +1,25 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// parseSweep parses -csweep, a comma separated list of concurrency levels
+// such as 1,2,4,8, each of which the phases run at in turn. An empty list
+// runs them once at -c.
+func parseSweep(list string) ([]int, error) {
+	if list == "" {
+		return []int{*c}, nil
+	}
+	var levels []int
+	for _, f := range strings.Split(list, ",") {
+		n, err := strconv.Atoi(strings.TrimSpace(f))
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("invalid -csweep level: %q", f)
+		}
+		levels = append(levels, n)
+	}
+	return levels, nil
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/smallnest/kvbench"
+)
+
+type tierStats interface {
+	TierStats() (hotHits, coldHits, misses int64)
+}
+
+// test a tiered store under the Zipfian workload of the cache test: -set
+// keys are written, filling the hot tier and spilling the rest to cold, and
+// then read with skew zipfS. The hot hit ratio comes from the store; the
+// mean Get latency is the blend of both tiers, to compare with the Get
+// phases of each tier run alone. Stores that are not tiered record -1.
+func testTiered(record *Record, name string, store kvbench.Store) {
+	ts, ok := store.(tierStats)
+	if !ok || *setCount < 2 {
+		record.add("Tiered op/s", "op/s", -1)
+		record.add("Tiered hot hit ratio(%)", "%", -1)
+		record.add("Tiered Get mean(ns)", "ns", -1)
+		return
+	}
+	for i := 0; i < *setCount; i += *batch {
+		checkInterrupt()
+		n := *setCount - i
+		if n > *batch {
+			n = *batch
+		}
+		keyList := make([][]byte, n)
+		valList := make([][]byte, n)
+		for j := range keyList {
+			keyList[j] = zipfKey(uint64(i + j))
+			valList[j] = data
+		}
+		if err := store.PSet(keyList, valList); err != nil {
+			panic(err)
+		}
+	}
+	hot0, cold0, misses0 := ts.TierStats()
+
+	var wg sync.WaitGroup
+	wg.Add(*c)
+
+	p := newPhase(record, name, "Tiered")
+	defer p.stop()
+
+	counts := make([]int, *c)
+	latencies := make([]time.Duration, *c)
+	start := time.Now()
+	for j := 0; j < *c; j++ {
+		index := uint64(j)
+		go func() {
+			var count int
+			var latency time.Duration
+			zipf := rand.NewZipf(newRand(index), zipfS, 1, uint64(*setCount-1))
+		LOOP:
+			for {
+				select {
+				case <-p.done():
+					break LOOP
+				default:
+					key := zipfKey(zipf.Uint64())
+					getStart := time.Now()
+					store.Get(key)
+					latency += time.Since(getStart)
+					count++
+					p.tick(index, 1)
+				}
+			}
+			counts[index] = count
+			latencies[index] = latency
+			wg.Done()
+		}()
+	}
+	wg.Wait()
+	dur := time.Since(start)
+	d := int64(dur)
+	var n int
+	var latency time.Duration
+	for i, count := range counts {
+		n += count
+		latency += latencies[i]
+	}
+	hot, cold, misses := ts.TierStats()
+	hot, cold, misses = hot-hot0, cold-cold0, misses-misses0
+	ratio := -1
+	if hot+cold+misses > 0 {
+		ratio = int(hot * 100 / (hot + cold + misses))
+	}
+	mean := -1
+	if n > 0 {
+		mean = int(latency.Nanoseconds() / int64(n))
+	}
+	fmt.Printf("%s tiered rate: %d op/s, hot hit ratio: %d%%, mean: %d ns, took: %d s\n", name, int64(n)*1e6/(d/1e3), ratio, mean, int(dur.Seconds()))
+	record.add("Tiered op/s", "op/s", int(int64(n)*1e6/(d/1e3)))
+	record.add("Tiered hot hit ratio(%)", "%", ratio)
+	record.add("Tiered Get mean(ns)", "ns", mean)
+}
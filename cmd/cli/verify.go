@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+
+	"github.com/smallnest/kvbench"
+)
+
+// verifyFailed is set when -verify found a missing or mismatched entry, to
+// exit with status 1 once the run is saved.
+var verifyFailed bool
+
+// verifySample is an entry written by the load phase, kept to be read back.
+type verifySample struct {
+	key   []byte
+	value []byte
+}
+
+// verifySampler keeps every stride-th entry of the load phase, up to
+// -verify-samples of them. A nil sampler keeps nothing.
+type verifySampler struct {
+	stride  int
+	seen    int
+	samples []verifySample
+}
+
+func newVerifySampler(count int) *verifySampler {
+	if !*verify || *verifySamples <= 0 {
+		return nil
+	}
+	stride := count / *verifySamples
+	if stride < 1 {
+		stride = 1
+	}
+	return &verifySampler{stride: stride}
+}
+
+func (v *verifySampler) add(key, value []byte) {
+	if v == nil {
+		return
+	}
+	if v.seen%v.stride == 0 && len(v.samples) < *verifySamples {
+		v.samples = append(v.samples, verifySample{
+			key:   append([]byte(nil), key...),
+			value: append([]byte(nil), value...),
+		})
+	}
+	v.seen++
+}
+
+// test that the sampled entries of the load phase read back unchanged. The
+// first -verify-dumps failures are described on stderr. The Verify column is
+// 1 if every sample passed and 0 otherwise, so rows of a store that lost or
+// corrupted data can be told apart.
+func testVerify(record *Record, name string, store kvbench.Store, sampler *verifySampler) {
+	if sampler == nil {
+		return
+	}
+	var missing, mismatched, dumps int
+	for _, e := range sampler.samples {
+		got, ok, err := store.Get(e.key)
+		if err == nil && ok && bytes.Equal(got, e.value) {
+			continue
+		}
+		if err != nil || !ok {
+			missing++
+		} else {
+			mismatched++
+		}
+		if dumps >= *verifyDumps {
+			continue
+		}
+		dumps++
+		switch {
+		case err != nil:
+			fmt.Fprintf(os.Stderr, "%s verify key %x: error: %v\n", name, e.key, err)
+		case !ok:
+			fmt.Fprintf(os.Stderr, "%s verify key %x: missing\n", name, e.key)
+		default:
+			fmt.Fprintf(os.Stderr, "%s verify key %x: expected %d bytes, got %d, first difference at offset %d\n",
+				name, e.key, len(e.value), len(got), diffOffset(e.value, got))
+		}
+	}
+	if n := missing + mismatched - dumps; n > 0 {
+		fmt.Fprintf(os.Stderr, "%s verify: %d more failures not shown\n", name, n)
+	}
+	fmt.Printf("%s verify: %d samples, %d missing, %d mismatched\n", name, len(sampler.samples), missing, mismatched)
+	pass := 1
+	if missing+mismatched > 0 {
+		pass = 0
+		verifyFailed = true
+	}
+	record.add("Verify", "", pass)
+	record.add("Verify mismatches", "", missing+mismatched)
+}
+
+// diffOffset returns the index of the first byte where a and b differ, or the
+// length of the shorter one if it is a prefix of the other.
+func diffOffset(a, b []byte) int {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if a[i] != b[i] {
+			return i
+		}
+	}
+	if len(a) < len(b) {
+		return len(a)
+	}
+	return len(b)
+}
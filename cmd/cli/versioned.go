@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/smallnest/kvbench"
+)
+
+// test versioned writes and historical reads of a store with explicit
+// timestamps (badger-managed). Every goroutine writes versions 1, 2, 3, ...
+// of its own key, then reads that key as of random earlier versions. Other
+// stores record -1.
+func testVersioned(record *Record, name string, store kvbench.Store) {
+	vs, ok := store.(kvbench.VersionedStore)
+	if !ok {
+		fmt.Printf("%s versioned set rate: %d op/s, historical get rate: %d op/s\n", name, -1, -1)
+		record.add("Versioned Set op/s", "op/s", -1)
+		record.add("Historical Get op/s", "op/s", -1)
+		return
+	}
+	keys := make([][]byte, *c)
+	for j := range keys {
+		keys[j] = []byte(*keyPrefix + "versioned-" + strconv.Itoa(j))
+	}
+	versions := make([]uint64, *c)
+
+	p := newPhase(record, name, "Versioned Set")
+	setRate, setErrs := runVersioned(p, func(j int) error {
+		versions[j]++
+		return vs.SetAt(keys[j], data, versions[j])
+	})
+	p.stop()
+
+	rs := make([]*rand.Rand, *c)
+	for j := range rs {
+		rs[j] = newRand(uint64(j))
+	}
+	p = newPhase(record, name, "Historical Get")
+	getRate, getErrs := runVersioned(p, func(j int) error {
+		if versions[j] == 0 {
+			return nil
+		}
+		_, ok, err := vs.GetAt(keys[j], 1+uint64(rs[j].Int63n(int64(versions[j]))))
+		if err == nil && !ok {
+			err = fmt.Errorf("version missing")
+		}
+		return err
+	})
+	p.stop()
+
+	if setErrs+getErrs > 0 {
+		fmt.Printf("%s versioned errors: %d set, %d get\n", name, setErrs, getErrs)
+	}
+	fmt.Printf("%s versioned set rate: %d op/s, historical get rate: %d op/s\n", name, setRate, getRate)
+	record.add("Versioned Set op/s", "op/s", int(setRate))
+	record.add("Historical Get op/s", "op/s", int(getRate))
+}
+
+// runVersioned calls op from *c goroutines until p is done, passing each its
+// index, and returns the rate in op/s and the number of failed calls.
+func runVersioned(p *phase, op func(j int) error) (int64, int) {
+	var wg sync.WaitGroup
+	wg.Add(*c)
+	counts := make([]int, *c)
+	errs := make([]int, *c)
+	start := time.Now()
+	for j := 0; j < *c; j++ {
+		index := j
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-p.done():
+					return
+				default:
+				}
+				if err := op(index); err != nil {
+					errs[index]++
+				}
+				counts[index]++
+				p.tick(uint64(index), 1)
+			}
+		}()
+	}
+	wg.Wait()
+	d := int64(time.Since(start))
+	var n, failed int
+	for j := range counts {
+		n += counts[j]
+		failed += errs[j]
+	}
+	return int64(n) * 1e6 / (d / 1e3), failed
+}
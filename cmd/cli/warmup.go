@@ -0,0 +1,25 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// warmUp runs the workload of a phase for -warmup before the measured
+// window and discards its results, so that page cache population, memtable
+// filling and compaction backlog do not weigh on the first seconds of the
+// measurement. run is the same function the phase measures with, so the
+// warm-up has its concurrency and key distribution; it gets a phase of its
+// own with no -until-stable windows, samples or latency histograms.
+func warmUp(name, label string, run func(p *phase)) {
+	if *warmup <= 0 {
+		return
+	}
+	ctx, cancel := context.WithTimeout(runCtx, *warmup)
+	defer cancel()
+	p := &phase{ctx: ctx, cancel: cancel, record: &Record{}, name: name, label: label}
+	start := time.Now()
+	run(p)
+	fmt.Printf("%s %s warm-up took: %s\n", name, label, time.Since(start).Round(time.Millisecond))
+}
@@ -96,34 +96,34 @@ func main() {
 	}
 
 	// print nosync throughputs
-	fmt.Println("nofsync - throughputs\n")
+	fmt.Print("nofsync - throughputs\n\n")
 	fmt.Println(nofsyncnames)
 	fmt.Println(nofsyncsp)
 	for _, v := range nofsyncTps {
 		fmt.Println(v)
 	}
-	fmt.Println("\n")
+	fmt.Print("\n\n")
 
 	// print nosync time
-	fmt.Println("nofsync - time\n")
+	fmt.Print("nofsync - time\n\n")
 	fmt.Println(nofsyncnames)
 	fmt.Println(nofsyncsp)
 	for _, v := range nofsyncTime {
 		fmt.Println(v)
 	}
-	fmt.Println("\n")
+	fmt.Print("\n\n")
 
 	// print sync throughputs
-	fmt.Println("fsync - throughputs\n")
+	fmt.Print("fsync - throughputs\n\n")
 	fmt.Println(fsyncnames)
 	fmt.Println(fsyncsp)
 	for _, v := range fsyncTps {
 		fmt.Println(v)
 	}
-	fmt.Println("\n")
+	fmt.Print("\n\n")
 
 	// print sync time
-	fmt.Println("fsync - time\n")
+	fmt.Print("fsync - time\n\n")
 	fmt.Println(fsyncnames)
 	fmt.Println(fsyncsp)
 	for _, v := range fsyncTime {
@@ -16,6 +16,7 @@ func main() {
 	flag.StringVar(&opts.Which, "store", "map", "store type: map,btree,bolt,leveldb")
 	flag.BoolVar(&opts.Fsync, "fsync", true, "fsync")
 	flag.StringVar(&opts.Path, "path", "", "database path or ':memory:' for none")
+	flag.BoolVar(&opts.GRPC, "grpc", false, "serve the gRPC service in kvpb instead of the Redis protocol")
 	flag.Parse()
 	opts.Log = log
 	if err := kvbench.Start(opts); err != nil {
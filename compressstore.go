@@ -0,0 +1,312 @@
+package kvbench
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/zstd"
+	"github.com/pierrec/lz4/v4"
+)
+
+var errCorruptValue = errors.New("corrupt compressed value")
+
+type valueCodec interface {
+	encode(src []byte) []byte
+	decode(src []byte) ([]byte, error)
+}
+
+type noneCodec struct{}
+
+func (noneCodec) encode(src []byte) []byte          { return src }
+func (noneCodec) decode(src []byte) ([]byte, error) { return src, nil }
+
+type snappyCodec struct{}
+
+func (snappyCodec) encode(src []byte) []byte          { return snappy.Encode(nil, src) }
+func (snappyCodec) decode(src []byte) ([]byte, error) { return snappy.Decode(nil, src) }
+
+type zstdCodec struct {
+	enc *zstd.Encoder
+	dec *zstd.Decoder
+}
+
+func (c *zstdCodec) encode(src []byte) []byte          { return c.enc.EncodeAll(src, nil) }
+func (c *zstdCodec) decode(src []byte) ([]byte, error) { return c.dec.DecodeAll(src, nil) }
+
+// lz4Codec uses the lz4 block format, which does not record the size of the
+// input, so each value starts with a uvarint of its length plus one, or zero
+// if the value did not compress and is stored as is.
+type lz4Codec struct{}
+
+func (lz4Codec) encode(src []byte) []byte {
+	dst := make([]byte, binary.MaxVarintLen64+lz4.CompressBlockBound(len(src)))
+	n := binary.PutUvarint(dst, uint64(len(src))+1)
+	m, err := lz4.CompressBlock(src, dst[n:], nil)
+	if err != nil || m == 0 {
+		dst = append(dst[:0], 0)
+		return append(dst, src...)
+	}
+	return dst[:n+m]
+}
+
+func (lz4Codec) decode(src []byte) ([]byte, error) {
+	size, n := binary.Uvarint(src)
+	if n <= 0 {
+		return nil, errCorruptValue
+	}
+	if size == 0 {
+		return src[n:], nil
+	}
+	dst := make([]byte, size-1)
+	m, err := lz4.UncompressBlock(src[n:], dst)
+	if err != nil {
+		return nil, err
+	}
+	if m != len(dst) {
+		return nil, errCorruptValue
+	}
+	return dst, nil
+}
+
+func newValueCodec(name string) (valueCodec, error) {
+	switch name {
+	case "none":
+		return noneCodec{}, nil
+	case "snappy":
+		return snappyCodec{}, nil
+	case "lz4":
+		return lz4Codec{}, nil
+	case "zstd":
+		enc, err := zstd.NewWriter(nil)
+		if err != nil {
+			return nil, err
+		}
+		dec, err := zstd.NewReader(nil)
+		if err != nil {
+			return nil, err
+		}
+		return &zstdCodec{enc: enc, dec: dec}, nil
+	}
+	return nil, fmt.Errorf("unknown codec: %v", name)
+}
+
+// compressStore compresses values before handing them to the inner store and
+// decompresses them on the way out, the way an application would on top of
+// a store without block compression.
+type compressStore struct {
+	Store
+	codec valueCodec
+	// updateMu serializes Incr and CAS
+	updateMu sync.Mutex
+
+	rawBytes        int64
+	compressedBytes int64
+	codecNanos      int64
+	codecCalls      int64
+}
+
+// NewCompressStore wraps inner with value compression. codec is one of
+// "snappy", "zstd", "lz4" or "none".
+func NewCompressStore(inner Store, codec string) (Store, error) {
+	vc, err := newValueCodec(codec)
+	if err != nil {
+		return nil, err
+	}
+	return &compressStore{Store: inner, codec: vc}, nil
+}
+
+// CompressionStats returns the bytes handed to the codec, the bytes it
+// produced, and the time spent compressing and decompressing per call.
+func (s *compressStore) CompressionStats() (raw, compressed int64, perCall time.Duration) {
+	calls := atomic.LoadInt64(&s.codecCalls)
+	if calls > 0 {
+		perCall = time.Duration(atomic.LoadInt64(&s.codecNanos) / calls)
+	}
+	return atomic.LoadInt64(&s.rawBytes), atomic.LoadInt64(&s.compressedBytes), perCall
+}
+
+func (s *compressStore) encode(value []byte) []byte {
+	start := time.Now()
+	v := s.codec.encode(value)
+	atomic.AddInt64(&s.codecNanos, int64(time.Since(start)))
+	atomic.AddInt64(&s.codecCalls, 1)
+	atomic.AddInt64(&s.rawBytes, int64(len(value)))
+	atomic.AddInt64(&s.compressedBytes, int64(len(v)))
+	return v
+}
+
+func (s *compressStore) decode(value []byte) ([]byte, error) {
+	start := time.Now()
+	v, err := s.codec.decode(value)
+	atomic.AddInt64(&s.codecNanos, int64(time.Since(start)))
+	atomic.AddInt64(&s.codecCalls, 1)
+	return v, err
+}
+
+func (s *compressStore) Set(key, value []byte) error {
+	return s.Store.Set(key, s.encode(value))
+}
+
+func (s *compressStore) SetAsync(key, value []byte, cb func(error)) {
+	s.Store.SetAsync(key, s.encode(value), cb)
+}
+
+func (s *compressStore) PSet(keys, values [][]byte) error {
+	encoded := make([][]byte, len(values))
+	for i := range values {
+		encoded[i] = s.encode(values[i])
+	}
+	return s.Store.PSet(keys, encoded)
+}
+
+func (s *compressStore) Get(key []byte) ([]byte, bool, error) {
+	v, ok, err := s.Store.Get(key)
+	if err != nil || !ok {
+		return v, ok, err
+	}
+	v, err = s.decode(v)
+	if err != nil {
+		return nil, false, err
+	}
+	return emptyIfNil(v), true, nil
+}
+
+func (s *compressStore) PGet(keys [][]byte) ([][]byte, []bool, error) {
+	values, oks, err := s.Store.PGet(keys)
+	if err != nil {
+		return values, oks, err
+	}
+	for i := range values {
+		if !oks[i] {
+			continue
+		}
+		if values[i], err = s.decode(values[i]); err != nil {
+			return nil, nil, err
+		}
+		values[i] = emptyIfNil(values[i])
+	}
+	return values, oks, nil
+}
+
+func (s *compressStore) Keys(pattern []byte, limit int, withvalues bool) ([][]byte, [][]byte, error) {
+	keys, vals, err := s.Store.Keys(pattern, limit, withvalues)
+	if err != nil {
+		return keys, vals, err
+	}
+	for i := range vals {
+		if vals[i], err = s.decode(vals[i]); err != nil {
+			return nil, nil, err
+		}
+	}
+	return keys, vals, nil
+}
+
+func (s *compressStore) AllKeys(limit int, withvalues bool) ([][]byte, [][]byte, error) {
+	keys, vals, err := s.Store.AllKeys(limit, withvalues)
+	if err != nil {
+		return keys, vals, err
+	}
+	for i := range vals {
+		if vals[i], err = s.decode(vals[i]); err != nil {
+			return nil, nil, err
+		}
+	}
+	return keys, vals, nil
+}
+
+func (s *compressStore) RangeScan(start, end []byte, limit int, withvalues bool) ([][]byte, [][]byte, error) {
+	keys, vals, err := s.Store.RangeScan(start, end, limit, withvalues)
+	if err != nil {
+		return keys, vals, err
+	}
+	for i := range vals {
+		if vals[i], err = s.decode(vals[i]); err != nil {
+			return nil, nil, err
+		}
+	}
+	return keys, vals, nil
+}
+
+// compressSnapshot decodes the values read from a snapshot of the wrapped
+// store.
+type compressSnapshot struct {
+	Snapshot
+	s *compressStore
+}
+
+func (s *compressStore) Snapshot() (Snapshot, error) {
+	snap, err := s.Store.Snapshot()
+	if err != nil {
+		return nil, err
+	}
+	return compressSnapshot{snap, s}, nil
+}
+
+func (s compressSnapshot) Get(key []byte) ([]byte, bool, error) {
+	v, ok, err := s.Snapshot.Get(key)
+	if err != nil || !ok {
+		return v, ok, err
+	}
+	v, err = s.s.decode(v)
+	if err != nil {
+		return nil, false, err
+	}
+	return emptyIfNil(v), true, nil
+}
+
+func (s compressSnapshot) RangeScan(start, end []byte, limit int, withvalues bool) ([][]byte, [][]byte, error) {
+	keys, vals, err := s.Snapshot.RangeScan(start, end, limit, withvalues)
+	if err != nil {
+		return keys, vals, err
+	}
+	for i := range vals {
+		if vals[i], err = s.s.decode(vals[i]); err != nil {
+			return nil, nil, err
+		}
+	}
+	return keys, vals, nil
+}
+
+// Merge adds with update rather than the merge operator of the inner store,
+// which would see compressed values.
+func (s *compressStore) Merge(key, value []byte) error {
+	return mergeWith(s.update, key, value)
+}
+
+// update reads and writes key under updateMu with Get and Set, which decode
+// and encode the value, as the wrapped store would compare or add to the
+// encoded bytes. Only Incr and CAS are atomic with each other.
+func (s *compressStore) update(key []byte, fn updateFunc) error {
+	s.updateMu.Lock()
+	defer s.updateMu.Unlock()
+	old, ok, err := s.Get(key)
+	if err != nil {
+		return err
+	}
+	v, err := fn(old, ok)
+	if err != nil || v == nil {
+		return err
+	}
+	return s.Set(key, v)
+}
+
+func (s *compressStore) Incr(key []byte, delta int64) (int64, error) {
+	return incrWith(s.update, key, delta)
+}
+
+func (s *compressStore) CAS(key, oldValue, newValue []byte) (bool, error) {
+	return casWith(s.update, key, oldValue, newValue)
+}
+
+func (s *compressStore) SetWithTTL(key, value []byte, ttl time.Duration) error {
+	return s.Store.SetWithTTL(key, s.encode(value), ttl)
+}
+
+func (s *compressStore) Capabilities() Capability {
+	return s.Store.Capabilities() | CapCAS | CapMerge
+}
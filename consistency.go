@@ -0,0 +1,25 @@
+package kvbench
+
+import "fmt"
+
+// Consistency is the read consistency requested from replicated backends.
+// The other stores always read their own writes, which is strong.
+type Consistency string
+
+const (
+	// ConsistencyStrong asks for linearizable reads.
+	ConsistencyStrong Consistency = "strong"
+	// ConsistencyEventual allows reads served from a possibly stale replica
+	// (serializable reads, follower reads or stale reads, depending on the
+	// backend).
+	ConsistencyEventual Consistency = "eventual"
+)
+
+// ParseConsistency parses "strong" or "eventual".
+func ParseConsistency(s string) (Consistency, error) {
+	switch c := Consistency(s); c {
+	case ConsistencyStrong, ConsistencyEventual:
+		return c, nil
+	}
+	return "", fmt.Errorf("unknown consistency level: %v", s)
+}
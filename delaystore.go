@@ -0,0 +1,124 @@
+package kvbench
+
+import (
+	"math/rand"
+	"time"
+)
+
+// delayStore wraps another store and sleeps before every operation. It is
+// not meant to be benchmarked for its own sake: with a known delay, the
+// latencies the harness reports can be checked against the configured value.
+type delayStore struct {
+	Store
+	delay  time.Duration
+	jitter time.Duration
+}
+
+// NewDelayStore returns a store that sleeps for delay plus a random amount in
+// [0, jitter) before delegating each operation to inner.
+func NewDelayStore(inner Store, delay time.Duration, jitter time.Duration) Store {
+	return &delayStore{
+		Store:  inner,
+		delay:  delay,
+		jitter: jitter,
+	}
+}
+
+func (s *delayStore) sleep() {
+	d := s.delay
+	if s.jitter > 0 {
+		d += time.Duration(rand.Int63n(int64(s.jitter)))
+	}
+	time.Sleep(d)
+}
+
+func (s *delayStore) Set(key, value []byte) error {
+	s.sleep()
+	return s.Store.Set(key, value)
+}
+
+func (s *delayStore) SetAsync(key, value []byte, cb func(error)) {
+	s.sleep()
+	s.Store.SetAsync(key, value, cb)
+}
+
+func (s *delayStore) PSet(keys, values [][]byte) error {
+	s.sleep()
+	return s.Store.PSet(keys, values)
+}
+
+func (s *delayStore) Get(key []byte) ([]byte, bool, error) {
+	s.sleep()
+	return s.Store.Get(key)
+}
+
+func (s *delayStore) Has(key []byte) (bool, error) {
+	s.sleep()
+	return s.Store.Has(key)
+}
+
+func (s *delayStore) PGet(keys [][]byte) ([][]byte, []bool, error) {
+	s.sleep()
+	return s.Store.PGet(keys)
+}
+
+func (s *delayStore) Del(key []byte) (bool, error) {
+	s.sleep()
+	return s.Store.Del(key)
+}
+
+func (s *delayStore) PDel(keys [][]byte) error {
+	s.sleep()
+	return s.Store.PDel(keys)
+}
+
+func (s *delayStore) Merge(key, value []byte) error {
+	s.sleep()
+	return s.Store.Merge(key, value)
+}
+
+func (s *delayStore) Incr(key []byte, delta int64) (int64, error) {
+	s.sleep()
+	return s.Store.Incr(key, delta)
+}
+
+func (s *delayStore) CAS(key, oldValue, newValue []byte) (bool, error) {
+	s.sleep()
+	return s.Store.CAS(key, oldValue, newValue)
+}
+
+func (s *delayStore) SetWithTTL(key, value []byte, ttl time.Duration) error {
+	s.sleep()
+	return s.Store.SetWithTTL(key, value, ttl)
+}
+
+func (s *delayStore) Ping() error {
+	s.sleep()
+	return s.Store.Ping()
+}
+
+func (s *delayStore) Keys(pattern []byte, limit int, withvalues bool) ([][]byte, [][]byte, error) {
+	s.sleep()
+	return s.Store.Keys(pattern, limit, withvalues)
+}
+
+func (s *delayStore) AllKeys(limit int, withvalues bool) ([][]byte, [][]byte, error) {
+	s.sleep()
+	return s.Store.AllKeys(limit, withvalues)
+}
+
+func (s *delayStore) RangeScan(start, end []byte, limit int, withvalues bool) ([][]byte, [][]byte, error) {
+	s.sleep()
+	return s.Store.RangeScan(start, end, limit, withvalues)
+}
+
+// Snapshot delays opening the snapshot only.
+func (s *delayStore) Snapshot() (Snapshot, error) {
+	s.sleep()
+	return s.Store.Snapshot()
+}
+
+func (s *delayStore) Count() (int64, error) {
+	s.sleep()
+	return s.Store.Count()
+}
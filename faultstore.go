@@ -0,0 +1,152 @@
+package kvbench
+
+import (
+	"errors"
+	"math/rand"
+	"time"
+)
+
+// ErrFault is the error returned by a fault store for an injected fault.
+var ErrFault = errors.New("injected fault")
+
+// faultStore wraps another store and fails a random fraction of the
+// operations with ErrFault, without passing them on. It checks that the
+// harness counts failed operations as errors rather than as work done.
+type faultStore struct {
+	Store
+	errRate float64
+}
+
+// NewFaultStore returns a store that fails each operation on inner with
+// probability errRate. Close, Ping, Compact and Capabilities never fail.
+func NewFaultStore(inner Store, errRate float64) Store {
+	return &faultStore{
+		Store:   inner,
+		errRate: errRate,
+	}
+}
+
+func (s *faultStore) fault() bool {
+	return s.errRate > 0 && rand.Float64() < s.errRate
+}
+
+func (s *faultStore) Set(key, value []byte) error {
+	if s.fault() {
+		return ErrFault
+	}
+	return s.Store.Set(key, value)
+}
+
+func (s *faultStore) SetAsync(key, value []byte, cb func(error)) {
+	if s.fault() {
+		cb(ErrFault)
+		return
+	}
+	s.Store.SetAsync(key, value, cb)
+}
+
+func (s *faultStore) PSet(keys, values [][]byte) error {
+	if s.fault() {
+		return ErrFault
+	}
+	return s.Store.PSet(keys, values)
+}
+
+func (s *faultStore) Get(key []byte) ([]byte, bool, error) {
+	if s.fault() {
+		return nil, false, ErrFault
+	}
+	return s.Store.Get(key)
+}
+
+func (s *faultStore) Has(key []byte) (bool, error) {
+	if s.fault() {
+		return false, ErrFault
+	}
+	return s.Store.Has(key)
+}
+
+func (s *faultStore) PGet(keys [][]byte) ([][]byte, []bool, error) {
+	if s.fault() {
+		return nil, nil, ErrFault
+	}
+	return s.Store.PGet(keys)
+}
+
+func (s *faultStore) Del(key []byte) (bool, error) {
+	if s.fault() {
+		return false, ErrFault
+	}
+	return s.Store.Del(key)
+}
+
+func (s *faultStore) PDel(keys [][]byte) error {
+	if s.fault() {
+		return ErrFault
+	}
+	return s.Store.PDel(keys)
+}
+
+func (s *faultStore) Merge(key, value []byte) error {
+	if s.fault() {
+		return ErrFault
+	}
+	return s.Store.Merge(key, value)
+}
+
+func (s *faultStore) Incr(key []byte, delta int64) (int64, error) {
+	if s.fault() {
+		return 0, ErrFault
+	}
+	return s.Store.Incr(key, delta)
+}
+
+func (s *faultStore) CAS(key, oldValue, newValue []byte) (bool, error) {
+	if s.fault() {
+		return false, ErrFault
+	}
+	return s.Store.CAS(key, oldValue, newValue)
+}
+
+func (s *faultStore) SetWithTTL(key, value []byte, ttl time.Duration) error {
+	if s.fault() {
+		return ErrFault
+	}
+	return s.Store.SetWithTTL(key, value, ttl)
+}
+
+func (s *faultStore) Keys(pattern []byte, limit int, withvalues bool) ([][]byte, [][]byte, error) {
+	if s.fault() {
+		return nil, nil, ErrFault
+	}
+	return s.Store.Keys(pattern, limit, withvalues)
+}
+
+func (s *faultStore) AllKeys(limit int, withvalues bool) ([][]byte, [][]byte, error) {
+	if s.fault() {
+		return nil, nil, ErrFault
+	}
+	return s.Store.AllKeys(limit, withvalues)
+}
+
+func (s *faultStore) RangeScan(start, end []byte, limit int, withvalues bool) ([][]byte, [][]byte, error) {
+	if s.fault() {
+		return nil, nil, ErrFault
+	}
+	return s.Store.RangeScan(start, end, limit, withvalues)
+}
+
+// Snapshot injects faults into opening the snapshot only.
+func (s *faultStore) Snapshot() (Snapshot, error) {
+	if s.fault() {
+		return nil, ErrFault
+	}
+	return s.Store.Snapshot()
+}
+
+func (s *faultStore) Count() (int64, error) {
+	if s.fault() {
+		return 0, ErrFault
+	}
+	return s.Store.Count()
+}
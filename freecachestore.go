@@ -0,0 +1,181 @@
+package kvbench
+
+import (
+	"time"
+
+	"github.com/coocood/freecache"
+)
+
+// freecacheStore is a freecache cache of a fixed number of bytes: 256
+// segments of ring buffers, each under its own lock, that evict the entries
+// least recently used on average once full. Like lruStore it is a baseline
+// for caches rather than a database.
+type freecacheStore struct {
+	cache *freecache.Cache
+}
+
+// NewFreecacheStore returns a freecache cache of maxBytes, at least 512 KiB.
+// Values larger than 1/1024 of it are rejected.
+func NewFreecacheStore(maxBytes int) (Store, error) {
+	return &freecacheStore{cache: freecache.NewCache(maxBytes)}, nil
+}
+
+func (s *freecacheStore) Close() error {
+	return nil
+}
+
+func (s *freecacheStore) PSet(keys, values [][]byte) error {
+	for i := range keys {
+		if err := s.Set(keys[i], values[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *freecacheStore) PGet(keys [][]byte) ([][]byte, []bool, error) {
+	var values [][]byte
+	var oks []bool
+	for i := range keys {
+		v, ok, err := s.Get(keys[i])
+		if err != nil {
+			return nil, nil, err
+		}
+		values = append(values, v)
+		oks = append(oks, ok)
+	}
+	return values, oks, nil
+}
+
+func (s *freecacheStore) Set(key, value []byte) error {
+	return s.cache.Set(key, value, 0)
+}
+
+// Get returns a copy of the value, which freecache makes.
+func (s *freecacheStore) Get(key []byte) ([]byte, bool, error) {
+	v, err := s.cache.Get(key)
+	if err == freecache.ErrNotFound {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return emptyIfNil(v), true, nil
+}
+
+// Has neither counts as a hit or miss nor refreshes the key. It looks the
+// key up with TTL, as Peek ignores expiration.
+func (s *freecacheStore) Has(key []byte) (bool, error) {
+	_, err := s.cache.TTL(key)
+	if err == freecache.ErrNotFound {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (s *freecacheStore) Del(key []byte) (bool, error) {
+	return s.cache.Del(key), nil
+}
+
+func (s *freecacheStore) PDel(keys [][]byte) error {
+	for _, k := range keys {
+		s.cache.Del(k)
+	}
+	return nil
+}
+
+func (s *freecacheStore) Keys(pattern []byte, limit int, withvalues bool) ([][]byte, [][]byte, error) {
+	return nil, nil, ErrNotSupported
+}
+
+// AllKeys returns the keys segment by segment, in hash order.
+func (s *freecacheStore) AllKeys(limit int, withvalues bool) ([][]byte, [][]byte, error) {
+	var keys [][]byte
+	var vals [][]byte
+	it := s.cache.NewIterator()
+	for e := it.Next(); e != nil; e = it.Next() {
+		if limit > 0 && len(keys) >= limit {
+			break
+		}
+		keys = append(keys, e.Key)
+		if withvalues {
+			vals = append(vals, emptyIfNil(e.Value))
+		}
+	}
+	return keys, vals, nil
+}
+
+func (s *freecacheStore) RangeScan(start, end []byte, limit int, withvalues bool) ([][]byte, [][]byte, error) {
+	return nil, nil, ErrNotSupported
+}
+
+func (s *freecacheStore) Snapshot() (Snapshot, error) {
+	return nil, ErrNotSupported
+}
+
+func (s *freecacheStore) Count() (int64, error) {
+	return s.cache.EntryCount(), nil
+}
+
+func (s *freecacheStore) FlushDB() error {
+	s.cache.Clear()
+	return nil
+}
+
+func (s *freecacheStore) Compact() error {
+	return ErrNotSupported
+}
+
+func (s *freecacheStore) SetAsync(key, value []byte, cb func(error)) {
+	cb(s.Set(key, value))
+}
+
+func (s *freecacheStore) Merge(key, value []byte) error {
+	return mergeWith(s.update, key, value)
+}
+
+// update reads and writes key under the lock of its segment with
+// freecache's Update.
+func (s *freecacheStore) update(key []byte, fn updateFunc) error {
+	var fnErr error
+	_, _, err := s.cache.Update(key, func(old []byte, ok bool) ([]byte, bool, int) {
+		v, err := fn(old, ok)
+		if err != nil {
+			fnErr = err
+			return nil, false, 0
+		}
+		return v, v != nil, 0
+	})
+	if fnErr != nil {
+		return fnErr
+	}
+	return err
+}
+
+func (s *freecacheStore) Incr(key []byte, delta int64) (int64, error) {
+	return incrWith(s.update, key, delta)
+}
+
+func (s *freecacheStore) CAS(key, oldValue, newValue []byte) (bool, error) {
+	return casWith(s.update, key, oldValue, newValue)
+}
+
+// SetWithTTL rounds ttl up to whole seconds, the granularity of freecache.
+func (s *freecacheStore) SetWithTTL(key, value []byte, ttl time.Duration) error {
+	return s.cache.Set(key, value, int((ttl+time.Second-1)/time.Second))
+}
+
+func (s *freecacheStore) Ping() error {
+	return nil
+}
+
+func (s *freecacheStore) Capabilities() Capability {
+	return CapTTL | CapCAS | CapMerge
+}
+
+// CacheStats returns the Get hits and misses and the entries evicted since
+// the store was opened. freecache counts an entry it moved to the end of its
+// ring buffer, to keep it, as evicted too.
+func (s *freecacheStore) CacheStats() (hits, misses, evictions int64) {
+	return s.cache.HitCount(), s.cache.MissCount(), s.cache.EvacuateCount()
+}
@@ -0,0 +1,243 @@
+package kvbench
+
+import (
+	"context"
+	"math"
+	"time"
+
+	"github.com/smallnest/kvbench/kvpb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+)
+
+// grpcMaxMsgSize lifts the 4MiB default of gRPC, which a Scan of the whole
+// keyspace or a large BatchSet easily exceeds.
+const grpcMaxMsgSize = math.MaxInt32
+
+// grpcStore is a client of a KV engine running in another process, reached
+// through the service in kvpb.
+type grpcStore struct {
+	conn   *grpc.ClientConn
+	client kvpb.KVClient
+}
+
+// NewGRPCStore connects to the kvpb.KV service at addr.
+func NewGRPCStore(addr string) (Store, error) {
+	conn, err := grpc.Dial(addr,
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithDefaultCallOptions(grpc.MaxCallRecvMsgSize(grpcMaxMsgSize), grpc.MaxCallSendMsgSize(grpcMaxMsgSize)))
+	if err != nil {
+		return nil, err
+	}
+	return &grpcStore{
+		conn:   conn,
+		client: kvpb.NewKVClient(conn),
+	}, nil
+}
+
+func (s *grpcStore) Close() error {
+	return s.conn.Close()
+}
+
+func (s *grpcStore) Set(key, value []byte) error {
+	_, err := s.client.Set(context.Background(), &kvpb.SetRequest{Key: key, Value: value})
+	return err
+}
+
+func (s *grpcStore) SetAsync(key, value []byte, cb func(error)) {
+	cb(s.Set(key, value))
+}
+
+func (s *grpcStore) PSet(keys, values [][]byte) error {
+	_, err := s.client.BatchSet(context.Background(), &kvpb.BatchSetRequest{Keys: keys, Values: values})
+	return err
+}
+
+func (s *grpcStore) Get(key []byte) ([]byte, bool, error) {
+	resp, err := s.client.Get(context.Background(), &kvpb.GetRequest{Key: key})
+	if err != nil {
+		return nil, false, err
+	}
+	if !resp.Found {
+		return nil, false, nil
+	}
+	return emptyIfNil(resp.Value), true, nil
+}
+
+// Has is a Get round trip: the service has no call of its own for it.
+func (s *grpcStore) Has(key []byte) (bool, error) {
+	resp, err := s.client.Get(context.Background(), &kvpb.GetRequest{Key: key})
+	if err != nil {
+		return false, err
+	}
+	return resp.Found, nil
+}
+
+func (s *grpcStore) PGet(keys [][]byte) ([][]byte, []bool, error) {
+	resp, err := s.client.BatchGet(context.Background(), &kvpb.BatchGetRequest{Keys: keys})
+	if err != nil {
+		return nil, nil, err
+	}
+	for i := range resp.Values {
+		if resp.Found[i] {
+			resp.Values[i] = emptyIfNil(resp.Values[i])
+		}
+	}
+	return resp.Values, resp.Found, nil
+}
+
+func (s *grpcStore) Del(key []byte) (bool, error) {
+	resp, err := s.client.Del(context.Background(), &kvpb.DelRequest{Key: key})
+	if err != nil {
+		return false, err
+	}
+	return resp.Found, nil
+}
+
+// PDel is one Del round trip per key: the service has no batch delete.
+func (s *grpcStore) PDel(keys [][]byte) error {
+	for _, k := range keys {
+		if _, err := s.Del(k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *grpcStore) Keys(pattern []byte, limit int, withvalues bool) ([][]byte, [][]byte, error) {
+	resp, err := s.client.Scan(context.Background(), &kvpb.ScanRequest{
+		Prefix:     pattern,
+		Limit:      int32(limit),
+		WithValues: withvalues,
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return resp.Keys, resp.Values, nil
+}
+
+func (s *grpcStore) FlushDB() error {
+	return ErrNotSupported
+}
+
+func (s *grpcStore) Compact() error {
+	return ErrNotSupported
+}
+
+// grpcServer serves a Store over the kvpb.KV service. It is the reference
+// implementation of the service.
+type grpcServer struct {
+	kvpb.UnimplementedKVServer
+	store Store
+}
+
+// NewGRPCServer returns a gRPC server that serves store.
+func NewGRPCServer(store Store) *grpc.Server {
+	srv := grpc.NewServer(grpc.MaxRecvMsgSize(grpcMaxMsgSize), grpc.MaxSendMsgSize(grpcMaxMsgSize))
+	kvpb.RegisterKVServer(srv, &grpcServer{store: store})
+	return srv
+}
+
+func (s *grpcServer) Set(_ context.Context, req *kvpb.SetRequest) (*kvpb.SetResponse, error) {
+	if err := s.store.Set(req.Key, req.Value); err != nil {
+		return nil, err
+	}
+	return &kvpb.SetResponse{}, nil
+}
+
+func (s *grpcServer) Get(_ context.Context, req *kvpb.GetRequest) (*kvpb.GetResponse, error) {
+	v, ok, err := s.store.Get(req.Key)
+	if err != nil {
+		return nil, err
+	}
+	return &kvpb.GetResponse{Value: v, Found: ok}, nil
+}
+
+func (s *grpcServer) Del(_ context.Context, req *kvpb.DelRequest) (*kvpb.DelResponse, error) {
+	ok, err := s.store.Del(req.Key)
+	if err != nil {
+		return nil, err
+	}
+	return &kvpb.DelResponse{Found: ok}, nil
+}
+
+func (s *grpcServer) BatchSet(_ context.Context, req *kvpb.BatchSetRequest) (*kvpb.BatchSetResponse, error) {
+	if len(req.Keys) != len(req.Values) {
+		return nil, status.Errorf(codes.InvalidArgument, "%d keys but %d values", len(req.Keys), len(req.Values))
+	}
+	if err := s.store.PSet(req.Keys, req.Values); err != nil {
+		return nil, err
+	}
+	return &kvpb.BatchSetResponse{}, nil
+}
+
+func (s *grpcServer) BatchGet(_ context.Context, req *kvpb.BatchGetRequest) (*kvpb.BatchGetResponse, error) {
+	values, oks, err := s.store.PGet(req.Keys)
+	if err != nil {
+		return nil, err
+	}
+	return &kvpb.BatchGetResponse{Values: values, Found: oks}, nil
+}
+
+func (s *grpcServer) Scan(_ context.Context, req *kvpb.ScanRequest) (*kvpb.ScanResponse, error) {
+	var keys, values [][]byte
+	var err error
+	if len(req.Prefix) == 0 {
+		// every key has the empty prefix
+		keys, values, err = s.store.AllKeys(int(req.Limit), req.WithValues)
+	} else {
+		keys, values, err = s.store.Keys(req.Prefix, int(req.Limit), req.WithValues)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &kvpb.ScanResponse{Keys: keys, Values: values}, nil
+}
+
+func (s *grpcStore) AllKeys(limit int, withvalues bool) ([][]byte, [][]byte, error) {
+	return s.Keys(nil, limit, withvalues)
+}
+
+func (s *grpcStore) RangeScan(start, end []byte, limit int, withvalues bool) ([][]byte, [][]byte, error) {
+	return nil, nil, ErrNotSupported
+}
+
+func (s *grpcStore) Snapshot() (Snapshot, error) {
+	return nil, ErrNotSupported
+}
+
+// Count lists every key, as the service has no count method.
+func (s *grpcStore) Count() (int64, error) {
+	keys, _, err := s.AllKeys(0, false)
+	return int64(len(keys)), err
+}
+
+func (s *grpcStore) Merge(key, value []byte) error {
+	return ErrNotSupported
+}
+
+func (s *grpcStore) Incr(key []byte, delta int64) (int64, error) {
+	return 0, ErrNotSupported
+}
+
+func (s *grpcStore) CAS(key, oldValue, newValue []byte) (bool, error) {
+	return false, ErrNotSupported
+}
+
+// SetWithTTL is not supported: the service has no expiring writes.
+func (s *grpcStore) SetWithTTL(key, value []byte, ttl time.Duration) error {
+	return ErrNotSupported
+}
+
+// Ping makes a Get round trip, which also establishes the connection.
+func (s *grpcStore) Ping() error {
+	_, err := s.client.Get(context.Background(), &kvpb.GetRequest{Key: []byte("kvbench-ping")})
+	return err
+}
+
+func (s *grpcStore) Capabilities() Capability {
+	// all the service promises
+	return CapKeys
+}
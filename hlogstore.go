@@ -0,0 +1,428 @@
+package kvbench
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// hlogMemSize is the size of the in-memory mutable region of the log.
+const hlogMemSize = 64 << 20
+
+const hlogHeaderSize = 9
+
+const (
+	hlogSet byte = iota
+	hlogDel
+)
+
+// hlogStore is a pure Go take on the hybrid log design used by FASTER: a
+// hash index maps every key to the address of its latest record in a single
+// append-only log. The tail of the log lives in memory, where records can be
+// updated in place, and is spilled to the log file once it fills up. Older
+// records are read back from the file.
+//
+// Unlike FASTER it serializes writers with a single lock and has no
+// checkpoints; with fsync the tail is written and synced on every write.
+type hlogStore struct {
+	mu    sync.RWMutex
+	path  string
+	fsync bool
+	f     *os.File
+	index map[string]int64
+	head  int64  // records below head are in the file
+	tail  int64  // address of the next record
+	buf   []byte // records in [head, tail)
+}
+
+func NewHybridLogStore(path string, fsync bool) (Store, error) {
+	if path == ":memory:" {
+		return nil, ErrMemoryNotAllowed
+	}
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0666)
+	if err != nil {
+		return nil, err
+	}
+	s := &hlogStore{
+		path:  path,
+		fsync: fsync,
+		f:     f,
+		index: make(map[string]int64),
+	}
+	if err := s.recover(); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// recover rebuilds the index from the log file, dropping a partially written
+// record at the end.
+func (s *hlogStore) recover() error {
+	rd := bufio.NewReader(s.f)
+	var addr int64
+	hdr := make([]byte, hlogHeaderSize)
+	for {
+		if _, err := io.ReadFull(rd, hdr); err != nil {
+			break
+		}
+		klen := binary.BigEndian.Uint32(hdr[0:])
+		vlen := binary.BigEndian.Uint32(hdr[4:])
+		key := make([]byte, klen)
+		if _, err := io.ReadFull(rd, key); err != nil {
+			break
+		}
+		if _, err := rd.Discard(int(vlen)); err != nil {
+			break
+		}
+		if hdr[8] == hlogDel {
+			delete(s.index, string(key))
+		} else {
+			s.index[string(key)] = addr
+		}
+		addr += hlogHeaderSize + int64(klen) + int64(vlen)
+	}
+	if err := s.f.Truncate(addr); err != nil {
+		return err
+	}
+	s.head, s.tail = addr, addr
+	return nil
+}
+
+// appendRecord adds a record to the mutable region, spilling the region to
+// the file first if the record does not fit.
+func (s *hlogStore) appendRecord(flag byte, key, value []byte) (int64, error) {
+	size := hlogHeaderSize + len(key) + len(value)
+	if len(s.buf) > 0 && len(s.buf)+size > hlogMemSize {
+		if err := s.flush(); err != nil {
+			return 0, err
+		}
+	}
+	var hdr [hlogHeaderSize]byte
+	binary.BigEndian.PutUint32(hdr[0:], uint32(len(key)))
+	binary.BigEndian.PutUint32(hdr[4:], uint32(len(value)))
+	hdr[8] = flag
+	s.buf = append(s.buf, hdr[:]...)
+	s.buf = append(s.buf, key...)
+	s.buf = append(s.buf, value...)
+	addr := s.tail
+	s.tail += int64(size)
+	return addr, nil
+}
+
+// flush writes the mutable region to the file.
+func (s *hlogStore) flush() error {
+	if len(s.buf) == 0 {
+		return nil
+	}
+	if _, err := s.f.WriteAt(s.buf, s.head); err != nil {
+		return err
+	}
+	s.head = s.tail
+	s.buf = s.buf[:0]
+	return nil
+}
+
+// commit makes the records appended so far durable when fsync is enabled.
+func (s *hlogStore) commit() error {
+	if !s.fsync {
+		return nil
+	}
+	if err := s.flush(); err != nil {
+		return err
+	}
+	return s.f.Sync()
+}
+
+func (s *hlogStore) set(key, value []byte) error {
+	if addr, ok := s.index[string(key)]; ok && addr >= s.head {
+		// in-place update in the mutable region
+		rec := s.buf[addr-s.head:]
+		klen := binary.BigEndian.Uint32(rec[0:])
+		vlen := binary.BigEndian.Uint32(rec[4:])
+		if int(vlen) == len(value) {
+			copy(rec[hlogHeaderSize+klen:], value)
+			return nil
+		}
+	}
+	addr, err := s.appendRecord(hlogSet, key, value)
+	if err != nil {
+		return err
+	}
+	s.index[string(key)] = addr
+	return nil
+}
+
+// read returns a copy of the value of the record at addr.
+func (s *hlogStore) read(addr int64) ([]byte, error) {
+	if addr >= s.head {
+		rec := s.buf[addr-s.head:]
+		klen := binary.BigEndian.Uint32(rec[0:])
+		vlen := binary.BigEndian.Uint32(rec[4:])
+		off := hlogHeaderSize + klen
+		return bcopy(rec[off : off+vlen]), nil
+	}
+	var hdr [hlogHeaderSize]byte
+	if _, err := s.f.ReadAt(hdr[:], addr); err != nil {
+		return nil, err
+	}
+	klen := binary.BigEndian.Uint32(hdr[0:])
+	vlen := binary.BigEndian.Uint32(hdr[4:])
+	v := make([]byte, vlen)
+	if _, err := s.f.ReadAt(v, addr+hlogHeaderSize+int64(klen)); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+func (s *hlogStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.flush(); err != nil {
+		return err
+	}
+	return s.f.Close()
+}
+
+func (s *hlogStore) PSet(keys, values [][]byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := range keys {
+		if err := s.set(keys[i], values[i]); err != nil {
+			return err
+		}
+	}
+	return s.commit()
+}
+
+func (s *hlogStore) PGet(keys [][]byte) ([][]byte, []bool, error) {
+	var values [][]byte
+	var oks []bool
+	for i := range keys {
+		value, ok, err := s.Get(keys[i])
+		if err != nil {
+			return nil, nil, err
+		}
+		values = append(values, value)
+		oks = append(oks, ok)
+	}
+	return values, oks, nil
+}
+
+func (s *hlogStore) Set(key, value []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.set(key, value); err != nil {
+		return err
+	}
+	return s.commit()
+}
+
+func (s *hlogStore) SetAsync(key, value []byte, cb func(error)) {
+	cb(s.Set(key, value))
+}
+
+func (s *hlogStore) Get(key []byte) ([]byte, bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	addr, ok := s.index[string(key)]
+	if !ok {
+		return nil, false, nil
+	}
+	v, err := s.read(addr)
+	if err != nil {
+		return nil, false, err
+	}
+	return v, true, nil
+}
+
+// Has only looks at the index, without reading the log.
+func (s *hlogStore) Has(key []byte) (bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	_, ok := s.index[string(key)]
+	return ok, nil
+}
+
+func (s *hlogStore) Del(key []byte) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.index[string(key)]; !ok {
+		return false, nil
+	}
+	if _, err := s.appendRecord(hlogDel, key, nil); err != nil {
+		return false, err
+	}
+	delete(s.index, string(key))
+	return true, s.commit()
+}
+
+// PDel appends the delete records of the present keys and commits once.
+func (s *hlogStore) PDel(keys [][]byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, k := range keys {
+		if _, ok := s.index[string(k)]; !ok {
+			continue
+		}
+		if _, err := s.appendRecord(hlogDel, k, nil); err != nil {
+			return err
+		}
+		delete(s.index, string(k))
+	}
+	return s.commit()
+}
+
+// Keys walks the whole hash index, so it costs the same for every prefix.
+func (s *hlogStore) Keys(pattern []byte, limit int, withvalues bool) ([][]byte, [][]byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var keys [][]byte
+	var vals [][]byte
+	for key, addr := range s.index {
+		if limit > 0 && len(keys) >= limit {
+			break
+		}
+		if !bytes.HasPrefix([]byte(key), pattern) {
+			continue
+		}
+		keys = append(keys, []byte(key))
+		if withvalues {
+			v, err := s.read(addr)
+			if err != nil {
+				return nil, nil, err
+			}
+			vals = append(vals, v)
+		}
+	}
+	return keys, vals, nil
+}
+
+func (s *hlogStore) FlushDB() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.f.Truncate(0); err != nil {
+		return err
+	}
+	s.index = make(map[string]int64)
+	s.head, s.tail = 0, 0
+	s.buf = s.buf[:0]
+	return nil
+}
+
+// Compact rewrites the live records into a new log file and swaps it in.
+func (s *hlogStore) Compact() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tmp := s.path + ".compact"
+	f, err := os.OpenFile(tmp, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	index := make(map[string]int64, len(s.index))
+	var addr int64
+	var hdr [hlogHeaderSize]byte
+	for key, old := range s.index {
+		v, err := s.read(old)
+		if err != nil {
+			f.Close()
+			os.Remove(tmp)
+			return err
+		}
+		binary.BigEndian.PutUint32(hdr[0:], uint32(len(key)))
+		binary.BigEndian.PutUint32(hdr[4:], uint32(len(v)))
+		hdr[8] = hlogSet
+		w.Write(hdr[:])
+		w.WriteString(key)
+		w.Write(v)
+		index[key] = addr
+		addr += hlogHeaderSize + int64(len(key)) + int64(len(v))
+	}
+	err = w.Flush()
+	if err == nil {
+		err = f.Sync()
+	}
+	if err == nil {
+		err = os.Rename(tmp, s.path)
+	}
+	if err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	s.f.Close()
+	s.f = f
+	s.index = index
+	s.head, s.tail = addr, addr
+	s.buf = s.buf[:0]
+	return nil
+}
+
+func (s *hlogStore) AllKeys(limit int, withvalues bool) ([][]byte, [][]byte, error) {
+	return s.Keys(nil, limit, withvalues)
+}
+
+func (s *hlogStore) RangeScan(start, end []byte, limit int, withvalues bool) ([][]byte, [][]byte, error) {
+	return nil, nil, ErrNotSupported
+}
+
+func (s *hlogStore) Snapshot() (Snapshot, error) {
+	return nil, ErrNotSupported
+}
+
+func (s *hlogStore) Count() (int64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return int64(len(s.index)), nil
+}
+
+func (s *hlogStore) Merge(key, value []byte) error {
+	return mergeWith(s.update, key, value)
+}
+
+// update reads key and appends its new value under the write lock.
+func (s *hlogStore) update(key []byte, fn updateFunc) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var old []byte
+	addr, ok := s.index[string(key)]
+	if ok {
+		var err error
+		if old, err = s.read(addr); err != nil {
+			return err
+		}
+	}
+	v, err := fn(old, ok)
+	if err != nil || v == nil {
+		return err
+	}
+	if err := s.set(key, v); err != nil {
+		return err
+	}
+	return s.commit()
+}
+
+func (s *hlogStore) Incr(key []byte, delta int64) (int64, error) {
+	return incrWith(s.update, key, delta)
+}
+
+func (s *hlogStore) CAS(key, oldValue, newValue []byte) (bool, error) {
+	return casWith(s.update, key, oldValue, newValue)
+}
+
+func (s *hlogStore) SetWithTTL(key, value []byte, ttl time.Duration) error {
+	return ErrNotSupported
+}
+
+func (s *hlogStore) Ping() error {
+	return nil
+}
+
+func (s *hlogStore) Capabilities() Capability {
+	return CapKeys | CapCompact | CapPersistent | CapCAS | CapMerge
+}
@@ -0,0 +1,312 @@
+package kvbench
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// jetStreamStore is a NATS JetStream key-value bucket. Keys are written in
+// hex, since a key must be a valid subject token, which keeps their order
+// and prefixes. The bucket keeps one revision per key in a file-backed
+// stream; whether the server syncs each write is its own sync_interval
+// setting, which the client cannot select, so there is no fsync.
+type jetStreamStore struct {
+	nc     *nats.Conn
+	js     nats.JetStreamContext
+	kv     nats.KeyValue
+	bucket string
+}
+
+// NewJetStreamKVStore connects to the NATS server at url and opens bucket,
+// creating it if needed.
+func NewJetStreamKVStore(url, bucket string) (Store, error) {
+	nc, err := nats.Connect(url)
+	if err != nil {
+		return nil, err
+	}
+	js, err := nc.JetStream()
+	if err != nil {
+		nc.Close()
+		return nil, err
+	}
+	s := &jetStreamStore{nc: nc, js: js, bucket: bucket}
+	if err := s.open(); err != nil {
+		nc.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *jetStreamStore) open() error {
+	kv, err := s.js.KeyValue(s.bucket)
+	if errors.Is(err, nats.ErrBucketNotFound) {
+		kv, err = s.js.CreateKeyValue(&nats.KeyValueConfig{
+			Bucket:  s.bucket,
+			History: 1,
+			Storage: nats.FileStorage,
+		})
+	}
+	if err != nil {
+		return err
+	}
+	s.kv = kv
+	return nil
+}
+
+func jetStreamKey(key []byte) string {
+	return hex.EncodeToString(key)
+}
+
+func (s *jetStreamStore) Close() error {
+	s.nc.Close()
+	return nil
+}
+
+func (s *jetStreamStore) Set(key, value []byte) error {
+	_, err := s.kv.Put(jetStreamKey(key), value)
+	return err
+}
+
+func (s *jetStreamStore) SetAsync(key, value []byte, cb func(error)) {
+	cb(s.Set(key, value))
+}
+
+// PSet publishes every entry to the subject of its key without waiting,
+// then waits for all the acknowledgements.
+func (s *jetStreamStore) PSet(keys, values [][]byte) error {
+	futures := make([]nats.PubAckFuture, len(keys))
+	for i := range keys {
+		f, err := s.js.PublishAsync("$KV."+s.bucket+"."+jetStreamKey(keys[i]), values[i])
+		if err != nil {
+			return err
+		}
+		futures[i] = f
+	}
+	for _, f := range futures {
+		select {
+		case <-f.Ok():
+		case err := <-f.Err():
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *jetStreamStore) Get(key []byte) ([]byte, bool, error) {
+	e, err := s.kv.Get(jetStreamKey(key))
+	if errors.Is(err, nats.ErrKeyNotFound) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return emptyIfNil(e.Value()), true, nil
+}
+
+func (s *jetStreamStore) Has(key []byte) (bool, error) {
+	_, ok, err := s.Get(key)
+	return ok, err
+}
+
+func (s *jetStreamStore) PGet(keys [][]byte) ([][]byte, []bool, error) {
+	vals := make([][]byte, len(keys))
+	oks := make([]bool, len(keys))
+	for i, k := range keys {
+		v, ok, err := s.Get(k)
+		if err != nil {
+			return nil, nil, err
+		}
+		vals[i], oks[i] = v, ok
+	}
+	return vals, oks, nil
+}
+
+// Del reads the key before deleting it, to report whether it was present.
+// The delete leaves a marker in the stream until Compact.
+func (s *jetStreamStore) Del(key []byte) (bool, error) {
+	_, ok, err := s.Get(key)
+	if err != nil || !ok {
+		return false, err
+	}
+	return true, s.kv.Delete(jetStreamKey(key))
+}
+
+func (s *jetStreamStore) PDel(keys [][]byte) error {
+	for _, k := range keys {
+		if err := s.kv.Delete(jetStreamKey(k)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// keys returns every key of the bucket in order. The bucket only lists all
+// of its keys, by reading the last message of every subject.
+func (s *jetStreamStore) keys() ([][]byte, error) {
+	names, err := s.kv.Keys()
+	if errors.Is(err, nats.ErrNoKeysFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(names)
+	keys := make([][]byte, 0, len(names))
+	for _, name := range names {
+		k, err := hex.DecodeString(name)
+		if err != nil {
+			// not written by this store
+			continue
+		}
+		keys = append(keys, k)
+	}
+	return keys, nil
+}
+
+// Keys lists every key and keeps those with the prefix pattern.
+func (s *jetStreamStore) Keys(pattern []byte, limit int, withvals bool) ([][]byte, [][]byte, error) {
+	return s.scan(func(k []byte) (bool, bool) {
+		ok := bytes.HasPrefix(k, pattern)
+		return ok, !ok && bytes.Compare(k, pattern) > 0
+	}, limit, withvals)
+}
+
+func (s *jetStreamStore) AllKeys(limit int, withvals bool) ([][]byte, [][]byte, error) {
+	return s.Keys(nil, limit, withvals)
+}
+
+func (s *jetStreamStore) RangeScan(start, end []byte, limit int, withvals bool) ([][]byte, [][]byte, error) {
+	return s.scan(func(k []byte) (bool, bool) {
+		past := end != nil && bytes.Compare(k, end) >= 0
+		return !past && bytes.Compare(k, start) >= 0, past
+	}, limit, withvals)
+}
+
+// scan lists the keys in order, keeping those match accepts until it
+// reports that the rest are past the range, and reads their values.
+func (s *jetStreamStore) scan(match func(k []byte) (ok, done bool), limit int, withvals bool) ([][]byte, [][]byte, error) {
+	all, err := s.keys()
+	if err != nil {
+		return nil, nil, err
+	}
+	var keys [][]byte
+	var vals [][]byte
+	for _, k := range all {
+		if limit > 0 && len(keys) >= limit {
+			break
+		}
+		ok, done := match(k)
+		if done {
+			break
+		}
+		if !ok {
+			continue
+		}
+		if withvals {
+			v, found, err := s.Get(k)
+			if err != nil {
+				return nil, nil, err
+			}
+			if !found {
+				// deleted since it was listed
+				continue
+			}
+			vals = append(vals, v)
+		}
+		keys = append(keys, k)
+	}
+	return keys, vals, nil
+}
+
+func (s *jetStreamStore) Snapshot() (Snapshot, error) {
+	return nil, ErrNotSupported
+}
+
+// Count lists every key.
+func (s *jetStreamStore) Count() (int64, error) {
+	keys, err := s.keys()
+	return int64(len(keys)), err
+}
+
+// FlushDB deletes the bucket and creates it again.
+func (s *jetStreamStore) FlushDB() error {
+	if err := s.js.DeleteKeyValue(s.bucket); err != nil && !errors.Is(err, nats.ErrBucketNotFound) {
+		return err
+	}
+	return s.open()
+}
+
+// Compact purges the markers left by deletes.
+func (s *jetStreamStore) Compact() error {
+	return s.kv.PurgeDeletes()
+}
+
+func (s *jetStreamStore) Merge(key, value []byte) error {
+	return mergeWith(s.update, key, value)
+}
+
+// update writes key only if it is still at the revision read, with Create
+// for a missing key, and reads it again when another write came first.
+func (s *jetStreamStore) update(key []byte, fn updateFunc) error {
+	name := jetStreamKey(key)
+	for {
+		var old []byte
+		var rev uint64
+		e, err := s.kv.Get(name)
+		if err != nil && !errors.Is(err, nats.ErrKeyNotFound) {
+			return err
+		}
+		ok := err == nil
+		if ok {
+			old, rev = emptyIfNil(e.Value()), e.Revision()
+		}
+		v, err := fn(old, ok)
+		if err != nil || v == nil {
+			return err
+		}
+		if ok {
+			_, err = s.kv.Update(name, v, rev)
+		} else {
+			_, err = s.kv.Create(name, v)
+		}
+		if !jetStreamConflict(err) {
+			return err
+		}
+	}
+}
+
+// jetStreamConflict reports whether err is a write rejected because the key
+// was not at the expected revision.
+func jetStreamConflict(err error) bool {
+	var apiErr *nats.APIError
+	return errors.Is(err, nats.ErrKeyExists) ||
+		errors.As(err, &apiErr) && apiErr.ErrorCode == nats.JSErrCodeStreamWrongLastSequence
+}
+
+func (s *jetStreamStore) Incr(key []byte, delta int64) (int64, error) {
+	return incrWith(s.update, key, delta)
+}
+
+func (s *jetStreamStore) CAS(key, oldValue, newValue []byte) (bool, error) {
+	return casWith(s.update, key, oldValue, newValue)
+}
+
+// SetWithTTL is not supported: a bucket has one TTL for all of its keys.
+func (s *jetStreamStore) SetWithTTL(key, value []byte, ttl time.Duration) error {
+	return ErrNotSupported
+}
+
+// Ping measures a round trip to the server.
+func (s *jetStreamStore) Ping() error {
+	_, err := s.nc.RTT()
+	return err
+}
+
+func (s *jetStreamStore) Capabilities() Capability {
+	return CapKeys | CapOrdered | CapCompact | CapPersistent | CapCAS | CapMerge
+}
@@ -0,0 +1,9 @@
+// Package kvpb holds the gRPC service used by the grpc store to benchmark KV
+// engines running in another process, possibly written in another language.
+// See kv.proto; kvbench -grpc serves any of the Go stores over it.
+package kvpb
+
+// The file is registered as kvpb/kv.proto rather than kv.proto, the name of
+// the etcd file that the tikv store links in too.
+//
+//go:generate protoc -I .. --go_out=.. --go_opt=paths=source_relative --go-grpc_out=.. --go-grpc_opt=paths=source_relative kvpb/kv.proto
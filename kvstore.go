@@ -1,8 +1,10 @@
 package kvbench
 
 import (
+	"io"
 	"os"
 	"sync"
+	"time"
 
 	"github.com/cznic/kv"
 )
@@ -42,9 +44,10 @@ func (s *kvStore) PSet(keys, values [][]byte) error {
 	if err := s.db.BeginTransaction(); err != nil {
 		return err
 	}
-	defer s.db.Rollback()
+	// a Rollback after the Commit would undo it
 	for i := range keys {
 		if err := s.db.Set(keys[i], values[i]); err != nil {
+			s.db.Rollback()
 			return err
 		}
 	}
@@ -81,6 +84,13 @@ func (s *kvStore) Get(key []byte) ([]byte, bool, error) {
 	return v, v != nil, nil
 }
 
+func (s *kvStore) Has(key []byte) (bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	v, err := s.db.Get(nil, key)
+	return v != nil, err
+}
+
 func (s *kvStore) Del(key []byte) (bool, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
@@ -98,6 +108,21 @@ func (s *kvStore) Del(key []byte) (bool, error) {
 	return true, nil
 }
 
+func (s *kvStore) PDel(keys [][]byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.db.BeginTransaction(); err != nil {
+		return err
+	}
+	for _, k := range keys {
+		if err := s.db.Delete(k); err != nil {
+			s.db.Rollback()
+			return err
+		}
+	}
+	return s.db.Commit()
+}
+
 func (s *kvStore) Keys(pattern []byte, limit int, withvalues bool) ([][]byte, [][]byte, error) {
 	return nil, nil, nil
 	/*
@@ -137,6 +162,65 @@ func (s *kvStore) Keys(pattern []byte, limit int, withvalues bool) ([][]byte, []
 	*/
 }
 
+func (s *kvStore) AllKeys(limit int, withvalues bool) ([][]byte, [][]byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var keys [][]byte
+	var vals [][]byte
+	enum, err := s.db.SeekFirst()
+	if err == io.EOF {
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	for limit <= 0 || len(keys) < limit {
+		key, value, err := enum.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		keys = append(keys, key)
+		if withvalues {
+			vals = append(vals, value)
+		}
+	}
+	return keys, vals, nil
+}
+
+func (s *kvStore) RangeScan(start, end []byte, limit int, withvalues bool) ([][]byte, [][]byte, error) {
+	return nil, nil, ErrNotSupported
+}
+
+func (s *kvStore) Snapshot() (Snapshot, error) {
+	return nil, ErrNotSupported
+}
+
+func (s *kvStore) Count() (int64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	enum, err := s.db.SeekFirst()
+	if err == io.EOF {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	var n int64
+	for {
+		_, _, err := enum.Next()
+		if err == io.EOF {
+			return n, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+		n++
+	}
+}
+
 func (s *kvStore) FlushDB() error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
@@ -150,3 +234,50 @@ func (s *kvStore) FlushDB() error {
 	s.db = db
 	return nil
 }
+
+func (s *kvStore) Compact() error {
+	return ErrNotSupported
+}
+
+func (s *kvStore) SetAsync(key, value []byte, cb func(error)) {
+	cb(s.Set(key, value))
+}
+
+func (s *kvStore) Merge(key, value []byte) error {
+	return mergeWith(s.update, key, value)
+}
+
+// update reads and writes key under the write lock.
+func (s *kvStore) update(key []byte, fn updateFunc) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	old, err := s.db.Get(nil, key)
+	if err != nil {
+		return err
+	}
+	v, err := fn(old, old != nil)
+	if err != nil || v == nil {
+		return err
+	}
+	return s.db.Set(key, v)
+}
+
+func (s *kvStore) Incr(key []byte, delta int64) (int64, error) {
+	return incrWith(s.update, key, delta)
+}
+
+func (s *kvStore) CAS(key, oldValue, newValue []byte) (bool, error) {
+	return casWith(s.update, key, oldValue, newValue)
+}
+
+func (s *kvStore) SetWithTTL(key, value []byte, ttl time.Duration) error {
+	return ErrNotSupported
+}
+
+func (s *kvStore) Ping() error {
+	return nil
+}
+
+func (s *kvStore) Capabilities() Capability {
+	return CapPersistent | CapTransactions | CapCAS | CapMerge
+}
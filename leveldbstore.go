@@ -1,27 +1,72 @@
 package kvbench
 
 import (
-	"github.com/syndtr/goleveldb/leveldb/util"
+	"fmt"
 	"os"
 	"sync"
+	"time"
 
 	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/filter"
+	"github.com/syndtr/goleveldb/leveldb/iterator"
 	"github.com/syndtr/goleveldb/leveldb/opt"
+	"github.com/syndtr/goleveldb/leveldb/util"
 )
 
+// leveldbStore guards db with mu: every operation holds the read lock while
+// it uses db, and FlushDB, which closes db and opens a new one, holds the
+// write lock, so no operation sees a closed or replaced db half way.
 type leveldbStore struct {
 	mu    sync.RWMutex
 	db    *leveldb.DB
 	path  string
 	fsync bool
+	opts  *opt.Options
 	wo    *opt.WriteOptions
 }
 
+// LevelDBOptions tunes a leveldb store. Zero fields keep the goleveldb
+// defaults.
+type LevelDBOptions struct {
+	// BloomBits is the bits per key of a bloom filter, 0 for no filter.
+	BloomBits int
+	// CompactionTableSize is the size of the sorted tables at level 0, in
+	// bytes. Tables at higher levels are multiples of it.
+	CompactionTableSize int
+	// WriteBuffer is the size of the memtable in bytes.
+	WriteBuffer int
+}
+
+// String returns the effective settings, with defaults filled in.
+func (o LevelDBOptions) String() string {
+	table, buffer := o.CompactionTableSize, o.WriteBuffer
+	if table <= 0 {
+		table = opt.DefaultCompactionTableSize
+	}
+	if buffer <= 0 {
+		buffer = opt.DefaultWriteBuffer
+	}
+	return fmt.Sprintf("bloombits=%d compactiontablesize=%dMiB writebuffer=%dMiB",
+		o.BloomBits, table/opt.MiB, buffer/opt.MiB)
+}
+
 func NewLevelDBStore(path string, fsync bool) (Store, error) {
+	return NewLevelDBStoreWithOptions(path, fsync, LevelDBOptions{})
+}
+
+// NewLevelDBStoreWithOptions is NewLevelDBStore with tuning options.
+func NewLevelDBStoreWithOptions(path string, fsync bool, o LevelDBOptions) (Store, error) {
 	if path == ":memory:" {
 		return nil, ErrMemoryNotAllowed
 	}
-	opts := &opt.Options{NoSync: !fsync}
+	opts := &opt.Options{
+		NoSync:              !fsync,
+		CompactionTableSize: o.CompactionTableSize,
+		WriteBuffer:         o.WriteBuffer,
+	}
+	if o.BloomBits > 0 {
+		opts.Filter = filter.NewBloomFilter(o.BloomBits)
+	}
 	db, err := leveldb.OpenFile(path, opts)
 	if err != nil {
 		return nil, err
@@ -30,6 +75,7 @@ func NewLevelDBStore(path string, fsync bool) (Store, error) {
 		db:    db,
 		path:  path,
 		fsync: fsync,
+		opts:  opts,
 		wo:    &opt.WriteOptions{Sync: fsync},
 	}, nil
 }
@@ -83,6 +129,12 @@ func (s *leveldbStore) Get(key []byte) ([]byte, bool, error) {
 	return v, true, nil
 }
 
+func (s *leveldbStore) Has(key []byte) (bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.db.Has(key, nil)
+}
+
 func (s *leveldbStore) Del(key []byte) (bool, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
@@ -97,36 +149,196 @@ func (s *leveldbStore) Del(key []byte) (bool, error) {
 	return true, nil
 }
 
+func (s *leveldbStore) PDel(keys [][]byte) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	batch := new(leveldb.Batch)
+	for _, k := range keys {
+		batch.Delete(k)
+	}
+	return s.db.Write(batch, s.wo)
+}
+
 func (s *leveldbStore) Keys(pattern []byte, limit int, withvalues bool) ([][]byte, [][]byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var keys [][]byte
+	var vals [][]byte
+	iter := s.db.NewIterator(util.BytesPrefix(pattern), nil)
+	defer iter.Release()
+	// the iterator reuses its buffers, so keys and values are copied
+	for iter.Next() {
+		if limit > 0 && len(keys) >= limit {
+			break
+		}
+		keys = append(keys, bcopy(iter.Key()))
+		if withvalues {
+			vals = append(vals, bcopy(iter.Value()))
+		}
+	}
+	return keys, vals, iter.Error()
+}
+
+func (s *leveldbStore) AllKeys(limit int, withvalues bool) ([][]byte, [][]byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 	var keys [][]byte
 	var vals [][]byte
-	iter := s.db.NewIterator(util.BytesPrefix([]byte("foo-")), nil)
+	iter := s.db.NewIterator(nil, nil)
+	defer iter.Release()
 	for iter.Next() {
-		key := iter.Key()
-		keys = append(keys, key)
+		if limit > 0 && len(keys) >= limit {
+			break
+		}
+		keys = append(keys, bcopy(iter.Key()))
 		if withvalues {
-			value := iter.Value()
-			vals = append(vals, value)
+			vals = append(vals, bcopy(iter.Value()))
 		}
 	}
-	iter.Release()
-	return keys, vals, nil
+	return keys, vals, iter.Error()
+}
+
+// RangeScan iterates a util.Range, whose nil Limit has no upper bound.
+func (s *leveldbStore) RangeScan(start, end []byte, limit int, withvalues bool) ([][]byte, [][]byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return leveldbRangeScan(s.db, start, end, limit, withvalues)
+}
+
+// leveldbReader is a *leveldb.DB or a *leveldb.Snapshot.
+type leveldbReader interface {
+	NewIterator(slice *util.Range, ro *opt.ReadOptions) iterator.Iterator
+}
+
+// leveldbRangeScan scans the DB or a snapshot.
+func leveldbRangeScan(r leveldbReader, start, end []byte, limit int, withvalues bool) ([][]byte, [][]byte, error) {
+	var keys [][]byte
+	var vals [][]byte
+	iter := r.NewIterator(&util.Range{Start: start, Limit: end}, nil)
+	defer iter.Release()
+	for iter.Next() {
+		if limit > 0 && len(keys) >= limit {
+			break
+		}
+		keys = append(keys, bcopy(iter.Key()))
+		if withvalues {
+			vals = append(vals, bcopy(iter.Value()))
+		}
+	}
+	return keys, vals, iter.Error()
+}
+
+func (s *leveldbStore) Count() (int64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var n int64
+	iter := s.db.NewIterator(nil, nil)
+	defer iter.Release()
+	for iter.Next() {
+		n++
+	}
+	return n, iter.Error()
 }
 
 func (s *leveldbStore) FlushDB() error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	s.db.Close()
-	os.RemoveAll(s.path)
-	s.db = nil
-	var opts *opt.Options
-	if !s.fsync {
-		opts = &opt.Options{NoSync: !s.fsync}
+	if err := os.RemoveAll(s.path); err != nil {
+		return err
 	}
-	db, err := leveldb.OpenFile(s.path, opts)
+	// on failure s.db stays the closed db, which fails every operation with
+	// leveldb.ErrClosed
+	db, err := leveldb.OpenFile(s.path, s.opts)
 	if err != nil {
 		return err
 	}
 	s.db = db
 	return nil
 }
+
+func (s *leveldbStore) Compact() error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.db.CompactRange(util.Range{})
+}
+
+func (s *leveldbStore) SetAsync(key, value []byte, cb func(error)) {
+	cb(s.Set(key, value))
+}
+
+func (s *leveldbStore) Merge(key, value []byte) error {
+	return mergeWith(s.update, key, value)
+}
+
+// update reads and writes key under the write lock of mu, which the other
+// operations wait for.
+func (s *leveldbStore) update(key []byte, fn updateFunc) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	old, err := s.db.Get(key, nil)
+	if err != nil && err != leveldb.ErrNotFound {
+		return err
+	}
+	v, err := fn(old, err == nil)
+	if err != nil || v == nil {
+		return err
+	}
+	return s.db.Put(key, v, s.wo)
+}
+
+func (s *leveldbStore) Incr(key []byte, delta int64) (int64, error) {
+	return incrWith(s.update, key, delta)
+}
+
+func (s *leveldbStore) CAS(key, oldValue, newValue []byte) (bool, error) {
+	return casWith(s.update, key, oldValue, newValue)
+}
+
+func (s *leveldbStore) SetWithTTL(key, value []byte, ttl time.Duration) error {
+	return ErrNotSupported
+}
+
+func (s *leveldbStore) Ping() error {
+	return nil
+}
+
+// leveldbSnapshot reads a *leveldb.Snapshot, which is safe for concurrent
+// use. FlushDB closes the db under it, which fails its reads.
+type leveldbSnapshot struct {
+	snap *leveldb.Snapshot
+}
+
+func (s *leveldbStore) Snapshot() (Snapshot, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	snap, err := s.db.GetSnapshot()
+	if err != nil {
+		return nil, err
+	}
+	return leveldbSnapshot{snap}, nil
+}
+
+func (s leveldbSnapshot) Get(key []byte) ([]byte, bool, error) {
+	v, err := s.snap.Get(key, nil)
+	if err == leveldb.ErrNotFound {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return v, true, nil
+}
+
+func (s leveldbSnapshot) RangeScan(start, end []byte, limit int, withvalues bool) ([][]byte, [][]byte, error) {
+	return leveldbRangeScan(s.snap, start, end, limit, withvalues)
+}
+
+func (s leveldbSnapshot) Close() error {
+	s.snap.Release()
+	return nil
+}
+
+func (s *leveldbStore) Capabilities() Capability {
+	return CapKeys | CapOrdered | CapCompact | CapPersistent | CapTransactions | CapSnapshots | CapCAS | CapMerge
+}
@@ -0,0 +1,231 @@
+package kvbench
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// lruStore is a memory-only LRU cache of a fixed number of entries, the
+// classic container/list and map design. It is a baseline for cache
+// replacement rather than a database: Set evicts the least recently used
+// entry once the store is full.
+type lruStore struct {
+	mu       sync.Mutex
+	capacity int
+	ll       *list.List // front is the most recently used
+	items    map[string]*list.Element
+
+	hits, misses, evictions int64
+}
+
+type lruEntry struct {
+	key   string
+	value []byte
+}
+
+// NewLRUStore returns an LRU cache holding at most capacity entries.
+func NewLRUStore(capacity int) (Store, error) {
+	if capacity < 1 {
+		capacity = 1
+	}
+	return &lruStore{
+		capacity: capacity,
+		ll:       list.New(),
+		items:    make(map[string]*list.Element),
+	}, nil
+}
+
+func (s *lruStore) Close() error {
+	return nil
+}
+
+// set must be called with s.mu held.
+func (s *lruStore) set(key, value []byte) {
+	if e, ok := s.items[string(key)]; ok {
+		e.Value.(*lruEntry).value = bcopy(value)
+		s.ll.MoveToFront(e)
+		return
+	}
+	s.items[string(key)] = s.ll.PushFront(&lruEntry{key: string(key), value: bcopy(value)})
+	if s.ll.Len() > s.capacity {
+		oldest := s.ll.Back()
+		s.ll.Remove(oldest)
+		delete(s.items, oldest.Value.(*lruEntry).key)
+		s.evictions++
+	}
+}
+
+func (s *lruStore) PSet(keys, values [][]byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := range keys {
+		s.set(keys[i], values[i])
+	}
+	return nil
+}
+
+func (s *lruStore) PGet(keys [][]byte) ([][]byte, []bool, error) {
+	var values [][]byte
+	var oks []bool
+	for i := range keys {
+		v, ok, _ := s.Get(keys[i])
+		values = append(values, v)
+		oks = append(oks, ok)
+	}
+	return values, oks, nil
+}
+
+func (s *lruStore) Set(key, value []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.set(key, value)
+	return nil
+}
+
+func (s *lruStore) Get(key []byte) ([]byte, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.items[string(key)]
+	if !ok {
+		s.misses++
+		return nil, false, nil
+	}
+	s.hits++
+	s.ll.MoveToFront(e)
+	return e.Value.(*lruEntry).value, true, nil
+}
+
+// Has neither counts as a hit or miss nor refreshes the key.
+func (s *lruStore) Has(key []byte) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.items[string(key)]
+	return ok, nil
+}
+
+func (s *lruStore) Del(key []byte) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.items[string(key)]
+	if ok {
+		s.ll.Remove(e)
+		delete(s.items, string(key))
+	}
+	return ok, nil
+}
+
+func (s *lruStore) PDel(keys [][]byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, k := range keys {
+		if e, ok := s.items[string(k)]; ok {
+			s.ll.Remove(e)
+			delete(s.items, string(k))
+		}
+	}
+	return nil
+}
+
+func (s *lruStore) Keys(pattern []byte, limit int, withvalues bool) ([][]byte, [][]byte, error) {
+	return nil, nil, ErrNotSupported
+}
+
+// AllKeys returns the keys from the most to the least recently used.
+func (s *lruStore) AllKeys(limit int, withvalues bool) ([][]byte, [][]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var keys [][]byte
+	var vals [][]byte
+	for e := s.ll.Front(); e != nil; e = e.Next() {
+		if limit > 0 && len(keys) >= limit {
+			break
+		}
+		entry := e.Value.(*lruEntry)
+		keys = append(keys, []byte(entry.key))
+		if withvalues {
+			vals = append(vals, bcopy(entry.value))
+		}
+	}
+	return keys, vals, nil
+}
+
+func (s *lruStore) RangeScan(start, end []byte, limit int, withvalues bool) ([][]byte, [][]byte, error) {
+	return nil, nil, ErrNotSupported
+}
+
+func (s *lruStore) Snapshot() (Snapshot, error) {
+	return nil, ErrNotSupported
+}
+
+func (s *lruStore) Count() (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return int64(s.ll.Len()), nil
+}
+
+func (s *lruStore) FlushDB() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.ll.Init()
+	s.items = make(map[string]*list.Element)
+	return nil
+}
+
+func (s *lruStore) Compact() error {
+	return ErrNotSupported
+}
+
+func (s *lruStore) SetAsync(key, value []byte, cb func(error)) {
+	cb(s.Set(key, value))
+}
+
+func (s *lruStore) Merge(key, value []byte) error {
+	return mergeWith(s.update, key, value)
+}
+
+// update reads and writes key under the lock. Like Has, it neither counts
+// as a hit or miss.
+func (s *lruStore) update(key []byte, fn updateFunc) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var old []byte
+	e, ok := s.items[string(key)]
+	if ok {
+		old = e.Value.(*lruEntry).value
+	}
+	v, err := fn(old, ok)
+	if err != nil || v == nil {
+		return err
+	}
+	s.set(key, v)
+	return nil
+}
+
+func (s *lruStore) Incr(key []byte, delta int64) (int64, error) {
+	return incrWith(s.update, key, delta)
+}
+
+func (s *lruStore) CAS(key, oldValue, newValue []byte) (bool, error) {
+	return casWith(s.update, key, oldValue, newValue)
+}
+
+func (s *lruStore) SetWithTTL(key, value []byte, ttl time.Duration) error {
+	return ErrNotSupported
+}
+
+func (s *lruStore) Ping() error {
+	return nil
+}
+
+func (s *lruStore) Capabilities() Capability {
+	return CapCAS | CapMerge
+}
+
+// CacheStats returns the Get hits and misses and the entries evicted since
+// the store was opened.
+func (s *lruStore) CacheStats() (hits, misses, evictions int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.hits, s.misses, s.evictions
+}
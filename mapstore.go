@@ -117,6 +117,13 @@ func (s *mapStore) Get(key []byte) ([]byte, bool, error) {
 	return v, ok, nil
 }
 
+func (s *mapStore) Has(key []byte) (bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	_, ok := s.keys[string(key)]
+	return ok, nil
+}
+
 func (s *mapStore) Del(key []byte) (bool, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
@@ -132,6 +139,26 @@ func (s *mapStore) Del(key []byte) (bool, error) {
 	return ok, nil
 }
 
+func (s *mapStore) PDel(keys [][]byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.aof != nil {
+		s.aof.BeginBuffer()
+		for i := range keys {
+			if _, ok := s.keys[string(keys[i])]; ok {
+				s.aof.AppendBuffer([]byte("del"), keys[i])
+			}
+		}
+		if err := s.aof.WriteBuffer(); err != nil {
+			return err
+		}
+	}
+	for i := range keys {
+		delete(s.keys, string(keys[i]))
+	}
+	return nil
+}
+
 func (s *mapStore) Keys(pattern []byte, limit int, withvalues bool) ([][]byte, [][]byte, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
@@ -152,6 +179,38 @@ func (s *mapStore) Keys(pattern []byte, limit int, withvalues bool) ([][]byte, [
 	return keys, vals, nil
 }
 
+// AllKeys returns the keys in map iteration order.
+func (s *mapStore) AllKeys(limit int, withvalues bool) ([][]byte, [][]byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var keys [][]byte
+	var vals [][]byte
+	for key, value := range s.keys {
+		if limit > 0 && len(keys) >= limit {
+			break
+		}
+		keys = append(keys, []byte(key))
+		if withvalues {
+			vals = append(vals, bcopy(value))
+		}
+	}
+	return keys, vals, nil
+}
+
+func (s *mapStore) RangeScan(start, end []byte, limit int, withvalues bool) ([][]byte, [][]byte, error) {
+	return nil, nil, ErrNotSupported
+}
+
+func (s *mapStore) Snapshot() (Snapshot, error) {
+	return nil, ErrNotSupported
+}
+
+func (s *mapStore) Count() (int64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return int64(len(s.keys)), nil
+}
+
 func (s *mapStore) FlushDB() error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
@@ -163,3 +222,56 @@ func (s *mapStore) FlushDB() error {
 	s.keys = make(map[string][]byte)
 	return nil
 }
+
+func (s *mapStore) Compact() error {
+	return ErrNotSupported
+}
+
+func (s *mapStore) SetAsync(key, value []byte, cb func(error)) {
+	cb(s.Set(key, value))
+}
+
+func (s *mapStore) Merge(key, value []byte) error {
+	return mergeWith(s.update, key, value)
+}
+
+// update reads and writes key under the write lock.
+func (s *mapStore) update(key []byte, fn updateFunc) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	old, ok := s.keys[string(key)]
+	v, err := fn(old, ok)
+	if err != nil || v == nil {
+		return err
+	}
+	if s.aof != nil {
+		if err := s.aof.Write([]byte("set"), key, v); err != nil {
+			return err
+		}
+	}
+	s.keys[string(key)] = bcopy(v)
+	return nil
+}
+
+func (s *mapStore) Incr(key []byte, delta int64) (int64, error) {
+	return incrWith(s.update, key, delta)
+}
+
+func (s *mapStore) CAS(key, oldValue, newValue []byte) (bool, error) {
+	return casWith(s.update, key, oldValue, newValue)
+}
+
+func (s *mapStore) SetWithTTL(key, value []byte, ttl time.Duration) error {
+	return ErrNotSupported
+}
+
+func (s *mapStore) Ping() error {
+	return nil
+}
+
+func (s *mapStore) Capabilities() Capability {
+	if s.aof != nil {
+		return CapKeys | CapPersistent | CapCAS | CapMerge
+	}
+	return CapKeys | CapCAS | CapMerge
+}
@@ -0,0 +1,329 @@
+package kvbench
+
+import (
+	"bytes"
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/go-memdb"
+)
+
+const memdbTable = "kv"
+
+type memdbEntry struct {
+	key   []byte
+	value []byte
+}
+
+// memdbKeyIndex indexes entries by their byte key. go-memdb rejects empty
+// index values, so a zero byte is appended to every key; this keeps distinct
+// keys distinct and leaves prefixes untouched.
+type memdbKeyIndex struct{}
+
+func (memdbKeyIndex) FromObject(obj interface{}) (bool, []byte, error) {
+	return true, append(bcopy(obj.(*memdbEntry).key), 0), nil
+}
+
+func (memdbKeyIndex) FromArgs(args ...interface{}) ([]byte, error) {
+	key, err := memdbArg(args)
+	if err != nil {
+		return nil, err
+	}
+	return append(bcopy(key), 0), nil
+}
+
+func (memdbKeyIndex) PrefixFromArgs(args ...interface{}) ([]byte, error) {
+	return memdbArg(args)
+}
+
+func memdbArg(args []interface{}) ([]byte, error) {
+	if len(args) != 1 {
+		return nil, fmt.Errorf("must provide only a single argument")
+	}
+	key, ok := args[0].([]byte)
+	if !ok {
+		return nil, fmt.Errorf("argument must be a []byte: %#v", args[0])
+	}
+	return key, nil
+}
+
+var memdbSchema = &memdb.DBSchema{
+	Tables: map[string]*memdb.TableSchema{
+		memdbTable: {
+			Name: memdbTable,
+			Indexes: map[string]*memdb.IndexSchema{
+				"id": {
+					Name:    "id",
+					Unique:  true,
+					Indexer: memdbKeyIndex{},
+				},
+			},
+		},
+	},
+}
+
+// memdbStore keeps the entries in hashicorp/go-memdb, an MVCC database over
+// immutable radix trees. It has no persistence.
+type memdbStore struct {
+	db *memdb.MemDB
+}
+
+// NewMemdbStore only accepts ":memory:" as path.
+func NewMemdbStore(path string, fsync bool) (Store, error) {
+	if path != ":memory:" {
+		return nil, ErrDiskNotAllowed
+	}
+	db, err := memdb.NewMemDB(memdbSchema)
+	if err != nil {
+		return nil, err
+	}
+	return &memdbStore{db: db}, nil
+}
+
+func (s *memdbStore) Close() error {
+	return nil
+}
+
+func (s *memdbStore) PSet(keys, values [][]byte) error {
+	txn := s.db.Txn(true)
+	defer txn.Abort()
+	for i := range keys {
+		if err := txn.Insert(memdbTable, &memdbEntry{key: bcopy(keys[i]), value: bcopy(values[i])}); err != nil {
+			return err
+		}
+	}
+	txn.Commit()
+	return nil
+}
+
+func (s *memdbStore) PGet(keys [][]byte) ([][]byte, []bool, error) {
+	var values [][]byte
+	var oks []bool
+	txn := s.db.Txn(false)
+	for i := range keys {
+		raw, err := txn.First(memdbTable, "id", keys[i])
+		if err != nil {
+			return nil, nil, err
+		}
+		if raw == nil {
+			values = append(values, nil)
+			oks = append(oks, false)
+		} else {
+			values = append(values, bcopy(raw.(*memdbEntry).value))
+			oks = append(oks, true)
+		}
+	}
+	return values, oks, nil
+}
+
+func (s *memdbStore) Set(key, value []byte) error {
+	return s.PSet([][]byte{key}, [][]byte{value})
+}
+
+func (s *memdbStore) SetAsync(key, value []byte, cb func(error)) {
+	cb(s.Set(key, value))
+}
+
+func (s *memdbStore) Get(key []byte) ([]byte, bool, error) {
+	raw, err := s.db.Txn(false).First(memdbTable, "id", key)
+	if err != nil || raw == nil {
+		return nil, false, err
+	}
+	return bcopy(raw.(*memdbEntry).value), true, nil
+}
+
+func (s *memdbStore) Has(key []byte) (bool, error) {
+	raw, err := s.db.Txn(false).First(memdbTable, "id", key)
+	return raw != nil, err
+}
+
+func (s *memdbStore) Del(key []byte) (bool, error) {
+	txn := s.db.Txn(true)
+	defer txn.Abort()
+	raw, err := txn.First(memdbTable, "id", key)
+	if err != nil || raw == nil {
+		return false, err
+	}
+	if err := txn.Delete(memdbTable, raw); err != nil {
+		return false, err
+	}
+	txn.Commit()
+	return true, nil
+}
+
+func (s *memdbStore) PDel(keys [][]byte) error {
+	txn := s.db.Txn(true)
+	defer txn.Abort()
+	for _, k := range keys {
+		raw, err := txn.First(memdbTable, "id", k)
+		if err != nil {
+			return err
+		}
+		if raw == nil {
+			continue
+		}
+		if err := txn.Delete(memdbTable, raw); err != nil {
+			return err
+		}
+	}
+	txn.Commit()
+	return nil
+}
+
+// Keys walks the radix tree below pattern, taken as a prefix.
+func (s *memdbStore) Keys(pattern []byte, limit int, withvalues bool) ([][]byte, [][]byte, error) {
+	it, err := s.db.Txn(false).Get(memdbTable, "id_prefix", pattern)
+	if err != nil {
+		return nil, nil, err
+	}
+	var keys [][]byte
+	var vals [][]byte
+	for raw := it.Next(); raw != nil; raw = it.Next() {
+		if limit > 0 && len(keys) >= limit {
+			break
+		}
+		e := raw.(*memdbEntry)
+		if !bytes.HasPrefix(e.key, pattern) {
+			continue
+		}
+		keys = append(keys, bcopy(e.key))
+		if withvalues {
+			vals = append(vals, bcopy(e.value))
+		}
+	}
+	return keys, vals, nil
+}
+
+func (s *memdbStore) FlushDB() error {
+	txn := s.db.Txn(true)
+	defer txn.Abort()
+	if _, err := txn.DeleteAll(memdbTable, "id"); err != nil {
+		return err
+	}
+	txn.Commit()
+	return nil
+}
+
+func (s *memdbStore) Compact() error {
+	return ErrNotSupported
+}
+
+func (s *memdbStore) AllKeys(limit int, withvalues bool) ([][]byte, [][]byte, error) {
+	return s.Keys(nil, limit, withvalues)
+}
+
+// RangeScan iterates the id index from its lower bound start.
+func (s *memdbStore) RangeScan(start, end []byte, limit int, withvalues bool) ([][]byte, [][]byte, error) {
+	return memdbRangeScan(s.db.Txn(false), start, end, limit, withvalues)
+}
+
+// memdbRangeScan scans the table as of the read transaction txn.
+func memdbRangeScan(txn *memdb.Txn, start, end []byte, limit int, withvalues bool) ([][]byte, [][]byte, error) {
+	it, err := txn.LowerBound(memdbTable, "id", emptyIfNil(start))
+	if err != nil {
+		return nil, nil, err
+	}
+	var keys [][]byte
+	var vals [][]byte
+	for raw := it.Next(); raw != nil; raw = it.Next() {
+		if limit > 0 && len(keys) >= limit {
+			break
+		}
+		e := raw.(*memdbEntry)
+		if end != nil && bytes.Compare(e.key, end) >= 0 {
+			break
+		}
+		keys = append(keys, bcopy(e.key))
+		if withvalues {
+			vals = append(vals, bcopy(e.value))
+		}
+	}
+	return keys, vals, nil
+}
+
+func (s *memdbStore) Count() (int64, error) {
+	it, err := s.db.Txn(false).Get(memdbTable, "id")
+	if err != nil {
+		return 0, err
+	}
+	var n int64
+	for raw := it.Next(); raw != nil; raw = it.Next() {
+		n++
+	}
+	return n, nil
+}
+
+func (s *memdbStore) Merge(key, value []byte) error {
+	return mergeWith(s.update, key, value)
+}
+
+// update reads and writes key in one write transaction, which go-memdb runs
+// one at a time.
+func (s *memdbStore) update(key []byte, fn updateFunc) error {
+	txn := s.db.Txn(true)
+	defer txn.Abort()
+	raw, err := txn.First(memdbTable, "id", key)
+	if err != nil {
+		return err
+	}
+	var old []byte
+	if raw != nil {
+		old = raw.(*memdbEntry).value
+	}
+	v, err := fn(old, raw != nil)
+	if err != nil || v == nil {
+		return err
+	}
+	if err := txn.Insert(memdbTable, &memdbEntry{key: bcopy(key), value: bcopy(v)}); err != nil {
+		return err
+	}
+	txn.Commit()
+	return nil
+}
+
+func (s *memdbStore) Incr(key []byte, delta int64) (int64, error) {
+	return incrWith(s.update, key, delta)
+}
+
+func (s *memdbStore) CAS(key, oldValue, newValue []byte) (bool, error) {
+	return casWith(s.update, key, oldValue, newValue)
+}
+
+func (s *memdbStore) SetWithTTL(key, value []byte, ttl time.Duration) error {
+	return ErrNotSupported
+}
+
+func (s *memdbStore) Ping() error {
+	return nil
+}
+
+// memdbSnapshot is a read transaction, which holds on to the immutable radix
+// tree of its start.
+type memdbSnapshot struct {
+	txn *memdb.Txn
+}
+
+func (s *memdbStore) Snapshot() (Snapshot, error) {
+	return memdbSnapshot{s.db.Txn(false)}, nil
+}
+
+func (s memdbSnapshot) Get(key []byte) ([]byte, bool, error) {
+	raw, err := s.txn.First(memdbTable, "id", key)
+	if err != nil || raw == nil {
+		return nil, false, err
+	}
+	return bcopy(raw.(*memdbEntry).value), true, nil
+}
+
+func (s memdbSnapshot) RangeScan(start, end []byte, limit int, withvalues bool) ([][]byte, [][]byte, error) {
+	return memdbRangeScan(s.txn, start, end, limit, withvalues)
+}
+
+func (s memdbSnapshot) Close() error {
+	s.txn.Abort()
+	return nil
+}
+
+func (s *memdbStore) Capabilities() Capability {
+	return CapKeys | CapOrdered | CapTransactions | CapSnapshots | CapCAS | CapMerge
+}
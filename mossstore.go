@@ -0,0 +1,348 @@
+package kvbench
+
+import (
+	"bytes"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/couchbase/moss"
+)
+
+// mossStore is a moss collection, an in-memory LSM of sorted segments. On a
+// path it is persisted to a moss Store, which writes the segments in the
+// background; in memory it is a bare collection. Every operation holds the
+// read lock of mu, and FlushDB and update the write lock.
+type mossStore struct {
+	mu    sync.RWMutex
+	coll  moss.Collection
+	store *moss.Store // nil in memory
+	path  string
+}
+
+// NewMossStore opens a moss collection persisted at path, or an in-memory
+// one for ":memory:". A batch returns before the persister has written it,
+// so a persisted collection refuses fsync with ErrFsyncNotAllowed.
+func NewMossStore(path string, fsync bool) (Store, error) {
+	if fsync && path != ":memory:" {
+		return nil, ErrFsyncNotAllowed
+	}
+	s := &mossStore{path: path}
+	if err := s.open(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *mossStore) open() error {
+	if s.path == ":memory:" {
+		coll, err := moss.NewCollection(moss.DefaultCollectionOptions)
+		if err != nil {
+			return err
+		}
+		if err := coll.Start(); err != nil {
+			return err
+		}
+		s.coll, s.store = coll, nil
+		return nil
+	}
+	if err := os.MkdirAll(s.path, 0755); err != nil {
+		return err
+	}
+	store, coll, err := moss.OpenStoreCollection(s.path, moss.StoreOptions{
+		CollectionOptions: moss.DefaultCollectionOptions,
+	}, moss.StorePersistOptions{
+		NoSync:            true,
+		CompactionConcern: moss.CompactionAllow,
+	})
+	if err != nil {
+		return err
+	}
+	s.coll, s.store = coll, store
+	return nil
+}
+
+func (s *mossStore) close() error {
+	err := s.coll.Close()
+	if s.store != nil {
+		if serr := s.store.Close(); err == nil {
+			err = serr
+		}
+	}
+	return err
+}
+
+func (s *mossStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.close()
+}
+
+// batch applies keys, with vals or deleted if vals is nil, in one moss
+// batch.
+func (s *mossStore) batch(keys, vals [][]byte) error {
+	var size int
+	for i := range keys {
+		size += len(keys[i])
+		if vals != nil {
+			size += len(vals[i])
+		}
+	}
+	b, err := s.coll.NewBatch(len(keys), size)
+	if err != nil {
+		return err
+	}
+	defer b.Close()
+	for i := range keys {
+		if vals != nil {
+			err = b.Set(keys[i], vals[i])
+		} else {
+			err = b.Del(keys[i])
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return s.coll.ExecuteBatch(b, moss.WriteOptions{})
+}
+
+func (s *mossStore) PSet(keys, vals [][]byte) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.batch(keys, vals)
+}
+
+func (s *mossStore) PGet(keys [][]byte) ([][]byte, []bool, error) {
+	snap, err := s.snapshot()
+	if err != nil {
+		return nil, nil, err
+	}
+	defer snap.Close()
+	vals := make([][]byte, len(keys))
+	oks := make([]bool, len(keys))
+	for i, k := range keys {
+		if vals[i], oks[i], err = snap.Get(k); err != nil {
+			return nil, nil, err
+		}
+	}
+	return vals, oks, nil
+}
+
+func (s *mossStore) Set(key, value []byte) error {
+	return s.PSet([][]byte{key}, [][]byte{value})
+}
+
+func (s *mossStore) SetAsync(key, value []byte, cb func(error)) {
+	cb(s.Set(key, value))
+}
+
+// Get reads key from a snapshot taken for it.
+func (s *mossStore) Get(key []byte) ([]byte, bool, error) {
+	snap, err := s.snapshot()
+	if err != nil {
+		return nil, false, err
+	}
+	defer snap.Close()
+	return snap.Get(key)
+}
+
+func (s *mossStore) Has(key []byte) (bool, error) {
+	_, ok, err := s.Get(key)
+	return ok, err
+}
+
+// Del reads the key from a snapshot before deleting it, to report whether
+// it was present.
+func (s *mossStore) Del(key []byte) (bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	snap, err := s.coll.Snapshot()
+	if err != nil {
+		return false, err
+	}
+	_, ok, err := mossSnapshot{snap}.Get(key)
+	snap.Close()
+	if err != nil || !ok {
+		return false, err
+	}
+	return true, s.batch([][]byte{key}, nil)
+}
+
+func (s *mossStore) PDel(keys [][]byte) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.batch(keys, nil)
+}
+
+func (s *mossStore) Keys(pattern []byte, limit int, withvals bool) ([][]byte, [][]byte, error) {
+	snap, err := s.snapshot()
+	if err != nil {
+		return nil, nil, err
+	}
+	defer snap.Close()
+	return mossScan(snap.snap, pattern, nil, pattern, limit, withvals)
+}
+
+func (s *mossStore) AllKeys(limit int, withvals bool) ([][]byte, [][]byte, error) {
+	return s.Keys(nil, limit, withvals)
+}
+
+func (s *mossStore) RangeScan(start, end []byte, limit int, withvals bool) ([][]byte, [][]byte, error) {
+	snap, err := s.snapshot()
+	if err != nil {
+		return nil, nil, err
+	}
+	defer snap.Close()
+	return snap.RangeScan(start, end, limit, withvals)
+}
+
+// mossScan iterates snap from start up to end, stopping at the first key
+// without prefix, and copies the entries out of the segments.
+func mossScan(snap moss.Snapshot, start, end, prefix []byte, limit int, withvals bool) ([][]byte, [][]byte, error) {
+	it, err := snap.StartIterator(start, end, moss.IteratorOptions{})
+	if err != nil {
+		return nil, nil, err
+	}
+	defer it.Close()
+	var keys [][]byte
+	var vals [][]byte
+	for {
+		if limit > 0 && len(keys) >= limit {
+			break
+		}
+		k, v, err := it.Current()
+		if err == moss.ErrIteratorDone {
+			break
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		if !bytes.HasPrefix(k, prefix) {
+			break
+		}
+		keys = append(keys, bcopy(k))
+		if withvals {
+			vals = append(vals, bcopy(v))
+		}
+		if err := it.Next(); err == moss.ErrIteratorDone {
+			break
+		} else if err != nil {
+			return nil, nil, err
+		}
+	}
+	return keys, vals, nil
+}
+
+// mossSnapshot is a moss snapshot of the collection, which later batches do
+// not change.
+type mossSnapshot struct {
+	snap moss.Snapshot
+}
+
+func (s *mossStore) snapshot() (mossSnapshot, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	snap, err := s.coll.Snapshot()
+	return mossSnapshot{snap}, err
+}
+
+func (s *mossStore) Snapshot() (Snapshot, error) {
+	snap, err := s.snapshot()
+	if err != nil {
+		return nil, err
+	}
+	return snap, nil
+}
+
+// Get reads the value without the copy of moss, whose copy of an empty value
+// would be nil like a missing one, and copies it instead.
+func (s mossSnapshot) Get(key []byte) ([]byte, bool, error) {
+	v, err := s.snap.Get(key, moss.ReadOptions{NoCopyValue: true})
+	if err != nil || v == nil {
+		return nil, false, err
+	}
+	return bcopy(v), true, nil
+}
+
+func (s mossSnapshot) RangeScan(start, end []byte, limit int, withvals bool) ([][]byte, [][]byte, error) {
+	return mossScan(s.snap, start, end, nil, limit, withvals)
+}
+
+func (s mossSnapshot) Close() error {
+	return s.snap.Close()
+}
+
+// Count iterates the keys.
+func (s *mossStore) Count() (int64, error) {
+	keys, _, err := s.Keys(nil, 0, false)
+	return int64(len(keys)), err
+}
+
+// FlushDB closes the collection, removes its files and opens it again.
+func (s *mossStore) FlushDB() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.close(); err != nil {
+		return err
+	}
+	if s.store != nil {
+		if err := os.RemoveAll(s.path); err != nil {
+			return err
+		}
+	}
+	return s.open()
+}
+
+// Compact is not supported: moss merges its segments in the background.
+func (s *mossStore) Compact() error {
+	return ErrNotSupported
+}
+
+func (s *mossStore) Merge(key, value []byte) error {
+	return mergeWith(s.update, key, value)
+}
+
+// update reads and writes key under the write lock of mu, which the other
+// operations wait for.
+func (s *mossStore) update(key []byte, fn updateFunc) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	snap, err := s.coll.Snapshot()
+	if err != nil {
+		return err
+	}
+	old, ok, err := mossSnapshot{snap}.Get(key)
+	snap.Close()
+	if err != nil {
+		return err
+	}
+	v, err := fn(old, ok)
+	if err != nil || v == nil {
+		return err
+	}
+	return s.batch([][]byte{key}, [][]byte{v})
+}
+
+func (s *mossStore) Incr(key []byte, delta int64) (int64, error) {
+	return incrWith(s.update, key, delta)
+}
+
+func (s *mossStore) CAS(key, oldValue, newValue []byte) (bool, error) {
+	return casWith(s.update, key, oldValue, newValue)
+}
+
+func (s *mossStore) SetWithTTL(key, value []byte, ttl time.Duration) error {
+	return ErrNotSupported
+}
+
+func (s *mossStore) Ping() error {
+	return nil
+}
+
+func (s *mossStore) Capabilities() Capability {
+	c := CapKeys | CapOrdered | CapSnapshots | CapCAS | CapMerge
+	if s.store != nil {
+		c |= CapPersistent
+	}
+	return c
+}
@@ -0,0 +1,47 @@
+package kvbench
+
+import "encoding/binary"
+
+// NamespaceStore is implemented by stores with native namespaces (column
+// families, buckets). Keys in different namespaces never collide, and a
+// namespace exists as soon as something is written into it.
+type NamespaceStore interface {
+	SetNS(ns, key, value []byte) error
+	GetNS(ns, key []byte) ([]byte, bool, error)
+	DelNS(ns, key []byte) (bool, error)
+}
+
+// Namespaces returns the native namespaces of store, or namespaces emulated
+// with a key prefix if it has none.
+func Namespaces(store Store) NamespaceStore {
+	if ns, ok := store.(NamespaceStore); ok {
+		return ns
+	}
+	return prefixNamespaces{store}
+}
+
+type prefixNamespaces struct {
+	Store
+}
+
+// nsKey prefixes key with the length of ns and ns itself, so that no key of
+// one namespace is a key of another.
+func nsKey(ns, key []byte) []byte {
+	r := make([]byte, binary.MaxVarintLen64+len(ns)+len(key))
+	n := binary.PutUvarint(r, uint64(len(ns)))
+	n += copy(r[n:], ns)
+	n += copy(r[n:], key)
+	return r[:n]
+}
+
+func (s prefixNamespaces) SetNS(ns, key, value []byte) error {
+	return s.Set(nsKey(ns, key), value)
+}
+
+func (s prefixNamespaces) GetNS(ns, key []byte) ([]byte, bool, error) {
+	return s.Get(nsKey(ns, key))
+}
+
+func (s prefixNamespaces) DelNS(ns, key []byte) (bool, error) {
+	return s.Del(nsKey(ns, key))
+}
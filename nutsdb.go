@@ -1,9 +1,13 @@
 package kvbench
 
 import (
+	"bytes"
+	"errors"
+	"sort"
 	"sync"
+	"time"
 
-	"github.com/xujiajun/nutsdb"
+	"github.com/nutsdb/nutsdb"
 )
 
 var nutsdbBucket = "keys"
@@ -51,15 +55,41 @@ func NewNutsdbStore(path string, fsync bool) (Store, error) {
 	}, nil
 }
 
+// nutsdbRun runs fn with run, either Update or View of the db. Those format
+// the error of a failed transaction with %v, which errors.Is does not see
+// through, so nutsdbRun returns the error of fn as it is.
+func nutsdbRun(run func(func(*nutsdb.Tx) error) error, fn func(*nutsdb.Tx) error) error {
+	var fnErr error
+	err := run(func(tx *nutsdb.Tx) error {
+		fnErr = fn(tx)
+		return fnErr
+	})
+	if fnErr != nil {
+		return fnErr
+	}
+	return err
+}
+
+// nutsdbNotFound reports whether err means that the key or its bucket is
+// missing.
+func nutsdbNotFound(err error) bool {
+	return errors.Is(err, nutsdb.ErrKeyNotFound) || errors.Is(err, nutsdb.ErrNotFoundKey) ||
+		errors.Is(err, nutsdb.ErrBucketNotFound) || errors.Is(err, nutsdb.ErrNotFoundBucket)
+}
+
 func (s *nutsdbStore) Close() error {
 	s.db.Close()
 	return nil
 }
 
+// PSet copies the keys and values, as nutsdb keeps the slices that Put gets
+// in its index.
 func (s *nutsdbStore) PSet(keys, vals [][]byte) error {
-	return s.db.Update(func(tx *nutsdb.Tx) error {
+	return nutsdbRun(s.db.Update, func(tx *nutsdb.Tx) error {
 		for i, k := range keys {
-			tx.Put(nutsdbBucket, k, vals[i], 0)
+			if err := tx.Put(nutsdbBucket, bcopy(k), bcopy(vals[i]), 0); err != nil {
+				return err
+			}
 		}
 
 		return nil
@@ -90,7 +120,7 @@ func (s *nutsdbStore) PGet(keys [][]byte) ([][]byte, []bool, error) {
 
 func (s *nutsdbStore) Set(key, value []byte) error {
 	return s.db.Update(func(tx *nutsdb.Tx) error {
-		return tx.Put(nutsdbBucket, key, value, 0)
+		return tx.Put(nutsdbBucket, bcopy(key), bcopy(value), 0)
 	})
 }
 
@@ -112,28 +142,63 @@ func (s *nutsdbStore) Get(key []byte) ([]byte, bool, error) {
 	return v, ok, err
 }
 
+func (s *nutsdbStore) Has(key []byte) (bool, error) {
+	var ok bool
+	err := nutsdbRun(s.db.View, func(tx *nutsdb.Tx) error {
+		_, err := tx.Get(nutsdbBucket, key)
+		ok = err == nil
+		return err
+	})
+	// a missing key and a missing bucket both mean the key is absent
+	if nutsdbNotFound(err) {
+		return false, nil
+	}
+	return ok, err
+}
+
 func (s *nutsdbStore) Del(key []byte) (bool, error) {
-	err := s.db.Update(func(tx *nutsdb.Tx) error {
+	err := nutsdbRun(s.db.Update, func(tx *nutsdb.Tx) error {
 		return tx.Delete(nutsdbBucket, key)
 	})
+	if nutsdbNotFound(err) {
+		return false, nil
+	}
 
 	return err == nil, err
 }
 
+func (s *nutsdbStore) PDel(keys [][]byte) error {
+	return nutsdbRun(s.db.Update, func(tx *nutsdb.Tx) error {
+		for _, k := range keys {
+			if err := tx.Delete(nutsdbBucket, k); err != nil && !nutsdbNotFound(err) {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
 func (s *nutsdbStore) Keys(pattern []byte, limit int, withvals bool) ([][]byte, [][]byte, error) {
 	var keys [][]byte
 	var vals [][]byte
 
 	err := s.db.View(func(tx *nutsdb.Tx) error {
 		entries, _, err := tx.PrefixScan(nutsdbBucket, pattern, 0, nutsdb.ScanNoLimit)
-
+		if errors.Is(err, nutsdb.ErrPrefixScan) || nutsdb.IsBucketEmpty(err) {
+			return nil
+		}
 		if err != nil {
 			return err
 		}
 
-		for i, entry := range entries {
-			keys[i] = entry.Key
-			vals[i] = entry.Value
+		for _, entry := range entries {
+			if limit > 0 && len(keys) >= limit {
+				break
+			}
+			keys = append(keys, bcopy(entry.Key))
+			if withvals {
+				vals = append(vals, bcopy(entry.Value))
+			}
 		}
 
 		return nil
@@ -142,6 +207,160 @@ func (s *nutsdbStore) Keys(pattern []byte, limit int, withvals bool) ([][]byte,
 	return keys, vals, err
 }
 
+func (s *nutsdbStore) AllKeys(limit int, withvals bool) ([][]byte, [][]byte, error) {
+	var keys [][]byte
+	var vals [][]byte
+	err := s.db.View(func(tx *nutsdb.Tx) error {
+		entries, err := tx.GetAll(nutsdbBucket)
+		if err != nil {
+			if nutsdb.IsBucketEmpty(err) {
+				return nil
+			}
+			return err
+		}
+		for _, entry := range entries {
+			if limit > 0 && len(keys) >= limit {
+				break
+			}
+			keys = append(keys, bcopy(entry.Key))
+			if withvals {
+				vals = append(vals, bcopy(entry.Value))
+			}
+		}
+		return nil
+	})
+	return keys, vals, err
+}
+
+// RangeScan uses the RangeScan of nutsdb, which includes end, so an entry at
+// end is dropped. Without end it filters and sorts GetAll.
+func (s *nutsdbStore) RangeScan(start, end []byte, limit int, withvals bool) ([][]byte, [][]byte, error) {
+	var entries nutsdb.Entries
+	err := s.db.View(func(tx *nutsdb.Tx) error {
+		var err error
+		if end != nil {
+			entries, err = tx.RangeScan(nutsdbBucket, start, end)
+		} else {
+			entries, err = tx.GetAll(nutsdbBucket)
+		}
+		if errors.Is(err, nutsdb.ErrRangeScan) || nutsdb.IsBucketEmpty(err) {
+			return nil
+		}
+		return err
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	if end == nil {
+		sort.Slice(entries, func(i, j int) bool {
+			return bytes.Compare(entries[i].Key, entries[j].Key) < 0
+		})
+	}
+	var keys [][]byte
+	var vals [][]byte
+	for _, e := range entries {
+		if limit > 0 && len(keys) >= limit {
+			break
+		}
+		if bytes.Compare(e.Key, start) < 0 || end != nil && bytes.Compare(e.Key, end) >= 0 {
+			continue
+		}
+		keys = append(keys, e.Key)
+		if withvals {
+			vals = append(vals, e.Value)
+		}
+	}
+	return keys, vals, nil
+}
+
+func (s *nutsdbStore) Snapshot() (Snapshot, error) {
+	return nil, ErrNotSupported
+}
+
+func (s *nutsdbStore) Count() (int64, error) {
+	var n int64
+	err := s.db.View(func(tx *nutsdb.Tx) error {
+		entries, err := tx.GetAll(nutsdbBucket)
+		if err != nil {
+			if nutsdb.IsBucketEmpty(err) {
+				return nil
+			}
+			return err
+		}
+		n = int64(len(entries))
+		return nil
+	})
+	return n, err
+}
+
+// FlushDB deletes the bucket of the keys, which the next Put creates again.
 func (s *nutsdbStore) FlushDB() error {
-	return s.db.Close()
+	return s.db.Update(func(tx *nutsdb.Tx) error {
+		return tx.DeleteBucket(nutsdb.DataStructureBPTree, nutsdbBucket)
+	})
+}
+
+// Compact merges the data files. Fewer than two of them leave nothing to
+// merge, which Merge reports with ErrDontNeedMerge.
+func (s *nutsdbStore) Compact() error {
+	if err := s.db.Merge(); err != nil && !errors.Is(err, nutsdb.ErrDontNeedMerge) {
+		return err
+	}
+	return nil
+}
+
+func (s *nutsdbStore) SetAsync(key, value []byte, cb func(error)) {
+	cb(s.Set(key, value))
+}
+
+func (s *nutsdbStore) Merge(key, value []byte) error {
+	return mergeWith(s.update, key, value)
+}
+
+// update reads and writes key in one write transaction, which nutsdb runs
+// one at a time.
+func (s *nutsdbStore) update(key []byte, fn updateFunc) error {
+	return nutsdbRun(s.db.Update, func(tx *nutsdb.Tx) error {
+		var old []byte
+		e, err := tx.Get(nutsdbBucket, key)
+		if err != nil && !nutsdbNotFound(err) {
+			return err
+		}
+		if err == nil {
+			old = e.Value
+		}
+		v, err := fn(old, err == nil)
+		if err != nil || v == nil {
+			return err
+		}
+		return tx.Put(nutsdbBucket, bcopy(key), v, 0)
+	})
+}
+
+func (s *nutsdbStore) Incr(key []byte, delta int64) (int64, error) {
+	return incrWith(s.update, key, delta)
+}
+
+func (s *nutsdbStore) CAS(key, oldValue, newValue []byte) (bool, error) {
+	return casWith(s.update, key, oldValue, newValue)
+}
+
+// SetWithTTL rounds ttl up to whole seconds, the unit of nutsdb.
+func (s *nutsdbStore) SetWithTTL(key, value []byte, ttl time.Duration) error {
+	secs := uint32((ttl + time.Second - 1) / time.Second)
+	if secs < 1 {
+		// a TTL of 0 means persistent to nutsdb
+		secs = 1
+	}
+	return s.db.Update(func(tx *nutsdb.Tx) error {
+		return tx.Put(nutsdbBucket, bcopy(key), bcopy(value), secs)
+	})
+}
+
+func (s *nutsdbStore) Ping() error {
+	return nil
+}
+
+func (s *nutsdbStore) Capabilities() Capability {
+	return CapKeys | CapOrdered | CapCompact | CapPersistent | CapTTL | CapTransactions | CapCAS | CapMerge | CapBackup
 }
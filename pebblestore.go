@@ -1,17 +1,49 @@
 package kvbench
 
 import (
+	"bytes"
+	"encoding/binary"
 	"fmt"
 	"io"
 	"sync"
+	"time"
 
 	"github.com/cockroachdb/pebble"
+	"github.com/cockroachdb/pebble/vfs"
 )
 
 type pebbleStore struct {
-	mu sync.RWMutex
-	db *pebble.DB
-	wo *pebble.WriteOptions
+	mu       sync.RWMutex
+	db       *pebble.DB
+	wo       *pebble.WriteOptions
+	opts     PebbleOptions
+	inMemory bool
+}
+
+// pebbleDefaultMaxBatchBytes bounds the batches of PSet when
+// PebbleOptions.MaxBatchBytes is 0.
+const pebbleDefaultMaxBatchBytes = 64 << 20
+
+// PebbleOptions tunes a pebble store. Zero fields keep the defaults.
+type PebbleOptions struct {
+	// MaxBatchBytes is the largest batch PSet commits at once, in bytes.
+	// Larger PSet calls are split and committed in several batches.
+	MaxBatchBytes int
+	// MaxBatchCount is the most entries PSet commits at once, 0 for no
+	// limit.
+	MaxBatchCount int
+}
+
+// String returns the effective settings, with defaults filled in.
+func (o PebbleOptions) String() string {
+	return fmt.Sprintf("maxbatchbytes=%dMiB maxbatchcount=%d", o.maxBatchBytes()>>20, o.MaxBatchCount)
+}
+
+func (o PebbleOptions) maxBatchBytes() int {
+	if o.MaxBatchBytes <= 0 {
+		return pebbleDefaultMaxBatchBytes
+	}
+	return o.MaxBatchBytes
 }
 
 func pebbleKey(key []byte) []byte {
@@ -22,11 +54,19 @@ func pebbleKey(key []byte) []byte {
 }
 
 func NewPebbleStore(path string, fsync bool) (Store, error) {
-	if path == ":memory:" {
-		return nil, ErrMemoryNotAllowed
-	}
+	return NewPebbleStoreWithOptions(path, fsync, PebbleOptions{})
+}
 
-	opts := &pebble.Options{}
+// NewPebbleStoreWithOptions is NewPebbleStore with tuning options. The
+// ":memory:" path opens pebble on an in-memory filesystem, which is lost on
+// Close.
+func NewPebbleStoreWithOptions(path string, fsync bool, o PebbleOptions) (Store, error) {
+	opts := &pebble.Options{Merger: pebbleAddMerger}
+	inMemory := path == ":memory:"
+	if inMemory {
+		opts.FS = vfs.NewMem()
+		path = "pebble"
+	}
 	if !fsync {
 		opts.DisableWAL = true
 	}
@@ -40,8 +80,10 @@ func NewPebbleStore(path string, fsync bool) (Store, error) {
 	}
 
 	return &pebbleStore{
-		db: db,
-		wo: wo,
+		db:       db,
+		wo:       wo,
+		opts:     o,
+		inMemory: inMemory,
 	}, nil
 }
 
@@ -50,29 +92,52 @@ func (s *pebbleStore) Close() error {
 	return nil
 }
 
+// PSet commits keys in batches of at most MaxBatchBytes and MaxBatchCount,
+// so a huge PSet does not build one huge batch in memory. A failed commit
+// leaves the earlier batches written.
 func (s *pebbleStore) PSet(keys, vals [][]byte) error {
-	wb := s.db.NewBatch()
+	return s.writeBatches(len(keys), func(wb *pebble.Batch, i int) error {
+		// Batch.Set ignores its write options, they apply to Commit
+		return wb.Set(keys[i], vals[i], nil)
+	})
+}
 
-	for i, k := range keys {
-		wb.Set(k, vals[i], s.wo)
+// writeBatches adds n operations to batches with add, and commits a batch
+// whenever it reaches the size or count limit of the options.
+func (s *pebbleStore) writeBatches(n int, add func(wb *pebble.Batch, i int) error) error {
+	maxBytes := s.opts.maxBatchBytes()
+	wb := s.db.NewBatch()
+	for i := 0; i < n; i++ {
+		if err := add(wb, i); err != nil {
+			wb.Close()
+			return err
+		}
+		full := wb.Len() >= maxBytes ||
+			(s.opts.MaxBatchCount > 0 && int(wb.Count()) >= s.opts.MaxBatchCount)
+		if full && i < n-1 {
+			if err := wb.Commit(s.wo); err != nil {
+				wb.Close()
+				return err
+			}
+			wb.Close()
+			wb = s.db.NewBatch()
+		}
 	}
+	defer wb.Close()
 	return wb.Commit(s.wo)
 }
 
 func (s *pebbleStore) PGet(keys [][]byte) ([][]byte, []bool, error) {
 	var vals = make([][]byte, len(keys))
 	var oks = make([]bool, len(keys))
-
-	var err error
-	var closer io.Closer
 	for i, k := range keys {
-		vals[i], closer, err = s.db.Get(k)
-		oks[i] = (err == nil)
-		if closer != nil {
-			closer.Close()
+		v, ok, err := s.Get(k)
+		if err != nil {
+			return nil, nil, err
 		}
+		vals[i], oks[i] = v, ok
 	}
-	return vals, oks, err
+	return vals, oks, nil
 }
 
 func (s *pebbleStore) Set(key, value []byte) error {
@@ -80,17 +145,35 @@ func (s *pebbleStore) Set(key, value []byte) error {
 }
 
 func (s *pebbleStore) Get(key []byte) ([]byte, bool, error) {
-	v, closer, err := s.db.Get(key)
+	return pebbleGet(s.db, key)
+}
+
+// pebbleGet reads key from the DB or a snapshot.
+func pebbleGet(r pebble.Reader, key []byte) ([]byte, bool, error) {
+	v, closer, err := r.Get(key)
+	if err == pebble.ErrNotFound {
+		return nil, false, nil
+	}
 	if err != nil {
-		if err != pebble.ErrNotFound {
-			fmt.Println(err)
-		}
 		return nil, false, err
 	}
-	if closer != nil {
-		closer.Close()
+	// v is only valid until closer is closed
+	v = bcopy(v)
+	closer.Close()
+	return v, true, nil
+}
+
+// Has does not copy the value out, unlike Get.
+func (s *pebbleStore) Has(key []byte) (bool, error) {
+	_, closer, err := s.db.Get(key)
+	if err == pebble.ErrNotFound {
+		return false, nil
 	}
-	return v, v != nil, err
+	if err != nil {
+		return false, err
+	}
+	closer.Close()
+	return true, nil
 }
 
 func (s *pebbleStore) Del(key []byte) (bool, error) {
@@ -98,53 +181,249 @@ func (s *pebbleStore) Del(key []byte) (bool, error) {
 	return err == nil, err
 }
 
+func (s *pebbleStore) PDel(keys [][]byte) error {
+	return s.writeBatches(len(keys), func(wb *pebble.Batch, i int) error {
+		return wb.Delete(keys[i], nil)
+	})
+}
+
 func (s *pebbleStore) Keys(pattern []byte, limit int, withvals bool) ([][]byte, [][]byte, error) {
 	var keys [][]byte
 	var vals [][]byte
+	iter := s.db.NewIter(nil)
+	defer iter.Close()
+	// the iterator reuses its buffers, so keys and values are copied
+	for iter.SeekGE(pattern); iter.Valid(); iter.Next() {
+		if limit > 0 && len(keys) >= limit {
+			break
+		}
+		key := iter.Key()
+		if !bytes.HasPrefix(key, pattern) {
+			break
+		}
+		keys = append(keys, bcopy(key))
+		if withvals {
+			vals = append(vals, bcopy(iter.Value()))
+		}
+	}
+	return keys, vals, iter.Error()
+}
 
-	// 定义前缀
-	prefix := []byte("myprefix")
-
-	// 创建迭代器，指定范围为前缀
-	iter := s.db.NewIter(&pebble.IterOptions{
-		LowerBound: prefix,
-		UpperBound: append(prefix, 0xFF),
-	})
+func (s *pebbleStore) AllKeys(limit int, withvals bool) ([][]byte, [][]byte, error) {
+	var keys [][]byte
+	var vals [][]byte
+	iter := s.db.NewIter(nil)
 	defer iter.Close()
+	for iter.First(); iter.Valid(); iter.Next() {
+		if limit > 0 && len(keys) >= limit {
+			break
+		}
+		keys = append(keys, bcopy(iter.Key()))
+		if withvals {
+			vals = append(vals, bcopy(iter.Value()))
+		}
+	}
+	return keys, vals, iter.Error()
+}
 
-	// 遍历具有前缀的所有键值对
+// RangeScan iterates with start and end as the bounds of the iterator, which
+// lets pebble skip the sstables outside them.
+func (s *pebbleStore) RangeScan(start, end []byte, limit int, withvals bool) ([][]byte, [][]byte, error) {
+	return pebbleRangeScan(s.db, start, end, limit, withvals)
+}
+
+// pebbleRangeScan scans the DB or a snapshot.
+func pebbleRangeScan(r pebble.Reader, start, end []byte, limit int, withvals bool) ([][]byte, [][]byte, error) {
+	var keys [][]byte
+	var vals [][]byte
+	iter := r.NewIter(&pebble.IterOptions{LowerBound: start, UpperBound: end})
+	defer iter.Close()
 	for iter.First(); iter.Valid(); iter.Next() {
-		key := iter.Key()
-		keys = append(keys, key)
+		if limit > 0 && len(keys) >= limit {
+			break
+		}
+		keys = append(keys, bcopy(iter.Key()))
 		if withvals {
-			value := iter.Value()
-			vals = append(vals, value)
+			vals = append(vals, bcopy(iter.Value()))
 		}
 	}
+	return keys, vals, iter.Error()
+}
 
-	//io := &pebble.IterOptions{}
-	//it := s.db.NewIter(io)
-	//defer it.Close()
-	//it.SeekGE(pattern)
-	//
-	//for ; it.Valid(); it.Next() {
-	//	key := it.Key()
-	//	if !bytes.HasPrefix(key, pattern) {
-	//		break
-	//	}
-	//
-	//	k := it.Key()
-	//	keys = append(keys, k)
-	//
-	//	if withvals {
-	//		value := it.Value()
-	//		vals = append(vals, value)
-	//	}
-	//}
-
-	return keys, vals, nil
+func (s *pebbleStore) Count() (int64, error) {
+	var n int64
+	iter := s.db.NewIter(nil)
+	defer iter.Close()
+	for iter.First(); iter.Valid(); iter.Next() {
+		n++
+	}
+	return n, iter.Error()
 }
 
+// FlushDB deletes the range of every key with one range tombstone, and
+// compacts it away so that the next writes do not read past it.
 func (s *pebbleStore) FlushDB() error {
-	return s.db.Flush()
+	start, end, err := s.keyRange()
+	if err != nil || start == nil {
+		return err
+	}
+	if err := s.db.DeleteRange(start, end, s.wo); err != nil {
+		return err
+	}
+	return s.db.Compact(start, end, true)
+}
+
+func (s *pebbleStore) Compact() error {
+	start, end, err := s.keyRange()
+	if err != nil || start == nil {
+		return err
+	}
+	return s.db.Compact(start, end, true)
+}
+
+// keyRange returns the range from the first key to just past the last one,
+// nil if the store is empty.
+func (s *pebbleStore) keyRange() (start, end []byte, err error) {
+	iter := s.db.NewIter(nil)
+	if iter.First() {
+		start = bcopy(iter.Key())
+	}
+	if iter.Last() {
+		end = append(bcopy(iter.Key()), 0)
+	}
+	return start, end, iter.Close()
+}
+
+// SetAsync only differs from Set with fsync enabled: the write is applied to
+// the memtable right away and cb runs once the WAL sync has completed.
+func (s *pebbleStore) SetAsync(key, value []byte, cb func(error)) {
+	if !s.wo.Sync {
+		cb(s.Set(key, value))
+		return
+	}
+	wb := s.db.NewBatch()
+	wb.Set(key, value, nil)
+	if err := s.db.ApplyNoSyncWait(wb, s.wo); err != nil {
+		wb.Close()
+		cb(err)
+		return
+	}
+	go func() {
+		err := wb.SyncWait()
+		wb.Close()
+		cb(err)
+	}()
+}
+
+func (s *pebbleStore) Merge(key, value []byte) error {
+	return s.db.Merge(key, value, s.wo)
+}
+
+// update reads key through an indexed batch and commits the new value with
+// it, under mu. The batch does not isolate key from other writers, and the
+// other operations do not take mu, so only Incr and CAS are atomic with each
+// other; the merge operator of Merge adds without returning the sum.
+func (s *pebbleStore) update(key []byte, fn updateFunc) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b := s.db.NewIndexedBatch()
+	defer b.Close()
+	var old []byte
+	cur, closer, err := b.Get(key)
+	if err != nil && err != pebble.ErrNotFound {
+		return err
+	}
+	if err == nil {
+		// cur is only valid until closer is closed
+		old = bcopy(cur)
+		closer.Close()
+	}
+	v, err := fn(old, err == nil)
+	if err != nil || v == nil {
+		return err
+	}
+	if err := b.Set(key, v, nil); err != nil {
+		return err
+	}
+	return b.Commit(s.wo)
+}
+
+func (s *pebbleStore) Incr(key []byte, delta int64) (int64, error) {
+	return incrWith(s.update, key, delta)
+}
+
+func (s *pebbleStore) CAS(key, oldValue, newValue []byte) (bool, error) {
+	return casWith(s.update, key, oldValue, newValue)
+}
+
+func (s *pebbleStore) SetWithTTL(key, value []byte, ttl time.Duration) error {
+	return ErrNotSupported
+}
+
+// pebbleAddMerger is the merge operator of Merge, which adds big-endian
+// uint64 counters.
+var pebbleAddMerger = &pebble.Merger{
+	Name: "kvbench.add",
+	Merge: func(key, value []byte) (pebble.ValueMerger, error) {
+		m := &pebbleAdd{}
+		return m, m.MergeNewer(value)
+	},
+}
+
+type pebbleAdd struct {
+	sum uint64
+}
+
+func (m *pebbleAdd) MergeNewer(value []byte) error {
+	if len(value) != 8 {
+		return fmt.Errorf("merge operand of %d bytes, want 8", len(value))
+	}
+	m.sum += binary.BigEndian.Uint64(value)
+	return nil
+}
+
+func (m *pebbleAdd) MergeOlder(value []byte) error {
+	return m.MergeNewer(value)
+}
+
+func (m *pebbleAdd) Finish(includesBase bool) ([]byte, io.Closer, error) {
+	r := make([]byte, 8)
+	binary.BigEndian.PutUint64(r, m.sum)
+	return r, nil, nil
+}
+
+func (s *pebbleStore) Ping() error {
+	return nil
+}
+
+// pebbleSnapshot reads a pebble.Snapshot, which is safe for concurrent use.
+type pebbleSnapshot struct {
+	snap *pebble.Snapshot
+}
+
+func (s *pebbleStore) Snapshot() (Snapshot, error) {
+	return pebbleSnapshot{s.db.NewSnapshot()}, nil
+}
+
+func (s pebbleSnapshot) Get(key []byte) ([]byte, bool, error) {
+	return pebbleGet(s.snap, key)
+}
+
+func (s pebbleSnapshot) RangeScan(start, end []byte, limit int, withvals bool) ([][]byte, [][]byte, error) {
+	return pebbleRangeScan(s.snap, start, end, limit, withvals)
+}
+
+func (s pebbleSnapshot) Close() error {
+	return s.snap.Close()
+}
+
+func (s *pebbleStore) Capabilities() Capability {
+	c := CapKeys | CapOrdered | CapCompact | CapSnapshots | CapCAS | CapBackup | CapMerge
+	if !s.inMemory {
+		c |= CapPersistent
+	}
+	if s.wo.Sync {
+		c |= CapAsync
+	}
+	return c
 }
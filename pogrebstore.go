@@ -2,15 +2,18 @@ package kvbench
 
 import (
 	"sync"
+	"time"
 
 	"github.com/akrylysov/pogreb"
+	"github.com/akrylysov/pogreb/fs"
 )
 
 var pogrebBucket = []byte("keys")
 
 type pogrebStore struct {
-	mu sync.RWMutex
-	db *pogreb.DB
+	mu    sync.RWMutex
+	db    *pogreb.DB
+	fsync bool
 }
 
 func pogrebKey(key []byte) []byte {
@@ -19,41 +22,53 @@ func pogrebKey(key []byte) []byte {
 	copy(r[1:], key)
 	return r
 }
+
 func NewPogrebStore(path string, fsync bool) (Store, error) {
 	if path == ":memory:" {
 		return nil, ErrMemoryNotAllowed
 	}
+	return newPogrebStore(path, fsync, fs.OSMMap)
+}
 
-	opts := &pogreb.Options{}
-
-	if fsync {
-		opts.BackgroundSyncInterval = -1
-	}
-
-	db, err := pogreb.Open(path, opts)
+// newPogrebStore opens pogreb on fsys. With fsync, the store syncs after
+// each of its write operations rather than letting pogreb sync after every
+// Put (BackgroundSyncInterval -1), which would sync a PSet once per key.
+func newPogrebStore(path string, fsync bool, fsys fs.FileSystem) (*pogrebStore, error) {
+	db, err := pogreb.Open(path, &pogreb.Options{FileSystem: fsys})
 	if err != nil {
 		return nil, err
 	}
 
 	return &pogrebStore{
-		db: db,
+		db:    db,
+		fsync: fsync,
 	}, nil
 }
 
+// sync syncs the segment pogreb appends to, with fsync.
+func (s *pogrebStore) sync() error {
+	if !s.fsync {
+		return nil
+	}
+	return s.db.Sync()
+}
+
 func (s *pogrebStore) Close() error {
 	s.db.Close()
 	return nil
 }
 
+// PSet puts the keys one by one, as pogreb has no batches, and syncs once.
+// Without fsync there is nothing to buffer: a Put only copies the record into
+// the page cache. A batch that fills a segment (4 GiB) leaves the syncing of
+// the full segment to the OS.
 func (s *pogrebStore) PSet(keys, values [][]byte) error {
-	var err error
 	for i, k := range keys {
-		e := s.db.Put(k, values[i])
-		if e != nil {
-			err = e
+		if err := s.db.Put(k, values[i]); err != nil {
+			return err
 		}
 	}
-	return err
+	return s.sync()
 }
 
 func (s *pogrebStore) PGet(keys [][]byte) ([][]byte, []bool, error) {
@@ -75,7 +90,10 @@ func (s *pogrebStore) PGet(keys [][]byte) ([][]byte, []bool, error) {
 }
 
 func (s *pogrebStore) Set(key, value []byte) error {
-	return s.db.Put(key, value)
+	if err := s.db.Put(key, value); err != nil {
+		return err
+	}
+	return s.sync()
 }
 
 func (s *pogrebStore) Get(key []byte) ([]byte, bool, error) {
@@ -83,15 +101,123 @@ func (s *pogrebStore) Get(key []byte) ([]byte, bool, error) {
 	return v, err == nil, err
 }
 
+func (s *pogrebStore) Has(key []byte) (bool, error) {
+	return s.db.Has(key)
+}
+
 func (s *pogrebStore) Del(key []byte) (bool, error) {
-	err := s.db.Delete(key)
-	return err == nil, err
+	if err := s.db.Delete(key); err != nil {
+		return false, err
+	}
+	return true, s.sync()
+}
+
+// PDel deletes the keys one by one, as pogreb has no batches, and syncs once.
+func (s *pogrebStore) PDel(keys [][]byte) error {
+	for _, k := range keys {
+		if err := s.db.Delete(k); err != nil {
+			return err
+		}
+	}
+	return s.sync()
 }
 
 func (s *pogrebStore) Keys(pattern []byte, limit int, withvalues bool) ([][]byte, [][]byte, error) {
 	return nil, nil, ErrNotSupported
 }
 
+// AllKeys returns the keys in the order of pogreb's hash index.
+func (s *pogrebStore) AllKeys(limit int, withvalues bool) ([][]byte, [][]byte, error) {
+	var keys [][]byte
+	var vals [][]byte
+	it := s.db.Items()
+	for limit <= 0 || len(keys) < limit {
+		key, value, err := it.Next()
+		if err == pogreb.ErrIterationDone {
+			break
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		keys = append(keys, key)
+		if withvalues {
+			vals = append(vals, value)
+		}
+	}
+	return keys, vals, nil
+}
+
+func (s *pogrebStore) RangeScan(start, end []byte, limit int, withvalues bool) ([][]byte, [][]byte, error) {
+	return nil, nil, ErrNotSupported
+}
+
+func (s *pogrebStore) Snapshot() (Snapshot, error) {
+	return nil, ErrNotSupported
+}
+
+// Count returns the number of keys pogreb keeps in its index.
+func (s *pogrebStore) Count() (int64, error) {
+	return int64(s.db.Count()), nil
+}
+
+// FlushDB deletes every key, as pogreb cannot empty a database, then
+// compacts the segments the deletes left mostly dead.
 func (s *pogrebStore) FlushDB() error {
-	return s.db.Close()
+	keys, _, err := s.AllKeys(0, false)
+	if err != nil {
+		return err
+	}
+	if err := s.PDel(keys); err != nil {
+		return err
+	}
+	return s.Compact()
+}
+
+func (s *pogrebStore) Compact() error {
+	_, err := s.db.Compact()
+	return err
+}
+
+func (s *pogrebStore) SetAsync(key, value []byte, cb func(error)) {
+	cb(s.Set(key, value))
+}
+
+func (s *pogrebStore) Merge(key, value []byte) error {
+	return mergeWith(s.update, key, value)
+}
+
+// update reads and writes key under mu. The other operations do not take it,
+// so only Incr and CAS are atomic with each other.
+func (s *pogrebStore) update(key []byte, fn updateFunc) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	old, err := s.db.Get(key)
+	if err != nil {
+		return err
+	}
+	v, err := fn(old, old != nil)
+	if err != nil || v == nil {
+		return err
+	}
+	return s.Set(key, v)
+}
+
+func (s *pogrebStore) Incr(key []byte, delta int64) (int64, error) {
+	return incrWith(s.update, key, delta)
+}
+
+func (s *pogrebStore) CAS(key, oldValue, newValue []byte) (bool, error) {
+	return casWith(s.update, key, oldValue, newValue)
+}
+
+func (s *pogrebStore) SetWithTTL(key, value []byte, ttl time.Duration) error {
+	return ErrNotSupported
+}
+
+func (s *pogrebStore) Ping() error {
+	return nil
+}
+
+func (s *pogrebStore) Capabilities() Capability {
+	return CapCompact | CapPersistent | CapCAS | CapMerge
 }
@@ -0,0 +1,316 @@
+package kvbench
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// redisBatchSize is the number of keys of one MSET, MGET or DEL sent by the
+// batch operations, which pipeline one command per batch.
+const redisBatchSize = 1000
+
+// redisStore is a client of Redis built on go-redis, with its connection pool
+// and pipelining, for a comparison with the embedded stores. Unlike
+// respStore, which only uses the commands every RESP server shares, it may
+// rely on Redis itself: with fsync, every write is followed by WAITAOF, which
+// waits until the server has written the append-only file to disk. That
+// needs Redis 7.2 or later with appendonly yes; otherwise the writes fail.
+type redisStore struct {
+	client *redis.Client
+	fsync  bool
+}
+
+// NewRedisStore returns a store that sends its operations to the Redis server
+// at addr. Connections are opened when first needed.
+func NewRedisStore(addr string, fsync bool) (Store, error) {
+	client := redis.NewClient(&redis.Options{
+		Addr:     addr,
+		PoolSize: respPoolSize,
+	})
+	return &redisStore{client: client, fsync: fsync}, nil
+}
+
+// write runs the commands queued by fn in one pipeline, followed by WAITAOF
+// with fsync.
+func (s *redisStore) write(fn func(p redis.Pipeliner)) error {
+	ctx := context.Background()
+	p := s.client.Pipeline()
+	fn(p)
+	var wait *redis.Cmd
+	if s.fsync {
+		wait = p.Do(ctx, "WAITAOF", 1, 0, 0)
+	}
+	if _, err := p.Exec(ctx); err != nil {
+		return err
+	}
+	if wait != nil {
+		// the number of local fsyncs, then of replicas
+		n, err := wait.Int64Slice()
+		if err != nil {
+			return err
+		}
+		if len(n) == 0 || n[0] < 1 {
+			return errors.New("redis: WAITAOF did not fsync the append-only file")
+		}
+	}
+	return nil
+}
+
+func (s *redisStore) Close() error {
+	return s.client.Close()
+}
+
+func (s *redisStore) Set(key, value []byte) error {
+	if !s.fsync {
+		return s.client.Set(context.Background(), string(key), value, 0).Err()
+	}
+	return s.write(func(p redis.Pipeliner) {
+		p.Set(context.Background(), string(key), value, 0)
+	})
+}
+
+func (s *redisStore) SetAsync(key, value []byte, cb func(error)) {
+	cb(s.Set(key, value))
+}
+
+// PSet pipelines one MSET per redisBatchSize keys.
+func (s *redisStore) PSet(keys, values [][]byte) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	return s.write(func(p redis.Pipeliner) {
+		for i := 0; i < len(keys); i += redisBatchSize {
+			end := i + redisBatchSize
+			if end > len(keys) {
+				end = len(keys)
+			}
+			pairs := make([]interface{}, 0, 2*(end-i))
+			for j := i; j < end; j++ {
+				pairs = append(pairs, string(keys[j]), values[j])
+			}
+			p.MSet(context.Background(), pairs...)
+		}
+	})
+}
+
+func (s *redisStore) Get(key []byte) ([]byte, bool, error) {
+	v, err := s.client.Get(context.Background(), string(key)).Bytes()
+	if err == redis.Nil {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return v, true, nil
+}
+
+func (s *redisStore) Has(key []byte) (bool, error) {
+	n, err := s.client.Exists(context.Background(), string(key)).Result()
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+// PGet pipelines one MGET per redisBatchSize keys.
+func (s *redisStore) PGet(keys [][]byte) ([][]byte, []bool, error) {
+	if len(keys) == 0 {
+		return nil, nil, nil
+	}
+	ctx := context.Background()
+	p := s.client.Pipeline()
+	var cmds []*redis.SliceCmd
+	for i := 0; i < len(keys); i += redisBatchSize {
+		end := i + redisBatchSize
+		if end > len(keys) {
+			end = len(keys)
+		}
+		batch := make([]string, 0, end-i)
+		for _, k := range keys[i:end] {
+			batch = append(batch, string(k))
+		}
+		cmds = append(cmds, p.MGet(ctx, batch...))
+	}
+	if _, err := p.Exec(ctx); err != nil {
+		return nil, nil, err
+	}
+	values := make([][]byte, 0, len(keys))
+	oks := make([]bool, 0, len(keys))
+	for _, cmd := range cmds {
+		for _, v := range cmd.Val() {
+			// a missing key is nil, any other value a string
+			v, ok := v.(string)
+			if ok {
+				values = append(values, []byte(v))
+			} else {
+				values = append(values, nil)
+			}
+			oks = append(oks, ok)
+		}
+	}
+	return values, oks, nil
+}
+
+func (s *redisStore) Del(key []byte) (bool, error) {
+	if !s.fsync {
+		n, err := s.client.Del(context.Background(), string(key)).Result()
+		if err != nil {
+			return false, err
+		}
+		return n > 0, nil
+	}
+	var del *redis.IntCmd
+	err := s.write(func(p redis.Pipeliner) {
+		del = p.Del(context.Background(), string(key))
+	})
+	if err != nil {
+		return false, err
+	}
+	return del.Val() > 0, nil
+}
+
+// PDel pipelines one DEL per redisBatchSize keys.
+func (s *redisStore) PDel(keys [][]byte) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	return s.write(func(p redis.Pipeliner) {
+		for i := 0; i < len(keys); i += redisBatchSize {
+			end := i + redisBatchSize
+			if end > len(keys) {
+				end = len(keys)
+			}
+			batch := make([]string, 0, end-i)
+			for _, k := range keys[i:end] {
+				batch = append(batch, string(k))
+			}
+			p.Del(context.Background(), batch...)
+		}
+	})
+}
+
+// Keys iterates SCAN with a MATCH of the escaped pattern followed by *, and
+// reads the values with PGet. SCAN visits the keys in hash order.
+func (s *redisStore) Keys(pattern []byte, limit int, withvalues bool) ([][]byte, [][]byte, error) {
+	match := ""
+	if len(pattern) > 0 {
+		match = string(globEscape(pattern)) + "*"
+	}
+	var keys [][]byte
+	it := s.client.Scan(context.Background(), 0, match, respScanCount).Iterator()
+	for it.Next(context.Background()) {
+		if limit > 0 && len(keys) >= limit {
+			break
+		}
+		keys = append(keys, []byte(it.Val()))
+	}
+	if err := it.Err(); err != nil {
+		return nil, nil, err
+	}
+	if !withvalues || len(keys) == 0 {
+		return keys, nil, nil
+	}
+	vals, _, err := s.PGet(keys)
+	if err != nil {
+		return nil, nil, err
+	}
+	return keys, vals, nil
+}
+
+func (s *redisStore) AllKeys(limit int, withvalues bool) ([][]byte, [][]byte, error) {
+	return s.Keys(nil, limit, withvalues)
+}
+
+func (s *redisStore) RangeScan(start, end []byte, limit int, withvalues bool) ([][]byte, [][]byte, error) {
+	return nil, nil, ErrNotSupported
+}
+
+func (s *redisStore) Snapshot() (Snapshot, error) {
+	return nil, ErrNotSupported
+}
+
+// Count sends DBSIZE, which counts every key of the database, not only
+// those written by the benchmark.
+func (s *redisStore) Count() (int64, error) {
+	return s.client.DBSize(context.Background()).Result()
+}
+
+func (s *redisStore) FlushDB() error {
+	return s.client.FlushDB(context.Background()).Err()
+}
+
+func (s *redisStore) Compact() error {
+	return ErrNotSupported
+}
+
+func (s *redisStore) Merge(key, value []byte) error {
+	return mergeWith(s.update, key, value)
+}
+
+// update watches key, reads it and writes the new value in MULTI/EXEC,
+// retried when another client changed key in between. INCRBY would store a
+// counter as decimal text instead.
+func (s *redisStore) update(key []byte, fn updateFunc) error {
+	ctx := context.Background()
+	for {
+		var written bool
+		err := s.client.Watch(ctx, func(tx *redis.Tx) error {
+			old, err := tx.Get(ctx, string(key)).Bytes()
+			if err != nil && err != redis.Nil {
+				return err
+			}
+			v, err := fn(old, err == nil)
+			if err != nil || v == nil {
+				return err
+			}
+			written = true
+			_, err = tx.TxPipelined(ctx, func(p redis.Pipeliner) error {
+				p.Set(ctx, string(key), v, 0)
+				return nil
+			})
+			return err
+		}, string(key))
+		if err == redis.TxFailedErr {
+			continue
+		}
+		if err != nil || !written || !s.fsync {
+			return err
+		}
+		// a pipeline of WAITAOF alone
+		return s.write(func(p redis.Pipeliner) {})
+	}
+}
+
+func (s *redisStore) Incr(key []byte, delta int64) (int64, error) {
+	return incrWith(s.update, key, delta)
+}
+
+func (s *redisStore) CAS(key, oldValue, newValue []byte) (bool, error) {
+	return casWith(s.update, key, oldValue, newValue)
+}
+
+func (s *redisStore) SetWithTTL(key, value []byte, ttl time.Duration) error {
+	if ttl < time.Millisecond {
+		ttl = time.Millisecond
+	}
+	return s.write(func(p redis.Pipeliner) {
+		p.Set(context.Background(), string(key), value, ttl)
+	})
+}
+
+func (s *redisStore) Ping() error {
+	return s.client.Ping(context.Background()).Err()
+}
+
+// Capabilities reports CapPersistent only with fsync, when WAITAOF has
+// confirmed every write.
+func (s *redisStore) Capabilities() Capability {
+	caps := CapKeys | CapTTL | CapCAS | CapMerge
+	if s.fsync {
+		caps |= CapPersistent
+	}
+	return caps
+}
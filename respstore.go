@@ -0,0 +1,408 @@
+package kvbench
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// respPoolSize is the number of idle connections a resp store keeps. More
+// are opened when more goroutines use the store at once.
+const respPoolSize = 128
+
+// respScanCount is the COUNT hint of the SCAN calls made by Keys.
+const respScanCount = 1000
+
+// respError is an error reply of the server. The connection stays usable.
+type respError string
+
+func (e respError) Error() string {
+	return string(e)
+}
+
+// respStore is a client of any server speaking the Redis protocol (RESP),
+// such as Redis, KeyDB, Dragonfly or Garnet. It only uses the commands they
+// all share: GET, SET (with PX), DEL, EXISTS, MGET, MSET, SCAN, DBSIZE,
+// FLUSHDB and PING.
+type respStore struct {
+	addr string
+
+	mu     sync.Mutex
+	closed bool
+	pool   chan *respConn
+}
+
+type respConn struct {
+	c net.Conn
+	r *bufio.Reader
+	w *bufio.Writer
+}
+
+// NewRESPStore returns a store that sends its operations to the RESP server
+// at addr. Connections are opened when first needed.
+func NewRESPStore(addr string) (Store, error) {
+	return &respStore{
+		addr: addr,
+		pool: make(chan *respConn, respPoolSize),
+	}, nil
+}
+
+func (s *respStore) conn() (*respConn, error) {
+	select {
+	case cn := <-s.pool:
+		return cn, nil
+	default:
+	}
+	c, err := net.Dial("tcp", s.addr)
+	if err != nil {
+		return nil, err
+	}
+	return &respConn{c: c, r: bufio.NewReader(c), w: bufio.NewWriter(c)}, nil
+}
+
+// release returns cn to the pool unless err left it in an unknown state.
+func (s *respStore) release(cn *respConn, err error) {
+	var rerr respError
+	if err != nil && !errors.As(err, &rerr) {
+		cn.c.Close()
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		cn.c.Close()
+		return
+	}
+	select {
+	case s.pool <- cn:
+	default:
+		cn.c.Close()
+	}
+}
+
+// do sends a command and returns its reply: a string for a status reply,
+// an int64, a []byte, nil for a null reply or a []interface{} of these.
+func (s *respStore) do(args ...[]byte) (interface{}, error) {
+	cn, err := s.conn()
+	if err != nil {
+		return nil, err
+	}
+	reply, err := cn.do(args)
+	s.release(cn, err)
+	return reply, err
+}
+
+func (cn *respConn) do(args [][]byte) (interface{}, error) {
+	cn.w.WriteString("*")
+	cn.w.WriteString(strconv.Itoa(len(args)))
+	cn.w.WriteString("\r\n")
+	for _, arg := range args {
+		cn.w.WriteString("$")
+		cn.w.WriteString(strconv.Itoa(len(arg)))
+		cn.w.WriteString("\r\n")
+		cn.w.Write(arg)
+		cn.w.WriteString("\r\n")
+	}
+	if err := cn.w.Flush(); err != nil {
+		return nil, err
+	}
+	return cn.read()
+}
+
+func (cn *respConn) line() (string, error) {
+	line, err := cn.r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return "", fmt.Errorf("resp: malformed reply %q", line)
+	}
+	return line[:len(line)-2], nil
+}
+
+func (cn *respConn) read() (interface{}, error) {
+	line, err := cn.line()
+	if err != nil {
+		return nil, err
+	}
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, respError(line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		b := make([]byte, n+2)
+		if _, err := io.ReadFull(cn.r, b); err != nil {
+			return nil, err
+		}
+		return b[:n], nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		a := make([]interface{}, n)
+		// an error inside an array does not end the reply
+		var firstErr error
+		for i := range a {
+			if a[i], err = cn.read(); err != nil {
+				var rerr respError
+				if !errors.As(err, &rerr) {
+					return nil, err
+				}
+				if firstErr == nil {
+					firstErr = err
+				}
+			}
+		}
+		return a, firstErr
+	}
+	return nil, fmt.Errorf("resp: unknown reply type %q", line[0])
+}
+
+func (s *respStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	for {
+		select {
+		case cn := <-s.pool:
+			cn.c.Close()
+		default:
+			return nil
+		}
+	}
+}
+
+func (s *respStore) Set(key, value []byte) error {
+	_, err := s.do([]byte("SET"), key, value)
+	return err
+}
+
+func (s *respStore) SetAsync(key, value []byte, cb func(error)) {
+	cb(s.Set(key, value))
+}
+
+func (s *respStore) PSet(keys, values [][]byte) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	args := make([][]byte, 0, 1+2*len(keys))
+	args = append(args, []byte("MSET"))
+	for i := range keys {
+		args = append(args, keys[i], values[i])
+	}
+	_, err := s.do(args...)
+	return err
+}
+
+func (s *respStore) Get(key []byte) ([]byte, bool, error) {
+	reply, err := s.do([]byte("GET"), key)
+	if err != nil || reply == nil {
+		return nil, false, err
+	}
+	v, ok := reply.([]byte)
+	if !ok {
+		return nil, false, fmt.Errorf("resp: unexpected GET reply %v", reply)
+	}
+	return v, true, nil
+}
+
+func (s *respStore) Has(key []byte) (bool, error) {
+	reply, err := s.do([]byte("EXISTS"), key)
+	if err != nil {
+		return false, err
+	}
+	n, _ := reply.(int64)
+	return n > 0, nil
+}
+
+func (s *respStore) PGet(keys [][]byte) ([][]byte, []bool, error) {
+	if len(keys) == 0 {
+		return nil, nil, nil
+	}
+	args := make([][]byte, 0, 1+len(keys))
+	args = append(args, []byte("MGET"))
+	args = append(args, keys...)
+	reply, err := s.do(args...)
+	if err != nil {
+		return nil, nil, err
+	}
+	a, ok := reply.([]interface{})
+	if !ok || len(a) != len(keys) {
+		return nil, nil, fmt.Errorf("resp: unexpected MGET reply %v", reply)
+	}
+	values := make([][]byte, len(a))
+	oks := make([]bool, len(a))
+	for i := range a {
+		values[i], oks[i] = a[i].([]byte)
+	}
+	return values, oks, nil
+}
+
+func (s *respStore) Del(key []byte) (bool, error) {
+	reply, err := s.do([]byte("DEL"), key)
+	if err != nil {
+		return false, err
+	}
+	n, _ := reply.(int64)
+	return n > 0, nil
+}
+
+// PDel sends one DEL with all the keys.
+func (s *respStore) PDel(keys [][]byte) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	args := make([][]byte, 0, 1+len(keys))
+	args = append(args, []byte("DEL"))
+	args = append(args, keys...)
+	_, err := s.do(args...)
+	return err
+}
+
+// Keys iterates SCAN with a MATCH of the escaped pattern followed by *, and
+// reads the values with MGET. SCAN visits the keys in hash order.
+func (s *respStore) Keys(pattern []byte, limit int, withvalues bool) ([][]byte, [][]byte, error) {
+	var match []byte
+	if len(pattern) > 0 {
+		match = append(globEscape(pattern), '*')
+	}
+	var keys [][]byte
+	cursor := []byte("0")
+	for {
+		args := [][]byte{[]byte("SCAN"), cursor}
+		if match != nil {
+			args = append(args, []byte("MATCH"), match)
+		}
+		args = append(args, []byte("COUNT"), []byte(strconv.Itoa(respScanCount)))
+		reply, err := s.do(args...)
+		if err != nil {
+			return nil, nil, err
+		}
+		a, ok := reply.([]interface{})
+		if !ok || len(a) != 2 {
+			return nil, nil, fmt.Errorf("resp: unexpected SCAN reply %v", reply)
+		}
+		cursor, _ = a[0].([]byte)
+		batch, _ := a[1].([]interface{})
+		for _, k := range batch {
+			if limit > 0 && len(keys) >= limit {
+				break
+			}
+			if k, ok := k.([]byte); ok {
+				keys = append(keys, k)
+			}
+		}
+		if string(cursor) == "0" || limit > 0 && len(keys) >= limit {
+			break
+		}
+	}
+	if !withvalues || len(keys) == 0 {
+		return keys, nil, nil
+	}
+	var vals [][]byte
+	for i := 0; i < len(keys); i += respScanCount {
+		end := i + respScanCount
+		if end > len(keys) {
+			end = len(keys)
+		}
+		values, _, err := s.PGet(keys[i:end])
+		if err != nil {
+			return nil, nil, err
+		}
+		vals = append(vals, values...)
+	}
+	return keys, vals, nil
+}
+
+// globEscape escapes the characters special to the glob patterns of MATCH.
+func globEscape(b []byte) []byte {
+	r := make([]byte, 0, len(b))
+	for _, c := range b {
+		switch c {
+		case '*', '?', '[', ']', '\\':
+			r = append(r, '\\')
+		}
+		r = append(r, c)
+	}
+	return r
+}
+
+func (s *respStore) AllKeys(limit int, withvalues bool) ([][]byte, [][]byte, error) {
+	return s.Keys(nil, limit, withvalues)
+}
+
+func (s *respStore) RangeScan(start, end []byte, limit int, withvalues bool) ([][]byte, [][]byte, error) {
+	return nil, nil, ErrNotSupported
+}
+
+func (s *respStore) Snapshot() (Snapshot, error) {
+	return nil, ErrNotSupported
+}
+
+// Count sends DBSIZE, which counts every key of the database, not only
+// those written by the benchmark.
+func (s *respStore) Count() (int64, error) {
+	reply, err := s.do([]byte("DBSIZE"))
+	if err != nil {
+		return 0, err
+	}
+	n, ok := reply.(int64)
+	if !ok {
+		return 0, fmt.Errorf("resp: unexpected DBSIZE reply %v", reply)
+	}
+	return n, nil
+}
+
+func (s *respStore) FlushDB() error {
+	_, err := s.do([]byte("FLUSHDB"))
+	return err
+}
+
+func (s *respStore) Compact() error {
+	return ErrNotSupported
+}
+
+func (s *respStore) Merge(key, value []byte) error {
+	return ErrNotSupported
+}
+
+func (s *respStore) Incr(key []byte, delta int64) (int64, error) {
+	return 0, ErrNotSupported
+}
+
+func (s *respStore) CAS(key, oldValue, newValue []byte) (bool, error) {
+	return false, ErrNotSupported
+}
+
+// SetWithTTL sends SET with PX, the TTL in milliseconds.
+func (s *respStore) SetWithTTL(key, value []byte, ttl time.Duration) error {
+	ms := ttl.Milliseconds()
+	if ms < 1 {
+		ms = 1
+	}
+	_, err := s.do([]byte("SET"), key, value, []byte("PX"), []byte(strconv.FormatInt(ms, 10)))
+	return err
+}
+
+func (s *respStore) Ping() error {
+	_, err := s.do([]byte("PING"))
+	return err
+}
+
+func (s *respStore) Capabilities() Capability {
+	// all the shared commands promise
+	return CapKeys | CapTTL
+}
@@ -1,126 +1,382 @@
+//go:build rocksdb
+
 package kvbench
 
-//
-//import (
-//	"sync"
-//
-//	rocksdb "github.com/tecbot/gorocksdb"
-//)
-//
-//type rocksdbStore struct {
-//	mu sync.RWMutex
-//	db *rocksdb.DB
-//	ro *rocksdb.ReadOptions
-//	wo *rocksdb.WriteOptions
-//	fo *rocksdb.FlushOptions
-//}
-//
-//func rocksdbKey(key []byte) []byte {
-//	r := make([]byte, len(key)+1)
-//	r[0] = 'k'
-//	copy(r[1:], key)
-//	return r
-//}
-//
-//func NewRocksdbStore(path string, fsync bool) (Store, error) {
-//	if path == ":memory:" {
-//		return nil, ErrMemoryNotAllowed
-//	}
-//
-//	opts := rocksdb.NewDefaultOptions()
-//	opts.SetCreateIfMissing(true)
-//
-//	ro := rocksdb.NewDefaultReadOptions()
-//	ro.SetFillCache(false)
-//
-//	wo := rocksdb.NewDefaultWriteOptions()
-//	wo.SetSync(fsync)
-//
-//	fo := rocksdb.NewDefaultFlushOptions()
-//
-//	db, err := rocksdb.OpenDb(opts, path)
-//	if err != nil {
-//		return nil, err
-//	}
-//
-//	return &rocksdbStore{
-//		db: db,
-//		ro: ro,
-//		wo: wo,
-//		fo: fo,
-//	}, nil
-//}
-//
-//func (s *rocksdbStore) Close() error {
-//	s.db.Close()
-//	return nil
-//}
-//
-//func (s *rocksdbStore) PSet(keys, vals [][]byte) error {
-//	wb := rocksdb.NewWriteBatch()
-//	defer wb.Destroy()
-//
-//	for i, k := range keys {
-//		wb.Put(k, vals[i])
-//	}
-//	return s.db.Write(s.wo, wb)
-//}
-//
-//func (s *rocksdbStore) PGet(keys [][]byte) ([][]byte, []bool, error) {
-//	var vals = make([][]byte, len(keys))
-//	var oks = make([]bool, len(keys))
-//
-//	var err error
-//	for i, k := range keys {
-//		vals[i], err = s.db.GetBytes(s.ro, k)
-//		oks[i] = (err == nil)
-//	}
-//	return vals, oks, err
-//}
-//
-//func (s *rocksdbStore) Set(key, value []byte) error {
-//	return s.db.Put(s.wo, key, value)
-//}
-//
-//func (s *rocksdbStore) Get(key []byte) ([]byte, bool, error) {
-//	v, err := s.db.GetBytes(s.ro, key)
-//	return v, v != nil, err
-//}
-//
-//func (s *rocksdbStore) Del(key []byte) (bool, error) {
-//	err := s.db.Delete(s.wo, key)
-//	return err == nil, err
-//}
-//
-//func (s *rocksdbStore) Keys(pattern []byte, limit int, withvals bool) ([][]byte, [][]byte, error) {
-//	var keys [][]byte
-//	var vals [][]byte
-//
-//	it := s.db.NewIterator(s.ro)
-//	defer it.Close()
-//	it.Seek(pattern)
-//
-//	for it = it; it.Valid(); it.Next() {
-//		key := it.Key()
-//
-//		k := make([]byte, key.Size())
-//		copy(k, key.Data())
-//		key.Free()
-//
-//		keys = append(keys, k)
-//
-//		if withvals {
-//			value := it.Value()
-//			v := make([]byte, value.Size())
-//			copy(v, value.Data())
-//			value.Free()
-//			vals = append(vals, v)
-//		}
-//	}
-//
-//	return keys, vals, nil
-//}
-//
-//func (s *rocksdbStore) FlushDB() error {
-//	return s.db.Flush(s.fo)
-//}
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"sync"
+	"time"
+
+	rocksdb "github.com/tecbot/gorocksdb"
+)
+
+// rocksdbStore is built with -tags rocksdb, as gorocksdb needs cgo and the
+// RocksDB C library. Like leveldbStore, every operation holds the read lock
+// of mu, and Close and FlushDB the write lock while they replace db.
+type rocksdbStore struct {
+	mu   sync.RWMutex
+	db   *rocksdb.DB
+	path string
+	opts *rocksdb.Options
+	ro   *rocksdb.ReadOptions
+	wo   *rocksdb.WriteOptions
+	fo   *rocksdb.FlushOptions
+}
+
+// NewRocksdbStore opens the RocksDB database at path. Without fsync, writes
+// skip the WAL, as with pebble, and Close flushes the memtables instead.
+func NewRocksdbStore(path string, fsync bool) (Store, error) {
+	if path == ":memory:" {
+		return nil, ErrMemoryNotAllowed
+	}
+
+	opts := rocksdb.NewDefaultOptions()
+	opts.SetCreateIfMissing(true)
+	opts.SetMergeOperator(rocksdbAddMerger{})
+
+	ro := rocksdb.NewDefaultReadOptions()
+	ro.SetFillCache(false)
+
+	wo := rocksdb.NewDefaultWriteOptions()
+	wo.SetSync(fsync)
+	wo.DisableWAL(!fsync)
+
+	fo := rocksdb.NewDefaultFlushOptions()
+	fo.SetWait(true)
+
+	db, err := rocksdb.OpenDb(opts, path)
+	if err != nil {
+		return nil, err
+	}
+
+	return &rocksdbStore{
+		db:   db,
+		path: path,
+		opts: opts,
+		ro:   ro,
+		wo:   wo,
+		fo:   fo,
+	}, nil
+}
+
+// Close flushes the memtables first, which hold the only copy of the
+// writes made without the WAL.
+func (s *rocksdbStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	err := s.db.Flush(s.fo)
+	s.db.Close()
+	return err
+}
+
+func (s *rocksdbStore) PSet(keys, vals [][]byte) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	wb := rocksdb.NewWriteBatch()
+	defer wb.Destroy()
+	for i, k := range keys {
+		wb.Put(k, vals[i])
+	}
+	return s.db.Write(s.wo, wb)
+}
+
+func (s *rocksdbStore) PGet(keys [][]byte) ([][]byte, []bool, error) {
+	var values [][]byte
+	var oks []bool
+	for i := range keys {
+		value, ok, err := s.Get(keys[i])
+		if err != nil {
+			return nil, nil, err
+		}
+		values = append(values, value)
+		oks = append(oks, ok)
+	}
+	return values, oks, nil
+}
+
+func (s *rocksdbStore) Set(key, value []byte) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.db.Put(s.wo, key, value)
+}
+
+func (s *rocksdbStore) SetAsync(key, value []byte, cb func(error)) {
+	cb(s.Set(key, value))
+}
+
+func (s *rocksdbStore) Get(key []byte) ([]byte, bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return rocksdbGet(s.db, s.ro, key)
+}
+
+// rocksdbGet reads key with ro, which may pin a snapshot.
+func rocksdbGet(db *rocksdb.DB, ro *rocksdb.ReadOptions, key []byte) ([]byte, bool, error) {
+	v, err := db.Get(ro, key)
+	if err != nil {
+		return nil, false, err
+	}
+	defer v.Free()
+	if !v.Exists() {
+		return nil, false, nil
+	}
+	// the slice is owned by RocksDB until freed
+	return emptyIfNil(bcopy(v.Data())), true, nil
+}
+
+func (s *rocksdbStore) Has(key []byte) (bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	v, err := s.db.Get(s.ro, key)
+	if err != nil {
+		return false, err
+	}
+	defer v.Free()
+	return v.Exists(), nil
+}
+
+func (s *rocksdbStore) Del(key []byte) (bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	v, err := s.db.Get(s.ro, key)
+	if err != nil {
+		return false, err
+	}
+	ok := v.Exists()
+	v.Free()
+	if !ok {
+		return false, nil
+	}
+	if err := s.db.Delete(s.wo, key); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (s *rocksdbStore) PDel(keys [][]byte) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	wb := rocksdb.NewWriteBatch()
+	defer wb.Destroy()
+	for _, k := range keys {
+		wb.Delete(k)
+	}
+	return s.db.Write(s.wo, wb)
+}
+
+func (s *rocksdbStore) Keys(pattern []byte, limit int, withvals bool) ([][]byte, [][]byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	it := s.db.NewIterator(s.ro)
+	defer it.Close()
+	return rocksdbScan(it, pattern, limit, withvals)
+}
+
+func (s *rocksdbStore) AllKeys(limit int, withvals bool) ([][]byte, [][]byte, error) {
+	return s.Keys(nil, limit, withvals)
+}
+
+func (s *rocksdbStore) RangeScan(start, end []byte, limit int, withvals bool) ([][]byte, [][]byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return rocksdbRangeScan(s.db, s.ro, start, end, limit, withvals)
+}
+
+// rocksdbRangeScan scans db with ro, which may pin a snapshot.
+func rocksdbRangeScan(db *rocksdb.DB, ro *rocksdb.ReadOptions, start, end []byte, limit int, withvals bool) ([][]byte, [][]byte, error) {
+	it := db.NewIterator(ro)
+	defer it.Close()
+	var keys [][]byte
+	var vals [][]byte
+	for it.Seek(start); it.Valid(); it.Next() {
+		if limit > 0 && len(keys) >= limit {
+			break
+		}
+		key := it.Key()
+		k := bcopy(key.Data())
+		key.Free()
+		if end != nil && bytes.Compare(k, end) >= 0 {
+			break
+		}
+		keys = append(keys, k)
+		if withvals {
+			value := it.Value()
+			vals = append(vals, emptyIfNil(bcopy(value.Data())))
+			value.Free()
+		}
+	}
+	return keys, vals, it.Err()
+}
+
+// Count iterates the keys. The rocksdb.estimate-num-keys property is only an
+// estimate.
+func (s *rocksdbStore) Count() (int64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	it := s.db.NewIterator(s.ro)
+	defer it.Close()
+	var n int64
+	for it.SeekToFirst(); it.Valid(); it.Next() {
+		n++
+	}
+	return n, it.Err()
+}
+
+// rocksdbScan reads the keys with prefix pattern from it, copying them out
+// of the slices of RocksDB.
+func rocksdbScan(it *rocksdb.Iterator, pattern []byte, limit int, withvals bool) ([][]byte, [][]byte, error) {
+	var keys [][]byte
+	var vals [][]byte
+	for it.Seek(pattern); it.Valid(); it.Next() {
+		if limit > 0 && len(keys) >= limit {
+			break
+		}
+		key := it.Key()
+		k := bcopy(key.Data())
+		key.Free()
+		if !bytes.HasPrefix(k, pattern) {
+			break
+		}
+		keys = append(keys, k)
+		if withvals {
+			value := it.Value()
+			vals = append(vals, emptyIfNil(bcopy(value.Data())))
+			value.Free()
+		}
+	}
+	return keys, vals, it.Err()
+}
+
+// FlushDB closes the database, removes its files and opens it again.
+func (s *rocksdbStore) FlushDB() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.db.Close()
+	if err := os.RemoveAll(s.path); err != nil {
+		return err
+	}
+	db, err := rocksdb.OpenDb(s.opts, s.path)
+	if err != nil {
+		return err
+	}
+	s.db = db
+	return nil
+}
+
+func (s *rocksdbStore) Compact() error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	s.db.CompactRange(rocksdb.Range{})
+	return nil
+}
+
+func (s *rocksdbStore) Merge(key, value []byte) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.db.Merge(s.wo, key, value)
+}
+
+// update reads and writes key under the write lock of mu, which the other
+// operations wait for.
+func (s *rocksdbStore) update(key []byte, fn updateFunc) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	old, err := s.db.Get(s.ro, key)
+	if err != nil {
+		return err
+	}
+	v, err := fn(old.Data(), old.Exists())
+	old.Free()
+	if err != nil || v == nil {
+		return err
+	}
+	return s.db.Put(s.wo, key, v)
+}
+
+func (s *rocksdbStore) Incr(key []byte, delta int64) (int64, error) {
+	return incrWith(s.update, key, delta)
+}
+
+func (s *rocksdbStore) CAS(key, oldValue, newValue []byte) (bool, error) {
+	return casWith(s.update, key, oldValue, newValue)
+}
+
+// SetWithTTL is not supported: RocksDB only expires a whole database opened
+// with a TTL, not single keys.
+func (s *rocksdbStore) SetWithTTL(key, value []byte, ttl time.Duration) error {
+	return ErrNotSupported
+}
+
+// rocksdbAddMerger is the merge operator of Merge, which adds big-endian
+// uint64 counters. A malformed operand fails the merge.
+type rocksdbAddMerger struct{}
+
+func (rocksdbAddMerger) Name() string {
+	return "kvbench.add"
+}
+
+func (rocksdbAddMerger) FullMerge(key, existing []byte, operands [][]byte) ([]byte, bool) {
+	var sum uint64
+	if existing != nil {
+		if len(existing) != 8 {
+			return nil, false
+		}
+		sum = binary.BigEndian.Uint64(existing)
+	}
+	for _, op := range operands {
+		if len(op) != 8 {
+			return nil, false
+		}
+		sum += binary.BigEndian.Uint64(op)
+	}
+	r := make([]byte, 8)
+	binary.BigEndian.PutUint64(r, sum)
+	return r, true
+}
+
+func (m rocksdbAddMerger) PartialMerge(key, left, right []byte) ([]byte, bool) {
+	return m.FullMerge(key, left, [][]byte{right})
+}
+
+func (s *rocksdbStore) Ping() error {
+	return nil
+}
+
+// rocksdbSnapshot reads through read options of its own that pin a RocksDB
+// snapshot. It keeps the db it was taken from, so it must be closed before
+// FlushDB or Close.
+type rocksdbSnapshot struct {
+	db   *rocksdb.DB
+	snap *rocksdb.Snapshot
+	ro   *rocksdb.ReadOptions
+}
+
+func (s *rocksdbStore) Snapshot() (Snapshot, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	snap := s.db.NewSnapshot()
+	ro := rocksdb.NewDefaultReadOptions()
+	ro.SetFillCache(false)
+	ro.SetSnapshot(snap)
+	return rocksdbSnapshot{s.db, snap, ro}, nil
+}
+
+func (s rocksdbSnapshot) Get(key []byte) ([]byte, bool, error) {
+	return rocksdbGet(s.db, s.ro, key)
+}
+
+func (s rocksdbSnapshot) RangeScan(start, end []byte, limit int, withvals bool) ([][]byte, [][]byte, error) {
+	return rocksdbRangeScan(s.db, s.ro, start, end, limit, withvals)
+}
+
+func (s rocksdbSnapshot) Close() error {
+	s.ro.Destroy()
+	s.db.ReleaseSnapshot(s.snap)
+	return nil
+}
+
+func (s *rocksdbStore) Capabilities() Capability {
+	return CapKeys | CapOrdered | CapCompact | CapPersistent | CapSnapshots | CapCAS | CapMerge
+}
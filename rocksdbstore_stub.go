@@ -0,0 +1,14 @@
+//go:build !rocksdb
+
+package kvbench
+
+import "errors"
+
+// errRocksDBNotBuilt is returned by NewRocksdbStore in the default build.
+var errRocksDBNotBuilt = errors.New("rocksdb support not built in, rebuild with -tags rocksdb and the RocksDB C library installed")
+
+// NewRocksdbStore fails unless built with -tags rocksdb, so the default
+// build needs neither cgo nor RocksDB.
+func NewRocksdbStore(path string, fsync bool) (Store, error) {
+	return nil, errRocksDBNotBuilt
+}
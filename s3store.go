@@ -0,0 +1,319 @@
+package kvbench
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// s3PutConcurrency bounds the PutObject calls in flight for one PSet.
+const s3PutConcurrency = 16
+
+// s3Store keeps each entry in an object of an S3 compatible bucket. Object
+// names are the prefix followed by the key in hex, since keys are binary,
+// which keeps both key order and key prefixes.
+type s3Store struct {
+	client *minio.Client
+	bucket string
+	prefix string
+}
+
+// NewS3Store stores entries under prefix in bucket, creating the bucket if
+// needed. endpoint is a host:port, with an http:// scheme to connect without
+// TLS. Credentials are taken from AWS_ACCESS_KEY_ID/AWS_SECRET_ACCESS_KEY or
+// MINIO_ROOT_USER/MINIO_ROOT_PASSWORD.
+func NewS3Store(bucket, prefix string, endpoint string) (Store, error) {
+	secure := true
+	if strings.HasPrefix(endpoint, "http://") {
+		secure = false
+		endpoint = strings.TrimPrefix(endpoint, "http://")
+	}
+	endpoint = strings.TrimPrefix(endpoint, "https://")
+	client, err := minio.New(endpoint, &minio.Options{
+		Creds: credentials.NewChainCredentials([]credentials.Provider{
+			&credentials.EnvAWS{},
+			&credentials.EnvMinio{},
+		}),
+		Secure: secure,
+	})
+	if err != nil {
+		return nil, err
+	}
+	ctx := context.Background()
+	exists, err := client.BucketExists(ctx, bucket)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		if err := client.MakeBucket(ctx, bucket, minio.MakeBucketOptions{}); err != nil {
+			return nil, err
+		}
+	}
+	return &s3Store{
+		client: client,
+		bucket: bucket,
+		prefix: prefix,
+	}, nil
+}
+
+func (s *s3Store) object(key []byte) string {
+	return s.prefix + hex.EncodeToString(key)
+}
+
+func isNoSuchKey(err error) bool {
+	return minio.ToErrorResponse(err).Code == "NoSuchKey"
+}
+
+func (s *s3Store) Close() error {
+	return nil
+}
+
+func (s *s3Store) Set(key, value []byte) error {
+	_, err := s.client.PutObject(context.Background(), s.bucket, s.object(key),
+		bytes.NewReader(value), int64(len(value)), minio.PutObjectOptions{})
+	return err
+}
+
+func (s *s3Store) SetAsync(key, value []byte, cb func(error)) {
+	cb(s.Set(key, value))
+}
+
+func (s *s3Store) PSet(keys, values [][]byte) error {
+	var wg sync.WaitGroup
+	var once sync.Once
+	var firstErr error
+	sem := make(chan struct{}, s3PutConcurrency)
+	for i := range keys {
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(i int) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			if err := s.Set(keys[i], values[i]); err != nil {
+				once.Do(func() { firstErr = err })
+			}
+		}(i)
+	}
+	wg.Wait()
+	return firstErr
+}
+
+func (s *s3Store) Get(key []byte) ([]byte, bool, error) {
+	obj, err := s.client.GetObject(context.Background(), s.bucket, s.object(key), minio.GetObjectOptions{})
+	if err != nil {
+		return nil, false, err
+	}
+	defer obj.Close()
+	v, err := io.ReadAll(obj)
+	if err != nil {
+		if isNoSuchKey(err) {
+			return nil, false, nil
+		}
+		return nil, false, err
+	}
+	return emptyIfNil(v), true, nil
+}
+
+// Has stats the object, which does not transfer its content.
+func (s *s3Store) Has(key []byte) (bool, error) {
+	_, err := s.client.StatObject(context.Background(), s.bucket, s.object(key), minio.StatObjectOptions{})
+	if err != nil {
+		if isNoSuchKey(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+func (s *s3Store) PGet(keys [][]byte) ([][]byte, []bool, error) {
+	var values [][]byte
+	var oks []bool
+	for i := range keys {
+		value, ok, err := s.Get(keys[i])
+		if err != nil {
+			return nil, nil, err
+		}
+		values = append(values, value)
+		oks = append(oks, ok)
+	}
+	return values, oks, nil
+}
+
+// Del stats the object first, because RemoveObject succeeds whether or not
+// it exists.
+func (s *s3Store) Del(key []byte) (bool, error) {
+	ctx := context.Background()
+	name := s.object(key)
+	if _, err := s.client.StatObject(ctx, s.bucket, name, minio.StatObjectOptions{}); err != nil {
+		if isNoSuchKey(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, s.client.RemoveObject(ctx, s.bucket, name, minio.RemoveObjectOptions{})
+}
+
+// PDel removes the objects with multi-object delete requests, which do not
+// fail for missing objects.
+func (s *s3Store) PDel(keys [][]byte) error {
+	objects := make(chan minio.ObjectInfo, len(keys))
+	for _, k := range keys {
+		objects <- minio.ObjectInfo{Key: s.object(k)}
+	}
+	close(objects)
+	var err error
+	for rerr := range s.client.RemoveObjects(context.Background(), s.bucket, objects, minio.RemoveObjectsOptions{}) {
+		if err == nil {
+			err = rerr.Err
+		}
+	}
+	return err
+}
+
+func (s *s3Store) Keys(pattern []byte, limit int, withvalues bool) ([][]byte, [][]byte, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var keys [][]byte
+	var vals [][]byte
+	for info := range s.client.ListObjects(ctx, s.bucket, minio.ListObjectsOptions{
+		Prefix:    s.object(pattern),
+		Recursive: true,
+	}) {
+		if info.Err != nil {
+			return nil, nil, info.Err
+		}
+		if limit > 0 && len(keys) >= limit {
+			break
+		}
+		key, err := hex.DecodeString(strings.TrimPrefix(info.Key, s.prefix))
+		if err != nil {
+			// not written by this store
+			continue
+		}
+		if withvalues {
+			v, ok, err := s.Get(key)
+			if err != nil {
+				return nil, nil, err
+			}
+			if !ok {
+				// deleted since it was listed
+				continue
+			}
+			vals = append(vals, v)
+		}
+		keys = append(keys, key)
+	}
+	return keys, vals, nil
+}
+
+func (s *s3Store) AllKeys(limit int, withvalues bool) ([][]byte, [][]byte, error) {
+	return s.Keys(nil, limit, withvalues)
+}
+
+// RangeScan lists the objects after the name of start without its last hex
+// digit, so that start itself is listed, and skips the few keys before start.
+// Hex digits sort as the bytes they encode, so the listing is in key order.
+func (s *s3Store) RangeScan(start, end []byte, limit int, withvalues bool) ([][]byte, [][]byte, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	opts := minio.ListObjectsOptions{Prefix: s.prefix, Recursive: true}
+	if len(start) > 0 {
+		name := s.object(start)
+		opts.StartAfter = name[:len(name)-1]
+	}
+	var keys [][]byte
+	var vals [][]byte
+	for info := range s.client.ListObjects(ctx, s.bucket, opts) {
+		if info.Err != nil {
+			return nil, nil, info.Err
+		}
+		if limit > 0 && len(keys) >= limit {
+			break
+		}
+		key, err := hex.DecodeString(strings.TrimPrefix(info.Key, s.prefix))
+		if err != nil || bytes.Compare(key, start) < 0 {
+			continue
+		}
+		if end != nil && bytes.Compare(key, end) >= 0 {
+			break
+		}
+		if withvalues {
+			v, ok, err := s.Get(key)
+			if err != nil {
+				return nil, nil, err
+			}
+			if !ok {
+				continue
+			}
+			vals = append(vals, v)
+		}
+		keys = append(keys, key)
+	}
+	return keys, vals, nil
+}
+
+func (s *s3Store) Snapshot() (Snapshot, error) {
+	return nil, ErrNotSupported
+}
+
+// Count lists every object of the prefix.
+func (s *s3Store) Count() (int64, error) {
+	keys, _, err := s.AllKeys(0, false)
+	return int64(len(keys)), err
+}
+
+func (s *s3Store) FlushDB() error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	objects := s.client.ListObjects(ctx, s.bucket, minio.ListObjectsOptions{
+		Prefix:    s.prefix,
+		Recursive: true,
+	})
+	for err := range s.client.RemoveObjects(ctx, s.bucket, objects, minio.RemoveObjectsOptions{}) {
+		return err.Err
+	}
+	return nil
+}
+
+func (s *s3Store) Compact() error {
+	return ErrNotSupported
+}
+
+func (s *s3Store) Merge(key, value []byte) error {
+	return ErrNotSupported
+}
+
+func (s *s3Store) Incr(key []byte, delta int64) (int64, error) {
+	return 0, ErrNotSupported
+}
+
+func (s *s3Store) CAS(key, oldValue, newValue []byte) (bool, error) {
+	return false, ErrNotSupported
+}
+
+// SetWithTTL is not supported: S3 expires objects by bucket lifecycle
+// rules, in days.
+func (s *s3Store) SetWithTTL(key, value []byte, ttl time.Duration) error {
+	return ErrNotSupported
+}
+
+// Ping checks that the bucket exists, a round trip to the endpoint.
+func (s *s3Store) Ping() error {
+	_, err := s.client.BucketExists(context.Background(), s.bucket)
+	return err
+}
+
+func (s *s3Store) Capabilities() Capability {
+	// objects are listed in name order
+	return CapKeys | CapOrdered | CapPersistent
+}
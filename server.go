@@ -1,18 +1,32 @@
 package kvbench
 
 import (
+	"bytes"
+	"encoding/binary"
 	"errors"
 	"fmt"
+	"net"
 	"os"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/tidwall/redcon"
 	"github.com/tidwall/redlog"
 )
 
 var ErrMemoryNotAllowed = errors.New(":memory: path not available")
+var ErrDiskNotAllowed = errors.New("only :memory: path available")
 var ErrNotSupported = errors.New("not supported")
+
+// ErrFsyncNotAllowed is returned by the stores that cannot sync their writes
+// before they return.
+var ErrFsyncNotAllowed = errors.New("fsync not available")
+
+// ErrNotCounter is returned by Incr when key holds a value that is not an
+// 8-byte counter.
+var ErrNotCounter = errors.New("value is not an 8-byte counter")
+
 var log = redlog.New(os.Stderr, nil)
 
 type Options struct {
@@ -21,6 +35,8 @@ type Options struct {
 	Fsync bool
 	Path  string
 	Log   *redlog.Logger
+	// GRPC serves the kvpb.KV gRPC service instead of the Redis protocol.
+	GRPC bool
 }
 
 type Store interface {
@@ -28,10 +44,78 @@ type Store interface {
 	Set(key, value []byte) error
 	PSet(keys, values [][]byte) error
 	Get(key []byte) ([]byte, bool, error)
+	// Has reports whether key is present without reading its value, where
+	// the store can tell.
+	Has(key []byte) (bool, error)
 	PGet(keys [][]byte) ([][]byte, []bool, error)
 	Del(key []byte) (bool, error)
+	// PDel deletes keys, present or not, in one batch where the store has
+	// batched writes, and one by one otherwise.
+	PDel(keys [][]byte) error
 	Keys(pattern []byte, limit int, withvalues bool) ([][]byte, [][]byte, error)
+	// AllKeys returns up to limit keys, all of them if limit <= 0, in
+	// whatever order the store enumerates them. Unlike Keys it does not need
+	// ordered keys, so hash-based stores support it too.
+	AllKeys(limit int, withvalues bool) ([][]byte, [][]byte, error)
+	// RangeScan returns up to limit keys, all of them if limit <= 0, from
+	// start up to but not including end, with the store's own range
+	// iterator. A nil end has no upper bound. Stores without ordered keys
+	// return ErrNotSupported.
+	RangeScan(start, end []byte, limit int, withvalues bool) ([][]byte, [][]byte, error)
+	// Snapshot opens a consistent point-in-time view of the store with its
+	// native snapshot or read transaction. Stores without snapshots return
+	// ErrNotSupported.
+	Snapshot() (Snapshot, error)
+	// Count returns the number of keys, from the store's own statistics
+	// where they are exact and by iterating the keys otherwise.
+	Count() (int64, error)
+	// FlushDB removes every key, those of namespaces included, and leaves
+	// the store open and empty, as a new one. Stores that cannot remove
+	// their keys return ErrNotSupported.
 	FlushDB() error
+	// Compact reclaims space held by deleted or overwritten entries.
+	// Stores without an explicit compaction step return ErrNotSupported.
+	Compact() error
+	// SetAsync writes key without waiting for the commit and reports the
+	// outcome through cb. key and value must not be modified until cb has
+	// been called. Stores without asynchronous commits call Set and invoke
+	// cb before returning.
+	SetAsync(key, value []byte, cb func(error))
+	// Merge adds value, a big-endian uint64, to the counter at key with the
+	// store's merge operator, without reading the counter first. Stores
+	// without merge operators read, add and write back atomically, as Incr
+	// does. A missing key counts as 0. Stores that cannot update a key
+	// atomically return ErrNotSupported.
+	Merge(key, value []byte) error
+	// Incr atomically adds delta to the big-endian int64 counter at key and
+	// returns the new value. A missing key counts as 0. Stores without an
+	// atomic increment read and write the counter in a transaction or under
+	// a lock. Stores that can do neither return ErrNotSupported.
+	Incr(key []byte, delta int64) (int64, error)
+	// CAS sets key to newValue only if its current value equals oldValue,
+	// or if oldValue is nil and key is absent, and reports whether it did.
+	// Stores check and write in a transaction or under a lock like Incr.
+	// Stores that can do neither return ErrNotSupported.
+	CAS(key, oldValue, newValue []byte) (bool, error)
+	// SetWithTTL writes key to expire after ttl, with the store's own
+	// expiration. Stores without expiring entries return ErrNotSupported.
+	SetWithTTL(key, value []byte, ttl time.Duration) error
+	// Ping checks that the store is reachable. Networked stores make a
+	// round trip, embedded ones return nil.
+	Ping() error
+	// Capabilities returns the features of the store.
+	Capabilities() Capability
+}
+
+// Snapshot is a view of a store as of Store.Snapshot: writes made after it
+// was opened are not seen. It must be closed, as it may pin old versions of
+// the data or hold back writes, as an open Bolt read transaction does when
+// the file has to grow.
+type Snapshot interface {
+	Get(key []byte) ([]byte, bool, error)
+	// RangeScan is Store.RangeScan within the snapshot.
+	RangeScan(start, end []byte, limit int, withvalues bool) ([][]byte, [][]byte, error)
+	Close() error
 }
 
 func Start(opts Options) error {
@@ -86,11 +170,11 @@ func Start(opts Options) error {
 			path = "buntdb.db"
 		}
 		store, err = NewBuntdbStore(path, fsync)
-	//case "rocksdb":
-	//	if path == "" {
-	//		path = "rocksdb.db"
-	//	}
-	//	store, err = NewRocksdbStore(path, fsync)
+	case "rocksdb":
+		if path == "" {
+			path = "rocksdb.db"
+		}
+		store, err = NewRocksdbStore(path, fsync)
 	case "pebble":
 		if path == "" {
 			path = "pebble.db"
@@ -106,6 +190,21 @@ func Start(opts Options) error {
 			path = "nutsdb.db"
 		}
 		store, err = NewNutsdbStore(path, fsync)
+	case "hlog":
+		if path == "" {
+			path = "hlog.db"
+		}
+		store, err = NewHybridLogStore(path, fsync)
+	case "sqlite":
+		if path == "" {
+			path = "sqlite.db"
+		}
+		store, err = NewSQLiteStore(path, fsync)
+	case "memdb":
+		if path == "" {
+			path = ":memory:"
+		}
+		store, err = NewMemdbStore(path, fsync)
 	}
 
 	if err != nil {
@@ -114,6 +213,14 @@ func Start(opts Options) error {
 
 	defer store.Close()
 	log.Printf("store type: %v, fsync: %v", which, fsync)
+	if opts.GRPC {
+		ln, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+		if err != nil {
+			return err
+		}
+		log.Printf("started grpc server on port %d", port)
+		return NewGRPCServer(store).Serve(ln)
+	}
 	var srv *redcon.Server
 	srv = redcon.NewServer(fmt.Sprintf(":%d", port),
 		func(conn redcon.Conn, cmd redcon.Command) {
@@ -347,6 +454,76 @@ func bcopy(b []byte) []byte {
 	copy(r, b)
 	return r
 }
+
+// addCounter adds delta to old, the 8-byte counter at a key or nil if !ok,
+// and returns the sum and its encoding.
+func addCounter(old []byte, ok bool, delta int64) (int64, []byte, error) {
+	var n int64
+	if ok {
+		if len(old) != 8 {
+			return 0, nil, ErrNotCounter
+		}
+		n = int64(binary.BigEndian.Uint64(old))
+	}
+	n += delta
+	v := make([]byte, 8)
+	binary.BigEndian.PutUint64(v, uint64(n))
+	return n, v, nil
+}
+
+// updateFunc returns the new value of a key from its current value, old, or
+// nil if !ok. It returns nil to leave the key alone.
+type updateFunc func(old []byte, ok bool) ([]byte, error)
+
+// incrWith adds delta to the counter at key with the update method of a
+// store, which calls fn with the current value of key and writes what it
+// returns, atomically.
+func incrWith(update func(key []byte, fn updateFunc) error, key []byte, delta int64) (int64, error) {
+	var n int64
+	err := update(key, func(old []byte, ok bool) ([]byte, error) {
+		var v []byte
+		var err error
+		n, v, err = addCounter(old, ok, delta)
+		return v, err
+	})
+	return n, err
+}
+
+// mergeWith adds value, a big-endian uint64, to the counter at key with the
+// update method of a store, for the stores without a merge operator.
+func mergeWith(update func(key []byte, fn updateFunc) error, key, value []byte) error {
+	if len(value) != 8 {
+		return ErrNotCounter
+	}
+	_, err := incrWith(update, key, int64(binary.BigEndian.Uint64(value)))
+	return err
+}
+
+// casWith swaps the value at key with the update method of a store, like
+// incrWith. update may call fn again after a conflict, so it decides afresh
+// each time.
+func casWith(update func(key []byte, fn updateFunc) error, key, oldValue, newValue []byte) (bool, error) {
+	var swapped bool
+	err := update(key, func(cur []byte, ok bool) ([]byte, error) {
+		swapped = false
+		if oldValue == nil && ok || oldValue != nil && (!ok || !bytes.Equal(cur, oldValue)) {
+			return nil, nil
+		}
+		swapped = true
+		return emptyIfNil(newValue), nil
+	})
+	return swapped && err == nil, err
+}
+
+// emptyIfNil returns b, or an empty non-nil slice when b is nil. Stores use it
+// so that a key holding an empty (or nil) value still reads back as present.
+func emptyIfNil(b []byte) []byte {
+	if b == nil {
+		return []byte{}
+	}
+	return b
+}
+
 func wrongArgs(conn redcon.Conn, cmd []byte) {
 	conn.WriteError(
 		"ERR wrong number of arguments for '" + string(cmd) + "' command")
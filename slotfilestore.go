@@ -0,0 +1,387 @@
+package kvbench
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/fnv"
+	"os"
+	"sync"
+	"time"
+)
+
+// slotFileSlots is the number of slots of a new slotted file. The file is
+// sparse, so only the slots written take up disk.
+const slotFileSlots = 1 << 23
+
+// slot header: state, key length, value length
+const slotHeaderSize = 1 + 2 + 4
+
+const (
+	slotEmpty byte = iota
+	slotUsed
+	slotDeleted
+)
+
+// ErrSlotTooSmall is returned by a slotted file store for a key and value
+// that do not fit in one slot.
+var ErrSlotTooSmall = errors.New("record does not fit in a slot")
+
+// ErrSlotFileFull is returned by a slotted file store when every slot is
+// taken.
+var ErrSlotFileFull = errors.New("slotted file is full")
+
+// slotFileStore keeps fixed-size records in one preallocated file, at the
+// slot given by the hash of the key, with linear probing on collisions.
+// There is no index in memory and no cache of its own: every probe is a
+// pread and every write a pwrite at the slot's offset, so it shows the
+// syscall and page cache cost the real databases build their indexes on.
+type slotFileStore struct {
+	mu       sync.RWMutex
+	f        *os.File
+	fsync    bool
+	slotSize int
+	slots    int64
+}
+
+// NewSlottedFileStore opens or creates the slotted file at path. A record
+// takes one slot of slotSize bytes, including a 7 byte header.
+func NewSlottedFileStore(path string, slotSize int, fsync bool) (Store, error) {
+	if path == ":memory:" {
+		return nil, ErrMemoryNotAllowed
+	}
+	if slotSize <= slotHeaderSize {
+		return nil, ErrSlotTooSmall
+	}
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0666)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	slots := fi.Size() / int64(slotSize)
+	if fi.Size() == 0 || fi.Size()%int64(slotSize) != 0 {
+		slots = slotFileSlots
+		if err := f.Truncate(slots * int64(slotSize)); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+	return &slotFileStore{
+		f:        f,
+		fsync:    fsync,
+		slotSize: slotSize,
+		slots:    slots,
+	}, nil
+}
+
+func (s *slotFileStore) home(key []byte) int64 {
+	h := fnv.New64a()
+	h.Write(key)
+	return int64(h.Sum64() % uint64(s.slots))
+}
+
+// find probes the slots from the home slot of key. It returns the slot
+// holding key, or -1 and the first free slot on the way, or -1, -1 if the
+// key is missing and the file is full. buf receives the record of a found
+// slot.
+func (s *slotFileStore) find(key, buf []byte) (found, free int64, err error) {
+	free = -1
+	slot := s.home(key)
+	for i := int64(0); i < s.slots; i++ {
+		if _, err := s.f.ReadAt(buf, slot*int64(s.slotSize)); err != nil {
+			return -1, -1, err
+		}
+		switch buf[0] {
+		case slotEmpty:
+			if free < 0 {
+				free = slot
+			}
+			return -1, free, nil
+		case slotDeleted:
+			if free < 0 {
+				free = slot
+			}
+		case slotUsed:
+			klen := int(binary.BigEndian.Uint16(buf[1:]))
+			if string(buf[slotHeaderSize:slotHeaderSize+klen]) == string(key) {
+				return slot, -1, nil
+			}
+		}
+		if slot++; slot == s.slots {
+			slot = 0
+		}
+	}
+	return -1, free, nil
+}
+
+func (s *slotFileStore) set(key, value, buf []byte) error {
+	if slotHeaderSize+len(key)+len(value) > s.slotSize || len(key) > 0xffff {
+		return ErrSlotTooSmall
+	}
+	found, free, err := s.find(key, buf)
+	if err != nil {
+		return err
+	}
+	slot := found
+	if slot < 0 {
+		slot = free
+	}
+	if slot < 0 {
+		return ErrSlotFileFull
+	}
+	rec := buf[:slotHeaderSize+len(key)+len(value)]
+	rec[0] = slotUsed
+	binary.BigEndian.PutUint16(rec[1:], uint16(len(key)))
+	binary.BigEndian.PutUint32(rec[3:], uint32(len(value)))
+	copy(rec[slotHeaderSize:], key)
+	copy(rec[slotHeaderSize+len(key):], value)
+	_, err = s.f.WriteAt(rec, slot*int64(s.slotSize))
+	return err
+}
+
+func (s *slotFileStore) commit() error {
+	if !s.fsync {
+		return nil
+	}
+	return s.f.Sync()
+}
+
+func (s *slotFileStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.f.Close()
+}
+
+func (s *slotFileStore) PSet(keys, values [][]byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	buf := make([]byte, s.slotSize)
+	for i := range keys {
+		if err := s.set(keys[i], values[i], buf); err != nil {
+			return err
+		}
+	}
+	return s.commit()
+}
+
+func (s *slotFileStore) PGet(keys [][]byte) ([][]byte, []bool, error) {
+	var values [][]byte
+	var oks []bool
+	for i := range keys {
+		value, ok, err := s.Get(keys[i])
+		if err != nil {
+			return nil, nil, err
+		}
+		values = append(values, value)
+		oks = append(oks, ok)
+	}
+	return values, oks, nil
+}
+
+func (s *slotFileStore) Set(key, value []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.set(key, value, make([]byte, s.slotSize)); err != nil {
+		return err
+	}
+	return s.commit()
+}
+
+func (s *slotFileStore) SetAsync(key, value []byte, cb func(error)) {
+	cb(s.Set(key, value))
+}
+
+func (s *slotFileStore) Get(key []byte) ([]byte, bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	buf := make([]byte, s.slotSize)
+	found, _, err := s.find(key, buf)
+	if err != nil || found < 0 {
+		return nil, false, err
+	}
+	klen := int(binary.BigEndian.Uint16(buf[1:]))
+	vlen := int(binary.BigEndian.Uint32(buf[3:]))
+	off := slotHeaderSize + klen
+	return buf[off : off+vlen], true, nil
+}
+
+func (s *slotFileStore) Has(key []byte) (bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	found, _, err := s.find(key, make([]byte, s.slotSize))
+	return found >= 0, err
+}
+
+// Del marks the slot of key deleted, so probes for other keys continue past
+// it, with a one byte pwrite.
+func (s *slotFileStore) Del(key []byte) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	found, _, err := s.find(key, make([]byte, s.slotSize))
+	if err != nil || found < 0 {
+		return false, err
+	}
+	if _, err := s.f.WriteAt([]byte{slotDeleted}, found*int64(s.slotSize)); err != nil {
+		return false, err
+	}
+	return true, s.commit()
+}
+
+// PDel marks the slots of the present keys deleted and syncs once.
+func (s *slotFileStore) PDel(keys [][]byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	buf := make([]byte, s.slotSize)
+	for _, k := range keys {
+		found, _, err := s.find(k, buf)
+		if err != nil {
+			return err
+		}
+		if found < 0 {
+			continue
+		}
+		if _, err := s.f.WriteAt([]byte{slotDeleted}, found*int64(s.slotSize)); err != nil {
+			return err
+		}
+	}
+	return s.commit()
+}
+
+// Keys is not supported: the slots are in hash order, so a prefix scan would
+// read the whole file.
+func (s *slotFileStore) Keys(pattern []byte, limit int, withvalues bool) ([][]byte, [][]byte, error) {
+	return nil, nil, ErrNotSupported
+}
+
+// AllKeys reads the slots in file order.
+func (s *slotFileStore) AllKeys(limit int, withvalues bool) ([][]byte, [][]byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var keys [][]byte
+	var vals [][]byte
+	// read a few thousand slots per syscall
+	chunk := make([]byte, s.slotSize*4096)
+	for first := int64(0); first < s.slots; first += 4096 {
+		n := s.slots - first
+		if n > 4096 {
+			n = 4096
+		}
+		if _, err := s.f.ReadAt(chunk[:n*int64(s.slotSize)], first*int64(s.slotSize)); err != nil {
+			return nil, nil, err
+		}
+		for i := int64(0); i < n; i++ {
+			if limit > 0 && len(keys) >= limit {
+				return keys, vals, nil
+			}
+			rec := chunk[i*int64(s.slotSize):]
+			if rec[0] != slotUsed {
+				continue
+			}
+			klen := int(binary.BigEndian.Uint16(rec[1:]))
+			vlen := int(binary.BigEndian.Uint32(rec[3:]))
+			keys = append(keys, bcopy(rec[slotHeaderSize:slotHeaderSize+klen]))
+			if withvalues {
+				vals = append(vals, bcopy(rec[slotHeaderSize+klen:slotHeaderSize+klen+vlen]))
+			}
+		}
+	}
+	return keys, vals, nil
+}
+
+func (s *slotFileStore) RangeScan(start, end []byte, limit int, withvalues bool) ([][]byte, [][]byte, error) {
+	return nil, nil, ErrNotSupported
+}
+
+func (s *slotFileStore) Snapshot() (Snapshot, error) {
+	return nil, ErrNotSupported
+}
+
+// Count reads every slot, as AllKeys does, and counts the used ones.
+func (s *slotFileStore) Count() (int64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var n int64
+	chunk := make([]byte, s.slotSize*4096)
+	for first := int64(0); first < s.slots; first += 4096 {
+		m := s.slots - first
+		if m > 4096 {
+			m = 4096
+		}
+		if _, err := s.f.ReadAt(chunk[:m*int64(s.slotSize)], first*int64(s.slotSize)); err != nil {
+			return 0, err
+		}
+		for i := int64(0); i < m; i++ {
+			if chunk[i*int64(s.slotSize)] == slotUsed {
+				n++
+			}
+		}
+	}
+	return n, nil
+}
+
+// FlushDB truncates the file and extends it again, which zeroes every slot.
+func (s *slotFileStore) FlushDB() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.f.Truncate(0); err != nil {
+		return err
+	}
+	return s.f.Truncate(s.slots * int64(s.slotSize))
+}
+
+func (s *slotFileStore) Compact() error {
+	return ErrNotSupported
+}
+
+func (s *slotFileStore) Merge(key, value []byte) error {
+	return mergeWith(s.update, key, value)
+}
+
+// update reads and writes the slot of key under the write lock.
+func (s *slotFileStore) update(key []byte, fn updateFunc) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	buf := make([]byte, s.slotSize)
+	found, _, err := s.find(key, buf)
+	if err != nil {
+		return err
+	}
+	var old []byte
+	if found >= 0 {
+		klen := int(binary.BigEndian.Uint16(buf[1:]))
+		vlen := int(binary.BigEndian.Uint32(buf[3:]))
+		off := slotHeaderSize + klen
+		old = buf[off : off+vlen]
+	}
+	v, err := fn(old, found >= 0)
+	if err != nil || v == nil {
+		return err
+	}
+	if err := s.set(key, v, buf); err != nil {
+		return err
+	}
+	return s.commit()
+}
+
+func (s *slotFileStore) Incr(key []byte, delta int64) (int64, error) {
+	return incrWith(s.update, key, delta)
+}
+
+func (s *slotFileStore) CAS(key, oldValue, newValue []byte) (bool, error) {
+	return casWith(s.update, key, oldValue, newValue)
+}
+
+func (s *slotFileStore) SetWithTTL(key, value []byte, ttl time.Duration) error {
+	return ErrNotSupported
+}
+
+func (s *slotFileStore) Ping() error {
+	return nil
+}
+
+func (s *slotFileStore) Capabilities() Capability {
+	return CapPersistent | CapCAS | CapMerge
+}
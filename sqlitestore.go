@@ -0,0 +1,281 @@
+package kvbench
+
+import (
+	"database/sql"
+	"net/url"
+	"strconv"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+// sqliteStore keeps the entries in one table of a SQLite database, through
+// the pure Go driver of modernc.org/sqlite. The database is in WAL mode, so
+// readers do not wait for the writer, and write transactions take the lock
+// when they begin, as a deferred one could fail with SQLITE_BUSY when it
+// upgrades. Writers wait up to sqliteBusyTimeout for each other.
+type sqliteStore struct {
+	db  *sql.DB
+	get *sql.Stmt
+	has *sql.Stmt
+	set *sql.Stmt
+	del *sql.Stmt
+}
+
+const sqliteBusyTimeout = 10 * time.Second
+
+// NewSQLiteStore opens the SQLite database at path. fsync selects PRAGMA
+// synchronous FULL, which syncs the WAL at every commit, instead of OFF.
+func NewSQLiteStore(path string, fsync bool) (Store, error) {
+	if path == ":memory:" {
+		// every connection of the pool would get its own database
+		return nil, ErrMemoryNotAllowed
+	}
+	sync := "OFF"
+	if fsync {
+		sync = "FULL"
+	}
+	q := url.Values{}
+	q.Add("_pragma", "journal_mode(WAL)")
+	q.Add("_pragma", "synchronous("+sync+")")
+	q.Add("_pragma", "busy_timeout("+strconv.FormatInt(sqliteBusyTimeout.Milliseconds(), 10)+")")
+	q.Set("_txlock", "immediate")
+	db, err := sql.Open("sqlite", "file:"+path+"?"+q.Encode())
+	if err != nil {
+		return nil, err
+	}
+	if _, err := db.Exec("CREATE TABLE IF NOT EXISTS kv(k BLOB PRIMARY KEY, v BLOB)"); err != nil {
+		db.Close()
+		return nil, err
+	}
+	s := &sqliteStore{db: db}
+	for _, p := range []struct {
+		stmt  **sql.Stmt
+		query string
+	}{
+		{&s.get, "SELECT v FROM kv WHERE k = ?"},
+		{&s.has, "SELECT 1 FROM kv WHERE k = ?"},
+		{&s.set, "INSERT OR REPLACE INTO kv(k, v) VALUES(?, ?)"},
+		{&s.del, "DELETE FROM kv WHERE k = ?"},
+	} {
+		if *p.stmt, err = db.Prepare(p.query); err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+func (s *sqliteStore) Close() error {
+	return s.db.Close()
+}
+
+// PSet writes keys in one transaction with the prepared INSERT OR REPLACE.
+func (s *sqliteStore) PSet(keys, values [][]byte) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	set := tx.Stmt(s.set)
+	for i := range keys {
+		if _, err := set.Exec(emptyIfNil(keys[i]), emptyIfNil(values[i])); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *sqliteStore) PGet(keys [][]byte) ([][]byte, []bool, error) {
+	var values [][]byte
+	var oks []bool
+	for _, k := range keys {
+		v, ok, err := s.Get(k)
+		if err != nil {
+			return nil, nil, err
+		}
+		values = append(values, v)
+		oks = append(oks, ok)
+	}
+	return values, oks, nil
+}
+
+// Set stores a nil key or value as a zero-length blob rather than NULL, which
+// would not compare equal to itself.
+func (s *sqliteStore) Set(key, value []byte) error {
+	_, err := s.set.Exec(emptyIfNil(key), emptyIfNil(value))
+	return err
+}
+
+func (s *sqliteStore) SetAsync(key, value []byte, cb func(error)) {
+	cb(s.Set(key, value))
+}
+
+func (s *sqliteStore) Get(key []byte) ([]byte, bool, error) {
+	var v []byte
+	err := s.get.QueryRow(emptyIfNil(key)).Scan(&v)
+	if err == sql.ErrNoRows {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return emptyIfNil(v), true, nil
+}
+
+func (s *sqliteStore) Has(key []byte) (bool, error) {
+	var one int
+	err := s.has.QueryRow(emptyIfNil(key)).Scan(&one)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (s *sqliteStore) Del(key []byte) (bool, error) {
+	r, err := s.del.Exec(emptyIfNil(key))
+	if err != nil {
+		return false, err
+	}
+	n, err := r.RowsAffected()
+	return n > 0, err
+}
+
+// PDel deletes keys in one transaction with the prepared DELETE.
+func (s *sqliteStore) PDel(keys [][]byte) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	del := tx.Stmt(s.del)
+	for _, k := range keys {
+		if _, err := del.Exec(emptyIfNil(k)); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// Keys reads the range of keys starting with pattern.
+func (s *sqliteStore) Keys(pattern []byte, limit int, withvals bool) ([][]byte, [][]byte, error) {
+	return s.RangeScan(pattern, prefixEnd(pattern), limit, withvals)
+}
+
+// RangeScan reads the range from the primary key index in key order, blobs
+// comparing with memcmp.
+func (s *sqliteStore) RangeScan(start, end []byte, limit int, withvals bool) ([][]byte, [][]byte, error) {
+	if limit <= 0 {
+		// no limit in SQLite
+		limit = -1
+	}
+	var rows *sql.Rows
+	var err error
+	if end != nil {
+		rows, err = s.db.Query("SELECT k, v FROM kv WHERE k >= ? AND k < ? ORDER BY k LIMIT ?", emptyIfNil(start), end, limit)
+	} else {
+		rows, err = s.db.Query("SELECT k, v FROM kv WHERE k >= ? ORDER BY k LIMIT ?", emptyIfNil(start), limit)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+	var keys [][]byte
+	var vals [][]byte
+	for rows.Next() {
+		var k, v []byte
+		if err := rows.Scan(&k, &v); err != nil {
+			return nil, nil, err
+		}
+		keys = append(keys, k)
+		if withvals {
+			vals = append(vals, emptyIfNil(v))
+		}
+	}
+	return keys, vals, rows.Err()
+}
+
+func (s *sqliteStore) Snapshot() (Snapshot, error) {
+	return nil, ErrNotSupported
+}
+
+// prefixEnd returns the smallest key greater than every key starting with
+// prefix, or nil if there is none.
+func prefixEnd(prefix []byte) []byte {
+	end := bcopy(prefix)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return end[:i+1]
+		}
+	}
+	return nil
+}
+
+func (s *sqliteStore) AllKeys(limit int, withvals bool) ([][]byte, [][]byte, error) {
+	return s.Keys(nil, limit, withvals)
+}
+
+func (s *sqliteStore) Count() (int64, error) {
+	var n int64
+	err := s.db.QueryRow("SELECT COUNT(*) FROM kv").Scan(&n)
+	return n, err
+}
+
+func (s *sqliteStore) FlushDB() error {
+	_, err := s.db.Exec("DELETE FROM kv")
+	return err
+}
+
+// Compact runs VACUUM, which rewrites the database file without free pages.
+func (s *sqliteStore) Compact() error {
+	_, err := s.db.Exec("VACUUM")
+	return err
+}
+
+func (s *sqliteStore) Merge(key, value []byte) error {
+	return mergeWith(s.update, key, value)
+}
+
+// update reads and writes key in one transaction, which takes the write lock
+// when it begins.
+func (s *sqliteStore) update(key []byte, fn updateFunc) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	var old []byte
+	err = tx.Stmt(s.get).QueryRow(emptyIfNil(key)).Scan(&old)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	v, err := fn(old, err == nil)
+	if err != nil || v == nil {
+		return err
+	}
+	if _, err := tx.Stmt(s.set).Exec(emptyIfNil(key), v); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *sqliteStore) Incr(key []byte, delta int64) (int64, error) {
+	return incrWith(s.update, key, delta)
+}
+
+func (s *sqliteStore) CAS(key, oldValue, newValue []byte) (bool, error) {
+	return casWith(s.update, key, oldValue, newValue)
+}
+
+func (s *sqliteStore) SetWithTTL(key, value []byte, ttl time.Duration) error {
+	return ErrNotSupported
+}
+
+func (s *sqliteStore) Ping() error {
+	return s.db.Ping()
+}
+
+func (s *sqliteStore) Capabilities() Capability {
+	return CapKeys | CapOrdered | CapCompact | CapPersistent | CapTransactions | CapCAS | CapMerge
+}
@@ -1,10 +1,22 @@
 package kvbench
 
 import (
+	"bytes"
 	"encoding/binary"
+	"errors"
 	"flag"
+	"net"
 	"os"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"time"
+
+	"github.com/akrylysov/pogreb/fs"
+	natsserver "github.com/nats-io/nats-server/v2/server"
+	"github.com/tidwall/redcon"
+	"go.etcd.io/bbolt"
 )
 
 var count = flag.Int("count", 1000, "item count for test")
@@ -15,19 +27,58 @@ var stores = []struct {
 	Factory func(path string, fsync bool) (Store, error)
 }{
 	{"badger", "badger.db", NewBadgerStore},
+	{"badger-managed", "badger.db", NewBadgerManagedStore},
+	{"badgerv4", "badgerv4.db", NewBadgerV4Store},
+	{"badgerv4/memory", ":memory:", NewBadgerV4Store},
 	{"bbolt", "bbolt.db", NewBboltStore},
-	{"bolt", "bolt.db", NewBboltStore},
+	{"bolt", "bolt.db", NewBoltStore},
 	{"leveldb", "leveldb.db", NewLevelDBStore},
 	{"kv", "kv.db", NewKVStore},
 	{"buntdb", "buntdb.db", NewBuntdbStore},
-	{"rocksdb", "rocksdb.db", NewRocksdbStore},
 	{"pebble", "pebble.db", NewPebbleStore},
+	{"pebble/memory", ":memory:", NewPebbleStore},
 	{"pogreb", "pogreb.db", NewPogrebStore},
 	{"btree", "btree.db", NewBTreeStore},
 	{"btree/memory", ":memory:", NewBTreeStore},
 	{"nutsdb", "nutsdb.db", NewNutsdbStore},
+	{"moss", "moss.db", NewMossStore},
+	{"moss/memory", ":memory:", NewMossStore},
+	{"hlog", "hlog.db", NewHybridLogStore},
+	{"sqlite", "sqlite.db", NewSQLiteStore},
+	{"slotfile", "slotfile.db", func(path string, fsync bool) (Store, error) { return NewSlottedFileStore(path, 512, fsync) }},
 	{"map", "map.db", NewMapStore},
 	{"map/memory", ":memory:", NewMapStore},
+	{"memdb/memory", ":memory:", NewMemdbStore},
+	{"lru/memory", ":memory:", func(string, bool) (Store, error) { return NewLRUStore(2 * *count) }},
+	{"freecache/memory", ":memory:", func(string, bool) (Store, error) { return NewFreecacheStore(64 << 20) }},
+	{"snappy:map", "map.db", compressed("snappy", NewMapStore)},
+	{"zstd:map", "map.db", compressed("zstd", NewMapStore)},
+	{"lz4:map", "map.db", compressed("lz4", NewMapStore)},
+	{"none:map", "map.db", compressed("none", NewMapStore)},
+	{"tiered:map", "map.db", tiered},
+}
+
+func compressed(codec string, factory func(path string, fsync bool) (Store, error)) func(path string, fsync bool) (Store, error) {
+	return func(path string, fsync bool) (Store, error) {
+		store, err := factory(path, fsync)
+		if err != nil {
+			return nil, err
+		}
+		return NewCompressStore(store, codec)
+	}
+}
+
+// tiered keeps a tenth of the keys in memory and the rest in a map store.
+func tiered(path string, fsync bool) (Store, error) {
+	hot, err := NewMapStore(":memory:", fsync)
+	if err != nil {
+		return nil, err
+	}
+	cold, err := NewMapStore(path, fsync)
+	if err != nil {
+		return nil, err
+	}
+	return NewTieredStore(hot, cold, *count/10), nil
 }
 
 func prefixKey(i int) []byte {
@@ -44,6 +95,9 @@ func wrapfsync(fn func(*testing.T, Store, bool), store Store, fsync bool) func(*
 func TestStore_fsync(t *testing.T) {
 	for _, s := range stores {
 		store, err := s.Factory(s.Path, true)
+		if errors.Is(err, ErrFsyncNotAllowed) {
+			continue
+		}
 		if err != nil {
 			os.RemoveAll(s.Path)
 			t.Fatal(err)
@@ -70,6 +124,12 @@ func testStore(t *testing.T, store Store, fsync bool) {
 
 	defer store.Close()
 
+	t.Run("ping", func(tt *testing.T) {
+		if err := store.Ping(); err != nil {
+			tt.Fatalf("failed to ping: %v", err)
+		}
+	})
+
 	t.Run("set", func(tt *testing.T) {
 		for i := 0; i < *count; i++ {
 			err := store.Set(prefixKey(i), v)
@@ -90,4 +150,1290 @@ func testStore(t *testing.T, store Store, fsync bool) {
 			}
 		}
 	})
+
+	t.Run("has", func(tt *testing.T) {
+		ok, err := store.Has(prefixKey(0))
+		if err != nil {
+			tt.Fatalf("failed to check key 0: %v", err)
+		}
+		if !ok {
+			tt.Fatalf("the key 0 does not exist")
+		}
+		ok, err = store.Has([]byte("has-missing"))
+		if err != nil {
+			tt.Fatalf("failed to check a missing key: %v", err)
+		}
+		if ok {
+			tt.Fatalf("a missing key exists")
+		}
+	})
+
+	t.Run("all keys", func(tt *testing.T) {
+		keys, vals, err := store.AllKeys(0, true)
+		if err != nil {
+			tt.Fatalf("failed to list all keys: %v", err)
+		}
+		if len(keys) != *count || len(vals) != *count {
+			tt.Fatalf("got %d keys and %d values, want %d", len(keys), len(vals), *count)
+		}
+		keys, _, err = store.AllKeys(10, false)
+		if err != nil {
+			tt.Fatalf("failed to list keys with a limit: %v", err)
+		}
+		if len(keys) != 10 {
+			tt.Fatalf("got %d keys with limit 10", len(keys))
+		}
+	})
+
+	t.Run("range scan", func(tt *testing.T) {
+		keys, vals, err := store.RangeScan(prefixKey(10), prefixKey(20), 0, true)
+		if err == ErrNotSupported {
+			return
+		}
+		if err != nil {
+			tt.Fatalf("failed to scan: %v", err)
+		}
+		if len(keys) != 10 || len(vals) != 10 {
+			tt.Fatalf("got %d keys and %d values, want 10", len(keys), len(vals))
+		}
+		if store.Capabilities()&CapOrdered != 0 {
+			for i, k := range keys {
+				if !bytes.Equal(k, prefixKey(10+i)) {
+					tt.Fatalf("got key %x at %d, want %x", k, i, prefixKey(10+i))
+				}
+			}
+		}
+		keys, _, err = store.RangeScan(prefixKey(*count-5), nil, 3, false)
+		if err != nil {
+			tt.Fatalf("failed to scan with a limit: %v", err)
+		}
+		if len(keys) != 3 {
+			tt.Fatalf("got %d keys with limit 3", len(keys))
+		}
+	})
+
+	t.Run("count", func(tt *testing.T) {
+		n, err := store.Count()
+		if err != nil {
+			tt.Fatalf("failed to count: %v", err)
+		}
+		if n != int64(*count) {
+			tt.Fatalf("got %d keys, want %d", n, *count)
+		}
+	})
+
+	t.Run("set async", func(tt *testing.T) {
+		key := []byte("set-async")
+		errc := make(chan error, 1)
+		store.SetAsync(key, v, func(err error) {
+			errc <- err
+		})
+		if err := <-errc; err != nil {
+			tt.Fatalf("failed to set async: %v", err)
+		}
+		if _, ok, err := store.Get(key); err != nil || !ok {
+			tt.Fatalf("async set key not found: ok=%v err=%v", ok, err)
+		}
+	})
+
+	t.Run("batch delete", func(tt *testing.T) {
+		keys := [][]byte{[]byte("pdel-0"), []byte("pdel-1"), []byte("pdel-2")}
+		if err := store.PSet(keys, [][]byte{v, v, v}); err != nil {
+			tt.Fatalf("failed to set the keys to delete: %v", err)
+		}
+		if err := store.PDel(append(keys[:2:2], []byte("pdel-missing"))); err != nil {
+			tt.Fatalf("failed to batch delete: %v", err)
+		}
+		for i, k := range keys {
+			ok, err := store.Has(k)
+			if err != nil {
+				tt.Fatalf("failed to check key %s: %v", k, err)
+			}
+			if ok != (i == 2) {
+				tt.Fatalf("key %s present: %v after deleting the first two", k, ok)
+			}
+		}
+	})
+
+	t.Run("incr", func(tt *testing.T) {
+		key := []byte("incr")
+		if _, err := store.Incr(key, 1); err == ErrNotSupported {
+			return
+		} else if err != nil {
+			tt.Fatalf("failed to incr: %v", err)
+		}
+		const goroutines, incrs = 8, 50
+		var wg sync.WaitGroup
+		errc := make(chan error, goroutines)
+		for g := 0; g < goroutines; g++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := 0; i < incrs; i++ {
+					if _, err := store.Incr(key, 2); err != nil {
+						errc <- err
+						return
+					}
+				}
+			}()
+		}
+		wg.Wait()
+		close(errc)
+		for err := range errc {
+			tt.Fatalf("failed to incr concurrently: %v", err)
+		}
+		want := int64(1 + 2*goroutines*incrs - 3)
+		n, err := store.Incr(key, -3)
+		if err != nil || n != want {
+			tt.Fatalf("got counter %d, err %v, want %d", n, err, want)
+		}
+		v, ok, err := store.Get(key)
+		if err != nil || !ok || len(v) != 8 || int64(binary.BigEndian.Uint64(v)) != want {
+			tt.Fatalf("got value %x, ok=%v, err %v, want the counter %d", v, ok, err, want)
+		}
+		if err := store.Set([]byte("incr-text"), []byte("1")); err != nil {
+			tt.Fatalf("failed to set: %v", err)
+		}
+		if _, err := store.Incr([]byte("incr-text"), 1); err != ErrNotCounter {
+			tt.Fatalf("got %v incrementing a 1 byte value, want ErrNotCounter", err)
+		}
+	})
+
+	t.Run("cas", func(tt *testing.T) {
+		key := []byte("cas")
+		gen := func(g int) []byte {
+			return []byte("gen-" + strconv.Itoa(g))
+		}
+		swapped, err := store.CAS(key, nil, gen(0))
+		if !store.Capabilities().Has(CapCAS) {
+			if !errors.Is(err, ErrNotSupported) {
+				tt.Fatalf("cas without the capability returned %v", err)
+			}
+			return
+		}
+		if err != nil || !swapped {
+			tt.Fatalf("cas of an absent key returned %v, %v", swapped, err)
+		}
+		if swapped, err := store.CAS(key, nil, gen(1)); err != nil || swapped {
+			tt.Fatalf("cas of a present key with a nil old value returned %v, %v", swapped, err)
+		}
+		if swapped, err := store.CAS(key, gen(1), gen(2)); err != nil || swapped {
+			tt.Fatalf("cas with a stale old value returned %v, %v", swapped, err)
+		}
+
+		// every goroutine races to move each generation to the next; exactly
+		// one of them may win
+		const goroutines, generations = 8, 20
+		var wg sync.WaitGroup
+		wins := make([][]int, goroutines)
+		errc := make(chan error, goroutines)
+		for i := 0; i < goroutines; i++ {
+			wins[i] = make([]int, generations)
+			wg.Add(1)
+			go func(wins []int) {
+				defer wg.Done()
+				for g := 0; g < generations; g++ {
+					for {
+						swapped, err := store.CAS(key, gen(g), gen(g+1))
+						if err != nil {
+							errc <- err
+							return
+						}
+						if swapped {
+							wins[g]++
+							break
+						}
+						v, _, err := store.Get(key)
+						if err != nil {
+							errc <- err
+							return
+						}
+						// another goroutine won generation g
+						if !bytes.Equal(v, gen(g)) {
+							break
+						}
+					}
+				}
+			}(wins[i])
+		}
+		wg.Wait()
+		close(errc)
+		for err := range errc {
+			tt.Fatalf("failed to cas concurrently: %v", err)
+		}
+		for g := 0; g < generations; g++ {
+			var n int
+			for i := range wins {
+				n += wins[i][g]
+			}
+			if n != 1 {
+				tt.Fatalf("generation %d had %d winners, want 1", g, n)
+			}
+		}
+		if v, ok, err := store.Get(key); err != nil || !ok || !bytes.Equal(v, gen(generations)) {
+			tt.Fatalf("got value %q, ok=%v, err %v, want %q", v, ok, err, gen(generations))
+		}
+	})
+
+	t.Run("snapshot", func(tt *testing.T) {
+		if err := store.Set([]byte("snap"), []byte("before")); err != nil {
+			tt.Fatalf("failed to set: %v", err)
+		}
+		snap, err := store.Snapshot()
+		if !store.Capabilities().Has(CapSnapshots) {
+			if !errors.Is(err, ErrNotSupported) {
+				tt.Fatalf("snapshot without the capability returned %v", err)
+			}
+			return
+		}
+		if err != nil {
+			tt.Fatalf("failed to open a snapshot: %v", err)
+		}
+		defer func() {
+			if err := snap.Close(); err != nil {
+				tt.Errorf("failed to close the snapshot: %v", err)
+			}
+		}()
+		if err := store.Set([]byte("snap"), []byte("after")); err != nil {
+			tt.Fatalf("failed to set: %v", err)
+		}
+		if err := store.Set([]byte("snap-new"), []byte("after")); err != nil {
+			tt.Fatalf("failed to set: %v", err)
+		}
+
+		if v, ok, err := snap.Get([]byte("snap")); err != nil || !ok || string(v) != "before" {
+			tt.Fatalf("snapshot read %q, ok=%v, err %v, want the value before it", v, ok, err)
+		}
+		if _, ok, err := snap.Get([]byte("snap-new")); err != nil || ok {
+			tt.Fatalf("snapshot read a key written after it, ok=%v, err %v", ok, err)
+		}
+		keys, vals, err := snap.RangeScan([]byte("snap"), []byte("snaq"), 0, true)
+		if err != nil || len(keys) != 1 || string(keys[0]) != "snap" || string(vals[0]) != "before" {
+			tt.Fatalf("snapshot scanned %q, %q, err %v", keys, vals, err)
+		}
+		if v, ok, err := store.Get([]byte("snap")); err != nil || !ok || string(v) != "after" {
+			tt.Fatalf("store read %q, ok=%v, err %v after the snapshot", v, ok, err)
+		}
+	})
+
+	t.Run("ttl", func(tt *testing.T) {
+		key := []byte("ttl-key")
+		err := store.SetWithTTL(key, v, time.Hour)
+		if !store.Capabilities().Has(CapTTL) {
+			if !errors.Is(err, ErrNotSupported) {
+				tt.Fatalf("set with ttl without the capability returned %v", err)
+			}
+			return
+		}
+		if err != nil {
+			tt.Fatalf("failed to set with ttl: %v", err)
+		}
+		if got, ok, err := store.Get(key); err != nil || !ok || !bytes.Equal(got, v) {
+			tt.Fatalf("key set with ttl read back as ok=%v err=%v", ok, err)
+		}
+	})
+
+	t.Run("capabilities", func(tt *testing.T) {
+		caps := store.Capabilities()
+		_, _, err := store.Keys(prefixKey(0)[:7], 0, false)
+		if caps.Has(CapKeys) && err != nil {
+			tt.Fatalf("keys failed with %v: %v", caps, err)
+		}
+		if !caps.Has(CapKeys) && err != nil && !errors.Is(err, ErrNotSupported) {
+			tt.Fatalf("failed to list keys: %v", err)
+		}
+		if err := store.Compact(); caps.Has(CapCompact) && errors.Is(err, ErrNotSupported) {
+			tt.Fatalf("compact not supported with %v", caps)
+		}
+	})
+
+	t.Run("merge", func(tt *testing.T) {
+		key := []byte("merge-counter")
+		one := make([]byte, 8)
+		binary.BigEndian.PutUint64(one, 1)
+		err := store.Merge(key, one)
+		if !store.Capabilities().Has(CapMerge) {
+			if !errors.Is(err, ErrNotSupported) {
+				tt.Fatalf("merge without the capability returned %v", err)
+			}
+			return
+		}
+		if err != nil {
+			tt.Fatalf("failed to merge: %v", err)
+		}
+		// concurrent merges of 1 to n each add up, none lost
+		const goroutines, n = 4, 25
+		var wg sync.WaitGroup
+		errs := make(chan error, goroutines)
+		for g := 0; g < goroutines; g++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := uint64(1); i <= n; i++ {
+					delta := make([]byte, 8)
+					binary.BigEndian.PutUint64(delta, i)
+					if err := store.Merge(key, delta); err != nil {
+						errs <- err
+						return
+					}
+				}
+			}()
+		}
+		wg.Wait()
+		close(errs)
+		for err := range errs {
+			tt.Fatalf("failed to merge: %v", err)
+		}
+		v, ok, err := store.Get(key)
+		if want := uint64(1 + goroutines*n*(n+1)/2); err != nil || !ok || len(v) != 8 || binary.BigEndian.Uint64(v) != want {
+			tt.Fatalf("merged counter read back as %x, ok=%v, err=%v, want %d", v, ok, err, want)
+		}
+	})
+
+	t.Run("compact", func(tt *testing.T) {
+		err := store.Compact()
+		if err != nil && !errors.Is(err, ErrNotSupported) {
+			tt.Fatalf("failed to compact: %v", err)
+		}
+	})
+
+	t.Run("namespaces", func(tt *testing.T) {
+		nss := Namespaces(store)
+		key := []byte("ns-key")
+		for _, ns := range []string{"a", "b"} {
+			if err := nss.SetNS([]byte(ns), key, []byte(ns)); err != nil {
+				tt.Fatalf("failed to set in namespace %s: %v", ns, err)
+			}
+		}
+		if _, err := nss.DelNS([]byte("a"), key); err != nil {
+			tt.Fatalf("failed to delete in namespace a: %v", err)
+		}
+		v, ok, err := nss.GetNS([]byte("b"), key)
+		if err != nil || !ok || !bytes.Equal(v, []byte("b")) {
+			tt.Fatalf("namespace b read back as %q, ok=%v, err=%v", v, ok, err)
+		}
+	})
+
+	t.Run("empty value", func(tt *testing.T) {
+		for _, v := range [][]byte{{}, nil} {
+			key := []byte("empty-value")
+			if err := store.Set(key, v); err != nil {
+				tt.Fatalf("failed to set empty value: %v", err)
+			}
+			got, ok, err := store.Get(key)
+			if err != nil {
+				tt.Fatalf("failed to get empty value: %v", err)
+			}
+			if !ok || got == nil || len(got) != 0 {
+				tt.Fatalf("empty value read back as ok=%v %q", ok, got)
+			}
+		}
+	})
+
+	t.Run("flushdb", func(tt *testing.T) {
+		err := store.FlushDB()
+		if errors.Is(err, ErrNotSupported) {
+			return
+		}
+		if err != nil {
+			tt.Fatalf("failed to flush: %v", err)
+		}
+		if n, err := store.Count(); err != nil || n != 0 {
+			tt.Fatalf("%d keys left after FlushDB, err=%v", n, err)
+		}
+		if ok, err := store.Has(prefixKey(0)); err != nil || ok {
+			tt.Fatalf("key 0 found after FlushDB: ok=%v err=%v", ok, err)
+		}
+		// emulated namespaces are keys, counted above
+		if nss, native := store.(NamespaceStore); native {
+			if _, ok, err := nss.GetNS([]byte("b"), []byte("ns-key")); err != nil || ok {
+				tt.Fatalf("namespace key read after FlushDB: ok=%v err=%v", ok, err)
+			}
+		}
+		// the store stays usable
+		if err := store.Set(prefixKey(0), v); err != nil {
+			tt.Fatalf("failed to set after FlushDB: %v", err)
+		}
+		if n, err := store.Count(); err != nil || n != 1 {
+			tt.Fatalf("%d keys after setting one after FlushDB, err=%v", n, err)
+		}
+	})
+}
+
+// Values above badger's value threshold go to the value log, which Stats
+// counts apart from the LSM tables.
+func TestBadgerStore_stats(t *testing.T) {
+	path := "badger-stats.db"
+	defer os.RemoveAll(path)
+	store, err := NewBadgerStore(path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	value := make([]byte, 4096)
+	for i := 0; i < 100; i++ {
+		if err := store.Set(prefixKey(i), value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, vlog := store.(interface{ Stats() (lsm, vlog int64) }).Stats()
+	if vlog < int64(100*len(value)) {
+		t.Fatalf("value log of %d bytes, want at least the %d bytes of values", vlog, 100*len(value))
+	}
+}
+
+func TestGRPCStore(t *testing.T) {
+	backend, err := NewMapStore(":memory:", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer backend.Close()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := NewGRPCServer(backend)
+	go srv.Serve(ln)
+	defer srv.Stop()
+
+	store, err := NewGRPCStore(ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	testStore(t, store, false)
+}
+
+func TestRESPStore(t *testing.T) {
+	backend, err := NewMapStore(":memory:", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer backend.Close()
+	srv := redcon.NewServer("127.0.0.1:0", func(conn redcon.Conn, cmd redcon.Command) {
+		serveRESP(backend, conn, cmd)
+	}, nil, nil)
+	errc := make(chan error, 1)
+	go srv.ListenServeAndSignal(errc)
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+
+	store, err := NewRESPStore(srv.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	testStore(t, store, false)
+}
+
+func TestRedisStore(t *testing.T) {
+	backend, err := NewMapStore(":memory:", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer backend.Close()
+	srv := redcon.NewServer("127.0.0.1:0", func(conn redcon.Conn, cmd redcon.Command) {
+		serveRESP(backend, conn, cmd)
+	}, nil, nil)
+	errc := make(chan error, 1)
+	go srv.ListenServeAndSignal(errc)
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+
+	// without fsync, as serveRESP does not know WAITAOF
+	store, err := NewRedisStore(srv.Addr().String(), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testStore(t, store, false)
+}
+
+func TestJetStreamKVStore(t *testing.T) {
+	srv, err := natsserver.NewServer(&natsserver.Options{
+		Host:      "127.0.0.1",
+		Port:      -1,
+		JetStream: true,
+		StoreDir:  t.TempDir(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.Start()
+	defer srv.Shutdown()
+	if !srv.ReadyForConnections(10 * time.Second) {
+		t.Fatal("nats server not ready")
+	}
+
+	store, err := NewJetStreamKVStore(srv.ClientURL(), "kvbench")
+	if err != nil {
+		t.Fatal(err)
+	}
+	testStore(t, store, false)
+}
+
+// respTx is the WATCH and MULTI state of a connection of serveRESP.
+type respTx struct {
+	watched map[string][]byte // the values at WATCH, nil if missing
+	multi   bool
+	queued  []redcon.Command
+}
+
+// respExecMu makes the EXECs of serveRESP atomic with each other.
+var respExecMu sync.Mutex
+
+// serveRESP answers the commands of the resp store from backend. SCAN
+// returns every match at once and only understands the MATCH patterns the
+// resp store sends, an escaped prefix followed by *. EXEC fails when a
+// watched key holds another value than at WATCH.
+func serveRESP(backend Store, conn redcon.Conn, cmd redcon.Command) {
+	name := strings.ToUpper(string(cmd.Args[0]))
+	tx, _ := conn.Context().(*respTx)
+	if tx != nil && tx.multi && name != "EXEC" {
+		// the arguments point into the read buffer of conn
+		var queued redcon.Command
+		for _, arg := range cmd.Args {
+			queued.Args = append(queued.Args, append([]byte(nil), arg...))
+		}
+		tx.queued = append(tx.queued, queued)
+		conn.WriteString("QUEUED")
+		return
+	}
+	switch name {
+	case "WATCH":
+		if tx == nil {
+			tx = &respTx{watched: make(map[string][]byte)}
+			conn.SetContext(tx)
+		}
+		for _, k := range cmd.Args[1:] {
+			v, ok, _ := backend.Get(k)
+			if ok {
+				v = append([]byte{}, v...)
+			}
+			tx.watched[string(k)] = v
+		}
+		conn.WriteString("OK")
+	case "UNWATCH":
+		conn.SetContext(nil)
+		conn.WriteString("OK")
+	case "MULTI":
+		if tx == nil {
+			tx = &respTx{}
+			conn.SetContext(tx)
+		}
+		tx.multi = true
+		conn.WriteString("OK")
+	case "EXEC":
+		conn.SetContext(nil)
+		if tx == nil || !tx.multi {
+			conn.WriteError("ERR EXEC without MULTI")
+			return
+		}
+		respExecMu.Lock()
+		defer respExecMu.Unlock()
+		for k, old := range tx.watched {
+			v, ok, _ := backend.Get([]byte(k))
+			if ok != (old != nil) || !bytes.Equal(v, old) {
+				conn.WriteNull()
+				return
+			}
+		}
+		conn.WriteArray(len(tx.queued))
+		for _, queued := range tx.queued {
+			serveRESP(backend, conn, queued)
+		}
+	case "PING":
+		conn.WriteString("PONG")
+	case "SET":
+		if err := backend.Set(cmd.Args[1], cmd.Args[2]); err != nil {
+			conn.WriteError(err.Error())
+			return
+		}
+		if len(cmd.Args) == 5 && strings.ToUpper(string(cmd.Args[3])) == "PX" {
+			// good enough for the tests: a later SET does not cancel it
+			ms, _ := strconv.Atoi(string(cmd.Args[4]))
+			key := append([]byte(nil), cmd.Args[1]...)
+			time.AfterFunc(time.Duration(ms)*time.Millisecond, func() { backend.Del(key) })
+		}
+		conn.WriteString("OK")
+	case "MSET":
+		var keys, values [][]byte
+		for i := 1; i+1 < len(cmd.Args); i += 2 {
+			keys = append(keys, cmd.Args[i])
+			values = append(values, cmd.Args[i+1])
+		}
+		if err := backend.PSet(keys, values); err != nil {
+			conn.WriteError(err.Error())
+			return
+		}
+		conn.WriteString("OK")
+	case "GET":
+		v, ok, err := backend.Get(cmd.Args[1])
+		switch {
+		case err != nil:
+			conn.WriteError(err.Error())
+		case !ok:
+			conn.WriteNull()
+		default:
+			conn.WriteBulk(v)
+		}
+	case "MGET":
+		values, oks, err := backend.PGet(cmd.Args[1:])
+		if err != nil {
+			conn.WriteError(err.Error())
+			return
+		}
+		conn.WriteArray(len(values))
+		for i := range values {
+			if oks[i] {
+				conn.WriteBulk(values[i])
+			} else {
+				conn.WriteNull()
+			}
+		}
+	case "DEL":
+		var n int
+		for _, k := range cmd.Args[1:] {
+			ok, err := backend.Del(k)
+			if err != nil {
+				conn.WriteError(err.Error())
+				return
+			}
+			if ok {
+				n++
+			}
+		}
+		conn.WriteInt(n)
+	case "EXISTS":
+		ok, err := backend.Has(cmd.Args[1])
+		if err != nil {
+			conn.WriteError(err.Error())
+		} else if ok {
+			conn.WriteInt(1)
+		} else {
+			conn.WriteInt(0)
+		}
+	case "SCAN":
+		var prefix []byte
+		for i := 2; i+1 < len(cmd.Args); i += 2 {
+			if strings.ToUpper(string(cmd.Args[i])) != "MATCH" {
+				continue
+			}
+			match := cmd.Args[i+1]
+			for j := 0; j < len(match)-1; j++ {
+				if match[j] == '\\' {
+					j++
+				}
+				prefix = append(prefix, match[j])
+			}
+		}
+		var keys [][]byte
+		var err error
+		if prefix == nil {
+			keys, _, err = backend.AllKeys(0, false)
+		} else {
+			keys, _, err = backend.Keys(prefix, 0, false)
+		}
+		if err != nil {
+			conn.WriteError(err.Error())
+			return
+		}
+		conn.WriteArray(2)
+		conn.WriteBulkString("0")
+		conn.WriteArray(len(keys))
+		for _, k := range keys {
+			conn.WriteBulk(k)
+		}
+	case "DBSIZE":
+		keys, _, err := backend.AllKeys(0, false)
+		if err != nil {
+			conn.WriteError(err.Error())
+			return
+		}
+		conn.WriteInt(len(keys))
+	case "FLUSHDB":
+		if err := backend.FlushDB(); err != nil {
+			conn.WriteError(err.Error())
+			return
+		}
+		conn.WriteString("OK")
+	default:
+		conn.WriteError("ERR unknown command '" + string(cmd.Args[0]) + "'")
+	}
+}
+
+// Entries written with SetWithTTL must be gone once the TTL has passed. The
+// stores wait together, as their TTLs have a granularity of a second.
+func TestStore_ttlExpires(t *testing.T) {
+	var ttlStores []Store
+	for _, s := range stores {
+		if s.Name != "badger" && s.Name != "badgerv4" && s.Name != "buntdb" && s.Name != "freecache/memory" && s.Name != "nutsdb" {
+			continue
+		}
+		path := "ttl-" + s.Path
+		defer os.RemoveAll(path)
+		store, err := s.Factory(path, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer store.Close()
+		if err := store.SetWithTTL([]byte("ttl-key"), []byte("v"), time.Second); err != nil {
+			t.Fatalf("%s: failed to set with ttl: %v", s.Name, err)
+		}
+		ttlStores = append(ttlStores, store)
+	}
+	time.Sleep(2100 * time.Millisecond)
+	for _, store := range ttlStores {
+		if ok, err := store.Has([]byte("ttl-key")); err != nil || ok {
+			t.Fatalf("%T: key present after its ttl: ok=%v err=%v", store, ok, err)
+		}
+	}
+}
+
+// The loader of the cli passes every page to PSet in the same buffers, so the
+// stores must not keep the keys or values they get.
+func TestStore_psetReusedBuffers(t *testing.T) {
+	const pages, pageSize = 20, 100
+	keyBuf := make([]byte, pageSize*8)
+	valBuf := make([]byte, pageSize*8)
+	keys := make([][]byte, pageSize)
+	vals := make([][]byte, pageSize)
+	for j := range keys {
+		keys[j] = keyBuf[j*8 : (j+1)*8]
+		vals[j] = valBuf[j*8 : (j+1)*8]
+	}
+	for _, s := range stores {
+		store, err := s.Factory(s.Path, false)
+		if err != nil {
+			os.RemoveAll(s.Path)
+			t.Fatal(err)
+		}
+		for p := 0; p < pages; p++ {
+			for j := range keys {
+				binary.BigEndian.PutUint64(keys[j], uint64(p*pageSize+j))
+				binary.BigEndian.PutUint64(vals[j], uint64(p*pageSize+j)+1)
+			}
+			if err := store.PSet(keys, vals); err != nil {
+				t.Fatalf("%s: failed to set page %d: %v", s.Name, p, err)
+			}
+		}
+		for i := 0; i < pages*pageSize; i++ {
+			v, ok, err := store.Get(prefixKey(i))
+			if err != nil || !ok || !bytes.Equal(v, prefixKey(i+1)) {
+				t.Fatalf("%s: key %d lost: ok=%v err=%v value=%x", s.Name, i, ok, err, v)
+			}
+		}
+		if n, err := store.Count(); err != nil || n != pages*pageSize {
+			t.Fatalf("%s: got %d keys, want %d: %v", s.Name, n, pages*pageSize, err)
+		}
+		store.Close()
+		os.RemoveAll(s.Path)
+	}
+}
+
+func TestHybridLogStore_recover(t *testing.T) {
+	path := "hlog-recover.db"
+	defer os.RemoveAll(path)
+	store, err := NewHybridLogStore(path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < *count; i++ {
+		if err := store.Set(prefixKey(i), prefixKey(i)); err != nil {
+			t.Fatalf("failed to set key %d: %v", i, err)
+		}
+	}
+	if _, err := store.Del(prefixKey(0)); err != nil {
+		t.Fatal(err)
+	}
+	store.Close()
+
+	store, err = NewHybridLogStore(path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	if _, ok, _ := store.Get(prefixKey(0)); ok {
+		t.Fatal("deleted key 0 came back after reopen")
+	}
+	for i := 1; i < *count; i++ {
+		v, ok, err := store.Get(prefixKey(i))
+		if err != nil || !ok || !bytes.Equal(v, prefixKey(i)) {
+			t.Fatalf("key %d not recovered: ok=%v err=%v", i, ok, err)
+		}
+	}
+}
+
+func TestPebbleStore_splitPSet(t *testing.T) {
+	path := "pebble-split.db"
+	defer os.RemoveAll(path)
+	store, err := NewPebbleStoreWithOptions(path, false, PebbleOptions{MaxBatchBytes: 4096, MaxBatchCount: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	// 95 entries of 256 bytes split at both the count and the byte limit
+	keys := make([][]byte, 95)
+	vals := make([][]byte, len(keys))
+	for i := range keys {
+		keys[i] = prefixKey(i)
+		vals[i] = bytes.Repeat([]byte{byte(i)}, 256)
+	}
+	if err := store.PSet(keys, vals); err != nil {
+		t.Fatal(err)
+	}
+	for i := range keys {
+		v, ok, err := store.Get(keys[i])
+		if err != nil || !ok || !bytes.Equal(v, vals[i]) {
+			t.Fatalf("key %d not written: ok=%v err=%v", i, ok, err)
+		}
+	}
+}
+
+func TestPebbleStore_keys(t *testing.T) {
+	path := "pebble-keys.db"
+	defer os.RemoveAll(path)
+	store, err := NewPebbleStore(path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	for _, k := range []string{"a1", "a2", "a3", "b1"} {
+		if err := store.Set([]byte(k), []byte("v"+k)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	keys, vals, err := store.Keys([]byte("a"), 0, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 3 || string(keys[0]) != "a1" || string(keys[2]) != "a3" || string(vals[1]) != "va2" {
+		t.Fatalf("Keys(a) = %q, %q", keys, vals)
+	}
+	if keys, _, _ := store.Keys([]byte("a"), 2, false); len(keys) != 2 {
+		t.Fatalf("Keys(a) with limit 2 returned %d keys", len(keys))
+	}
+	if keys, _, _ := store.Keys([]byte("c"), 0, false); len(keys) != 0 {
+		t.Fatalf("Keys(c) = %q", keys)
+	}
+}
+
+func TestPebbleStore_memory(t *testing.T) {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	store, err := NewPebbleStore(":memory:", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Set([]byte("k"), []byte("v")); err != nil {
+		t.Fatal(err)
+	}
+	if v, ok, err := store.Get([]byte("k")); err != nil || !ok || string(v) != "v" {
+		t.Fatalf("Get(k) = %q, %v, %v", v, ok, err)
+	}
+	if store.Capabilities()&CapPersistent != 0 {
+		t.Fatal("in-memory pebble reports CapPersistent")
+	}
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Fatalf("in-memory pebble wrote %d files", len(entries))
+	}
+}
+
+func TestPebbleStore_getMissing(t *testing.T) {
+	path := "pebble-missing.db"
+	defer os.RemoveAll(path)
+	store, err := NewPebbleStore(path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	if v, ok, err := store.Get([]byte("missing")); v != nil || ok || err != nil {
+		t.Fatalf("Get(missing) = %q, %v, %v", v, ok, err)
+	}
+	store.Set([]byte("present"), []byte("v"))
+	_, oks, err := store.PGet([][]byte{[]byte("present"), []byte("missing")})
+	if err != nil || !oks[0] || oks[1] {
+		t.Fatalf("PGet = %v, %v", oks, err)
+	}
+}
+
+func TestLevelDBStore_keys(t *testing.T) {
+	path := "leveldb-keys.db"
+	defer os.RemoveAll(path)
+	store, err := NewLevelDBStore(path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	for _, k := range []string{"a1", "a2", "a3", "b1", "b2"} {
+		if err := store.Set([]byte(k), []byte("v"+k)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	keys, vals, err := store.Keys([]byte("b"), 0, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 2 || string(keys[0]) != "b1" || string(keys[1]) != "b2" || string(vals[0]) != "vb1" || string(vals[1]) != "vb2" {
+		t.Fatalf("Keys(b) = %q, %q", keys, vals)
+	}
+	if keys, _, _ := store.Keys([]byte("a"), 2, false); len(keys) != 2 {
+		t.Fatalf("Keys(a) with limit 2 returned %d keys", len(keys))
+	}
+}
+
+// Writers must never see the db FlushDB closes and replaces; run with -race.
+func TestLevelDBStore_flushWhileWriting(t *testing.T) {
+	path := "leveldb-flush.db"
+	defer os.RemoveAll(path)
+	store, err := NewLevelDBStore(path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	done := make(chan struct{})
+	errc := make(chan error, 4)
+	for g := 0; g < 4; g++ {
+		go func(g int) {
+			for i := 0; ; i++ {
+				select {
+				case <-done:
+					errc <- nil
+					return
+				default:
+				}
+				key := prefixKey(g<<20 | i)
+				if err := store.Set(key, key); err != nil {
+					errc <- err
+					return
+				}
+				if err := store.PSet([][]byte{key}, [][]byte{key}); err != nil {
+					errc <- err
+					return
+				}
+				if _, err := store.Del(key); err != nil {
+					errc <- err
+					return
+				}
+			}
+		}(g)
+	}
+	for i := 0; i < 20; i++ {
+		if err := store.FlushDB(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	close(done)
+	for g := 0; g < 4; g++ {
+		if err := <-errc; err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestBadgerManagedStore_versions(t *testing.T) {
+	path := "badger-managed.db"
+	defer os.RemoveAll(path)
+	store, err := NewBadgerManagedStore(path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	vs := store.(VersionedStore)
+	key := []byte("versioned")
+	for v := uint64(1); v <= 5; v++ {
+		if err := vs.SetAt(key, prefixKey(int(v)), v*10); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, ok, err := vs.GetAt(key, 5); err != nil || ok {
+		t.Fatalf("key read before its first version: ok=%v err=%v", ok, err)
+	}
+	for v := uint64(1); v <= 5; v++ {
+		got, ok, err := vs.GetAt(key, v*10+5)
+		if err != nil || !ok || !bytes.Equal(got, prefixKey(int(v))) {
+			t.Fatalf("version %d read back as %x, ok=%v, err=%v", v, got, ok, err)
+		}
+	}
+	// plain writes commit after the versions written with SetAt
+	if err := store.Set(key, []byte("latest")); err != nil {
+		t.Fatal(err)
+	}
+	if got, _, _ := store.Get(key); !bytes.Equal(got, []byte("latest")) {
+		t.Fatalf("latest version read back as %q", got)
+	}
+}
+
+func TestLRUStore_evict(t *testing.T) {
+	store, err := NewLRUStore(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.Set([]byte("a"), []byte("a"))
+	store.Set([]byte("b"), []byte("b"))
+	store.Get([]byte("a")) // b is now the least recently used
+	store.Set([]byte("c"), []byte("c"))
+	for key, want := range map[string]bool{"a": true, "b": false, "c": true} {
+		if _, ok, _ := store.Get([]byte(key)); ok != want {
+			t.Fatalf("key %s present: %v, want %v", key, ok, want)
+		}
+	}
+	hits, misses, evictions := store.(interface {
+		CacheStats() (hits, misses, evictions int64)
+	}).CacheStats()
+	if hits != 3 || misses != 1 || evictions != 1 {
+		t.Fatalf("got %d hits, %d misses, %d evictions", hits, misses, evictions)
+	}
+}
+
+func TestFreecacheStore_evict(t *testing.T) {
+	// the smallest cache, 2 KiB per segment
+	store, err := NewFreecacheStore(512 << 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	value := make([]byte, 256)
+	for i := 0; i < 10000; i++ {
+		if err := store.Set(prefixKey(i), value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n, _ := store.Count(); n >= 10000 {
+		t.Fatalf("%d entries held, none evicted", n)
+	}
+	store.Get(prefixKey(9999))
+	store.Get([]byte("missing"))
+	hits, misses, evictions := store.(interface {
+		CacheStats() (hits, misses, evictions int64)
+	}).CacheStats()
+	if hits != 1 || misses != 1 || evictions == 0 {
+		t.Fatalf("got %d hits, %d misses, %d evictions", hits, misses, evictions)
+	}
+}
+
+func TestTieredStore_promote(t *testing.T) {
+	hot, _ := NewMapStore(":memory:", false)
+	cold, _ := NewMapStore(":memory:", false)
+	store := NewTieredStore(hot, cold, 1)
+	store.Set([]byte("a"), []byte("a"))
+	store.Set([]byte("b"), []byte("b")) // a is evicted to cold
+	if _, ok, _ := hot.Get([]byte("a")); ok {
+		t.Fatal("a still in the hot store")
+	}
+	if v, ok, _ := store.Get([]byte("a")); !ok || string(v) != "a" {
+		t.Fatalf("Get(a) = %q, %v", v, ok)
+	}
+	// promoting a evicts b
+	if _, ok, _ := hot.Get([]byte("a")); !ok {
+		t.Fatal("a not promoted")
+	}
+	if _, ok, _ := cold.Get([]byte("b")); !ok {
+		t.Fatal("b not evicted")
+	}
+	store.Get([]byte("a"))
+	store.Get([]byte("c"))
+	hotHits, coldHits, misses := store.(interface {
+		TierStats() (hotHits, coldHits, misses int64)
+	}).TierStats()
+	if hotHits != 1 || coldHits != 1 || misses != 1 {
+		t.Fatalf("got %d hot hits, %d cold hits, %d misses", hotHits, coldHits, misses)
+	}
+	if keys, _, _ := store.AllKeys(0, false); len(keys) != 2 {
+		t.Fatalf("AllKeys returned %d keys", len(keys))
+	}
+}
+
+func TestFaultStore(t *testing.T) {
+	inner, err := NewMapStore(":memory:", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, rate := range []float64{0, 1} {
+		store := NewFaultStore(inner, rate)
+		err := store.Set([]byte("k"), []byte("v"))
+		_, _, getErr := store.Get([]byte("k"))
+		if rate == 0 && (err != nil || getErr != nil) {
+			t.Fatalf("rate 0 failed: set %v, get %v", err, getErr)
+		}
+		if rate == 1 && (!errors.Is(err, ErrFault) || !errors.Is(getErr, ErrFault)) {
+			t.Fatalf("rate 1 did not fail: set %v, get %v", err, getErr)
+		}
+		if err := store.Ping(); err != nil {
+			t.Fatalf("rate %v ping failed: %v", rate, err)
+		}
+	}
+}
+
+func TestBoltStore_readTxReuse(t *testing.T) {
+	for _, s := range []struct {
+		name    string
+		factory func(path string, fsync bool, o BoltOptions) (Store, error)
+	}{
+		{"bolt", NewBoltStoreWithOptions},
+		{"bbolt", NewBboltStoreWithOptions},
+	} {
+		path := s.name + "-rotx.db"
+		store, err := s.factory(path, false, BoltOptions{ReadTxReuse: 100})
+		if err != nil {
+			t.Fatal(err)
+		}
+		v := make([]byte, 256)
+		for round := 0; round < 2; round++ {
+			// the second round grows the file while reused read
+			// transactions are pooled, which must not block the writer
+			for i := 0; i < *count; i++ {
+				if err := store.Set(prefixKey(round**count+i), v); err != nil {
+					t.Fatalf("%s: failed to set key %d: %v", s.name, i, err)
+				}
+			}
+			time.Sleep(2 * roTxMaxAge)
+			for i := 0; i < *count; i++ {
+				if _, ok, err := store.Get(prefixKey(round**count + i)); err != nil || !ok {
+					t.Fatalf("%s: key %d not found: ok=%v err=%v", s.name, i, ok, err)
+				}
+			}
+		}
+		store.Close()
+		os.RemoveAll(path)
+	}
+}
+
+// TestBboltStore_noFreelistSync flushes and refills a bbolt store without
+// fsync or a synced freelist, then reopens it, as -runs and -drop-cache do:
+// the file must pass bbolt's consistency check and hold the refilled keys,
+// whether it is reopened with the freelist rebuilt or synced again.
+func TestBboltStore_noFreelistSync(t *testing.T) {
+	path := "bbolt-nofreelistsync.db"
+	defer os.RemoveAll(path)
+	o := BoltOptions{NoFreelistSync: true, FreelistType: "hashmap", InitialMmapSize: 1 << 20}
+	store, err := NewBboltStoreWithOptions(path, false, o)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := make([]byte, 256)
+	for i := 0; i < *count; i++ {
+		if err := store.Set(prefixKey(i), v); err != nil {
+			t.Fatalf("failed to set key %d: %v", i, err)
+		}
+	}
+	if err := store.FlushDB(); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < *count/2; i++ {
+		if err := store.Set(prefixKey(i), v); err != nil {
+			t.Fatalf("failed to set key %d: %v", i, err)
+		}
+	}
+	store.Close()
+
+	for _, noSync := range []bool{true, false} {
+		o.NoFreelistSync = noSync
+		store, err := NewBboltStoreWithOptions(path, false, o)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = store.(*bboltStore).db.View(func(tx *bbolt.Tx) error {
+			var first error
+			// drained, so that Check is done with tx before it ends
+			for err := range tx.Check() {
+				if first == nil {
+					first = err
+				}
+			}
+			return first
+		})
+		if err != nil {
+			t.Fatalf("nofreelistsync=%v: %v", noSync, err)
+		}
+		if n, err := store.Count(); err != nil || n != int64(*count/2) {
+			t.Fatalf("nofreelistsync=%v: count %d, %v, want %d", noSync, n, err, *count/2)
+		}
+		store.Close()
+	}
+
+	if _, err := NewBboltStoreWithOptions(path, false, BoltOptions{FreelistType: "list"}); err == nil {
+		t.Fatal("unknown freelist type accepted")
+	}
+	if _, err := NewBoltStoreWithOptions("bolt-"+path, false, BoltOptions{NoFreelistSync: true}); err == nil {
+		t.Fatal("bolt accepted a bbolt freelist option")
+	}
+}
+
+// syncCountingFS counts the Sync calls on the files pogreb opens.
+type syncCountingFS struct {
+	fs.FileSystem
+	syncs *int64
+}
+
+func (f syncCountingFS) OpenFile(name string, flag int, perm os.FileMode) (fs.File, error) {
+	file, err := f.FileSystem.OpenFile(name, flag, perm)
+	return syncCountingFile{file, f.syncs}, err
+}
+
+type syncCountingFile struct {
+	fs.File
+	syncs *int64
+}
+
+func (f syncCountingFile) Sync() error {
+	*f.syncs++
+	return f.File.Sync()
+}
+
+// TestPogrebStore_psetSyncsOnce writes 1000 keys with fsync in one PSet and
+// with one Set per key, which is what PSet used to cost: pogreb synced after
+// every Put.
+func TestPogrebStore_psetSyncsOnce(t *testing.T) {
+	path := "pogreb-pset.db"
+	defer os.RemoveAll(path)
+	var syncs int64
+	store, err := newPogrebStore(path, true, syncCountingFS{fs.OSMMap, &syncs})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	const n = 1000
+	keys := make([][]byte, n)
+	vals := make([][]byte, n)
+	for i := range keys {
+		keys[i] = prefixKey(i)
+		vals[i] = make([]byte, 256)
+	}
+
+	syncs = 0
+	start := time.Now()
+	if err := store.PSet(keys, vals); err != nil {
+		t.Fatal(err)
+	}
+	batch, batchSyncs := time.Since(start), syncs
+
+	syncs = 0
+	start = time.Now()
+	for i := range keys {
+		if err := store.Set(keys[i], vals[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	perKey, perKeySyncs := time.Since(start), syncs
+
+	t.Logf("PSet of %d keys: %v with %d syncs, one Set per key: %v with %d syncs", n, batch, batchSyncs, perKey, perKeySyncs)
+	if batchSyncs != 1 || perKeySyncs != n {
+		t.Fatalf("got %d syncs for the PSet and %d for the Sets, want 1 and %d", batchSyncs, perKeySyncs, n)
+	}
+	if batch >= perKey {
+		t.Fatalf("PSet took %v, no faster than %d Sets in %v", batch, n, perKey)
+	}
 }
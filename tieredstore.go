@@ -0,0 +1,378 @@
+package kvbench
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// tieredStore models a memory tier over a disk tier. Writes go to hot; once
+// hot holds more than hotCapacity keys the least recently used one is moved
+// to cold. Get reads hot, then cold, and promotes what it finds in cold back
+// to hot. A key in hot shadows any older value left in cold by a Set that
+// did not read it first, so Set costs no disk write until an eviction. One
+// mutex serializes the operations, which keeps the tiers consistent across
+// evictions and promotions.
+type tieredStore struct {
+	mu          sync.Mutex
+	hot, cold   Store
+	hotCapacity int
+	ll          *list.List // keys in hot, front is the most recently used
+	items       map[string]*list.Element
+
+	hotHits, coldHits, misses int64
+}
+
+// NewTieredStore returns a store that keeps at most hotCapacity keys in hot
+// and the rest in cold.
+func NewTieredStore(hot, cold Store, hotCapacity int) Store {
+	if hotCapacity < 1 {
+		hotCapacity = 1
+	}
+	return &tieredStore{
+		hot:         hot,
+		cold:        cold,
+		hotCapacity: hotCapacity,
+		ll:          list.New(),
+		items:       make(map[string]*list.Element),
+	}
+}
+
+func (s *tieredStore) Close() error {
+	err := s.hot.Close()
+	if cerr := s.cold.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// set must be called with s.mu held.
+func (s *tieredStore) set(key, value []byte) error {
+	if err := s.hot.Set(key, value); err != nil {
+		return err
+	}
+	if e, ok := s.items[string(key)]; ok {
+		s.ll.MoveToFront(e)
+		return nil
+	}
+	s.items[string(key)] = s.ll.PushFront(string(key))
+	for s.ll.Len() > s.hotCapacity {
+		if err := s.evict(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// evict moves the least recently used key of hot to cold. It must be called
+// with s.mu held.
+func (s *tieredStore) evict() error {
+	oldest := s.ll.Back()
+	key := []byte(oldest.Value.(string))
+	v, ok, err := s.hot.Get(key)
+	if err != nil {
+		return err
+	}
+	if ok {
+		if err := s.cold.Set(key, v); err != nil {
+			return err
+		}
+	}
+	if _, err := s.hot.Del(key); err != nil {
+		return err
+	}
+	s.ll.Remove(oldest)
+	delete(s.items, string(key))
+	return nil
+}
+
+func (s *tieredStore) PSet(keys, values [][]byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := range keys {
+		if err := s.set(keys[i], values[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *tieredStore) PGet(keys [][]byte) ([][]byte, []bool, error) {
+	var values [][]byte
+	var oks []bool
+	for i := range keys {
+		v, ok, err := s.Get(keys[i])
+		if err != nil {
+			return nil, nil, err
+		}
+		values = append(values, v)
+		oks = append(oks, ok)
+	}
+	return values, oks, nil
+}
+
+func (s *tieredStore) Set(key, value []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.set(key, value)
+}
+
+func (s *tieredStore) Get(key []byte) ([]byte, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.get(key)
+}
+
+// get must be called with s.mu held.
+func (s *tieredStore) get(key []byte) ([]byte, bool, error) {
+	if e, ok := s.items[string(key)]; ok {
+		v, ok, err := s.hot.Get(key)
+		if err != nil {
+			return nil, false, err
+		}
+		s.hotHits++
+		s.ll.MoveToFront(e)
+		return v, ok, nil
+	}
+	v, ok, err := s.cold.Get(key)
+	if err != nil {
+		return nil, false, err
+	}
+	if !ok {
+		s.misses++
+		return nil, false, nil
+	}
+	s.coldHits++
+	v = bcopy(v)
+	if err := s.set(key, v); err != nil {
+		return nil, false, err
+	}
+	if _, err := s.cold.Del(key); err != nil {
+		return nil, false, err
+	}
+	return v, true, nil
+}
+
+// Has neither promotes a cold key nor counts in TierStats.
+func (s *tieredStore) Has(key []byte) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.items[string(key)]; ok {
+		return true, nil
+	}
+	return s.cold.Has(key)
+}
+
+func (s *tieredStore) Del(key []byte) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var hotOK bool
+	if e, ok := s.items[string(key)]; ok {
+		s.ll.Remove(e)
+		delete(s.items, string(key))
+		var err error
+		if hotOK, err = s.hot.Del(key); err != nil {
+			return false, err
+		}
+	}
+	coldOK, err := s.cold.Del(key)
+	return hotOK || coldOK, err
+}
+
+// PDel deletes keys from both tiers with one PDel each.
+func (s *tieredStore) PDel(keys [][]byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var hot [][]byte
+	for _, k := range keys {
+		if e, ok := s.items[string(k)]; ok {
+			s.ll.Remove(e)
+			delete(s.items, string(k))
+			hot = append(hot, k)
+		}
+	}
+	if len(hot) > 0 {
+		if err := s.hot.PDel(hot); err != nil {
+			return err
+		}
+	}
+	return s.cold.PDel(keys)
+}
+
+// Keys returns the matching keys of hot followed by those of cold, so they
+// are not ordered across the tiers.
+func (s *tieredStore) Keys(pattern []byte, limit int, withvalues bool) ([][]byte, [][]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	keys, vals, err := s.hot.Keys(pattern, limit, withvalues)
+	if err != nil {
+		return nil, nil, err
+	}
+	if limit > 0 && len(keys) >= limit {
+		return keys, vals, nil
+	}
+	rest := limit
+	if limit > 0 {
+		rest -= len(keys)
+	}
+	ckeys, cvals, err := s.cold.Keys(pattern, rest, withvalues)
+	if err != nil {
+		return nil, nil, err
+	}
+	return s.appendCold(keys, vals, ckeys, cvals)
+}
+
+// AllKeys returns the keys of hot followed by those of cold.
+func (s *tieredStore) AllKeys(limit int, withvalues bool) ([][]byte, [][]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	keys, vals, err := s.hot.AllKeys(limit, withvalues)
+	if err != nil {
+		return nil, nil, err
+	}
+	if limit > 0 && len(keys) >= limit {
+		return keys, vals, nil
+	}
+	rest := limit
+	if limit > 0 {
+		rest -= len(keys)
+	}
+	ckeys, cvals, err := s.cold.AllKeys(rest, withvalues)
+	if err != nil {
+		return nil, nil, err
+	}
+	return s.appendCold(keys, vals, ckeys, cvals)
+}
+
+// RangeScan scans hot, then cold for the keys hot does not hold. As with
+// Keys, the keys are not in order across the tiers.
+func (s *tieredStore) RangeScan(start, end []byte, limit int, withvalues bool) ([][]byte, [][]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	keys, vals, err := s.hot.RangeScan(start, end, limit, withvalues)
+	if err != nil {
+		return nil, nil, err
+	}
+	if limit > 0 && len(keys) >= limit {
+		return keys, vals, nil
+	}
+	rest := limit
+	if limit > 0 {
+		rest -= len(keys)
+	}
+	ckeys, cvals, err := s.cold.RangeScan(start, end, rest, withvalues)
+	if err != nil {
+		return nil, nil, err
+	}
+	return s.appendCold(keys, vals, ckeys, cvals)
+}
+
+func (s *tieredStore) Snapshot() (Snapshot, error) {
+	return nil, ErrNotSupported
+}
+
+// Count adds the keys of hot to those of cold that hot does not shadow.
+func (s *tieredStore) Count() (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ckeys, _, err := s.cold.AllKeys(0, false)
+	if err != nil {
+		return 0, err
+	}
+	n := int64(s.ll.Len())
+	for _, k := range ckeys {
+		if _, ok := s.items[string(k)]; !ok {
+			n++
+		}
+	}
+	return n, nil
+}
+
+// appendCold appends the keys and values read from cold that hot does not
+// shadow. It must be called with s.mu held.
+func (s *tieredStore) appendCold(keys, vals, ckeys, cvals [][]byte) ([][]byte, [][]byte, error) {
+	for i, k := range ckeys {
+		if _, ok := s.items[string(k)]; ok {
+			continue
+		}
+		keys = append(keys, k)
+		if cvals != nil {
+			vals = append(vals, cvals[i])
+		}
+	}
+	return keys, vals, nil
+}
+
+func (s *tieredStore) FlushDB() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.ll.Init()
+	s.items = make(map[string]*list.Element)
+	if err := s.hot.FlushDB(); err != nil {
+		return err
+	}
+	return s.cold.FlushDB()
+}
+
+func (s *tieredStore) Compact() error {
+	return s.cold.Compact()
+}
+
+func (s *tieredStore) SetAsync(key, value []byte, cb func(error)) {
+	cb(s.Set(key, value))
+}
+
+func (s *tieredStore) Merge(key, value []byte) error {
+	return mergeWith(s.update, key, value)
+}
+
+// update reads and writes key under the lock, which moves it to hot.
+func (s *tieredStore) update(key []byte, fn updateFunc) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	old, ok, err := s.get(key)
+	if err != nil {
+		return err
+	}
+	v, err := fn(old, ok)
+	if err != nil || v == nil {
+		return err
+	}
+	return s.set(key, v)
+}
+
+func (s *tieredStore) Incr(key []byte, delta int64) (int64, error) {
+	return incrWith(s.update, key, delta)
+}
+
+func (s *tieredStore) CAS(key, oldValue, newValue []byte) (bool, error) {
+	return casWith(s.update, key, oldValue, newValue)
+}
+
+// SetWithTTL is not supported: an expiry would have to follow the key
+// between the tiers.
+func (s *tieredStore) SetWithTTL(key, value []byte, ttl time.Duration) error {
+	return ErrNotSupported
+}
+
+func (s *tieredStore) Ping() error {
+	if err := s.hot.Ping(); err != nil {
+		return err
+	}
+	return s.cold.Ping()
+}
+
+// Capabilities are the keys and persistence both tiers share, and whether
+// cold can compact. Keys are not ordered across the tiers.
+func (s *tieredStore) Capabilities() Capability {
+	caps := s.hot.Capabilities() & s.cold.Capabilities() & (CapKeys | CapPersistent)
+	return caps | s.cold.Capabilities()&CapCompact | CapCAS | CapMerge
+}
+
+// TierStats returns the Gets served by hot and by cold and the Gets that
+// found the key in neither, since the store was opened.
+func (s *tieredStore) TierStats() (hotHits, coldHits, misses int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.hotHits, s.coldHits, s.misses
+}
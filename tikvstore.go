@@ -0,0 +1,394 @@
+package kvbench
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	tikverr "github.com/tikv/client-go/v2/error"
+	"github.com/tikv/client-go/v2/kv"
+	"github.com/tikv/client-go/v2/rawkv"
+	"github.com/tikv/client-go/v2/txnkv"
+	"github.com/tikv/client-go/v2/txnkv/transaction"
+	"github.com/tikv/client-go/v2/txnkv/txnsnapshot"
+)
+
+// tikvScanPage is the number of entries read per request by the scans, the
+// largest page the raw API allows by default.
+const tikvScanPage = 10240
+
+// tikvStore is a client of a TiKV cluster, found through its PD servers, to
+// compare the embedded stores with a distributed one. In raw mode it uses
+// the raw API, where every operation is a single request; in txn mode every
+// write is a transaction with its two-phase commit and reads are snapshot
+// reads, each taking a timestamp from PD first. TiKV syncs its Raft log as
+// the servers are configured to, so there is no fsync to select. Like every
+// TiKV client it cannot store empty values.
+type tikvStore struct {
+	raw *rawkv.Client // nil in txn mode
+	txn *txnkv.Client // nil in raw mode
+
+	replicaRead kv.ReplicaReadType // of the snapshots in txn mode
+}
+
+// NewTiKVStore connects to the TiKV cluster of the PD servers pdAddrs, with
+// the transactional API if txn is set and the raw API otherwise. The two
+// APIs must not be mixed on one cluster. With ConsistencyEventual the
+// snapshot reads are served by followers; the raw API only reads from the
+// leaders, so it takes ConsistencyStrong only.
+func NewTiKVStore(pdAddrs []string, txn bool, consistency Consistency) (Store, error) {
+	if txn {
+		client, err := txnkv.NewClient(pdAddrs)
+		if err != nil {
+			return nil, err
+		}
+		s := &tikvStore{txn: client, replicaRead: kv.ReplicaReadLeader}
+		if consistency == ConsistencyEventual {
+			s.replicaRead = kv.ReplicaReadFollower
+		}
+		return s, nil
+	}
+	if consistency != ConsistencyStrong {
+		return nil, errors.New("tikv: the raw API cannot read from followers")
+	}
+	client, err := rawkv.NewClientWithOpts(context.Background(), pdAddrs)
+	if err != nil {
+		return nil, err
+	}
+	return &tikvStore{raw: client}, nil
+}
+
+func (s *tikvStore) Close() error {
+	if s.raw != nil {
+		return s.raw.Close()
+	}
+	return s.txn.Close()
+}
+
+// write runs fn in a transaction and commits it, again from the start when
+// it conflicts with a concurrent transaction.
+func (s *tikvStore) write(fn func(txn *transaction.KVTxn) error) error {
+	for {
+		txn, err := s.txn.Begin()
+		if err != nil {
+			return err
+		}
+		if err := fn(txn); err != nil {
+			txn.Rollback()
+			return err
+		}
+		err = txn.Commit(context.Background())
+		if !tikverr.IsErrWriteConflict(err) {
+			return err
+		}
+	}
+}
+
+// snapshot returns a snapshot at a new timestamp, which sees every
+// transaction committed before, read from the replicas of replicaRead.
+func (s *tikvStore) snapshot() (*txnsnapshot.KVSnapshot, error) {
+	ts, err := s.txn.GetTimestamp(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	snap := s.txn.GetSnapshot(ts)
+	snap.SetReplicaRead(s.replicaRead)
+	return snap, nil
+}
+
+func (s *tikvStore) Set(key, value []byte) error {
+	if s.raw != nil {
+		return s.raw.Put(context.Background(), key, value)
+	}
+	return s.write(func(txn *transaction.KVTxn) error {
+		return txn.Set(key, value)
+	})
+}
+
+func (s *tikvStore) SetAsync(key, value []byte, cb func(error)) {
+	cb(s.Set(key, value))
+}
+
+// PSet is one BatchPut, or one transaction.
+func (s *tikvStore) PSet(keys, values [][]byte) error {
+	if s.raw != nil {
+		return s.raw.BatchPut(context.Background(), keys, values)
+	}
+	return s.write(func(txn *transaction.KVTxn) error {
+		for i := range keys {
+			if err := txn.Set(keys[i], values[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *tikvStore) Get(key []byte) ([]byte, bool, error) {
+	if s.raw != nil {
+		v, err := s.raw.Get(context.Background(), key)
+		return v, v != nil, err
+	}
+	snap, err := s.snapshot()
+	if err != nil {
+		return nil, false, err
+	}
+	return tikvSnapshot{snap}.Get(key)
+}
+
+func (s *tikvStore) Has(key []byte) (bool, error) {
+	_, ok, err := s.Get(key)
+	return ok, err
+}
+
+func (s *tikvStore) PGet(keys [][]byte) ([][]byte, []bool, error) {
+	vals := make([][]byte, len(keys))
+	oks := make([]bool, len(keys))
+	if s.raw != nil {
+		vs, err := s.raw.BatchGet(context.Background(), keys)
+		if err != nil {
+			return nil, nil, err
+		}
+		for i, v := range vs {
+			vals[i], oks[i] = v, v != nil
+		}
+		return vals, oks, nil
+	}
+	snap, err := s.snapshot()
+	if err != nil {
+		return nil, nil, err
+	}
+	m, err := snap.BatchGet(context.Background(), keys)
+	if err != nil {
+		return nil, nil, err
+	}
+	for i, k := range keys {
+		vals[i], oks[i] = m[string(k)]
+	}
+	return vals, oks, nil
+}
+
+// Del reads the key before deleting it, to report whether it was present:
+// in the same transaction in txn mode, in a request of its own in raw mode.
+func (s *tikvStore) Del(key []byte) (bool, error) {
+	ctx := context.Background()
+	if s.raw != nil {
+		v, err := s.raw.Get(ctx, key)
+		if err != nil || v == nil {
+			return false, err
+		}
+		return true, s.raw.Delete(ctx, key)
+	}
+	var ok bool
+	err := s.write(func(txn *transaction.KVTxn) error {
+		ok = false
+		_, err := txn.Get(ctx, key)
+		if tikverr.IsErrNotFound(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		ok = true
+		return txn.Delete(key)
+	})
+	return ok, err
+}
+
+func (s *tikvStore) PDel(keys [][]byte) error {
+	if s.raw != nil {
+		return s.raw.BatchDelete(context.Background(), keys)
+	}
+	return s.write(func(txn *transaction.KVTxn) error {
+		for _, k := range keys {
+			if err := txn.Delete(k); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Keys scans the range of the keys starting with pattern.
+func (s *tikvStore) Keys(pattern []byte, limit int, withvals bool) ([][]byte, [][]byte, error) {
+	return s.RangeScan(pattern, prefixEnd(pattern), limit, withvals)
+}
+
+func (s *tikvStore) AllKeys(limit int, withvals bool) ([][]byte, [][]byte, error) {
+	return s.Keys(nil, limit, withvals)
+}
+
+// RangeScan reads the range in pages of tikvScanPage entries, a Scan
+// request each in raw mode.
+func (s *tikvStore) RangeScan(start, end []byte, limit int, withvals bool) ([][]byte, [][]byte, error) {
+	if s.raw == nil {
+		snap, err := s.snapshot()
+		if err != nil {
+			return nil, nil, err
+		}
+		return tikvSnapshot{snap}.RangeScan(start, end, limit, withvals)
+	}
+	var opts []rawkv.RawOption
+	if !withvals {
+		opts = append(opts, rawkv.ScanKeyOnly())
+	}
+	var keys [][]byte
+	var vals [][]byte
+	for {
+		n := tikvScanPage
+		if limit > 0 && limit-len(keys) < n {
+			n = limit - len(keys)
+		}
+		ks, vs, err := s.raw.Scan(context.Background(), start, end, n, opts...)
+		if err != nil {
+			return nil, nil, err
+		}
+		keys = append(keys, ks...)
+		if withvals {
+			vals = append(vals, vs...)
+		}
+		if len(ks) < n || len(keys) == limit {
+			return keys, vals, nil
+		}
+		// the smallest key after the last one
+		start = append(bcopy(ks[len(ks)-1]), 0)
+	}
+}
+
+// tikvSnapshot is a snapshot read of txn mode.
+type tikvSnapshot struct {
+	snap *txnsnapshot.KVSnapshot
+}
+
+// Snapshot is only supported in txn mode; the raw API has no snapshots.
+func (s *tikvStore) Snapshot() (Snapshot, error) {
+	if s.raw != nil {
+		return nil, ErrNotSupported
+	}
+	snap, err := s.snapshot()
+	if err != nil {
+		return nil, err
+	}
+	return tikvSnapshot{snap}, nil
+}
+
+func (s tikvSnapshot) Get(key []byte) ([]byte, bool, error) {
+	v, err := s.snap.Get(context.Background(), key)
+	if tikverr.IsErrNotFound(err) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return v, true, nil
+}
+
+func (s tikvSnapshot) RangeScan(start, end []byte, limit int, withvals bool) ([][]byte, [][]byte, error) {
+	s.snap.SetScanBatchSize(tikvScanPage)
+	it, err := s.snap.Iter(start, end)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer it.Close()
+	var keys [][]byte
+	var vals [][]byte
+	for it.Valid() {
+		if limit > 0 && len(keys) >= limit {
+			break
+		}
+		keys = append(keys, bcopy(it.Key()))
+		if withvals {
+			vals = append(vals, bcopy(it.Value()))
+		}
+		if err := it.Next(); err != nil {
+			return nil, nil, err
+		}
+	}
+	return keys, vals, nil
+}
+
+func (s tikvSnapshot) Close() error {
+	return nil
+}
+
+// Count scans every key.
+func (s *tikvStore) Count() (int64, error) {
+	keys, _, err := s.AllKeys(0, false)
+	return int64(len(keys)), err
+}
+
+// FlushDB deletes the keys a page at a time: the raw API can delete a
+// range, but has no end key after every key.
+func (s *tikvStore) FlushDB() error {
+	for {
+		keys, _, err := s.AllKeys(tikvScanPage, false)
+		if err != nil || len(keys) == 0 {
+			return err
+		}
+		if err := s.PDel(keys); err != nil {
+			return err
+		}
+	}
+}
+
+// Compact is not supported: TiKV compacts its RocksDB instances by itself.
+func (s *tikvStore) Compact() error {
+	return ErrNotSupported
+}
+
+func (s *tikvStore) Merge(key, value []byte) error {
+	return mergeWith(s.update, key, value)
+}
+
+// update reads and writes key in one transaction. The raw API could only do
+// it with every write in its atomic mode, which would slow the other phases
+// down, so raw mode does not support it.
+func (s *tikvStore) update(key []byte, fn updateFunc) error {
+	if s.raw != nil {
+		return ErrNotSupported
+	}
+	return s.write(func(txn *transaction.KVTxn) error {
+		old, err := txn.Get(context.Background(), key)
+		ok := err == nil
+		if err != nil && !tikverr.IsErrNotFound(err) {
+			return err
+		}
+		v, err := fn(old, ok)
+		if err != nil || v == nil {
+			return err
+		}
+		return txn.Set(key, v)
+	})
+}
+
+func (s *tikvStore) Incr(key []byte, delta int64) (int64, error) {
+	return incrWith(s.update, key, delta)
+}
+
+func (s *tikvStore) CAS(key, oldValue, newValue []byte) (bool, error) {
+	return casWith(s.update, key, oldValue, newValue)
+}
+
+// SetWithTTL rounds ttl up to whole seconds in raw mode, which needs
+// storage.enable-ttl on the servers. Transactions have no TTL.
+func (s *tikvStore) SetWithTTL(key, value []byte, ttl time.Duration) error {
+	if s.raw == nil {
+		return ErrNotSupported
+	}
+	return s.raw.PutWithTTL(context.Background(), key, value, uint64((ttl+time.Second-1)/time.Second))
+}
+
+// Ping reads a key, a round trip to a TiKV server, after one to PD for the
+// timestamp in txn mode.
+func (s *tikvStore) Ping() error {
+	_, _, err := s.Get([]byte("kvbench-ping"))
+	return err
+}
+
+func (s *tikvStore) Capabilities() Capability {
+	c := CapKeys | CapOrdered | CapPersistent
+	if s.raw != nil {
+		return c | CapTTL
+	}
+	return c | CapTransactions | CapSnapshots | CapCAS | CapMerge
+}
@@ -0,0 +1,10 @@
+package kvbench
+
+// VersionedStore is implemented by stores that keep older versions of a key,
+// with explicit timestamps chosen by the caller, as an MVCC layer needs.
+type VersionedStore interface {
+	// SetAt writes value as the version of key at timestamp version.
+	SetAt(key, value []byte, version uint64) error
+	// GetAt reads the newest version of key at or before timestamp version.
+	GetAt(key []byte, version uint64) ([]byte, bool, error)
+}